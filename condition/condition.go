@@ -0,0 +1,723 @@
+// Package condition parses and evaluates the expressions that custom alert
+// rules fire on, such as
+//
+//	price < 90% * avg(price, 30d) and min(original_price, 30d) == max(original_price, 30d)
+//
+// An expression reads the fields of a refreshed product and the average,
+// minimum or maximum of its price history over a window. It can only
+// compute and compare, so any expression a user saves is safe to evaluate.
+package condition
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxLength = 500
+	MinWindow = time.Hour
+	MaxWindow = 365 * 24 * time.Hour
+)
+
+// Type is the type of a field or of part of an expression.
+type Type int
+
+const (
+	Number Type = iota
+	Bool
+)
+
+func (t Type) String() string {
+	if t == Bool {
+		return "true or false"
+	}
+	return "a number"
+}
+
+// Fields are the product fields an expression can read: its prices after
+// and before the refresh, its target price (0 when unset), its discount in
+// percent, whether it is in stock now and was before, and how many checks
+// in a row failed before the refresh.
+var Fields = map[string]Type{
+	"price":                   Number,
+	"original_price":          Number,
+	"previous_price":          Number,
+	"previous_original_price": Number,
+	"target_price":            Number,
+	"discount":                Number,
+	"check_failures":          Number,
+	"in_stock":                Bool,
+	"was_in_stock":            Bool,
+}
+
+// HistoryFields are the fields whose history avg, min and max read.
+var HistoryFields = []string{"price", "original_price"}
+
+// Functions aggregate a history field over a window.
+var Functions = []string{"avg", "min", "max"}
+
+var keywords = []string{"and", "or", "not", "true", "false"}
+
+// Env is what an expression is evaluated against.
+type Env struct {
+	Numbers map[string]float64
+	Bools   map[string]bool
+	// Aggregate returns the average, minimum or maximum ("avg", "min" or
+	// "max") of a history field over the window before the refresh.
+	Aggregate func(fn, field string, window time.Duration) float64
+}
+
+// Error is a mistake in an expression found at Pos, the position of the
+// character it starts at, counting from 1.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at character %d: %s", e.Pos, e.Msg)
+}
+
+// Expr is a parsed expression that evaluates to true or false.
+type Expr struct {
+	src   string
+	truth func(env Env) (bool, error)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval reports whether the expression holds in env. It fails only when the
+// expression divides by zero.
+func (e *Expr) Eval(env Env) (bool, error) {
+	return e.truth(env)
+}
+
+// Parse checks an expression and prepares it for evaluation. Its errors are
+// *Error values that say where the mistake is and how to fix it.
+func Parse(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, &Error{1, "the condition is empty, write one such as price < 50000"}
+	}
+	if utf8.RuneCountInString(src) > MaxLength {
+		return nil, &Error{MaxLength + 1, fmt.Sprintf("the condition is longer than %d characters", MaxLength)}
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokEOF {
+		return nil, p.errorf(next.pos, "unexpected %s, join conditions with and or or", p.describe(next))
+	}
+	if c.typ != Bool {
+		return nil, p.errorf(c.start, "%s is a number, compare it to something, e.g. %s < 50000",
+			p.text(c), p.text(c))
+	}
+
+	return &Expr{src: src, truth: c.truth}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokDuration
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	// text is the operator or lowercased identifier.
+	text     string
+	num      float64
+	duration time.Duration
+	// pos and end are byte offsets into the source.
+	pos, end int
+}
+
+var operators = []string{"<=", ">=", "==", "!=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "(", ")", ","}
+
+var durationUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isDigit(c) || c == '.':
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.' || src[i] == '_') {
+				i++
+			}
+			num, err := strconv.ParseFloat(strings.ReplaceAll(src[start:i], "_", ""), 64)
+			if err != nil {
+				return nil, errorAt(src, start, fmt.Sprintf("%q is not a number", src[start:i]))
+			}
+			tok := token{kind: tokNumber, num: num, pos: start}
+
+			end := i
+			for end < len(src) && isLetter(src[end]) {
+				end++
+			}
+			switch {
+			case i < len(src) && src[i] == '%':
+				tok.num /= 100
+				i++
+			case end == i+1 && durationUnits[src[i]] != 0:
+				tok.kind = tokDuration
+				tok.duration = time.Duration(num * float64(durationUnits[src[i]]))
+				i++
+			case end > i:
+				return nil, errorAt(src, start, fmt.Sprintf("%q is not a number, windows are written like 24h, 30d or 2w",
+					src[start:end]))
+			}
+			tok.end = i
+			tokens = append(tokens, tok)
+
+		case isLetter(c):
+			start := i
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: strings.ToLower(src[start:i]), pos: start, end: i})
+
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				msg := fmt.Sprintf("unexpected %q", src[i:i+utf8RuneLen(src[i:])])
+				switch c {
+				case '=':
+					msg += ", compare with =="
+				case '&':
+					msg += ", join conditions with and"
+				case '|':
+					msg += ", join conditions with or"
+				}
+				return nil, errorAt(src, i, msg)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i, end: i + len(op)})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src), end: len(src)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func utf8RuneLen(s string) int {
+	_, size := utf8.DecodeRuneInString(s)
+	return size
+}
+
+func errorAt(src string, offset int, msg string) *Error {
+	return &Error{utf8.RuneCountInString(src[:offset]) + 1, msg}
+}
+
+// compiled is part of an expression, ready to evaluate to a number or to
+// true or false depending on typ. start and end are its byte offsets.
+type compiled struct {
+	typ        Type
+	start, end int
+	num        func(env Env) (float64, error)
+	truth      func(env Env) (bool, error)
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the operators or keywords
+// given.
+func (p *parser) accept(texts ...string) (token, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp && tok.kind != tokIdent {
+		return tok, false
+	}
+	for _, text := range texts {
+		if tok.text == text {
+			return p.next(), true
+		}
+	}
+	return tok, false
+}
+
+func (p *parser) errorf(offset int, format string, args ...interface{}) *Error {
+	return errorAt(p.src, offset, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) text(c compiled) string {
+	return strings.TrimSpace(p.src[c.start:c.end])
+}
+
+func (p *parser) describe(tok token) string {
+	if tok.kind == tokEOF {
+		return "end of the condition"
+	}
+	return fmt.Sprintf("%q", p.src[tok.pos:tok.end])
+}
+
+// want checks that an operand of op has the type it needs.
+func (p *parser) want(c compiled, typ Type, op string) error {
+	if c.typ == typ {
+		return nil
+	}
+	return p.errorf(c.start, "%s needs %s on both sides, but %s is %s", op, typ, p.text(c), c.typ)
+}
+
+func (p *parser) or() (compiled, error) {
+	left, err := p.and()
+	if err != nil {
+		return compiled{}, err
+	}
+	for {
+		op, ok := p.accept("or", "||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return compiled{}, err
+		}
+		if err = p.want(left, Bool, op.text); err != nil {
+			return compiled{}, err
+		}
+		if err = p.want(right, Bool, op.text); err != nil {
+			return compiled{}, err
+		}
+
+		l, r := left.truth, right.truth
+		left = compiled{typ: Bool, start: left.start, end: right.end, truth: func(env Env) (bool, error) {
+			ok, err := l(env)
+			if err != nil || ok {
+				return ok, err
+			}
+			return r(env)
+		}}
+	}
+}
+
+func (p *parser) and() (compiled, error) {
+	left, err := p.not()
+	if err != nil {
+		return compiled{}, err
+	}
+	for {
+		op, ok := p.accept("and", "&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return compiled{}, err
+		}
+		if err = p.want(left, Bool, op.text); err != nil {
+			return compiled{}, err
+		}
+		if err = p.want(right, Bool, op.text); err != nil {
+			return compiled{}, err
+		}
+
+		l, r := left.truth, right.truth
+		left = compiled{typ: Bool, start: left.start, end: right.end, truth: func(env Env) (bool, error) {
+			ok, err := l(env)
+			if err != nil || !ok {
+				return ok, err
+			}
+			return r(env)
+		}}
+	}
+}
+
+func (p *parser) not() (compiled, error) {
+	op, ok := p.accept("not", "!")
+	if !ok {
+		return p.comparison()
+	}
+	operand, err := p.not()
+	if err != nil {
+		return compiled{}, err
+	}
+	if operand.typ != Bool {
+		return compiled{}, p.errorf(operand.start, "%s needs true or false, but %s is %s", op.text,
+			p.text(operand), operand.typ)
+	}
+
+	truth := operand.truth
+	return compiled{typ: Bool, start: op.pos, end: operand.end, truth: func(env Env) (bool, error) {
+		ok, err := truth(env)
+		return !ok, err
+	}}, nil
+}
+
+var comparisons = []string{"<", "<=", ">", ">=", "==", "!="}
+
+func (p *parser) comparison() (compiled, error) {
+	left, err := p.sum()
+	if err != nil {
+		return compiled{}, err
+	}
+	op, ok := p.accept(comparisons...)
+	if !ok {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return compiled{}, err
+	}
+	if next, chained := p.accept(comparisons...); chained {
+		return compiled{}, p.errorf(next.pos, "comparisons cannot be chained, join them with and")
+	}
+
+	result := compiled{typ: Bool, start: left.start, end: right.end}
+	if (op.text == "==" || op.text == "!=") && left.typ == Bool && right.typ == Bool {
+		l, r := left.truth, right.truth
+		equal := op.text == "=="
+		result.truth = func(env Env) (bool, error) {
+			a, err := l(env)
+			if err != nil {
+				return false, err
+			}
+			b, err := r(env)
+			if err != nil {
+				return false, err
+			}
+			return (a == b) == equal, nil
+		}
+		return result, nil
+	}
+	if err = p.want(left, Number, op.text); err != nil {
+		return compiled{}, err
+	}
+	if err = p.want(right, Number, op.text); err != nil {
+		return compiled{}, err
+	}
+
+	l, r := left.num, right.num
+	compare := map[string]func(a, b float64) bool{
+		"<":  func(a, b float64) bool { return a < b },
+		"<=": func(a, b float64) bool { return a <= b },
+		">":  func(a, b float64) bool { return a > b },
+		">=": func(a, b float64) bool { return a >= b },
+		"==": func(a, b float64) bool { return a == b },
+		"!=": func(a, b float64) bool { return a != b },
+	}[op.text]
+	result.truth = func(env Env) (bool, error) {
+		a, err := l(env)
+		if err != nil {
+			return false, err
+		}
+		b, err := r(env)
+		if err != nil {
+			return false, err
+		}
+		return compare(a, b), nil
+	}
+	return result, nil
+}
+
+func (p *parser) sum() (compiled, error) {
+	return p.arithmetic(p.product, "+", "-")
+}
+
+func (p *parser) product() (compiled, error) {
+	return p.arithmetic(p.unary, "*", "/")
+}
+
+// arithmetic parses operands joined by the operators given, all of the same
+// precedence and left associative.
+func (p *parser) arithmetic(operand func() (compiled, error), ops ...string) (compiled, error) {
+	left, err := operand()
+	if err != nil {
+		return compiled{}, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return compiled{}, err
+		}
+		if err = p.want(left, Number, op.text); err != nil {
+			return compiled{}, err
+		}
+		if err = p.want(right, Number, op.text); err != nil {
+			return compiled{}, err
+		}
+
+		l, r := left.num, right.num
+		divisor := p.text(right)
+		var apply func(a, b float64) (float64, error)
+		switch op.text {
+		case "+":
+			apply = func(a, b float64) (float64, error) { return a + b, nil }
+		case "-":
+			apply = func(a, b float64) (float64, error) { return a - b, nil }
+		case "*":
+			apply = func(a, b float64) (float64, error) { return a * b, nil }
+		case "/":
+			apply = func(a, b float64) (float64, error) {
+				if b == 0 {
+					return 0, fmt.Errorf("division by zero: %s is 0", divisor)
+				}
+				return a / b, nil
+			}
+		}
+		left = compiled{typ: Number, start: left.start, end: right.end, num: func(env Env) (float64, error) {
+			a, err := l(env)
+			if err != nil {
+				return 0, err
+			}
+			b, err := r(env)
+			if err != nil {
+				return 0, err
+			}
+			return apply(a, b)
+		}}
+	}
+}
+
+func (p *parser) unary() (compiled, error) {
+	op, ok := p.accept("-")
+	if !ok {
+		return p.primary()
+	}
+	operand, err := p.unary()
+	if err != nil {
+		return compiled{}, err
+	}
+	if operand.typ != Number {
+		return compiled{}, p.errorf(operand.start, "- needs a number, but %s is %s", p.text(operand), operand.typ)
+	}
+
+	num := operand.num
+	return compiled{typ: Number, start: op.pos, end: operand.end, num: func(env Env) (float64, error) {
+		v, err := num(env)
+		return -v, err
+	}}, nil
+}
+
+func (p *parser) primary() (compiled, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		v := tok.num
+		return compiled{typ: Number, start: tok.pos, end: tok.end, num: func(Env) (float64, error) {
+			return v, nil
+		}}, nil
+
+	case tokDuration:
+		return compiled{}, p.errorf(tok.pos, "%s is a window, it can only be the second argument of avg, min or max",
+			p.describe(tok))
+
+	case tokIdent:
+		if p.peek().text == "(" && p.peek().kind == tokOp {
+			return p.call(tok)
+		}
+		return p.ident(tok)
+
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.or()
+			if err != nil {
+				return compiled{}, err
+			}
+			closing := p.next()
+			if closing.kind != tokOp || closing.text != ")" {
+				return compiled{}, p.errorf(closing.pos, "expected ) to close the ( at character %d, found %s",
+					utf8.RuneCountInString(p.src[:tok.pos])+1, p.describe(closing))
+			}
+			inner.start, inner.end = tok.pos, closing.end
+			return inner, nil
+		}
+	}
+
+	return compiled{}, p.errorf(tok.pos, "expected a number, a field or (, found %s", p.describe(tok))
+}
+
+func (p *parser) ident(tok token) (compiled, error) {
+	name := tok.text
+	switch name {
+	case "true", "false":
+		v := name == "true"
+		return compiled{typ: Bool, start: tok.pos, end: tok.end, truth: func(Env) (bool, error) {
+			return v, nil
+		}}, nil
+	}
+	if containsString(Functions, name) {
+		return compiled{}, p.errorf(tok.pos, "%s needs a field and a window, e.g. %s(price, 30d)", name, name)
+	}
+	if containsString(keywords, name) {
+		return compiled{}, p.errorf(tok.pos, "expected a number, a field or (, found %s", p.describe(tok))
+	}
+
+	typ, ok := Fields[name]
+	if !ok {
+		return compiled{}, p.errorf(tok.pos, "unknown field %q%s", name, suggest(name, fieldNames()))
+	}
+	if typ == Bool {
+		return compiled{typ: Bool, start: tok.pos, end: tok.end, truth: func(env Env) (bool, error) {
+			return env.Bools[name], nil
+		}}, nil
+	}
+	return compiled{typ: Number, start: tok.pos, end: tok.end, num: func(env Env) (float64, error) {
+		return env.Numbers[name], nil
+	}}, nil
+}
+
+// call parses an aggregate such as avg(price, 30d) after its name.
+func (p *parser) call(name token) (compiled, error) {
+	fn := name.text
+	if !containsString(Functions, fn) {
+		if _, ok := Fields[fn]; ok {
+			return compiled{}, p.errorf(name.pos, "%s is a field, not a function", fn)
+		}
+		return compiled{}, p.errorf(name.pos, "unknown function %q%s, use %s", fn, suggest(fn, Functions),
+			strings.Join(Functions, ", "))
+	}
+	usage := fmt.Sprintf("%s needs a field and a window, e.g. %s(price, 30d)", fn, fn)
+
+	p.next()
+	field := p.next()
+	if field.kind != tokIdent {
+		return compiled{}, p.errorf(field.pos, "%s", usage)
+	}
+	if !containsString(HistoryFields, field.text) {
+		return compiled{}, p.errorf(field.pos, "%s can only read %s", fn, strings.Join(HistoryFields, " or "))
+	}
+	if _, ok := p.accept(","); !ok {
+		return compiled{}, p.errorf(p.peek().pos, "%s", usage)
+	}
+	window := p.next()
+	if window.kind != tokDuration {
+		return compiled{}, p.errorf(window.pos, "the window of %s must be a duration such as 24h, 30d or 2w", fn)
+	}
+	if window.duration < MinWindow || window.duration > MaxWindow {
+		return compiled{}, p.errorf(window.pos, "the window of %s must be between 1h and 365d", fn)
+	}
+	closing := p.next()
+	if closing.kind != tokOp || closing.text != ")" {
+		return compiled{}, p.errorf(closing.pos, "%s", usage)
+	}
+
+	historyField, duration := field.text, window.duration
+	return compiled{typ: Number, start: name.pos, end: closing.end, num: func(env Env) (float64, error) {
+		return env.Aggregate(fn, historyField, duration), nil
+	}}, nil
+}
+
+func fieldNames() []string {
+	names := make([]string, 0, len(Fields)+len(keywords))
+	for name := range Fields {
+		names = append(names, name)
+	}
+	names = append(names, keywords...)
+	sort.Strings(names)
+
+	return names
+}
+
+// suggest returns ", did you mean ...?" with the closest of names when it is
+// a likely typo of word.
+func suggest(word string, names []string) string {
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := distance(word, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
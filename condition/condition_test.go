@@ -0,0 +1,133 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEnv() Env {
+	return Env{
+		Numbers: map[string]float64{
+			"price":                   45000,
+			"original_price":          60000,
+			"previous_price":          50000,
+			"previous_original_price": 60000,
+			"target_price":            0,
+			"discount":                25,
+		},
+		Bools: map[string]bool{"in_stock": true},
+		Aggregate: func(fn, field string, window time.Duration) float64 {
+			if field == "original_price" {
+				return 60000
+			}
+			switch {
+			case fn == "avg" && window == 30*24*time.Hour:
+				return 52000
+			case fn == "min":
+				return 45000
+			}
+			return 55000
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"price < 50000", true},
+		{"price < 90% * avg(price, 30d) and min(original_price, 30d) == max(original_price, 30d)", true},
+		{"price < 0.85 * avg(price, 30d)", false},
+		{"price <= min(price, 2w) && in_stock", true},
+		{"NOT in_stock or discount >= 30", false},
+		{"not was_in_stock and in_stock", true},
+		{"(price - previous_price) / previous_price * 100 <= -10", true},
+		{"price + 1_000 == 46000", true},
+		{"-price < 0 and in_stock == true", true},
+		{"1 + 2 * 3 == 7", true},
+		{"price < target_price", false},
+		{"avg(price, 24h) > avg(price, 30d)", true},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q) = %v", tt.src, err)
+			continue
+		}
+		got, err := expr.Eval(testEnv())
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %v, %v, want %v", tt.src, got, err, tt.want)
+		}
+	}
+}
+
+func TestEvalDivisionByZero(t *testing.T) {
+	expr, err := Parse("price / target_price > 1 or in_stock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = expr.Eval(testEnv())
+	if err == nil || !strings.Contains(err.Error(), "target_price is 0") {
+		t.Errorf("Eval() = %v, want a division by zero", err)
+	}
+
+	// or stops at the first side that holds.
+	expr, err = Parse("in_stock or price / target_price > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := expr.Eval(testEnv())
+	if err != nil || !got {
+		t.Errorf("Eval() = %v, %v", got, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{"  ", 1, "the condition is empty"},
+		{"price", 1, "price is a number, compare it to something"},
+		{"prise < 100", 1, `unknown field "prise", did you mean price?`},
+		{"price < 100 adn in_stock", 13, `unexpected "adn"`},
+		{"price = 100", 7, "compare with =="},
+		{"price < 100 & in_stock", 13, "join conditions with and"},
+		{"price < 100 and discount", 17, "and needs true or false on both sides, but discount is a number"},
+		{"in_stock + 1 > 2", 1, "+ needs a number on both sides, but in_stock is true or false"},
+		{"not price", 5, "not needs true or false, but price is a number"},
+		{"1 < price < 2", 11, "comparisons cannot be chained"},
+		{"average(price, 30d) > 1", 1, `unknown function "average", use avg, min, max`},
+		{"price(1) > 1", 1, "price is a field, not a function"},
+		{"avg > 1", 1, "avg needs a field and a window, e.g. avg(price, 30d)"},
+		{"avg(discount, 30d) > 1", 5, "avg can only read price or original_price"},
+		{"avg(price, 30) > 1", 12, "the window of avg must be a duration"},
+		{"avg(price, 30m) > 1", 12, `"30m" is not a number`},
+		{"max(price, 400d) > 1", 12, "the window of max must be between 1h and 365d"},
+		{"min(price 30d) > 1", 11, "min needs a field and a window"},
+		{"price > 30d", 9, `"30d" is a window`},
+		{"(price > 1", 11, "expected ) to close the ( at character 1, found end of the condition"},
+		{"price > ", 9, "expected a number, a field or (, found end of the condition"},
+		{"price > and", 9, `expected a number, a field or (, found "and"`},
+		{"price > 1.2.3", 9, `"1.2.3" is not a number`},
+		{"harga < 100", 1, `unknown field "harga"`},
+		{"price < 100 $", 13, `unexpected "$"`},
+		{strings.Repeat("1", MaxLength+1), MaxLength + 1, "longer than 500 characters"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		perr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Parse(%q) = %v, want an *Error", tt.src, err)
+			continue
+		}
+		if perr.Pos != tt.pos || !strings.Contains(perr.Msg, tt.msg) {
+			t.Errorf("Parse(%q) = %q at %d, want %q at %d", tt.src, perr.Msg, perr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
@@ -6,9 +6,9 @@ require (
 	github.com/PuerkitoBio/goquery v1.7.1
 	github.com/dustin/go-humanize v1.0.0
 	github.com/gorilla/mux v1.8.0
-	github.com/jasonlvhit/gocron v0.0.1
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/joho/godotenv v1.3.0
 	github.com/lib/pq v1.2.0
 	github.com/microcosm-cc/bluemonday v1.0.15
+	modernc.org/sqlite v1.20.3
 )
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+func (h *handler) HandleAlertsView(w http.ResponseWriter, _ *http.Request) {
+	var tmpl = template.Must(template.ParseFiles(
+		path.Join("handler", "ui", "alerts.html"),
+		path.Join("handler", "ui", "navbar.html"),
+	))
+
+	var data = map[string]interface{}{
+		"title": "Alerts",
+	}
+
+	err := tmpl.ExecuteTemplate(w, "alerts", data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) HandleListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.usecase.ListAlertRules(r.Context())
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, rules, nil, http.StatusOK)
+}
+
+// HandleCreateAlertRule creates a rule from the JSON body, e.g.
+// {"product_id": 1, "kind": "percent_drop", "threshold": 10, "window_hours": 24,
+// "cooldown_minutes": 60, "quiet_start": "22:00", "quiet_end": "07:00"}.
+func (h *handler) HandleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var payload usecase.AlertRulePayload
+	err := decodeJSON(r, &payload)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.usecase.CreateAlertRule(r.Context(), payload)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, rule, nil, http.StatusCreated)
+}
+
+// HandleUpdateAlertRule replaces a rule with the JSON body, which takes the
+// same fields as a new rule and "enabled".
+func (h *handler) HandleUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload usecase.AlertRulePayload
+	err = decodeJSON(r, &payload)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.usecase.UpdateAlertRule(r.Context(), id, payload)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, rule, nil, http.StatusOK)
+}
+
+// HandleTestAlertRule sends a test alert of a rule and lists how each
+// channel took it.
+func (h *handler) HandleTestAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.usecase.TestAlertRule(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, results, nil, http.StatusOK)
+}
+
+func (h *handler) HandleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.DeleteAlertRule)
+}
+
+// HandleSnoozeAlertRule keeps a rule from firing for the minutes in the JSON
+// body, e.g. {"minutes": 60}; zero minutes lifts the snooze.
+func (h *handler) HandleSnoozeAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Minutes int `json:"minutes"`
+	}
+	err = decodeJSON(r, &body)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.usecase.SnoozeAlertRule(r.Context(), id, body.Minutes)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, rule, nil, http.StatusOK)
+}
+
+func (h *handler) HandleAcknowledgeAlertEvent(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.AcknowledgeAlertEvent)
+}
+
+// HandleListAlertEvents lists fired alerts in DataTables form, only those of
+// product_id when it is given.
+func (h *handler) HandleListAlertEvents(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	length, _ := strconv.Atoi(r.FormValue("length"))
+	draw := r.FormValue("draw")
+	productID, _ := strconv.ParseInt(r.FormValue("product_id"), 10, 64)
+
+	paginated, err := h.usecase.ListAlertEvents(r.Context(), draw, productID, start, length)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPAjax(w, paginated, http.StatusOK)
+}
+
+// HandleListAlertDeliveries lists the notification delivery log in
+// DataTables form, that of one product's alerts with product_id.
+func (h *handler) HandleListAlertDeliveries(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	length, _ := strconv.Atoi(r.FormValue("length"))
+	draw := r.FormValue("draw")
+	productID, _ := strconv.ParseInt(r.FormValue("product_id"), 10, 64)
+
+	paginated, err := h.usecase.ListAlertDeliveries(r.Context(), draw, productID, start, length)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPAjax(w, paginated, http.StatusOK)
+}
+
+// HandleListAlertChannels lists the channels rules can choose from.
+func (h *handler) HandleListAlertChannels(w http.ResponseWriter, _ *http.Request) {
+	httpHandler.WriteHTTPResponse(w, h.usecase.AlertChannels(), nil, http.StatusOK)
+}
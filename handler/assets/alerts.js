@@ -0,0 +1,270 @@
+function escapeHtml(text) {
+    return $("<div>").text(text).html();
+}
+
+function showError(xhr, status, error) {
+    alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+}
+
+let rules = {};
+
+function ruleButton(rule, cls, label) {
+    return $('<button class="btn btn-sm me-1 mb-1">').addClass(cls).data("id", rule.id).text(label);
+}
+
+function snoozeButton(rule, minutes, label) {
+    return ruleButton(rule, "btn-outline-secondary rule-snooze", label).data("minutes", minutes);
+}
+
+function describeControls(rule) {
+    let controls = [];
+    if (rule.cooldown_minutes) {
+        controls.push(rule.cooldown_minutes + " min cooldown");
+    }
+    if (rule.hysteresis) {
+        controls.push("hysteresis");
+    }
+    if (rule.quiet_start) {
+        controls.push("quiet " + rule.quiet_start + " to " + rule.quiet_end + " " + rule.timezone);
+    }
+    return controls.join(", ");
+}
+
+function loadRules() {
+    $.get("/api/alerts/rules").done(function (obj) {
+        let body = $("#rules tbody").empty();
+        rules = {};
+        (obj.data || []).forEach(function (rule) {
+            rules[rule.id] = rule;
+            let watches = rule.product_id ? $('<a>').attr("href", "/detailview?id=" + rule.product_id)
+                .text("Product " + rule.product_id) : $("<span>").text("Tag " + rule.tag);
+            let action = $("<td>")
+                .append(ruleButton(rule, "btn-outline-primary rule-edit", "Edit"))
+                .append(ruleButton(rule, "btn-outline-primary rule-test", "Test"))
+                .append(snoozeButton(rule, 60, "Snooze 1h"))
+                .append(snoozeButton(rule, 1440, "Snooze 1d"));
+            if (rule.snoozed_until) {
+                action.append(snoozeButton(rule, 0, "Unsnooze"));
+            }
+            action.append(ruleButton(rule, "btn-outline-danger rule-delete", "Delete"));
+            $("<tr>")
+                .append($("<td>").text(rule.id))
+                .append($("<td>").append(watches))
+                .append($("<td>").text(rule.kind))
+                .append($("<td>").text(rule.expression || rule.threshold))
+                .append($("<td>").text(rule.channels ? rule.channels.join(", ") : "all"))
+                .append($("<td>").text(describeControls(rule)))
+                .append($("<td>").text(rule.enabled ? "Yes" : "No"))
+                .append($("<td>").text(rule.snoozed_until || ""))
+                .append(action)
+                .appendTo(body);
+        });
+    }).fail(showError);
+}
+
+function loadChannels() {
+    $.get("/api/alerts/channels").done(function (obj) {
+        let box = $("#rule-channels").empty();
+        (obj.data || []).forEach(function (channel) {
+            let id = "channel-" + channel;
+            box.append($('<input class="form-check-input" type="checkbox" name="channels">')
+                .attr("id", id).val(channel))
+                .append($('<label class="form-check-label ms-1 me-2">').attr("for", id).text(channel));
+        });
+    }).fail(showError);
+}
+
+function resetForm() {
+    $("#rule-form")[0].reset();
+    $("#rule-id").val("");
+    $("#rule-submit").text("Add rule");
+    $("#rule-cancel").addClass("d-none");
+}
+
+function editRule(rule) {
+    resetForm();
+    $("#rule-id").val(rule.id);
+    $("#rule-target").val(rule.product_id ? "product" : "tag");
+    $("#rule-watch").val(rule.product_id || rule.tag);
+    $("#rule-kind").val(rule.kind);
+    $("#rule-threshold").val(rule.threshold || "");
+    $("#rule-window").val(rule.window_hours || "");
+    $("#rule-expression").val(rule.expression || "");
+    $("input[name=channels]").each(function () {
+        this.checked = (rule.channels || []).indexOf(this.value) >= 0;
+    });
+    $("#rule-cooldown").val(rule.cooldown_minutes || "");
+    $("#rule-hysteresis").prop("checked", !!rule.hysteresis);
+    $("#rule-quiet-start").val(rule.quiet_start || "");
+    $("#rule-quiet-end").val(rule.quiet_end || "");
+    $("#rule-timezone").val(rule.timezone || "");
+    $("#rule-enabled").prop("checked", rule.enabled);
+    $("#rule-submit").text("Save rule " + rule.id);
+    $("#rule-cancel").removeClass("d-none");
+    window.scrollTo(0, 0);
+}
+
+function formPayload() {
+    let payload = {
+        kind: $("#rule-kind").val(),
+        threshold: parseInt($("#rule-threshold").val() || "0", 10),
+        window_hours: parseInt($("#rule-window").val() || "0", 10),
+        expression: $("#rule-expression").val(),
+        channels: $("input[name=channels]:checked").map(function () {
+            return this.value;
+        }).get(),
+        cooldown_minutes: parseInt($("#rule-cooldown").val() || "0", 10),
+        hysteresis: $("#rule-hysteresis").prop("checked"),
+        quiet_start: $("#rule-quiet-start").val(),
+        quiet_end: $("#rule-quiet-end").val(),
+        timezone: $("#rule-timezone").val(),
+        enabled: $("#rule-enabled").prop("checked")
+    };
+    if ($("#rule-target").val() === "product") {
+        payload.product_id = parseInt($("#rule-watch").val() || "0", 10);
+    } else {
+        payload.tag = $("#rule-watch").val();
+    }
+    return payload;
+}
+
+$(document).ready(function () {
+    var events = $('#events').DataTable({
+        "dom": "lrtip",
+        "bSort": false,
+        "processing": true,
+        "serverSide": true,
+        "ajax": {
+            url: "/api/alerts/events",
+        },
+        "columns": [
+            {"data": "id"},
+            {"data": "rule_id"},
+            {
+                "data": "product_name", "render": function (data, type, row) {
+                    return '<a href="/detailview?id=' + row.product_id + '">' + escapeHtml(data) + '</a>';
+                }
+            },
+            {
+                "data": "message", "render": function (data) {
+                    return escapeHtml(data);
+                }
+            },
+            {"data": "price_string"},
+            {"data": "created_at"},
+            {
+                "data": "acknowledged_at", "render": function (data, type, row) {
+                    if (data) {
+                        return data;
+                    }
+                    return '<button class="btn btn-sm btn-outline-primary event-acknowledge" data-id="' +
+                        row.id + '">Acknowledge</button>';
+                }
+            }
+        ]
+    });
+
+    var deliveries = $('#deliveries').DataTable({
+        "dom": "lrtip",
+        "bSort": false,
+        "processing": true,
+        "serverSide": true,
+        "ajax": {
+            url: "/api/alerts/deliveries",
+        },
+        "columns": [
+            {"data": "id"},
+            {"data": "event_id"},
+            {
+                "data": "product_name", "render": function (data) {
+                    return escapeHtml(data);
+                }
+            },
+            {"data": "channel"},
+            {"data": "status"},
+            {"data": "attempts"},
+            {
+                "data": "error", "render": function (data) {
+                    return data ? escapeHtml(data) : "";
+                }
+            },
+            {"data": "created_at"},
+            {
+                "data": "sent_at", "render": function (data) {
+                    return data ? data : "";
+                }
+            }
+        ]
+    });
+
+    loadChannels();
+    loadRules();
+
+    let params = new URLSearchParams(window.location.search);
+    if (params.get("product_id")) {
+        $("#rule-target").val("product");
+        $("#rule-watch").val(params.get("product_id"));
+    }
+
+    $("#rule-form").on("submit", function (e) {
+        e.preventDefault();
+        let id = $("#rule-id").val();
+        $.ajax({
+            url: id ? "/api/alerts/rules/" + id : "/api/alerts/rules",
+            method: id ? "PUT" : "POST",
+            contentType: "application/json",
+            data: JSON.stringify(formPayload())
+        }).done(function () {
+            resetForm();
+            loadRules();
+        }).fail(showError);
+    });
+
+    $("#rule-cancel").on("click", resetForm);
+
+    $("#rules").on("click", ".rule-edit", function () {
+        editRule(rules[$(this).data("id")]);
+    });
+
+    $("#rules").on("click", ".rule-test", function () {
+        let id = $(this).data("id");
+        $.post("/api/alerts/rules/" + id + "/test").done(function (obj) {
+            let box = $("#test-results").removeClass("d-none").empty()
+                .append($("<div>").text("Test of rule " + id + ":"));
+            obj.data.forEach(function (result) {
+                box.append($("<div>").text(result.channel + ": " + result.status +
+                    (result.error ? " (" + result.error + ")" : "")));
+            });
+        }).fail(showError);
+    });
+
+    $("#rules").on("click", ".rule-snooze", function () {
+        $.ajax({
+            url: "/api/alerts/rules/" + $(this).data("id") + "/snooze",
+            method: "POST",
+            contentType: "application/json",
+            data: JSON.stringify({minutes: $(this).data("minutes")})
+        }).done(loadRules).fail(showError);
+    });
+
+    $("#rules").on("click", ".rule-delete", function () {
+        if (!confirm("Delete this rule with its events and deliveries?")) {
+            return;
+        }
+        $.ajax({url: "/api/alerts/rules/" + $(this).data("id"), method: "DELETE"})
+            .done(function () {
+                loadRules();
+                events.ajax.reload();
+                deliveries.ajax.reload();
+            })
+            .fail(showError);
+    });
+
+    $("#events").on("click", ".event-acknowledge", function () {
+        $.ajax({url: "/api/alerts/events/" + $(this).data("id") + "/acknowledge", method: "POST"})
+            .done(function () {
+                events.ajax.reload(null, false);
+            })
+            .fail(showError);
+    });
+});
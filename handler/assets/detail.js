@@ -1,11 +1,16 @@
-$(document).ready(function () {
+let chart = null;
+
+function loadChart(days) {
     let product_id = $("#product_id").val();
-    $.get("/histories", {product_id: product_id})
+    $.get("/histories", {product_id: product_id, days: days})
         .done(function (data) {
             let current_prices = data.map(product => product.current_price);
             let original_price = data.map(product => product.original_price);
             let update_time = data.map(product => product.update_time);
-            new Chart("myChart", {
+            if (chart !== null) {
+                chart.destroy();
+            }
+            chart = new Chart("myChart", {
                 type: "line",
                 data: {
                     labels: update_time,
@@ -26,4 +31,149 @@ $(document).ready(function () {
                 }
             });
         });
-});
\ No newline at end of file
+}
+
+// loadAlerts fills the alert tables with the rules watching the product,
+// directly or through one of its tags, its latest alerts and their
+// deliveries.
+function loadAlerts() {
+    let product_id = parseInt($("#product_id").val(), 10);
+    let tags = $(".product-tag").map(function () {
+        return $(this).text();
+    }).get();
+
+    $.get("/api/alerts/rules").done(function (obj) {
+        let body = $("#alert-rules tbody").empty();
+        (obj.data || []).filter(function (rule) {
+            return rule.product_id === product_id || tags.indexOf(rule.tag) >= 0;
+        }).forEach(function (rule) {
+            $("<tr>")
+                .append($("<td>").append($("<a>").attr("href", "/admin/alerts").text(rule.id)))
+                .append($("<td>").text(rule.product_id ? "This product" : "Tag " + rule.tag))
+                .append($("<td>").text(rule.kind))
+                .append($("<td>").text(rule.threshold))
+                .append($("<td>").text(rule.enabled ? "Yes" : "No"))
+                .append($("<td>").text(rule.snoozed_until || ""))
+                .appendTo(body);
+        });
+    });
+
+    $.get("/api/alerts/events", {product_id: product_id, length: 20}).done(function (obj) {
+        let body = $("#alert-events tbody").empty();
+        (obj.data || []).forEach(function (event) {
+            $("<tr>")
+                .append($("<td>").text(event.created_at))
+                .append($("<td>").text(event.kind))
+                .append($("<td>").text(event.message))
+                .append($("<td>").text(event.price_string))
+                .append($("<td>").text(event.acknowledged_at || ""))
+                .appendTo(body);
+        });
+    });
+
+    $.get("/api/alerts/deliveries", {product_id: product_id, length: 20}).done(function (obj) {
+        let body = $("#alert-deliveries tbody").empty();
+        (obj.data || []).forEach(function (delivery) {
+            $("<tr>")
+                .append($("<td>").text(delivery.event_id))
+                .append($("<td>").text(delivery.channel))
+                .append($("<td>").text(delivery.status))
+                .append($("<td>").text(delivery.attempts))
+                .append($("<td>").text(delivery.error || ""))
+                .append($("<td>").text(delivery.sent_at || ""))
+                .appendTo(body);
+        });
+    });
+}
+
+$(document).ready(function () {
+    loadChart(0);
+    loadAlerts();
+});
+
+$(".history-range").click(function (event) {
+    event.preventDefault();
+    $(".history-range").removeClass("active");
+    $(this).addClass("active");
+    loadChart($(this).data("days"));
+});
+
+$("#refresh-product").click(function (event) {
+    event.preventDefault();
+    let product_id = $("#product_id").val();
+    let button = $(this);
+    button.prop("disabled", true);
+    $("#refresh-status").text("refreshing...");
+    $.post("/products/" + product_id + "/refresh")
+        .done(function (obj) {
+            $("#current_price").text(obj.data.current_price_string);
+            $("#original_price").text(obj.data.original_price_string);
+            $("#in_stock").text(obj.data.in_stock ? "Available" : "Out of stock");
+            $("#refresh-status").text(obj.data.changed ? "price changed" : "price unchanged");
+            loadAlerts();
+        })
+        .fail(function (xhr, status, error) {
+            $("#refresh-status").text("");
+            alert(error)
+        })
+        .always(function () {
+            button.prop("disabled", false);
+        });
+});
+
+function productAction(method, url, onDone) {
+    $.ajax({url: url, method: method})
+        .done(onDone)
+        .fail(function (xhr, status, error) {
+            alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+        });
+}
+
+$("#archive-product").click(function (event) {
+    event.preventDefault();
+    productAction("POST", "/products/" + $("#product_id").val() + "/archive", function () {
+        window.location.reload();
+    });
+});
+
+$("#restore-product").click(function (event) {
+    event.preventDefault();
+    productAction("POST", "/products/" + $("#product_id").val() + "/restore", function () {
+        window.location.reload();
+    });
+});
+
+$("#purge-product").click(function (event) {
+    event.preventDefault();
+    if (!confirm("Delete this product with all its price history? This cannot be undone.")) {
+        return;
+    }
+    productAction("DELETE", "/products/" + $("#product_id").val(), function () {
+        window.location.replace("/listview");
+    });
+});
+
+$("#settings-form").submit(function (event) {
+    event.preventDefault();
+    let settings = {
+        display_name: $("#display_name").val(),
+        notes: $("#notes").val(),
+        target_price: parseInt($("#target_price").val() || "0", 10),
+        refresh_interval_minutes: parseInt($("#refresh_interval_minutes").val() || "0", 10)
+    };
+    $("#settings-status").text("saving...");
+    $.ajax({
+        url: "/api/products/" + $("#product_id").val(),
+        method: "PATCH",
+        contentType: "application/json",
+        data: JSON.stringify(settings)
+    })
+        .done(function (obj) {
+            $("#product_name").text(obj.data.display_name || obj.data.name);
+            $("#settings-status").text("saved");
+        })
+        .fail(function (xhr, status, error) {
+            $("#settings-status").text("");
+            alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+        });
+});
@@ -0,0 +1,94 @@
+function escapeHtml(text) {
+    return $("<div>").text(text).html();
+}
+
+function showError(xhr, status, error) {
+    alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+}
+
+function loadCollections() {
+    $.get("/api/collections").done(function (obj) {
+        let select = $("#digest-collection");
+        (obj.data || []).forEach(function (collection) {
+            $("<option>").val(collection.id).text(collection.name).appendTo(select);
+        });
+    }).fail(showError);
+}
+
+function loadDigests() {
+    $.get("/api/digests").done(function (obj) {
+        let body = $("#digests tbody").empty();
+        (obj.data || []).forEach(function (digest) {
+            $("<tr>")
+                .append($("<td>").text(digest.id))
+                .append($("<td>").text(digest.collection_name || "All products"))
+                .append($("<td>").text(digest.period))
+                .append($("<td>").text(digest.channels ? digest.channels.join(", ") : "all"))
+                .append($("<td>").text(digest.created_at))
+                .append($("<td>").append($('<button class="btn btn-sm btn-outline-danger digest-delete">')
+                    .data("id", digest.id).text("Delete")))
+                .appendTo(body);
+        });
+    }).fail(showError);
+}
+
+$(document).ready(function () {
+    var table = $('#reports').DataTable({
+        "dom": "lrtip",
+        "bSort": false,
+        "processing": true,
+        "serverSide": true,
+        "ajax": {
+            url: "/api/digests/reports",
+        },
+        "columns": [
+            {"data": "id"},
+            {"data": "digest_id"},
+            {
+                "data": "title", "render": function (data, type, row) {
+                    return '<a href="/digests/reports/' + row.id + '">' + escapeHtml(data) + '</a>';
+                }
+            },
+            {"data": "period_start"},
+            {"data": "period_end"},
+            {"data": "created_at"}
+        ]
+    });
+
+    loadCollections();
+    loadDigests();
+
+    $("#digest-form").on("submit", function (e) {
+        e.preventDefault();
+        let channels = $("#digest-channels").val().split(",").map(function (channel) {
+            return channel.trim();
+        }).filter(function (channel) {
+            return channel !== "";
+        });
+        $.ajax({
+            url: "/api/digests",
+            method: "POST",
+            contentType: "application/json",
+            data: JSON.stringify({
+                collection_id: parseInt($("#digest-collection").val(), 10),
+                period: $("#digest-period").val(),
+                channels: channels
+            })
+        }).done(function () {
+            $("#digest-channels").val("");
+            loadDigests();
+        }).fail(showError);
+    });
+
+    $("#digests").on("click", ".digest-delete", function () {
+        if (!confirm("Delete this digest and the digests it sent?")) {
+            return;
+        }
+        $.ajax({url: "/api/digests/" + $(this).data("id"), method: "DELETE"})
+            .done(function () {
+                loadDigests();
+                table.ajax.reload();
+            })
+            .fail(showError);
+    });
+});
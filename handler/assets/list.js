@@ -1,29 +1,231 @@
+let archived = false;
+let selected = new Set();
+
+function escapeHtml(text) {
+    return $("<div>").text(text).html();
+}
+
+function updateSelected() {
+    $("#bulk-selected").text(selected.size + " selected");
+}
+
+// loadFilters fills the tag and collection selects, keeping their choice.
+function loadFilters() {
+    $.get("/api/tags").done(function (obj) {
+        let select = $("#filter-tag");
+        let current = select.val();
+        select.find("option:not(:first)").remove();
+        (obj.data || []).forEach(function (tag) {
+            select.append($("<option>").val(tag.name).text(tag.name + " (" + tag.products + ")"));
+        });
+        select.val(current);
+    });
+    $.get("/api/marketplaces").done(function (obj) {
+        let select = $("#filter-marketplace");
+        let current = select.val();
+        select.find("option:not(:first)").remove();
+        (obj.data || []).forEach(function (marketplace) {
+            select.append($("<option>").val(marketplace).text(marketplace));
+        });
+        select.val(current);
+    });
+    $.get("/api/collections").done(function (obj) {
+        $("#filter-collection, #bulk-collection").each(function () {
+            let select = $(this);
+            let current = select.val();
+            select.find("option:not(:first)").remove();
+            (obj.data || []).forEach(function (collection) {
+                select.append($("<option>").val(collection.id)
+                    .text(collection.name + " (" + collection.products + ")"));
+            });
+            select.val(current);
+        });
+    });
+}
+
+function sendJSON(method, url, body) {
+    return $.ajax({url: url, method: method, contentType: "application/json", data: JSON.stringify(body)})
+        .fail(function (xhr, status, error) {
+            alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+        });
+}
+
+// bulkAction runs an action on the selected products, then reloads the list.
+function bulkAction(method, url, body) {
+    if (selected.size === 0) {
+        alert("Select at least one product first.");
+        return;
+    }
+    body.product_ids = Array.from(selected);
+    sendJSON(method, url, body).done(function () {
+        loadFilters();
+        $('#list').DataTable().ajax.reload(null, false);
+    });
+}
+
+function productAction(method, url, confirmText) {
+    if (confirmText && !confirm(confirmText)) {
+        return;
+    }
+    $.ajax({url: url, method: method})
+        .done(function () {
+            $('#list').DataTable().ajax.reload();
+        })
+        .fail(function (xhr, status, error) {
+            alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+        });
+}
+
 $(document).ready(function () {
     var table = $('#list').DataTable({
-        "dom": "lrtip",
-        "bSort": false,
+        "dom": "lfrtip",
+        "order": [],
+        "searchDelay": 400,
         "processing": true,
         "serverSide": true,
         "ajax": {
             url: "/list/product",
+            data: function (d) {
+                d.archived = archived;
+                d.tag = $("#filter-tag").val();
+                d.collection = $("#filter-collection").val();
+                d.marketplace = $("#filter-marketplace").val();
+                d.min_price = $("#filter-min-price").val();
+                d.max_price = $("#filter-max-price").val();
+                d.on_sale = $("#filter-on-sale").is(":checked");
+            }
         },
         "columns": [
+            {
+                "data": "id", "orderable": false, "render": function (data) {
+                    let checked = selected.has(data) ? " checked" : "";
+                    return '<input type="checkbox" class="product-select" value="' + data + '"' + checked + '>';
+                }
+            },
             {
                 "data": "name", "render": function (data, type, row, meta) {
-                    if (row.url === "") {
-                        return data;
+                    // The display name is typed in by the user, so it is escaped.
+                    let name = escapeHtml(row.display_name || data);
+                    if (row.url !== "") {
+                        name = '<a href="' + row.url + '">' + name + '</a>';
                     }
-                    return '<a href="' + row.url + '">' + data + '</a>';
+                    (row.tags || []).forEach(function (tag) {
+                        name += ' <span class="badge bg-light text-dark border">' + escapeHtml(tag) + '</span>';
+                    });
+                    return name;
                 }
             },
+            {"data": "marketplace", "orderable": false},
             {"data": "current_price", render: $.fn.dataTable.render.number(',', '.', 0, 'Rp')},
             {"data": "original_price", render: $.fn.dataTable.render.number(',', '.', 0, 'Rp')},
             {
-                "data": "id",
-                "render": function (data) {
-                    return '<a href="/detailview?id=' + data + '">Show</a>'
+                "data": "discount", "render": function (data) {
+                    return data > 0 ? data + "%" : "";
+                }
+            },
+            {"data": "last_changed_at"},
+            {
+                "data": "id", "orderable": false,
+                "render": function (data, type, row) {
+                    let actions = '<a href="/detailview?id=' + data + '">Show</a>';
+                    if (row.archived) {
+                        actions += ' | <a href="#" class="product-restore" data-id="' + data + '">Restore</a>';
+                        actions += ' | <a href="#" class="product-purge text-danger" data-id="' + data + '">Purge</a>';
+                    } else {
+                        actions += ' | <a href="#" class="product-archive" data-id="' + data + '">Archive</a>';
+                    }
+                    return actions;
                 }
             }
         ]
     });
-});
\ No newline at end of file
+
+    $(".list-archived").click(function (event) {
+        event.preventDefault();
+        $(".list-archived").removeClass("active");
+        $(this).addClass("active");
+        archived = $(this).data("archived");
+        table.ajax.reload();
+    });
+
+    $("#filter-tag, #filter-collection, #filter-marketplace, #filter-on-sale").change(function () {
+        table.ajax.reload();
+    });
+
+    let priceTimer = null;
+    $("#filter-min-price, #filter-max-price").on("input", function () {
+        clearTimeout(priceTimer);
+        priceTimer = setTimeout(function () {
+            table.ajax.reload();
+        }, 400);
+    });
+
+    loadFilters();
+});
+
+$('#list').on("change", ".product-select", function () {
+    let id = parseInt($(this).val(), 10);
+    if (this.checked) {
+        selected.add(id);
+    } else {
+        selected.delete(id);
+    }
+    updateSelected();
+});
+
+$("#select-all").change(function () {
+    let checked = this.checked;
+    $("#list .product-select").each(function () {
+        this.checked = checked;
+        $(this).trigger("change");
+    });
+});
+
+function bulkTags() {
+    return $("#bulk-tags").val().split(",").map(tag => tag.trim()).filter(tag => tag !== "");
+}
+
+$("#bulk-tag-add").click(function (event) {
+    event.preventDefault();
+    bulkAction("POST", "/api/products/tags", {add: bulkTags()});
+});
+
+$("#bulk-tag-remove").click(function (event) {
+    event.preventDefault();
+    bulkAction("POST", "/api/products/tags", {remove: bulkTags()});
+});
+
+$("#bulk-collection-add, #bulk-collection-remove").click(function (event) {
+    event.preventDefault();
+    let collection = $("#bulk-collection").val();
+    if (collection === "") {
+        alert("Choose a collection first.");
+        return;
+    }
+    let method = this.id === "bulk-collection-add" ? "POST" : "DELETE";
+    bulkAction(method, "/api/collections/" + collection + "/products", {});
+});
+
+$("#create-collection").click(function (event) {
+    event.preventDefault();
+    sendJSON("POST", "/api/collections", {name: $("#new-collection").val()}).done(function () {
+        $("#new-collection").val("");
+        loadFilters();
+    });
+});
+
+$('#list').on("click", ".product-archive", function (event) {
+    event.preventDefault();
+    productAction("POST", "/products/" + $(this).data("id") + "/archive");
+});
+
+$('#list').on("click", ".product-restore", function (event) {
+    event.preventDefault();
+    productAction("POST", "/products/" + $(this).data("id") + "/restore");
+});
+
+$('#list').on("click", ".product-purge", function (event) {
+    event.preventDefault();
+    productAction("DELETE", "/products/" + $(this).data("id"),
+        "Delete this product with all its price history? This cannot be undone.");
+});
@@ -0,0 +1,30 @@
+$(document).ready(function () {
+    var table = $('#runs').DataTable({
+        "dom": "lrtip",
+        "bSort": false,
+        "processing": true,
+        "serverSide": true,
+        "ajax": {
+            url: "/api/runs",
+        },
+        "columns": [
+            {"data": "id"},
+            {"data": "started_at"},
+            {
+                "data": "finished_at", "render": function (data) {
+                    return data === "" ? "running" : data;
+                }
+            },
+            {"data": "attempted"},
+            {"data": "succeeded"},
+            {"data": "failed"},
+            {"data": "changed"},
+            {
+                "data": "id",
+                "render": function (data) {
+                    return '<a href="/admin/runs/' + data + '">Show</a>'
+                }
+            }
+        ]
+    });
+});
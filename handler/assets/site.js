@@ -1,10 +1,48 @@
+function waitForJob(jobId, onDone) {
+    $.get("/jobs/" + jobId)
+        .done(function (obj) {
+            let job = obj.data;
+            if (job.status === "done") {
+                onDone(job);
+            } else if (job.status === "failed") {
+                $("#job-status").text("");
+                alert(job.error);
+            } else {
+                let retry = job.error ? " (retrying: " + job.error + ")" : "";
+                $("#job-status").text("job " + job.id + " " + job.status + retry + "...");
+                setTimeout(function () {
+                    waitForJob(jobId, onDone);
+                }, 1000);
+            }
+        })
+        .fail(function (xhr, status, error) {
+            alert(error)
+        });
+}
+
 $("#form-link").submit(function (event) {
     event.preventDefault();
     $.post("/addlink", {input_link: $("#input-link").val()})
         .done(function (obj) {
-            window.location.replace("detailview?id=" + obj.data.id);
+            waitForJob(obj.data.job_id, function (job) {
+                window.location.replace("detailview?id=" + job.result.product_id);
+            });
+        })
+        .fail(function (xhr, status, error) {
+            alert(error)
+        });
+});
+
+$("#form-import").submit(function (event) {
+    event.preventDefault();
+    $.post("/import", {links: $("#input-links").val()})
+        .done(function (obj) {
+            waitForJob(obj.data.job_id, function (job) {
+                $("#job-status").text(job.result.job_ids.length + " links queued for import");
+                $("#input-links").val("");
+            });
         })
         .fail(function (xhr, status, error) {
             alert(error)
         });
-});
\ No newline at end of file
+});
@@ -0,0 +1,97 @@
+function escapeHtml(text) {
+    return $("<div>").text(text).html();
+}
+
+function showError(xhr, status, error) {
+    alert(xhr.responseJSON ? xhr.responseJSON.error : error)
+}
+
+function loadWebhooks() {
+    $.get("/api/webhooks").done(function (obj) {
+        let body = $("#webhooks tbody").empty();
+        (obj.data || []).forEach(function (webhook) {
+            $("<tr>")
+                .append($("<td>").text(webhook.id))
+                .append($("<td>").text(webhook.url))
+                .append($("<td>").text(webhook.events.join(", ")))
+                .append($("<td>").text(webhook.created_at))
+                .append($("<td>").append($('<button class="btn btn-sm btn-outline-danger webhook-delete">')
+                    .data("id", webhook.id).text("Delete")))
+                .appendTo(body);
+        });
+    }).fail(showError);
+}
+
+$(document).ready(function () {
+    var table = $('#deliveries').DataTable({
+        "dom": "lrtip",
+        "bSort": false,
+        "processing": true,
+        "serverSide": true,
+        "ajax": {
+            url: "/api/webhooks/deliveries",
+        },
+        "columns": [
+            {"data": "id"},
+            {
+                "data": "url", "render": function (data) {
+                    return escapeHtml(data);
+                }
+            },
+            {"data": "event"},
+            {"data": "status"},
+            {"data": "attempts"},
+            {
+                "data": "status_code", "render": function (data) {
+                    return data ? data : "";
+                }
+            },
+            {
+                "data": "error", "render": function (data) {
+                    return data ? escapeHtml(data) : "";
+                }
+            },
+            {"data": "created_at"},
+            {
+                "data": "delivered_at", "render": function (data) {
+                    return data ? data : "";
+                }
+            }
+        ]
+    });
+
+    loadWebhooks();
+
+    $("#webhook-form").on("submit", function (e) {
+        e.preventDefault();
+        let events = $("input[name=events]:checked").map(function () {
+            return this.value;
+        }).get();
+        if (events.length === 0) {
+            alert("Choose at least one event.");
+            return;
+        }
+        $.ajax({
+            url: "/api/webhooks",
+            method: "POST",
+            contentType: "application/json",
+            data: JSON.stringify({url: $("#webhook-url").val(), events: events})
+        }).done(function (obj) {
+            $("#webhook-url").val("");
+            $("#webhook-secret").removeClass("d-none").find("code").text(obj.data.secret);
+            loadWebhooks();
+        }).fail(showError);
+    });
+
+    $("#webhooks").on("click", ".webhook-delete", function () {
+        if (!confirm("Delete this webhook and its delivery history?")) {
+            return;
+        }
+        $.ajax({url: "/api/webhooks/" + $(this).data("id"), method: "DELETE"})
+            .done(function () {
+                loadWebhooks();
+                table.ajax.reload();
+            })
+            .fail(showError);
+    });
+});
@@ -0,0 +1,308 @@
+// Package bot manages the watchlist from Telegram chat commands.
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/ediprako/pricemonitor/notifier/format"
+	"github.com/ediprako/pricemonitor/notifier/telegram"
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+const (
+	listPageSize = 10
+	// retryDelay is how long the bot waits after polling failed.
+	retryDelay = 5 * time.Second
+)
+
+const usage = `Commands:
+/add &lt;link&gt; - watch a product
+/list [page] - list the watched products
+/price &lt;id&gt; - show a product's price
+/remove &lt;id&gt; - stop watching a product
+/alert &lt;id&gt; &lt;price&gt; - alert when a product is at or below a price`
+
+type usecaseProvider interface {
+	EnqueueScrape(ctx context.Context, link string) (int64, error)
+	ListProduct(ctx context.Context, draw string, offset, limit int, filter usecase.ProductFilter) (usecase.PaginateData, error)
+	GetProductDetail(ctx context.Context, id int64) (usecase.Product, error)
+	ArchiveProduct(ctx context.Context, id int64) error
+	CreateAlertRule(ctx context.Context, payload usecase.AlertRulePayload) (usecase.AlertRule, error)
+}
+type bot struct {
+	usecase     usecaseProvider
+	client      *telegram.Client
+	chats       map[int64]bool
+	pollTimeout time.Duration
+}
+
+// New returns a bot answering the chats listed, and ignoring every other
+// chat. The client's timeout has to be longer than pollTimeout.
+func New(usecase usecaseProvider, client *telegram.Client, chats []int64, pollTimeout time.Duration) *bot {
+	b := &bot{
+		usecase:     usecase,
+		client:      client,
+		chats:       make(map[int64]bool),
+		pollTimeout: pollTimeout,
+	}
+	for _, chat := range chats {
+		b.chats[chat] = true
+	}
+
+	return b
+}
+
+// Run long-polls for commands and answers them until ctx is done.
+func (b *bot) Run(ctx context.Context) error {
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := b.client.GetUpdates(ctx, offset, b.pollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Println(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message == nil || update.Message.Text == "" {
+				continue
+			}
+
+			chatID := update.Message.Chat.ID
+			if !b.chats[chatID] {
+				log.Printf("bot: ignoring a message from chat %d, which is not allowed", chatID)
+				continue
+			}
+
+			reply := b.reply(ctx, update.Message.Text)
+			err = b.client.SendMessage(ctx, strconv.FormatInt(chatID, 10), reply)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	log.Println("bot stopped")
+	return nil
+}
+
+// reply runs a command and returns the answer, in Telegram's HTML subset.
+func (b *bot) reply(ctx context.Context, text string) string {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return usage
+	}
+	// In groups commands may be addressed as /command@bot_name.
+	command := strings.SplitN(args[0], "@", 2)[0]
+	args = args[1:]
+
+	var reply string
+	var err error
+	switch command {
+	case "/add":
+		reply, err = b.add(ctx, args)
+	case "/list":
+		reply, err = b.list(ctx, args)
+	case "/price":
+		reply, err = b.price(ctx, args)
+	case "/remove":
+		reply, err = b.remove(ctx, args)
+	case "/alert":
+		reply, err = b.alert(ctx, args)
+	default:
+		return usage
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return reply
+}
+
+// usageError is a command called with the wrong arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func errorReply(err error) string {
+	var validation usecase.ValidationError
+	switch {
+	case errors.As(err, new(usageError)):
+		return "Usage: " + html.EscapeString(err.Error())
+	case errors.As(err, &validation):
+		return "Cannot do that: " + html.EscapeString(validation.Message)
+	case errors.Is(err, sql.ErrNoRows):
+		return "There is no such product."
+	default:
+		log.Println(err)
+		return "Something went wrong, please try again later."
+	}
+}
+
+// productID reads a product id, written with or without a leading #.
+func productID(s string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 64)
+
+	return id, err == nil && id > 0
+}
+
+// parsePrice reads a price in rupiah, written plain or with thousands
+// separators, e.g. 45000, 45.000 or Rp45,000.
+func parsePrice(s string) (int64, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "rp"), ".")
+	s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	price, err := strconv.ParseInt(s, 10, 64)
+
+	return price, err == nil && price > 0
+}
+
+func (b *bot) add(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("/add <link>")
+	}
+	// The link is cleaned up the same way as one typed in the web form.
+	link := bluemonday.UGCPolicy().Sanitize(args[0])
+
+	jobID, err := b.usecase.EnqueueScrape(ctx, link)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Queued as job %d. The product shows up in /list once it is scraped.", jobID), nil
+}
+
+func (b *bot) list(ctx context.Context, args []string) (string, error) {
+	page := 1
+	if len(args) > 0 {
+		var err error
+		page, err = strconv.Atoi(args[0])
+		if err != nil || page < 1 || len(args) > 1 {
+			return "", usageError("/list [page]")
+		}
+	}
+
+	paginated, err := b.usecase.ListProduct(ctx, "", (page-1)*listPageSize, listPageSize, usecase.ProductFilter{})
+	if err != nil {
+		return "", err
+	}
+	if paginated.RecordsTotal == 0 {
+		return "No products are watched yet. Add one with /add &lt;link&gt;.", nil
+	}
+	if len(paginated.Products) == 0 {
+		return fmt.Sprintf("There are only %d products.", paginated.RecordsTotal), nil
+	}
+
+	var reply strings.Builder
+	for _, product := range paginated.Products {
+		name := product.DisplayName
+		if name == "" {
+			name = product.Name
+		}
+		fmt.Fprintf(&reply, "#%d %s - %s", product.ID, html.EscapeString(name), format.Price(product.CurrentPrice))
+		if !product.InStock {
+			reply.WriteString(" (out of stock)")
+		}
+		reply.WriteString("\n")
+	}
+	pages := (paginated.RecordsTotal + listPageSize - 1) / listPageSize
+	fmt.Fprintf(&reply, "\nPage %d of %d", page, pages)
+	if int64(page) < pages {
+		fmt.Fprintf(&reply, ", next: /list %d", page+1)
+	}
+
+	return reply.String(), nil
+}
+
+func (b *bot) price(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("/price <id>")
+	}
+	id, ok := productID(args[0])
+	if !ok {
+		return "", usageError("/price <id>")
+	}
+
+	product, err := b.usecase.GetProductDetail(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	name := product.DisplayName
+	if name == "" {
+		name = product.Name
+	}
+
+	var reply strings.Builder
+	fmt.Fprintf(&reply, "<b>%s</b>\nPrice: <b>%s</b>", html.EscapeString(name), format.Price(product.CurrentPrice))
+	if product.Discount > 0 {
+		fmt.Fprintf(&reply, ", %d%% off %s", product.Discount, format.Price(product.OriginalPrice))
+	}
+	if !product.InStock {
+		reply.WriteString("\nOut of stock")
+	}
+	if product.TargetPrice > 0 {
+		fmt.Fprintf(&reply, "\nTarget: %s", format.Price(product.TargetPrice))
+	}
+	fmt.Fprintf(&reply, "\nChecked %s\n<a href=\"%s\">View the product</a>", product.LastCheckedAt,
+		html.EscapeString(product.URL))
+
+	return reply.String(), nil
+}
+
+func (b *bot) remove(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("/remove <id>")
+	}
+	id, ok := productID(args[0])
+	if !ok {
+		return "", usageError("/remove <id>")
+	}
+
+	err := b.usecase.ArchiveProduct(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Stopped watching #%d. Its history is kept and it can be restored from the web list.", id), nil
+}
+
+func (b *bot) alert(ctx context.Context, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", usageError("/alert <id> <price>")
+	}
+	id, ok := productID(args[0])
+	price, okPrice := parsePrice(args[1])
+	if !ok || !okPrice {
+		return "", usageError("/alert <id> <price>")
+	}
+
+	_, err := b.usecase.CreateAlertRule(ctx, usecase.AlertRulePayload{
+		ProductID: id,
+		Kind:      model.AlertKindBelowTarget,
+		Threshold: price,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("You will be alerted when #%d is at or below %s.", id, format.Price(price)), nil
+}
@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/notifier/telegram"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+// fakeUsecase watches a single product, #1, and lists the given products.
+type fakeUsecase struct {
+	products []usecase.Product
+	scraped  []string
+	archived []int64
+	rules    []usecase.AlertRulePayload
+}
+
+func (f *fakeUsecase) EnqueueScrape(ctx context.Context, link string) (int64, error) {
+	f.scraped = append(f.scraped, link)
+	return 42, nil
+}
+
+func (f *fakeUsecase) ListProduct(ctx context.Context, draw string, offset, limit int, filter usecase.ProductFilter) (usecase.PaginateData, error) {
+	total := int64(len(f.products))
+	data := usecase.PaginateData{RecordsTotal: total, RecordsFiltered: total}
+	if offset < len(f.products) {
+		data.Products = f.products[offset:]
+		if len(data.Products) > limit {
+			data.Products = data.Products[:limit]
+		}
+	}
+	return data, nil
+}
+
+func (f *fakeUsecase) GetProductDetail(ctx context.Context, id int64) (usecase.Product, error) {
+	if id != 1 {
+		return usecase.Product{}, sql.ErrNoRows
+	}
+	return usecase.Product{ID: 1, Name: "Kopi <Gula>", CurrentPrice: 45000, OriginalPrice: 60000, Discount: 25,
+		InStock: true, URL: "https://shop.example/kopi", LastCheckedAt: "2021-09-01 10:00:00"}, nil
+}
+
+func (f *fakeUsecase) ArchiveProduct(ctx context.Context, id int64) error {
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	f.archived = append(f.archived, id)
+	return nil
+}
+
+func (f *fakeUsecase) CreateAlertRule(ctx context.Context, payload usecase.AlertRulePayload) (usecase.AlertRule, error) {
+	if payload.Threshold > 1000000 {
+		return usecase.AlertRule{}, usecase.ValidationError{Field: "threshold", Message: "too high"}
+	}
+	f.rules = append(f.rules, payload)
+	return usecase.AlertRule{ID: 1}, nil
+}
+
+func TestReply(t *testing.T) {
+	ctx := context.Background()
+	uc := &fakeUsecase{products: []usecase.Product{
+		{ID: 1, Name: "Kopi <Gula>", CurrentPrice: 45000, InStock: true},
+		{ID: 2, Name: "Teh", CurrentPrice: 12000},
+	}}
+	b := New(uc, nil, nil, time.Second)
+
+	for _, test := range []struct {
+		command string
+		want    string
+	}{
+		{"/add https://shop.example/kopi", "Queued as job 42"},
+		{"/add", "Usage: /add &lt;link&gt;"},
+		{"/list", "#1 Kopi &lt;Gula&gt; - Rp. 45,000\n#2 Teh - Rp. 12,000 (out of stock)\n\nPage 1 of 1"},
+		{"/list 2", "There are only 2 products."},
+		{"/list@price_bot", "#1 Kopi"},
+		{"/price 1", "<b>Kopi &lt;Gula&gt;</b>\nPrice: <b>Rp. 45,000</b>, 25% off Rp. 60,000"},
+		{"/price 7", "There is no such product."},
+		{"/price kopi", "Usage: /price &lt;id&gt;"},
+		{"/remove #1", "Stopped watching #1."},
+		{"/alert 1 Rp45.000", "You will be alerted when #1 is at or below Rp. 45,000."},
+		{"/alert 1 5000000", "Cannot do that: too high"},
+		{"/alert 1", "Usage: /alert &lt;id&gt; &lt;price&gt;"},
+		{"hello", "Commands:"},
+	} {
+		got := b.reply(ctx, test.command)
+		if !strings.Contains(got, test.want) {
+			t.Errorf("reply(%q) = %q, want it to contain %q", test.command, got, test.want)
+		}
+	}
+
+	if len(uc.scraped) != 1 || uc.scraped[0] != "https://shop.example/kopi" {
+		t.Errorf("scraped links = %q", uc.scraped)
+	}
+	if len(uc.archived) != 1 || uc.archived[0] != 1 {
+		t.Errorf("archived products = %v", uc.archived)
+	}
+	if len(uc.rules) != 1 || uc.rules[0].ProductID != 1 || uc.rules[0].Kind != "below_target" ||
+		uc.rules[0].Threshold != 45000 {
+		t.Errorf("created alert rules = %+v", uc.rules)
+	}
+}
+
+// botAPI is a Bot API server holding the updates to hand out and the
+// messages the bot sent.
+type botAPI struct {
+	mu      sync.Mutex
+	updates []telegram.Update
+	offsets []int64
+	sent    []map[string]string
+}
+
+func (a *botAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var params map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&params)
+
+	var result interface{} = true
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+		offset := int64(params["offset"].(float64))
+		a.offsets = append(a.offsets, offset)
+		updates := []telegram.Update{}
+		for _, update := range a.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		result = updates
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		a.sent = append(a.sent, map[string]string{
+			"chat_id": params["chat_id"].(string),
+			"text":    params["text"].(string),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func TestRun(t *testing.T) {
+	api := &botAPI{updates: []telegram.Update{
+		{UpdateID: 10, Message: &telegram.Message{Chat: telegram.Chat{ID: 99}, Text: "/list"}},
+		{UpdateID: 11, Message: &telegram.Message{Chat: telegram.Chat{ID: 5}, Text: "/price 1"}},
+	}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := New(&fakeUsecase{}, telegram.NewClient(server.URL, "123:abc", time.Second), []int64{5}, 0)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		api.mu.Lock()
+		polled := len(api.offsets)
+		api.mu.Unlock()
+		if polled >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.offsets) < 2 || api.offsets[0] != 0 || api.offsets[1] != 12 {
+		t.Errorf("polled offsets = %v, want 0 then 12", api.offsets)
+	}
+	// Chat 99 is not allowed, so only chat 5 is answered.
+	if len(api.sent) != 1 || api.sent[0]["chat_id"] != "5" || !strings.Contains(api.sent[0]["text"], "Rp. 45,000") {
+		t.Errorf("sent messages = %v", api.sent)
+	}
+}
@@ -3,28 +3,61 @@ package cron
 import (
 	"context"
 	"log"
+	"time"
+
+	"github.com/ediprako/pricemonitor/usecase"
 )
 
 type usecaseProvider interface {
-	RefreshProductInformation(ctx context.Context) error
+	RefreshProductInformation(ctx context.Context) (usecase.RefreshRun, error)
+	RollupPriceHistory(ctx context.Context, retention time.Duration) error
+	SendDigests(ctx context.Context) (int, error)
 }
 type cron struct {
-	usecase usecaseProvider
+	usecase          usecaseProvider
+	historyRetention time.Duration
 }
 
-func New(usecase usecaseProvider) *cron {
+func New(usecase usecaseProvider, historyRetention time.Duration) *cron {
 	return &cron{
-		usecase: usecase,
+		usecase:          usecase,
+		historyRetention: historyRetention,
 	}
 }
 
-func (c *cron) CronRefreshProductInformation() error {
-	err := c.usecase.RefreshProductInformation(context.Background())
+func (c *cron) CronRefreshProductInformation(ctx context.Context) error {
+	run, err := c.usecase.RefreshProductInformation(ctx)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
+	if run.ID != 0 {
+		log.Printf("refresh run %d: attempted=%d succeeded=%d failed=%d changed=%d", run.ID, run.Attempted,
+			run.Succeeded, run.Failed, run.Changed)
+	}
 	log.Println("cron finished")
 	return nil
 }
+
+func (c *cron) CronRollupPriceHistory(ctx context.Context) error {
+	err := c.usecase.RollupPriceHistory(ctx, c.historyRetention)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	log.Println("price history rollup finished")
+	return nil
+}
+
+func (c *cron) CronSendDigests(ctx context.Context) error {
+	sent, err := c.usecase.SendDigests(ctx)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	log.Printf("sent %d digests", sent)
+	return nil
+}
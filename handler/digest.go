@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+	"github.com/ediprako/pricemonitor/notifier/format"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+func (h *handler) HandleDigestsView(w http.ResponseWriter, _ *http.Request) {
+	var tmpl = template.Must(template.ParseFiles(
+		path.Join("handler", "ui", "digests.html"),
+		path.Join("handler", "ui", "navbar.html"),
+	))
+
+	var data = map[string]interface{}{
+		"title": "Digests",
+	}
+
+	err := tmpl.ExecuteTemplate(w, "digests", data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleDigestReportView shows a sent digest the way the notifiers listed
+// it.
+func (h *handler) HandleDigestReportView(w http.ResponseWriter, r *http.Request) {
+	var tmpl = template.Must(template.ParseFiles(
+		path.Join("handler", "ui", "digest.html"),
+		path.Join("handler", "ui", "navbar.html"),
+	))
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.usecase.GetDigestReport(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var data = map[string]interface{}{
+		"report":   report,
+		"period":   format.Period(report.Summary.From, report.Summary.To),
+		"sections": format.Sections(*report.Summary),
+		"title":    report.Title,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "digest", data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) HandleListDigests(w http.ResponseWriter, r *http.Request) {
+	digests, err := h.usecase.ListDigests(r.Context())
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, digests, nil, http.StatusOK)
+}
+
+// HandleCreateDigest creates a digest from the JSON body, e.g.
+// {"collection_id": 1, "period": "weekly", "channels": ["email"]}.
+func (h *handler) HandleCreateDigest(w http.ResponseWriter, r *http.Request) {
+	var payload usecase.DigestPayload
+	err := decodeJSON(r, &payload)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	digest, err := h.usecase.CreateDigest(r.Context(), payload)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, digest, nil, http.StatusCreated)
+}
+
+func (h *handler) HandleDeleteDigest(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.DeleteDigest)
+}
+
+// HandleListDigestReports lists sent digests in DataTables form, only those
+// of digest_id when it is given.
+func (h *handler) HandleListDigestReports(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	length, _ := strconv.Atoi(r.FormValue("length"))
+	draw := r.FormValue("draw")
+	digestID, _ := strconv.ParseInt(r.FormValue("digest_id"), 10, 64)
+
+	paginated, err := h.usecase.ListDigestReports(r.Context(), draw, digestID, start, length)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPAjax(w, paginated, http.StatusOK)
+}
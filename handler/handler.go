@@ -2,12 +2,15 @@ package handler
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"path"
 	"strconv"
 
+	"github.com/gorilla/mux"
 	"github.com/microcosm-cc/bluemonday"
 
 	"github.com/ediprako/pricemonitor/usecase"
@@ -17,10 +20,47 @@ import (
 
 type usecaseProvider interface {
 	RegisterProduct(ctx context.Context, link string) (int64, error)
-	ListProduct(ctx context.Context, draw string, page, pagesize int) (usecase.PaginateData, error)
+	ListProduct(ctx context.Context, draw string, offset, limit int, filter usecase.ProductFilter) (usecase.PaginateData, error)
 	GetProductDetail(ctx context.Context, id int64) (usecase.Product, error)
-	ListPriceHistory(ctx context.Context, productID int64, limit int) ([]usecase.PriceHistory, error)
-	UpDatabase(ctx context.Context) error
+	ListPriceHistory(ctx context.Context, productID int64, limit int, days int) ([]usecase.PriceHistory, error)
+	RefreshProduct(ctx context.Context, id int64) (usecase.RefreshResult, error)
+	ArchiveProduct(ctx context.Context, id int64) error
+	RestoreProduct(ctx context.Context, id int64) error
+	PurgeProduct(ctx context.Context, id int64) error
+	UpdateProductSettings(ctx context.Context, id int64, patch usecase.ProductSettingsPatch) (usecase.Product, error)
+	ListMarketplaces(ctx context.Context) ([]string, error)
+	ListTags(ctx context.Context) ([]usecase.Tag, error)
+	TagProducts(ctx context.Context, productIDs []int64, add, remove []string) error
+	ListCollections(ctx context.Context) ([]usecase.Collection, error)
+	CreateCollection(ctx context.Context, name, description string) (usecase.Collection, error)
+	DeleteCollection(ctx context.Context, id int64) error
+	AddToCollection(ctx context.Context, id int64, productIDs []int64) error
+	RemoveFromCollection(ctx context.Context, id int64, productIDs []int64) error
+	ListAlertRules(ctx context.Context) ([]usecase.AlertRule, error)
+	CreateAlertRule(ctx context.Context, payload usecase.AlertRulePayload) (usecase.AlertRule, error)
+	UpdateAlertRule(ctx context.Context, id int64, payload usecase.AlertRulePayload) (usecase.AlertRule, error)
+	TestAlertRule(ctx context.Context, id int64) ([]usecase.AlertTestResult, error)
+	DeleteAlertRule(ctx context.Context, id int64) error
+	SnoozeAlertRule(ctx context.Context, id int64, minutes int) (usecase.AlertRule, error)
+	AcknowledgeAlertEvent(ctx context.Context, id int64) error
+	ListAlertEvents(ctx context.Context, draw string, productID int64, offset, limit int) (usecase.PaginateAlertEvent, error)
+	ListAlertDeliveries(ctx context.Context, draw string, productID int64, offset, limit int) (usecase.PaginateAlertDelivery, error)
+	AlertChannels() []string
+	ListWebhooks(ctx context.Context) ([]usecase.Webhook, error)
+	CreateWebhook(ctx context.Context, payload usecase.WebhookPayload) (usecase.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	ListWebhookDeliveries(ctx context.Context, draw string, webhookID int64, offset, limit int) (usecase.PaginateWebhookDelivery, error)
+	ListDigests(ctx context.Context) ([]usecase.Digest, error)
+	CreateDigest(ctx context.Context, payload usecase.DigestPayload) (usecase.Digest, error)
+	DeleteDigest(ctx context.Context, id int64) error
+	ListDigestReports(ctx context.Context, draw string, digestID int64, offset, limit int) (usecase.PaginateDigestReport, error)
+	GetDigestReport(ctx context.Context, id int64) (usecase.DigestReport, error)
+	EnqueueScrape(ctx context.Context, link string) (int64, error)
+	EnqueueRefresh(ctx context.Context, productID int64) (int64, error)
+	EnqueueImport(ctx context.Context, links []string) (int64, error)
+	GetJob(ctx context.Context, id int64) (usecase.Job, error)
+	ListRefreshRun(ctx context.Context, draw string, offset, limit int) (usecase.PaginateRefreshRun, error)
+	GetRefreshRunDetail(ctx context.Context, id int64) (usecase.RefreshRun, error)
 }
 type handler struct {
 	usecase usecaseProvider
@@ -32,6 +72,42 @@ func New(usecase usecaseProvider) *handler {
 	}
 }
 
+// errorStatus maps an error from the usecase to its HTTP status.
+func errorStatus(err error) int {
+	var validationErr usecase.ValidationError
+	var conflictErr usecase.ConflictError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	case errors.As(err, &conflictErr):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleIDAction runs action on the id in the path and responds with the id.
+func (h *handler) handleIDAction(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, id int64) error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	err = action(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, struct {
+		ID int64 `json:"id"`
+	}{id}, nil, http.StatusOK)
+}
+
 func (h *handler) HandleIndexView(w http.ResponseWriter, _ *http.Request) {
 	var tmpl = template.Must(template.ParseFiles(
 		path.Join("handler", "ui", "index.html"),
@@ -102,7 +178,7 @@ func (h *handler) HandleAddLink(w http.ResponseWriter, r *http.Request) {
 	p := bluemonday.UGCPolicy()
 	inputLink = p.Sanitize(inputLink)
 
-	id, err := h.usecase.RegisterProduct(r.Context(), inputLink)
+	jobID, err := h.usecase.EnqueueScrape(r.Context(), inputLink)
 	if err != nil {
 		log.Println(err)
 		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
@@ -110,16 +186,68 @@ func (h *handler) HandleAddLink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	httpHandler.WriteHTTPResponse(w, struct {
-		ID int64 `json:"id"`
-	}{id}, nil, http.StatusOK)
+		JobID int64 `json:"job_id"`
+	}{jobID}, nil, http.StatusAccepted)
 	return
 }
 
+func (h *handler) HandleRefreshProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("async") == "true" {
+		jobID, err := h.usecase.EnqueueRefresh(r.Context(), id)
+		if err != nil {
+			log.Println(err)
+			httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+			return
+		}
+
+		httpHandler.WriteHTTPResponse(w, struct {
+			JobID int64 `json:"job_id"`
+		}{jobID}, nil, http.StatusAccepted)
+		return
+	}
+
+	result, err := h.usecase.RefreshProduct(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, result, nil, http.StatusOK)
+}
+
 func (h *handler) HandleListProduct(w http.ResponseWriter, r *http.Request) {
 	start, _ := strconv.Atoi(r.FormValue("start"))
 	length, _ := strconv.Atoi(r.FormValue("length"))
 	draw := r.FormValue("draw")
-	paginated, err := h.usecase.ListProduct(r.Context(), draw, start, length)
+	collectionID, _ := strconv.ParseInt(r.FormValue("collection"), 10, 64)
+	minPrice, _ := strconv.ParseInt(r.FormValue("min_price"), 10, 64)
+	maxPrice, _ := strconv.ParseInt(r.FormValue("max_price"), 10, 64)
+	filter := usecase.ProductFilter{
+		Archived:     r.FormValue("archived") == "true",
+		Tag:          r.FormValue("tag"),
+		CollectionID: collectionID,
+		Search:       r.FormValue("search[value]"),
+		MinPrice:     minPrice,
+		MaxPrice:     maxPrice,
+		OnSale:       r.FormValue("on_sale") == "true",
+		Marketplace:  r.FormValue("marketplace"),
+	}
+
+	// DataTables sends the ordered column by index; its data name is the
+	// sort.
+	if column := r.FormValue("order[0][column]"); column != "" {
+		filter.Sort = r.FormValue("columns[" + column + "][data]")
+		filter.Descending = r.FormValue("order[0][dir]") == "desc"
+	}
+
+	paginated, err := h.usecase.ListProduct(r.Context(), draw, start, length, filter)
 	if err != nil {
 		log.Println(err)
 		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
@@ -137,8 +265,9 @@ func (h *handler) HandleListHistories(w http.ResponseWriter, r *http.Request) {
 	}
 
 	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	days, _ := strconv.Atoi(r.FormValue("days"))
 
-	histories, err := h.usecase.ListPriceHistory(r.Context(), productID, limit)
+	histories, err := h.usecase.ListPriceHistory(r.Context(), productID, limit, days)
 	if err != nil {
 		log.Println(err)
 		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
@@ -147,12 +276,3 @@ func (h *handler) HandleListHistories(w http.ResponseWriter, r *http.Request) {
 
 	httpHandler.WriteHTTPAjax(w, histories, http.StatusOK)
 }
-
-func (h *handler) HandleUpDatabase(ctx context.Context) error {
-	err := h.usecase.UpDatabase(ctx)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/microcosm-cc/bluemonday"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+)
+
+func (h *handler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.usecase.GetJob(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, job, nil, http.StatusOK)
+}
+
+// HandleImport queues a bulk import of newline separated links.
+func (h *handler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	p := bluemonday.UGCPolicy()
+
+	var links []string
+	for _, line := range strings.Split(r.FormValue("links"), "\n") {
+		link := strings.TrimSpace(p.Sanitize(line))
+		if link != "" {
+			links = append(links, link)
+		}
+	}
+
+	if len(links) == 0 {
+		httpHandler.WriteHTTPResponse(w, nil, errors.New("no links to import"), http.StatusBadRequest)
+		return
+	}
+
+	jobID, err := h.usecase.EnqueueImport(r.Context(), links)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, struct {
+		JobID int64 `json:"job_id"`
+	}{jobID}, nil, http.StatusAccepted)
+}
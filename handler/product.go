@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ediprako/pricemonitor/usecase"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+)
+
+func (h *handler) HandleArchiveProduct(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.ArchiveProduct)
+}
+
+func (h *handler) HandleRestoreProduct(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.RestoreProduct)
+}
+
+func (h *handler) HandlePurgeProduct(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.PurgeProduct)
+}
+
+// HandleGetProduct returns a product with its settings.
+func (h *handler) HandleGetProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.usecase.GetProductDetail(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, product, nil, http.StatusOK)
+}
+
+// HandleUpdateProductSettings changes the settings given in the JSON body and
+// returns the updated product.
+func (h *handler) HandleUpdateProductSettings(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	var patch usecase.ProductSettingsPatch
+	err = decodeJSON(r, &patch)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.usecase.UpdateProductSettings(r.Context(), id, patch)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, product, nil, http.StatusOK)
+}
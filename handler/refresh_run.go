@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+)
+
+func (h *handler) HandleRefreshRunsView(w http.ResponseWriter, _ *http.Request) {
+	var tmpl = template.Must(template.ParseFiles(
+		path.Join("handler", "ui", "runs.html"),
+		path.Join("handler", "ui", "navbar.html"),
+	))
+
+	var data = map[string]interface{}{
+		"title": "Refresh Runs",
+	}
+
+	err := tmpl.ExecuteTemplate(w, "runs", data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) HandleRefreshRunView(w http.ResponseWriter, r *http.Request) {
+	var tmpl = template.Must(template.ParseFiles(
+		path.Join("handler", "ui", "run.html"),
+		path.Join("handler", "ui", "navbar.html"),
+	))
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	run, err := h.usecase.GetRefreshRunDetail(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var data = map[string]interface{}{
+		"run":   run,
+		"title": "Refresh Run #" + strconv.FormatInt(run.ID, 10),
+	}
+
+	err = tmpl.ExecuteTemplate(w, "run", data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) HandleListRefreshRun(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	length, _ := strconv.Atoi(r.FormValue("length"))
+	draw := r.FormValue("draw")
+	paginated, err := h.usecase.ListRefreshRun(r.Context(), draw, start, length)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPAjax(w, paginated, http.StatusOK)
+}
+
+func (h *handler) HandleRefreshRunDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	run, err := h.usecase.GetRefreshRunDetail(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, run, nil, http.StatusOK)
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+)
+
+// decodeJSON reads the request body into v, rejecting unknown fields.
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+func (h *handler) HandleListMarketplaces(w http.ResponseWriter, r *http.Request) {
+	marketplaces, err := h.usecase.ListMarketplaces(r.Context())
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, marketplaces, nil, http.StatusOK)
+}
+
+func (h *handler) HandleListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.usecase.ListTags(r.Context())
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, tags, nil, http.StatusOK)
+}
+
+// HandleTagProducts adds and removes tags on several products, e.g.
+// {"product_ids": [1, 2], "add": ["coffee"], "remove": ["tea"]}.
+func (h *handler) HandleTagProducts(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ProductIDs []int64  `json:"product_ids"`
+		Add        []string `json:"add"`
+		Remove     []string `json:"remove"`
+	}
+	err := decodeJSON(r, &body)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.usecase.TagProducts(r.Context(), body.ProductIDs, body.Add, body.Remove)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, struct {
+		ProductIDs []int64 `json:"product_ids"`
+	}{body.ProductIDs}, nil, http.StatusOK)
+}
+
+func (h *handler) HandleListCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := h.usecase.ListCollections(r.Context())
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, collections, nil, http.StatusOK)
+}
+
+func (h *handler) HandleCreateCollection(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	err := decodeJSON(r, &body)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	collection, err := h.usecase.CreateCollection(r.Context(), body.Name, body.Description)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, collection, nil, http.StatusCreated)
+}
+
+func (h *handler) HandleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.DeleteCollection)
+}
+
+// HandleCollectionProducts adds the products in the body to the collection,
+// or removes them on DELETE.
+func (h *handler) HandleCollectionProducts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		ProductIDs []int64 `json:"product_ids"`
+	}
+	err = decodeJSON(r, &body)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = h.usecase.RemoveFromCollection(r.Context(), id, body.ProductIDs)
+	} else {
+		err = h.usecase.AddToCollection(r.Context(), id, body.ProductIDs)
+	}
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, struct {
+		ProductIDs []int64 `json:"product_ids"`
+	}{body.ProductIDs}, nil, http.StatusOK)
+}
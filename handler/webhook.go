@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	httpHandler "github.com/ediprako/pricemonitor/handler/http"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+func (h *handler) HandleWebhooksView(w http.ResponseWriter, _ *http.Request) {
+	var tmpl = template.Must(template.ParseFiles(
+		path.Join("handler", "ui", "webhooks.html"),
+		path.Join("handler", "ui", "navbar.html"),
+	))
+
+	var data = map[string]interface{}{
+		"title": "Webhooks",
+	}
+
+	err := tmpl.ExecuteTemplate(w, "webhooks", data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.usecase.ListWebhooks(r.Context())
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, webhooks, nil, http.StatusOK)
+}
+
+// HandleCreateWebhook creates a webhook from the JSON body, e.g.
+// {"url": "https://example.com/hook", "events": ["price_changed"]}. The
+// response is the only one showing its secret.
+func (h *handler) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload usecase.WebhookPayload
+	err := decodeJSON(r, &payload)
+	if err != nil {
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.usecase.CreateWebhook(r.Context(), payload)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, errorStatus(err))
+		return
+	}
+
+	httpHandler.WriteHTTPResponse(w, webhook, nil, http.StatusCreated)
+}
+
+func (h *handler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	h.handleIDAction(w, r, h.usecase.DeleteWebhook)
+}
+
+// HandleListWebhookDeliveries lists posted webhooks in DataTables form, only
+// those of webhook_id when it is given.
+func (h *handler) HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	length, _ := strconv.Atoi(r.FormValue("length"))
+	draw := r.FormValue("draw")
+	webhookID, _ := strconv.ParseInt(r.FormValue("webhook_id"), 10, 64)
+
+	paginated, err := h.usecase.ListWebhookDeliveries(r.Context(), draw, webhookID, start, length)
+	if err != nil {
+		log.Println(err)
+		httpHandler.WriteHTTPResponse(w, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	httpHandler.WriteHTTPAjax(w, paginated, http.StatusOK)
+}
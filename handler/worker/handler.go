@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// cancelGrace is how long jobs still running after the drain timeout get to
+// return once cancelled.
+const cancelGrace = 10 * time.Second
+
+type usecaseProvider interface {
+	ProcessNextJob(ctx context.Context) (bool, error)
+}
+type worker struct {
+	usecase      usecaseProvider
+	concurrency  int
+	pollInterval time.Duration
+	drainTimeout time.Duration
+}
+
+func New(usecase usecaseProvider, concurrency int, pollInterval, drainTimeout time.Duration) *worker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &worker{
+		usecase:      usecase,
+		concurrency:  concurrency,
+		pollInterval: pollInterval,
+		drainTimeout: drainTimeout,
+	}
+}
+
+// Run consumes queued jobs until ctx is done, then waits up to the drain
+// timeout for jobs in progress before cancelling them.
+func (w *worker) Run(ctx context.Context) error {
+	jobCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.poll(ctx, jobCtx)
+		}()
+	}
+
+	<-ctx.Done()
+	log.Println("worker stopping, waiting for running jobs")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("worker stopped")
+		return nil
+	case <-time.After(w.drainTimeout):
+		cancel()
+	}
+
+	// Cancelled jobs get a short while to return; one that ignores its
+	// context must not hold the shutdown forever.
+	select {
+	case <-done:
+		return fmt.Errorf("worker: running jobs cancelled after %s drain timeout", w.drainTimeout)
+	case <-time.After(cancelGrace):
+		return fmt.Errorf("worker: running jobs abandoned %s after cancelling them", cancelGrace)
+	}
+}
+
+func (w *worker) poll(ctx, jobCtx context.Context) {
+	for ctx.Err() == nil {
+		processed, err := w.usecase.ProcessNextJob(jobCtx)
+		if err != nil {
+			log.Println(err)
+		}
+		if processed && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.pollInterval):
+		}
+	}
+}
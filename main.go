@@ -7,90 +7,444 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/ediprako/pricemonitor/handler"
+	"github.com/ediprako/pricemonitor/handler/bot"
 	"github.com/ediprako/pricemonitor/handler/cron"
+	"github.com/ediprako/pricemonitor/handler/worker"
+	"github.com/ediprako/pricemonitor/notifier/email"
+	"github.com/ediprako/pricemonitor/notifier/slack"
+	"github.com/ediprako/pricemonitor/notifier/telegram"
+	"github.com/ediprako/pricemonitor/repository/memory"
+	"github.com/ediprako/pricemonitor/repository/migration"
+	"github.com/ediprako/pricemonitor/repository/model"
 	"github.com/ediprako/pricemonitor/repository/pgsql"
+	"github.com/ediprako/pricemonitor/repository/sqlite"
+	"github.com/ediprako/pricemonitor/scheduler"
 	"github.com/ediprako/pricemonitor/usecase"
 	"github.com/gorilla/mux"
-	"github.com/jasonlvhit/gocron"
 	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	mode := flag.String("mode", "http", "service mode (http,cron)")
+	mode := flag.String("mode", "http", "service mode (http,cron,worker,bot,all,migrate,cleanup)")
 	flag.Parse()
 
 	if *mode == "" {
 		*mode = "http"
 	}
 
+	var run func(ctx context.Context, store storage) error
 	switch *mode {
 	case "http":
-		mainHttp()
+		run = mainHttp
 	case "cron":
-		mainCron()
+		run = mainCron
+	case "worker":
+		run = mainWorker
+	case "bot":
+		run = mainBot
+	case "all":
+		run = mainAll
+	case "migrate":
+		run = mainMigrate
+	case "cleanup":
+		run = mainCleanup
 	default:
 		log.Fatal("unknown mode")
 	}
-}
 
-func mainCron() {
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Fatalf("Error loading .env file")
 	}
 
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	host := os.Getenv("DB_HOST")
-	dbport := os.Getenv("DB_PORT")
-	sslmode := os.Getenv("DB_SSLMODE")
+	store, err := openStorage(os.Getenv("DB_DRIVER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if store.db != nil {
+		defer store.db.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	db, err := settingDB(user, password, dbname, host, dbport, sslmode)
+	err = run(ctx, store)
 	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+// mainAll hosts the HTTP server, the scheduler and a job worker in one
+// process, and the Telegram bot when a bot token is set. When any of them
+// stops, the others are shut down as well.
+func mainAll(ctx context.Context, store storage) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	services := []func(ctx context.Context, store storage) error{mainHttp, mainCron, mainWorker}
+	if os.Getenv("TELEGRAM_BOT_TOKEN") != "" {
+		services = append(services, mainBot)
+	}
+	errs := make(chan error, len(services))
+	for _, service := range services {
+		go func(service func(ctx context.Context, store storage) error) {
+			errs <- service(ctx, store)
+		}(service)
+	}
+
+	err := <-errs
+	cancel()
+	for i := 1; i < len(services); i++ {
+		if errNext := <-errs; err == nil {
+			err = errNext
+		}
+	}
+
+	return err
+}
+
+// repository is everything the modes need from the storage backend.
+type repository interface {
+	usecase.DBProvider
+	CountOrphans(ctx context.Context) ([]model.OrphanCount, error)
+	DeleteOrphans(ctx context.Context) ([]model.OrphanCount, error)
+}
+
+// storage is the backend every mode runs on. db is nil for the in-memory
+// driver, which has no schema to migrate.
+type storage struct {
+	db   *sqlx.DB
+	repo repository
+}
+
+func openStorage(driver string) (storage, error) {
+	switch driver {
+	case "postgres", "":
+		user := os.Getenv("DB_USER")
+		password := os.Getenv("DB_PASSWORD")
+		dbname := os.Getenv("DB_NAME")
+		host := os.Getenv("DB_HOST")
+		dbport := os.Getenv("DB_PORT")
+		sslmode := os.Getenv("DB_SSLMODE")
+
+		db, err := settingDB(user, password, dbname, host, dbport, sslmode)
+		if err != nil {
+			return storage{}, err
+		}
+		return storage{db: db, repo: pgsql.New(db)}, nil
+	case "sqlite":
+		path := os.Getenv("DB_PATH")
+		if path == "" {
+			path = "pricemonitor.db" // Default file in the working directory if not specified
+		}
+
+		db, err := sqlite.Open(path)
+		if err != nil {
+			return storage{}, err
+		}
+		return storage{db: db, repo: sqlite.New(db)}, nil
+	case "memory":
+		return storage{repo: memory.New()}, nil
+	default:
+		return storage{}, fmt.Errorf("unknown DB_DRIVER %q (postgres, sqlite, memory)", driver)
+	}
+}
+
+// migrator returns the schema migrator for the driver the storage was
+// opened with.
+func (s storage) migrator() (*migration.Migrator, error) {
+	var migrations []migration.Migration
+	var err error
+	switch s.db.DriverName() {
+	case "sqlite":
+		migrations, err = sqlite.Migrations()
+	default:
+		migrations, err = pgsql.Migrations()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return migration.New(s.db, migrations), nil
+}
+
+// configuredNotifiers returns the alert notifiers set up in the environment.
+// Email is sent when SMTP_HOST is set, Telegram messages when
+// TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID are, and Slack messages when
+// SLACK_WEBHOOK_URL is.
+func configuredNotifiers() ([]usecase.Notifier, error) {
+	var notifiers []usecase.Notifier
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		config := email.Config{
+			Host:     host,
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+			TLS:      os.Getenv("SMTP_TLS"),
+		}
+		if config.Port == "" {
+			config.Port = "587" // Default submission port if not specified
+		}
+		for _, to := range strings.Split(os.Getenv("SMTP_TO"), ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				config.To = append(config.To, to)
+			}
+		}
+
+		switch {
+		case config.From == "":
+			return nil, fmt.Errorf("SMTP_FROM: required when SMTP_HOST is set")
+		case len(config.To) == 0:
+			return nil, fmt.Errorf("SMTP_TO: required when SMTP_HOST is set")
+		}
+		switch config.TLS {
+		case "", email.TLSStartTLS, email.TLSImplicit, email.TLSNone:
+		default:
+			return nil, fmt.Errorf("SMTP_TLS: unknown mode %q", config.TLS)
+		}
+		notifiers = append(notifiers, email.New(config))
+	}
+
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		config := telegram.Config{
+			BaseURL: os.Getenv("TELEGRAM_API_URL"),
+			Token:   token,
+			ChatID:  os.Getenv("TELEGRAM_CHAT_ID"),
+		}
+		if config.ChatID == "" {
+			return nil, fmt.Errorf("TELEGRAM_CHAT_ID: required when TELEGRAM_BOT_TOKEN is set")
+		}
+		notifiers = append(notifiers, telegram.New(config))
+	}
+
+	if webhookURL := os.Getenv("SLACK_WEBHOOK_URL"); webhookURL != "" {
+		notifiers = append(notifiers, slack.New(slack.Config{WebhookURL: webhookURL}))
+	}
+
+	return notifiers, nil
+}
+
+// mainMigrate runs the migrate command given as the first argument:
+// up, down or status.
+func mainMigrate(ctx context.Context, store storage) error {
+	if store.db == nil {
+		return fmt.Errorf("the in-memory store has no schema to migrate")
+	}
+
+	m, err := store.migrator()
+	if err != nil {
+		return err
+	}
+
+	switch flag.Arg(0) {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mg := range applied {
+			fmt.Printf("applied %d_%s\n", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		mg, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d_%s\n", mg.Version, mg.Name)
+	case "status", "":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (up, down, status)", flag.Arg(0))
+	}
+
+	return nil
+}
+
+// mainCleanup reports price history, image and refresh run rows whose
+// product or run no longer exists, and deletes them when the first argument
+// is "remove".
+func mainCleanup(ctx context.Context, store storage) error {
+	repoDB := store.repo
+
+	var counts []model.OrphanCount
+	var err error
+	switch flag.Arg(0) {
+	case "remove":
+		counts, err = repoDB.DeleteOrphans(ctx)
+	case "report", "":
+		counts, err = repoDB.CountOrphans(ctx)
+	default:
+		return fmt.Errorf("unknown cleanup command %q (report, remove)", flag.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	action := "orphaned"
+	if flag.Arg(0) == "remove" {
+		action = "removed"
+	}
+	for _, count := range counts {
+		fmt.Printf("%s: %d %s rows\n", count.Table, count.Rows, action)
+	}
+
+	return nil
+}
+
+func mainCron(ctx context.Context, store storage) error {
+	notifiers, err := configuredNotifiers()
+	if err != nil {
+		return err
+	}
+	uc := usecase.New(store.repo, notifiers...)
+
+	retentionDays := 365 // Default keep one year of raw history if not specified
+	if days := os.Getenv("HISTORY_RETENTION_DAYS"); days != "" {
+		retentionDays, err = strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("HISTORY_RETENTION_DAYS: %v", err)
+		}
+	}
+	c := cron.New(uc, time.Duration(retentionDays)*24*time.Hour)
+
+	refreshSchedule := os.Getenv("REFRESH_SCHEDULE")
+	if refreshSchedule == "" {
+		refreshSchedule = "* * * * *" // Default every minute if not specified
+	}
+
+	rollupSchedule := os.Getenv("ROLLUP_SCHEDULE")
+	if rollupSchedule == "" {
+		rollupSchedule = "15 3 * * *" // Default daily at 03:15 if not specified
+	}
+
+	digestSchedule := os.Getenv("DIGEST_SCHEDULE")
+	if digestSchedule == "" {
+		digestSchedule = "0 7 * * *" // Default daily at 07:00 if not specified
+	}
 
-	repoDB := pgsql.New(db)
-	uc := usecase.New(repoDB)
-	c := cron.New(uc)
-	gocron.Every(1).Minutes().Do(c.CronRefreshProductInformation)
+	s := scheduler.New(shutdownTimeout)
+	err = s.Add("refresh-product", refreshSchedule, c.CronRefreshProductInformation)
+	if err != nil {
+		return err
+	}
+
+	err = s.Add("rollup-history", rollupSchedule, c.CronRollupPriceHistory)
+	if err != nil {
+		return err
+	}
+
+	err = s.Add("send-digests", digestSchedule, c.CronSendDigests)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("starting cron..")
 
-	<-gocron.Start()
+	return s.Run(ctx)
 }
 
-func mainHttp() {
-	err := godotenv.Load(".env")
+func mainWorker(ctx context.Context, store storage) error {
+	notifiers, err := configuredNotifiers()
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		return err
+	}
+	uc := usecase.New(store.repo, notifiers...)
+
+	concurrency, _ := strconv.Atoi(os.Getenv("WORKER_CONCURRENCY"))
+	wk := worker.New(uc, concurrency, time.Second, shutdownTimeout)
+
+	fmt.Println("starting worker..")
+
+	return wk.Run(ctx)
+}
+
+// botPollTimeout is how long a poll of the Bot API waits for commands.
+const botPollTimeout = 50 * time.Second
+
+func mainBot(ctx context.Context, store storage) error {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return fmt.Errorf("TELEGRAM_BOT_TOKEN: required to run the bot")
 	}
 
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	host := os.Getenv("DB_HOST")
-	dbport := os.Getenv("DB_PORT")
-	sslmode := os.Getenv("DB_SSLMODE")
+	// Only the chats listed may use the bot; by default the alert chat.
+	chatList := os.Getenv("TELEGRAM_BOT_CHATS")
+	if chatList == "" {
+		chatList = os.Getenv("TELEGRAM_CHAT_ID")
+	}
+	var chats []int64
+	for _, chat := range strings.Split(chatList, ",") {
+		if chat = strings.TrimSpace(chat); chat == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(chat, 10, 64)
+		if err != nil {
+			return fmt.Errorf("TELEGRAM_BOT_CHATS: %q is not a chat id", chat)
+		}
+		chats = append(chats, id)
+	}
+	if len(chats) == 0 {
+		return fmt.Errorf("TELEGRAM_BOT_CHATS: required to run the bot")
+	}
 
-	db, err := settingDB(user, password, dbname, host, dbport, sslmode)
+	notifiers, err := configuredNotifiers()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	uc := usecase.New(store.repo, notifiers...)
 
-	repoDB := pgsql.New(db)
-	uc := usecase.New(repoDB)
-	h := handler.New(uc)
+	client := telegram.NewClient(os.Getenv("TELEGRAM_API_URL"), token, botPollTimeout+10*time.Second)
+	b := bot.New(uc, client, chats, botPollTimeout)
+
+	fmt.Println("starting bot..")
+
+	return b.Run(ctx)
+}
 
-	err = h.HandleUpDatabase(context.Background())
+func mainHttp(ctx context.Context, store storage) error {
+	notifiers, err := configuredNotifiers()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	uc := usecase.New(store.repo, notifiers...)
+	h := handler.New(uc)
+
+	if store.db != nil {
+		m, err := store.migrator()
+		if err != nil {
+			return err
+		}
+
+		err = m.EnsureCurrent(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	r := mux.NewRouter()
@@ -101,8 +455,50 @@ func mainHttp() {
 	r.HandleFunc("/listview", h.HandleListView).Methods(http.MethodGet)
 	r.HandleFunc("/list/product", h.HandleListProduct).Methods(http.MethodGet)
 	r.HandleFunc("/addlink", h.HandleAddLink).Methods(http.MethodPost)
+	r.HandleFunc("/import", h.HandleImport).Methods(http.MethodPost)
+	r.HandleFunc("/jobs/{id:[0-9]+}", h.HandleGetJob).Methods(http.MethodGet)
 	r.HandleFunc("/detailview", h.HandleDetailView).Methods(http.MethodGet)
+	r.HandleFunc("/products/{id:[0-9]+}/refresh", h.HandleRefreshProduct).Methods(http.MethodPost)
+	r.HandleFunc("/products/{id:[0-9]+}/archive", h.HandleArchiveProduct).Methods(http.MethodPost)
+	r.HandleFunc("/products/{id:[0-9]+}/restore", h.HandleRestoreProduct).Methods(http.MethodPost)
+	r.HandleFunc("/products/{id:[0-9]+}", h.HandlePurgeProduct).Methods(http.MethodDelete)
+	r.HandleFunc("/api/products/{id:[0-9]+}", h.HandleGetProduct).Methods(http.MethodGet)
+	r.HandleFunc("/api/products/{id:[0-9]+}", h.HandleUpdateProductSettings).Methods(http.MethodPatch)
+	r.HandleFunc("/api/products/tags", h.HandleTagProducts).Methods(http.MethodPost)
+	r.HandleFunc("/api/tags", h.HandleListTags).Methods(http.MethodGet)
+	r.HandleFunc("/api/marketplaces", h.HandleListMarketplaces).Methods(http.MethodGet)
+	r.HandleFunc("/api/collections", h.HandleListCollections).Methods(http.MethodGet)
+	r.HandleFunc("/api/collections", h.HandleCreateCollection).Methods(http.MethodPost)
+	r.HandleFunc("/api/collections/{id:[0-9]+}", h.HandleDeleteCollection).Methods(http.MethodDelete)
+	r.HandleFunc("/api/collections/{id:[0-9]+}/products", h.HandleCollectionProducts).
+		Methods(http.MethodPost, http.MethodDelete)
+	r.HandleFunc("/admin/alerts", h.HandleAlertsView).Methods(http.MethodGet)
+	r.HandleFunc("/api/alerts/rules", h.HandleListAlertRules).Methods(http.MethodGet)
+	r.HandleFunc("/api/alerts/rules", h.HandleCreateAlertRule).Methods(http.MethodPost)
+	r.HandleFunc("/api/alerts/rules/{id:[0-9]+}", h.HandleUpdateAlertRule).Methods(http.MethodPut)
+	r.HandleFunc("/api/alerts/rules/{id:[0-9]+}", h.HandleDeleteAlertRule).Methods(http.MethodDelete)
+	r.HandleFunc("/api/alerts/rules/{id:[0-9]+}/test", h.HandleTestAlertRule).Methods(http.MethodPost)
+	r.HandleFunc("/api/alerts/rules/{id:[0-9]+}/snooze", h.HandleSnoozeAlertRule).Methods(http.MethodPost)
+	r.HandleFunc("/api/alerts/events", h.HandleListAlertEvents).Methods(http.MethodGet)
+	r.HandleFunc("/api/alerts/events/{id:[0-9]+}/acknowledge", h.HandleAcknowledgeAlertEvent).Methods(http.MethodPost)
+	r.HandleFunc("/api/alerts/deliveries", h.HandleListAlertDeliveries).Methods(http.MethodGet)
+	r.HandleFunc("/api/alerts/channels", h.HandleListAlertChannels).Methods(http.MethodGet)
+	r.HandleFunc("/admin/webhooks", h.HandleWebhooksView).Methods(http.MethodGet)
+	r.HandleFunc("/api/webhooks", h.HandleListWebhooks).Methods(http.MethodGet)
+	r.HandleFunc("/api/webhooks", h.HandleCreateWebhook).Methods(http.MethodPost)
+	r.HandleFunc("/api/webhooks/{id:[0-9]+}", h.HandleDeleteWebhook).Methods(http.MethodDelete)
+	r.HandleFunc("/api/webhooks/deliveries", h.HandleListWebhookDeliveries).Methods(http.MethodGet)
+	r.HandleFunc("/admin/digests", h.HandleDigestsView).Methods(http.MethodGet)
+	r.HandleFunc("/digests/reports/{id:[0-9]+}", h.HandleDigestReportView).Methods(http.MethodGet)
+	r.HandleFunc("/api/digests", h.HandleListDigests).Methods(http.MethodGet)
+	r.HandleFunc("/api/digests", h.HandleCreateDigest).Methods(http.MethodPost)
+	r.HandleFunc("/api/digests/{id:[0-9]+}", h.HandleDeleteDigest).Methods(http.MethodDelete)
+	r.HandleFunc("/api/digests/reports", h.HandleListDigestReports).Methods(http.MethodGet)
 	r.HandleFunc("/histories", h.HandleListHistories).Methods(http.MethodGet)
+	r.HandleFunc("/admin/runs", h.HandleRefreshRunsView).Methods(http.MethodGet)
+	r.HandleFunc("/admin/runs/{id:[0-9]+}", h.HandleRefreshRunView).Methods(http.MethodGet)
+	r.HandleFunc("/api/runs", h.HandleListRefreshRun).Methods(http.MethodGet)
+	r.HandleFunc("/api/runs/{id:[0-9]+}", h.HandleRefreshRunDetail).Methods(http.MethodGet)
 	r.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("pong"))
 		return
@@ -120,7 +516,21 @@ func mainHttp() {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
 }
 
 func settingDB(user, password, dbname, host, port, ssl string) (*sqlx.DB, error) {
@@ -0,0 +1,294 @@
+// Package email delivers fired alerts and digests as email over SMTP.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"embed"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/ediprako/pricemonitor/notifier/format"
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// TLS modes: STARTTLS upgrades a plain connection and is required when
+// chosen, implicit TLS connects over TLS (usually port 465), and none sends
+// in the clear.
+const (
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+	TLSNone     = "none"
+)
+
+const defaultTimeout = 30 * time.Second
+
+//go:embed templates
+var templates embed.FS
+
+var (
+	htmlAlert  = htmlTemplate.Must(htmlTemplate.ParseFS(templates, "templates/alert.html"))
+	textAlert  = textTemplate.Must(textTemplate.ParseFS(templates, "templates/alert.txt"))
+	htmlDigest = htmlTemplate.Must(htmlTemplate.ParseFS(templates, "templates/digest.html"))
+	textDigest = textTemplate.Must(textTemplate.ParseFS(templates, "templates/digest.txt"))
+)
+
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	To       []string
+	TLS      string
+	// Timeout bounds a whole send; zero means 30 seconds.
+	Timeout time.Duration
+}
+
+type notifier struct {
+	config Config
+}
+
+func New(config Config) *notifier {
+	if config.TLS == "" {
+		config.TLS = TLSStartTLS
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	return &notifier{
+		config: config,
+	}
+}
+
+func (n *notifier) Channel() string {
+	return "email"
+}
+
+// Notify mails the alert to every recipient at once.
+func (n *notifier) Notify(ctx context.Context, notification model.Notification) error {
+	message, err := n.message(notification, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+
+	return n.send(ctx, message)
+}
+
+// NotifyDigest mails the digest to every recipient at once.
+func (n *notifier) NotifyDigest(ctx context.Context, summary model.DigestSummary) error {
+	message, err := n.digestMessage(summary, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+
+	return n.send(ctx, message)
+}
+
+// alertData is what the templates show; prices are already formatted.
+type alertData struct {
+	Name          string
+	Message       string
+	URL           string
+	Image         string
+	Price         string
+	PreviousPrice string
+	OriginalPrice string
+}
+
+// message renders the alert as a mail.
+func (n *notifier) message(notification model.Notification, now time.Time) ([]byte, error) {
+	data := alertData{
+		Name:          notification.ProductName,
+		Message:       notification.Message,
+		URL:           notification.URL,
+		Image:         notification.Image,
+		Price:         format.Price(notification.Price),
+		PreviousPrice: format.Price(notification.PreviousPrice),
+	}
+	if notification.OriginalPrice > notification.Price {
+		data.OriginalPrice = format.Price(notification.OriginalPrice)
+	}
+
+	messageID := fmt.Sprintf("alert-%d.%d", notification.EventID, now.UnixNano())
+	return n.compose("Price alert: "+notification.ProductName, messageID, now, func(w io.Writer) error {
+		return textAlert.Execute(w, data)
+	}, func(w io.Writer) error {
+		return htmlAlert.Execute(w, data)
+	})
+}
+
+// digestData is what the digest templates show.
+type digestData struct {
+	Title    string
+	Period   string
+	Products int
+	Sections []format.Section
+}
+
+func (n *notifier) digestMessage(summary model.DigestSummary, now time.Time) ([]byte, error) {
+	data := digestData{
+		Title:    summary.Title,
+		Period:   format.Period(summary.From, summary.To),
+		Products: summary.Products,
+		Sections: format.Sections(summary),
+	}
+
+	messageID := fmt.Sprintf("digest.%d", now.UnixNano())
+	return n.compose(summary.Title, messageID, now, func(w io.Writer) error {
+		return textDigest.Execute(w, data)
+	}, func(w io.Writer) error {
+		return htmlDigest.Execute(w, data)
+	})
+}
+
+// compose builds a multipart/alternative mail with a text and an HTML part.
+func (n *notifier) compose(subject, messageID string, now time.Time, text, html func(w io.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	var headers bytes.Buffer
+	for _, field := range [][2]string{
+		{"From", n.config.From},
+		{"To", strings.Join(n.config.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", messageID, n.config.Host)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + body.Boundary()},
+	} {
+		fmt.Fprintf(&headers, "%s: %s\r\n", field[0], field[1])
+	}
+	headers.WriteString("\r\n")
+
+	// Clients show the last part they can display, so HTML comes last.
+	err := writePart(body, "text/plain", text)
+	if err != nil {
+		return nil, err
+	}
+	err = writePart(body, "text/html", html)
+	if err != nil {
+		return nil, err
+	}
+	err = body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(headers.Bytes(), buf.Bytes()...), nil
+}
+
+func writePart(body *multipart.Writer, contentType string, render func(w io.Writer) error) error {
+	part, err := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	w := quotedprintable.NewWriter(part)
+	err = render(w)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+func (n *notifier) send(ctx context.Context, message []byte) error {
+	addr := net.JoinHostPort(n.config.Host, n.config.Port)
+	tlsConfig := &tls.Config{ServerName: n.config.Host}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if n.config.TLS == TLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.config.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The envelope takes bare addresses, without the display names the
+	// headers may have.
+	from, err := mail.ParseAddress(n.config.From)
+	if err != nil {
+		return err
+	}
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	for _, to := range n.config.To {
+		rcpt, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		err = client.Rcpt(rcpt.Address)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,266 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// fakeServer is an SMTP server that keeps the last mail it was sent. It
+// offers AUTH PLAIN but no STARTTLS.
+type fakeServer struct {
+	host, port string
+	// dataReply answers the end of a mail; empty accepts it.
+	dataReply string
+
+	mu   sync.Mutex
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeServer{}
+	s.host, s.port, _ = net.SplitHostPort(listener.Addr().String())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+
+	tp.PrintfLine("220 localhost fake smtp")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			tp.PrintfLine("500 empty command")
+			continue
+		}
+
+		s.mu.Lock()
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.auth = string(credentials)
+			tp.PrintfLine("235 2.7.0 accepted")
+		case "MAIL":
+			s.from = line
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = append(s.to, line)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			s.data, _ = tp.ReadDotBytes()
+			if s.dataReply != "" {
+				tp.PrintfLine("%s", s.dataReply)
+			} else {
+				tp.PrintfLine("250 queued")
+			}
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeServer) config() Config {
+	return Config{
+		Host:     s.host,
+		Port:     s.port,
+		Username: "monitor",
+		Password: "secret",
+		From:     "Price Monitor <monitor@example.com>",
+		To:       []string{"a@example.com", "B <b@example.com>"},
+		TLS:      TLSNone,
+		Timeout:  5 * time.Second,
+	}
+}
+
+var notification = model.Notification{
+	EventID:       7,
+	Kind:          model.AlertKindBelowTarget,
+	Message:       "Kopi & Gula is Rp. 45,000, at or below the target of Rp. 46,000",
+	ProductID:     1,
+	ProductName:   "Kopi & Gula",
+	URL:           "https://shop.example/kopi",
+	Image:         "https://shop.example/kopi.jpg",
+	Price:         45000,
+	PreviousPrice: 50000,
+	OriginalPrice: 60000,
+}
+
+func TestNotify(t *testing.T) {
+	s := newFakeServer(t)
+
+	err := New(s.config()).Notify(context.Background(), notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.auth != "\x00monitor\x00secret" {
+		t.Errorf("AUTH PLAIN credentials = %q", s.auth)
+	}
+	if !strings.Contains(s.from, "<monitor@example.com>") || len(s.to) != 2 ||
+		!strings.Contains(s.to[1], "<b@example.com>") {
+		t.Errorf("envelope = %q, %q", s.from, s.to)
+	}
+
+	msg, parts := readMail(t, s.data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Price alert: Kopi & Gula" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if msg.Header.Get("To") != "a@example.com, B <b@example.com>" {
+		t.Errorf("To = %q", msg.Header.Get("To"))
+	}
+
+	text := parts["text/plain"]
+	for _, want := range []string{"Kopi & Gula", "Rp. 45,000", "Rp. 50,000", "Rp. 60,000", "https://shop.example/kopi"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part is missing %q:\n%s", want, text)
+		}
+	}
+	html := parts["text/html"]
+	for _, want := range []string{"Kopi &amp; Gula", `<img src="https://shop.example/kopi.jpg"`,
+		`href="https://shop.example/kopi"`, "<strong>Rp. 45,000</strong>", "<s>Rp. 50,000</s>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML part is missing %q:\n%s", want, html)
+		}
+	}
+}
+
+// readMail parses a multipart/alternative mail into its header and its parts
+// by content type.
+func readMail(t *testing.T, data []byte) (*mail.Message, map[string]string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", mediaType, err)
+	}
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+
+	return msg, parts
+}
+
+func TestNotifyDigest(t *testing.T) {
+	s := newFakeServer(t)
+	summary := model.DigestSummary{
+		Title:    "Daily digest: breakfast",
+		Period:   model.DigestPeriodDaily,
+		From:     time.Date(2021, 9, 1, 7, 0, 0, 0, time.UTC),
+		To:       time.Date(2021, 9, 2, 7, 0, 0, 0, time.UTC),
+		Products: 3,
+		Drops: []model.DigestItem{{ProductID: 1, Name: "Kopi & Gula", URL: "https://shop.example/kopi",
+			Price: 45000, PreviousPrice: 50000}},
+	}
+
+	err := New(s.config()).NotifyDigest(context.Background(), summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, parts := readMail(t, s.data)
+	if msg.Header.Get("Subject") != "Daily digest: breakfast" {
+		t.Errorf("Subject = %q", msg.Header.Get("Subject"))
+	}
+	for _, want := range []string{"1 Sep 2021 07:00 to 2 Sep 2021 07:00 UTC, 3 products", "Biggest drops",
+		"- Kopi & Gula: Rp. 45,000, down Rp. 5,000 (-10%) from Rp. 50,000"} {
+		if !strings.Contains(parts["text/plain"], want) {
+			t.Errorf("text part is missing %q:\n%s", want, parts["text/plain"])
+		}
+	}
+	if want := `<a href="https://shop.example/kopi">Kopi &amp; Gula</a>`; !strings.Contains(parts["text/html"], want) {
+		t.Errorf("HTML part is missing %q:\n%s", want, parts["text/html"])
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	s := newFakeServer(t)
+	s.dataReply = "554 5.7.1 message rejected"
+
+	err := New(s.config()).Notify(context.Background(), notification)
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("Notify() to a server rejecting the mail = %v", err)
+	}
+}
+
+func TestNotifyRequiresStartTLS(t *testing.T) {
+	s := newFakeServer(t)
+	config := s.config()
+	config.TLS = ""
+
+	err := New(config).Notify(context.Background(), notification)
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Notify() without STARTTLS on the server = %v", err)
+	}
+}
+
+func TestNotifyUnreachable(t *testing.T) {
+	config := Config{Host: "127.0.0.1", Port: "1", From: "monitor@example.com", To: []string{"a@example.com"}}
+
+	err := New(config).Notify(context.Background(), notification)
+	if err == nil {
+		t.Error("Notify() to a closed port succeeded")
+	}
+}
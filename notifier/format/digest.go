@@ -0,0 +1,60 @@
+package format
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const periodLayout = "2 Jan 2006 15:04"
+
+// Period describes the time a digest covers, in UTC.
+func Period(from, to time.Time) string {
+	return from.UTC().Format(periodLayout) + " to " + to.UTC().Format(periodLayout) + " UTC"
+}
+
+// Section is a titled list of a digest, in the order every notifier shows
+// them.
+type Section struct {
+	Title string
+	Lines []Line
+}
+
+// Line is a product in a section; Detail says what happened to it.
+type Line struct {
+	Name   string
+	URL    string
+	Detail string
+}
+
+// Sections lists the sections of a digest that have any product.
+func Sections(summary model.DigestSummary) []Section {
+	var sections []Section
+	add := func(title string, items []model.DigestItem, detail func(model.DigestItem) string) {
+		if len(items) == 0 {
+			return
+		}
+		section := Section{Title: title}
+		for _, item := range items {
+			section.Lines = append(section.Lines, Line{Name: item.Name, URL: item.URL, Detail: detail(item)})
+		}
+		sections = append(sections, section)
+	}
+
+	add("Biggest drops", summary.Drops, func(item model.DigestItem) string {
+		return fmt.Sprintf("%s, %s from %s", Price(item.Price), Change(item.PreviousPrice, item.Price),
+			Price(item.PreviousPrice))
+	})
+	add("New lows", summary.NewLows, func(item model.DigestItem) string {
+		return fmt.Sprintf("%s, the low was %s", Price(item.Price), Price(item.PreviousPrice))
+	})
+	add("Back in stock", summary.BackInStock, func(item model.DigestItem) string {
+		return Price(item.Price)
+	})
+	add("Broken links", summary.Broken, func(item model.DigestItem) string {
+		return item.Error
+	})
+
+	return sections
+}
@@ -0,0 +1,28 @@
+// Package format formats prices and digests the same way for every
+// notifier.
+package format
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+)
+
+func Price(price int64) string {
+	return "Rp. " + humanize.Comma(price)
+}
+
+// Change describes how a price moved from the previous one, e.g.
+// "down Rp. 5,000 (-10%)". It is empty when there is nothing to compare.
+func Change(previousPrice, price int64) string {
+	if previousPrice <= 0 || price == previousPrice {
+		return ""
+	}
+
+	delta := price - previousPrice
+	percent := delta * 100 / previousPrice
+	if delta < 0 {
+		return fmt.Sprintf("down %s (%d%%)", Price(-delta), percent)
+	}
+	return fmt.Sprintf("up %s (+%d%%)", Price(delta), percent)
+}
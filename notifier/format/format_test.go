@@ -0,0 +1,42 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func TestChange(t *testing.T) {
+	for _, test := range []struct {
+		previous, price int64
+		want            string
+	}{
+		{50000, 45000, "down Rp. 5,000 (-10%)"},
+		{40000, 50000, "up Rp. 10,000 (+25%)"},
+		{50000, 50000, ""},
+		{0, 50000, ""},
+	} {
+		got := Change(test.previous, test.price)
+		if got != test.want {
+			t.Errorf("Change(%d, %d) = %q, want %q", test.previous, test.price, got, test.want)
+		}
+	}
+}
+
+func TestSections(t *testing.T) {
+	summary := model.DigestSummary{
+		Drops:  []model.DigestItem{{Name: "Kopi", Price: 45000, PreviousPrice: 50000}},
+		Broken: []model.DigestItem{{Name: "Teh", Error: "status 404"}},
+	}
+
+	sections := Sections(summary)
+	if len(sections) != 2 || sections[0].Title != "Biggest drops" || sections[1].Title != "Broken links" {
+		t.Fatalf("Sections() = %+v", sections)
+	}
+	if got := sections[0].Lines[0].Detail; got != "Rp. 45,000, down Rp. 5,000 (-10%) from Rp. 50,000" {
+		t.Errorf("drop detail = %q", got)
+	}
+	if got := sections[1].Lines[0].Detail; got != "status 404" {
+		t.Errorf("broken link detail = %q", got)
+	}
+}
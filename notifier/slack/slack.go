@@ -0,0 +1,134 @@
+// Package slack delivers fired alerts and digests to a Slack incoming webhook,
+// or to any chat accepting the same payload, such as Mattermost.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ediprako/pricemonitor/notifier/format"
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Config struct {
+	// WebhookURL is the incoming webhook the alerts are posted to.
+	WebhookURL string
+	Timeout    time.Duration
+}
+
+type notifier struct {
+	config Config
+	client *http.Client
+}
+
+func New(config Config) *notifier {
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	return &notifier{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+func (n *notifier) Channel() string {
+	return "slack"
+}
+
+type payload struct {
+	Text string `json:"text"`
+}
+
+// Notify posts the alert to the webhook.
+func (n *notifier) Notify(ctx context.Context, notification model.Notification) error {
+	return n.post(ctx, message(notification))
+}
+
+// NotifyDigest posts the digest to the webhook.
+func (n *notifier) NotifyDigest(ctx context.Context, summary model.DigestSummary) error {
+	return n.post(ctx, digestMessage(summary))
+}
+
+func (n *notifier) post(ctx context.Context, text string) error {
+	body, err := json.Marshal(payload{Text: text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("slack: invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The webhook URL is a secret, so it is left out of the error.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("slack: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("slack: %s: %s", resp.Status, bytes.TrimSpace(excerpt))
+	}
+
+	return nil
+}
+
+// escaper escapes the characters Slack's mrkdwn treats as markup.
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// message formats an alert in Slack's mrkdwn.
+func message(notification model.Notification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*<%s|%s>*\n%s\n", escaper.Replace(notification.URL),
+		escaper.Replace(notification.ProductName), escaper.Replace(notification.Message))
+
+	fmt.Fprintf(&b, "Price: *%s*", format.Price(notification.Price))
+	if change := format.Change(notification.PreviousPrice, notification.Price); change != "" {
+		fmt.Fprintf(&b, ", %s from %s", change, format.Price(notification.PreviousPrice))
+	}
+	if notification.OriginalPrice > notification.Price {
+		fmt.Fprintf(&b, "\nOriginal price: ~%s~", format.Price(notification.OriginalPrice))
+	}
+
+	return b.String()
+}
+
+// digestMessage formats a digest in Slack's mrkdwn.
+func digestMessage(summary model.DigestSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n%s, %d products\n", escaper.Replace(summary.Title),
+		format.Period(summary.From, summary.To), summary.Products)
+
+	sections := format.Sections(summary)
+	if len(sections) == 0 {
+		b.WriteString("\nNothing changed.")
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&b, "\n*%s*\n", section.Title)
+		for _, line := range section.Lines {
+			fmt.Fprintf(&b, "• <%s|%s>: %s\n", escaper.Replace(line.URL), escaper.Replace(line.Name),
+				escaper.Replace(line.Detail))
+		}
+	}
+
+	return b.String()
+}
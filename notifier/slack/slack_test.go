@@ -0,0 +1,91 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+var notification = model.Notification{
+	EventID:       7,
+	Kind:          model.AlertKindPercentDrop,
+	Message:       "Kopi & Gula dropped 10% to Rp. 45,000 from Rp. 50,000 within 24 hours",
+	ProductID:     1,
+	ProductName:   "Kopi & Gula",
+	URL:           "https://shop.example/kopi",
+	Price:         45000,
+	PreviousPrice: 50000,
+	OriginalPrice: 60000,
+}
+
+var summary = model.DigestSummary{
+	Title:    "Daily digest: breakfast",
+	Period:   model.DigestPeriodDaily,
+	From:     time.Date(2021, 9, 1, 7, 0, 0, 0, time.UTC),
+	To:       time.Date(2021, 9, 2, 7, 0, 0, 0, time.UTC),
+	Products: 3,
+	Drops: []model.DigestItem{{ProductID: 1, Name: notification.ProductName, URL: notification.URL,
+		Price: 45000, PreviousPrice: 50000}},
+	Broken: []model.DigestItem{{ProductID: 2, Name: "Teh", URL: "https://shop.example/teh", Error: "status 404"}},
+}
+
+func TestNotify(t *testing.T) {
+	var sent payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(Config{WebhookURL: server.URL + "/services/T/B/X"}).Notify(context.Background(), notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"*<https://shop.example/kopi|Kopi &amp; Gula>*", "Price: *Rp. 45,000*",
+		"down Rp. 5,000 (-10%) from Rp. 50,000", "~Rp. 60,000~"} {
+		if !strings.Contains(sent.Text, want) {
+			t.Errorf("message is missing %q:\n%s", want, sent.Text)
+		}
+	}
+}
+
+func TestNotifyDigest(t *testing.T) {
+	var sent payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(Config{WebhookURL: server.URL + "/services/T/B/X"}).NotifyDigest(context.Background(), summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"*Daily digest: breakfast*", "3 products", "*Biggest drops*",
+		"• <https://shop.example/kopi|Kopi &amp; Gula>: Rp. 45,000, down Rp. 5,000 (-10%) from Rp. 50,000",
+		"*Broken links*", "status 404"} {
+		if !strings.Contains(sent.Text, want) {
+			t.Errorf("message is missing %q:\n%s", want, sent.Text)
+		}
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(Config{WebhookURL: server.URL + "/services/T/B/secret"}).Notify(context.Background(), notification)
+	if err == nil || !strings.Contains(err.Error(), "invalid_token") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Notify() with a revoked webhook = %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultBaseURL = "https://api.telegram.org"
+
+// Client calls the Telegram Bot API methods the monitor needs.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient returns a client of the Bot API at baseURL, or DefaultBaseURL
+// when it is empty. Every call is bounded by timeout.
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+type sendMessage struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+// SendMessage sends text, formatted in Telegram's HTML subset, to a chat.
+func (c *Client) SendMessage(ctx context.Context, chatID, text string) error {
+	return c.call(ctx, "sendMessage", sendMessage{ChatID: chatID, Text: text, ParseMode: "HTML"}, nil)
+}
+
+type getUpdates struct {
+	Offset         int64    `json:"offset"`
+	Timeout        int      `json:"timeout"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+// GetUpdates long-polls for the messages sent to the bot from offset on,
+// waiting up to wait for the first one. The client's timeout has to be
+// longer than wait.
+func (c *Client) GetUpdates(ctx context.Context, offset int64, wait time.Duration) ([]Update, error) {
+	var updates []Update
+	err := c.call(ctx, "getUpdates", getUpdates{
+		Offset:         offset,
+		Timeout:        int(wait / time.Second),
+		AllowedUpdates: []string{"message"},
+	}, &updates)
+
+	return updates, err
+}
+
+// response is the envelope of every Bot API answer.
+type response struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// call calls a Bot API method and decodes its result into out, unless out
+// is nil. Errors never include the URL, since it holds the bot token.
+func (c *Client) call(ctx context.Context, method string, params, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.baseURL+"/bot"+c.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("telegram %s: invalid base URL", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var result response
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("telegram %s: %s", method, resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram %s: %s", method, result.Description)
+	}
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(result.Result, out)
+}
@@ -0,0 +1,95 @@
+// Package telegram calls the Telegram Bot API and delivers fired alerts and
+// digests as messages from a bot.
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/ediprako/pricemonitor/notifier/format"
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Config struct {
+	// BaseURL is the Bot API server; empty means DefaultBaseURL.
+	BaseURL string
+	Token   string
+	// ChatID is the chat, group or channel the alerts go to.
+	ChatID  string
+	Timeout time.Duration
+}
+
+type notifier struct {
+	chatID string
+	client *Client
+}
+
+func New(config Config) *notifier {
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	return &notifier{
+		chatID: config.ChatID,
+		client: NewClient(config.BaseURL, config.Token, config.Timeout),
+	}
+}
+
+func (n *notifier) Channel() string {
+	return "telegram"
+}
+
+// Notify sends the alert to the configured chat.
+func (n *notifier) Notify(ctx context.Context, notification model.Notification) error {
+	return n.client.SendMessage(ctx, n.chatID, message(notification))
+}
+
+// NotifyDigest sends the digest to the configured chat.
+func (n *notifier) NotifyDigest(ctx context.Context, summary model.DigestSummary) error {
+	return n.client.SendMessage(ctx, n.chatID, digestMessage(summary))
+}
+
+// message formats an alert in the HTML subset Telegram understands.
+func message(notification model.Notification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n%s\n\n", html.EscapeString(notification.ProductName),
+		html.EscapeString(notification.Message))
+
+	fmt.Fprintf(&b, "Price: <b>%s</b>", format.Price(notification.Price))
+	if change := format.Change(notification.PreviousPrice, notification.Price); change != "" {
+		fmt.Fprintf(&b, ", %s from %s", change, format.Price(notification.PreviousPrice))
+	}
+	b.WriteString("\n")
+	if notification.OriginalPrice > notification.Price {
+		fmt.Fprintf(&b, "Original price: <s>%s</s>\n", format.Price(notification.OriginalPrice))
+	}
+
+	fmt.Fprintf(&b, "\n<a href=\"%s\">View the product</a>", html.EscapeString(notification.URL))
+	return b.String()
+}
+
+// digestMessage formats a digest in the HTML subset Telegram understands.
+func digestMessage(summary model.DigestSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n%s, %d products\n", html.EscapeString(summary.Title),
+		format.Period(summary.From, summary.To), summary.Products)
+
+	sections := format.Sections(summary)
+	if len(sections) == 0 {
+		b.WriteString("\nNothing changed.")
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&b, "\n<b>%s</b>\n", section.Title)
+		for _, line := range section.Lines {
+			fmt.Fprintf(&b, "• <a href=\"%s\">%s</a>: %s\n", html.EscapeString(line.URL),
+				html.EscapeString(line.Name), html.EscapeString(line.Detail))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+var notification = model.Notification{
+	EventID:       7,
+	Kind:          model.AlertKindBelowTarget,
+	Message:       "Kopi <Gula> is Rp. 45,000, at or below the target of Rp. 46,000",
+	ProductID:     1,
+	ProductName:   "Kopi <Gula>",
+	URL:           "https://shop.example/kopi?a=1&b=2",
+	Price:         45000,
+	PreviousPrice: 50000,
+	OriginalPrice: 60000,
+}
+
+var summary = model.DigestSummary{
+	Title:    "Daily digest: breakfast",
+	Period:   model.DigestPeriodDaily,
+	From:     time.Date(2021, 9, 1, 7, 0, 0, 0, time.UTC),
+	To:       time.Date(2021, 9, 2, 7, 0, 0, 0, time.UTC),
+	Products: 3,
+	Drops: []model.DigestItem{{ProductID: 1, Name: notification.ProductName, URL: notification.URL,
+		Price: 45000, PreviousPrice: 50000}},
+	Broken: []model.DigestItem{{ProductID: 2, Name: "Teh", URL: "https://shop.example/teh", Error: "status 404"}},
+}
+
+func TestNotify(t *testing.T) {
+	var path string
+	var sent sendMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Write([]byte(`{"ok": true, "result": {"message_id": 1}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(Config{BaseURL: server.URL + "/", Token: "123:abc", ChatID: "-100"}).
+		Notify(context.Background(), notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/bot123:abc/sendMessage" || sent.ChatID != "-100" || sent.ParseMode != "HTML" {
+		t.Errorf("request to %s = %+v", path, sent)
+	}
+	for _, want := range []string{"<b>Kopi &lt;Gula&gt;</b>", "<b>Rp. 45,000</b>",
+		"down Rp. 5,000 (-10%) from Rp. 50,000", "<s>Rp. 60,000</s>",
+		`<a href="https://shop.example/kopi?a=1&amp;b=2">`} {
+		if !strings.Contains(sent.Text, want) {
+			t.Errorf("message is missing %q:\n%s", want, sent.Text)
+		}
+	}
+}
+
+func TestNotifyDigest(t *testing.T) {
+	var sent sendMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Write([]byte(`{"ok": true, "result": {"message_id": 2}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(Config{BaseURL: server.URL + "/", Token: "123:abc", ChatID: "-100"}).
+		NotifyDigest(context.Background(), summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"<b>Daily digest: breakfast</b>", "3 products", "<b>Biggest drops</b>",
+		`• <a href="https://shop.example/kopi?a=1&amp;b=2">Kopi &lt;Gula&gt;</a>: Rp. 45,000, down Rp. 5,000 (-10%) from Rp. 50,000`,
+		"<b>Broken links</b>", "status 404"} {
+		if !strings.Contains(sent.Text, want) {
+			t.Errorf("message is missing %q:\n%s", want, sent.Text)
+		}
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(Config{BaseURL: server.URL, Token: "123:abc", ChatID: "-100"}).
+		Notify(context.Background(), notification)
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Notify() to a missing chat = %v", err)
+	}
+}
+
+func TestNotifyHidesToken(t *testing.T) {
+	err := New(Config{BaseURL: "http://127.0.0.1:1", Token: "123:secret", ChatID: "-100"}).
+		Notify(context.Background(), notification)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Notify() to a closed port = %v, want an error without the token", err)
+	}
+}
@@ -0,0 +1,424 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CreateAlertRule(ctx context.Context, rule model.AlertRule) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rule.ProductID != 0 {
+		if _, ok := r.products[rule.ProductID]; !ok {
+			return 0, sql.ErrNoRows
+		}
+	}
+
+	rule.ID = r.nextID("alert_rule")
+	rule.CreatedAt = now()
+	r.alertRules[rule.ID] = rule
+
+	return rule.ID, nil
+}
+
+func (r *repository) GetAlertRules(ctx context.Context) ([]model.AlertRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rules []model.AlertRule
+	for _, rule := range r.alertRules {
+		rules = append(rules, rule)
+	}
+	sortAlertRules(rules)
+
+	return rules, nil
+}
+
+func (r *repository) GetAlertRuleByID(ctx context.Context, id int64) (model.AlertRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rule, ok := r.alertRules[id]
+	if !ok {
+		return model.AlertRule{}, sql.ErrNoRows
+	}
+
+	return rule, nil
+}
+
+// UpdateAlertRule stores the edited settings of a rule and forgets where it
+// stood for its products, so that its new conditions start out armed.
+func (r *repository) UpdateAlertRule(ctx context.Context, rule model.AlertRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.alertRules[rule.ID]
+	if !ok {
+		return nil
+	}
+	if rule.ProductID != 0 {
+		if _, ok := r.products[rule.ProductID]; !ok {
+			return sql.ErrNoRows
+		}
+	}
+
+	rule.SnoozedUntil, rule.CreatedAt = stored.SnoozedUntil, stored.CreatedAt
+	r.alertRules[rule.ID] = rule
+	for key := range r.alertStates {
+		if key.ruleID == rule.ID {
+			delete(r.alertStates, key)
+		}
+	}
+
+	return nil
+}
+
+// DeleteAlertRule deletes a rule together with its events.
+func (r *repository) DeleteAlertRule(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteAlertRule(id)
+
+	return nil
+}
+
+func (r *repository) deleteAlertRule(id int64) {
+	delete(r.alertRules, id)
+	for key := range r.alertStates {
+		if key.ruleID == id {
+			delete(r.alertStates, key)
+		}
+	}
+	r.deleteAlertEvents(func(event model.AlertEvent) bool {
+		return event.RuleID == id
+	})
+}
+
+// deleteAlertEvents deletes the events matching remove with their
+// deliveries.
+func (r *repository) deleteAlertEvents(remove func(event model.AlertEvent) bool) {
+	events := r.alertEvents[:0]
+	for _, event := range r.alertEvents {
+		if !remove(event) {
+			events = append(events, event)
+			continue
+		}
+		for id, delivery := range r.deliveries {
+			if delivery.EventID == event.ID {
+				delete(r.deliveries, id)
+			}
+		}
+	}
+	r.alertEvents = events
+}
+
+// GetAlertRulesForProduct returns the enabled rules on a product and on any
+// of its tags.
+func (r *repository) GetAlertRulesForProduct(ctx context.Context, productID int64) ([]model.AlertRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tags := make(map[string]bool)
+	for key := range r.productTags {
+		if key.productID == productID {
+			tags[r.tags[key.tagID]] = true
+		}
+	}
+
+	var rules []model.AlertRule
+	for _, rule := range r.alertRules {
+		if !rule.Enabled {
+			continue
+		}
+		if (rule.ProductID != 0 && rule.ProductID == productID) || (rule.Tag != "" && tags[rule.Tag]) {
+			rules = append(rules, rule)
+		}
+	}
+	sortAlertRules(rules)
+
+	return rules, nil
+}
+
+func sortAlertRules(rules []model.AlertRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+}
+
+// SnoozeAlertRule keeps a rule from firing until the time given, or lets it
+// fire again when until is null.
+func (r *repository) SnoozeAlertRule(ctx context.Context, id int64, until sql.NullTime) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rule, ok := r.alertRules[id]
+	if !ok {
+		return nil
+	}
+	if until.Valid {
+		until.Time = until.Time.UTC()
+	}
+	rule.SnoozedUntil = until
+	r.alertRules[id] = rule
+
+	return nil
+}
+
+// GetAlertState returns where a rule stands for a product, or sql.ErrNoRows
+// when it never fired for it.
+func (r *repository) GetAlertState(ctx context.Context, ruleID, productID int64) (model.AlertState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.alertStates[alertStateKey{ruleID, productID}]
+	if !ok {
+		return model.AlertState{}, sql.ErrNoRows
+	}
+
+	return state, nil
+}
+
+func (r *repository) SaveAlertState(ctx context.Context, state model.AlertState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.alertRules[state.RuleID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := r.products[state.ProductID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	if state.LastFiredAt.Valid {
+		state.LastFiredAt.Time = state.LastFiredAt.Time.UTC()
+	}
+	r.alertStates[alertStateKey{state.RuleID, state.ProductID}] = state
+
+	return nil
+}
+
+// AcknowledgeAlertEvent marks an event as seen; acknowledging it again
+// keeps the first time.
+func (r *repository) AcknowledgeAlertEvent(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, event := range r.alertEvents {
+		if event.ID == id && !event.AcknowledgedAt.Valid {
+			r.alertEvents[i].AcknowledgedAt = sql.NullTime{Time: now(), Valid: true}
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) InsertAlertEvent(ctx context.Context, event model.AlertEvent) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.alertRules[event.RuleID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+	if _, ok := r.products[event.ProductID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	event.ID = r.nextID("alert_event")
+	event.CreatedAt = now()
+	r.alertEvents = append(r.alertEvents, event)
+
+	return event.ID, nil
+}
+
+// GetAlertEvents returns events newest first, only those of productID unless
+// it is zero.
+func (r *repository) GetAlertEvents(ctx context.Context, productID int64, limit, offset int) ([]model.AlertEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := r.productAlertEvents(productID)
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.After(events[j].CreatedAt)
+		}
+		return events[i].ID > events[j].ID
+	})
+
+	if offset >= len(events) {
+		return nil, nil
+	}
+	events = events[offset:]
+	if limit < len(events) {
+		events = events[:limit]
+	}
+	for i := range events {
+		events[i].ProductName = r.products[events[i].ProductID].Name
+	}
+
+	return events, nil
+}
+
+func (r *repository) GetTotalAlertEvent(ctx context.Context, productID int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int64(len(r.productAlertEvents(productID))), nil
+}
+
+func (r *repository) productAlertEvents(productID int64) []model.AlertEvent {
+	var events []model.AlertEvent
+	for _, event := range r.alertEvents {
+		if productID == 0 || event.ProductID == productID {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+func (r *repository) GetAlertEventByID(ctx context.Context, id int64) (model.AlertEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event, ok := r.alertEvent(id)
+	if !ok {
+		return model.AlertEvent{}, sql.ErrNoRows
+	}
+
+	return event, nil
+}
+
+// alertEvent returns an event with its product name.
+func (r *repository) alertEvent(id int64) (model.AlertEvent, bool) {
+	for _, event := range r.alertEvents {
+		if event.ID == id {
+			event.ProductName = r.products[event.ProductID].Name
+			return event, true
+		}
+	}
+
+	return model.AlertEvent{}, false
+}
+
+func (r *repository) InsertAlertDelivery(ctx context.Context, delivery model.AlertDelivery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.alertEvent(delivery.EventID); !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	delivery.ID = r.nextID("alert_delivery")
+	delivery.CreatedAt = now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	r.deliveries[delivery.ID] = delivery
+
+	return delivery.ID, nil
+}
+
+// withEvent returns the delivery with its event's message and product name.
+func (r *repository) withEvent(delivery model.AlertDelivery) model.AlertDelivery {
+	event, _ := r.alertEvent(delivery.EventID)
+	delivery.ProductName = event.ProductName
+	delivery.Message = event.Message
+
+	return delivery
+}
+
+func (r *repository) GetAlertDeliveryByID(ctx context.Context, id int64) (model.AlertDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delivery, ok := r.deliveries[id]
+	if !ok {
+		return model.AlertDelivery{}, sql.ErrNoRows
+	}
+
+	return r.withEvent(delivery), nil
+}
+
+// RecordAlertDeliveryAttempt counts a delivery attempt and stores its
+// outcome. An empty errMessage clears the last error.
+func (r *repository) RecordAlertDeliveryAttempt(ctx context.Context, id int64, status, errMessage string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delivery, ok := r.deliveries[id]
+	if !ok {
+		return nil
+	}
+
+	delivery.Status = status
+	delivery.Attempts++
+	delivery.LastError = errMessage
+	delivery.UpdatedAt = now()
+	if status == model.DeliveryStatusSent {
+		delivery.SentAt = sql.NullTime{Time: delivery.UpdatedAt, Valid: true}
+	}
+	r.deliveries[id] = delivery
+
+	return nil
+}
+
+// GetAlertDeliveries returns deliveries newest first, only those of events
+// on productID unless it is zero.
+func (r *repository) GetAlertDeliveries(ctx context.Context, productID int64, limit, offset int) ([]model.AlertDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deliveries []model.AlertDelivery
+	for _, delivery := range r.deliveries {
+		if r.deliveryOnProduct(delivery, productID) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+
+	if offset >= len(deliveries) {
+		return nil, nil
+	}
+	deliveries = deliveries[offset:]
+	if limit < len(deliveries) {
+		deliveries = deliveries[:limit]
+	}
+	for i := range deliveries {
+		deliveries[i] = r.withEvent(deliveries[i])
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) GetTotalAlertDelivery(ctx context.Context, productID int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total int64
+	for _, delivery := range r.deliveries {
+		if r.deliveryOnProduct(delivery, productID) {
+			total++
+		}
+	}
+
+	return total, nil
+}
+
+// deliveryOnProduct reports whether a delivery is of an event on productID,
+// or true when productID is zero.
+func (r *repository) deliveryOnProduct(delivery model.AlertDelivery, productID int64) bool {
+	if productID == 0 {
+		return true
+	}
+	event, _ := r.alertEvent(delivery.EventID)
+
+	return event.ProductID == productID
+}
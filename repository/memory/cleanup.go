@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CountOrphans(ctx context.Context) ([]model.OrphanCount, error) {
+	return r.orphans(false), nil
+}
+
+// DeleteOrphans removes every orphaned row and reports how many were deleted
+// per table.
+func (r *repository) DeleteOrphans(ctx context.Context) ([]model.OrphanCount, error) {
+	return r.orphans(true), nil
+}
+
+func (r *repository) orphans(remove bool) []model.OrphanCount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	histories := r.histories[:0:0]
+	var historyRows int64
+	for _, history := range r.histories {
+		if _, ok := r.products[history.ProductID]; !ok {
+			historyRows++
+			continue
+		}
+		histories = append(histories, history)
+	}
+
+	images := r.images[:0:0]
+	var imageRows int64
+	for _, image := range r.images {
+		if _, ok := r.products[image.ProductID]; !ok {
+			imageRows++
+			continue
+		}
+		images = append(images, image)
+	}
+
+	items := r.runItems[:0:0]
+	var itemRows int64
+	for _, item := range r.runItems {
+		_, productOK := r.products[item.ProductID]
+		_, runOK := r.runs[item.RunID]
+		if !productOK || !runOK {
+			itemRows++
+			continue
+		}
+		items = append(items, item)
+	}
+
+	if remove {
+		r.histories, r.images, r.runItems = histories, images, items
+	}
+
+	return []model.OrphanCount{
+		{Table: "price_history", Rows: historyRows},
+		{Table: "product_images", Rows: imageRows},
+		{Table: "refresh_run_item", Rows: itemRows},
+	}
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CreateCollection(ctx context.Context, name, description string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, collection := range r.collections {
+		if collection.Name == name {
+			return 0, fmt.Errorf("collection %q already exists", name)
+		}
+	}
+
+	id := r.nextID("collection")
+	r.collections[id] = model.Collection{ID: id, Name: name, Description: description, CreatedAt: now()}
+
+	return id, nil
+}
+
+// withProducts returns the collection with its product count.
+func (r *repository) withProducts(collection model.Collection) model.Collection {
+	for key := range r.collectionProducts {
+		if key.collectionID == collection.ID {
+			collection.Products++
+		}
+	}
+
+	return collection
+}
+
+// GetCollections returns every collection by name with its product count.
+func (r *repository) GetCollections(ctx context.Context) ([]model.Collection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var collections []model.Collection
+	for _, collection := range r.collections {
+		collections = append(collections, r.withProducts(collection))
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+
+	return collections, nil
+}
+
+func (r *repository) GetCollectionByID(ctx context.Context, id int64) (model.Collection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	collection, ok := r.collections[id]
+	if !ok {
+		return model.Collection{}, sql.ErrNoRows
+	}
+
+	return r.withProducts(collection), nil
+}
+
+// DeleteCollection deletes a collection. Its products are kept.
+func (r *repository) DeleteCollection(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.collections, id)
+	for key := range r.collectionProducts {
+		if key.collectionID == id {
+			delete(r.collectionProducts, key)
+		}
+	}
+	for digestID, digest := range r.digests {
+		if digest.CollectionID == id {
+			r.deleteDigest(digestID)
+		}
+	}
+
+	return nil
+}
+
+// AddCollectionProducts adds the products to a collection. Unknown products
+// are ignored.
+func (r *repository) AddCollectionProducts(ctx context.Context, collectionID int64, productIDs []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.collections[collectionID]; !ok {
+		return nil
+	}
+	for _, productID := range productIDs {
+		if _, ok := r.products[productID]; ok {
+			r.collectionProducts[collectionProduct{collectionID, productID}] = true
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) RemoveCollectionProducts(ctx context.Context, collectionID int64, productIDs []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, productID := range productIDs {
+		delete(r.collectionProducts, collectionProduct{collectionID, productID})
+	}
+
+	return nil
+}
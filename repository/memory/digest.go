@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CreateDigest(ctx context.Context, digest model.Digest) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if digest.CollectionID != 0 {
+		if _, ok := r.collections[digest.CollectionID]; !ok {
+			return 0, sql.ErrNoRows
+		}
+	}
+
+	digest.ID = r.nextID("digest")
+	digest.CollectionName = ""
+	digest.CreatedAt = now()
+	r.digests[digest.ID] = digest
+
+	return digest.ID, nil
+}
+
+// withCollectionName fills in the joined collection name.
+func (r *repository) withCollectionName(digest model.Digest) model.Digest {
+	digest.CollectionName = r.collections[digest.CollectionID].Name
+	return digest
+}
+
+func (r *repository) GetDigests(ctx context.Context) ([]model.Digest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var digests []model.Digest
+	for _, digest := range r.digests {
+		digests = append(digests, r.withCollectionName(digest))
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i].ID < digests[j].ID
+	})
+
+	return digests, nil
+}
+
+func (r *repository) GetDigestByID(ctx context.Context, id int64) (model.Digest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest, ok := r.digests[id]
+	if !ok {
+		return model.Digest{}, sql.ErrNoRows
+	}
+
+	return r.withCollectionName(digest), nil
+}
+
+// DeleteDigest deletes a digest with its reports.
+func (r *repository) DeleteDigest(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteDigest(id)
+	return nil
+}
+
+func (r *repository) deleteDigest(id int64) {
+	delete(r.digests, id)
+	for reportID, report := range r.digestReports {
+		if report.DigestID == id {
+			delete(r.digestReports, reportID)
+		}
+	}
+}
+
+func (r *repository) InsertDigestReport(ctx context.Context, report model.DigestReport) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.digests[report.DigestID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	report.ID = r.nextID("digest_report")
+	report.PeriodStart = report.PeriodStart.UTC()
+	report.PeriodEnd = report.PeriodEnd.UTC()
+	report.CreatedAt = now()
+	r.digestReports[report.ID] = report
+
+	return report.ID, nil
+}
+
+func (r *repository) GetDigestReportByID(ctx context.Context, id int64) (model.DigestReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.digestReports[id]
+	if !ok {
+		return model.DigestReport{}, sql.ErrNoRows
+	}
+
+	return report, nil
+}
+
+// GetLastDigestReport returns the report of a digest ending last, or
+// sql.ErrNoRows when it has none.
+func (r *repository) GetLastDigestReport(ctx context.Context, digestID int64) (model.DigestReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := r.digestReportsOf(digestID)
+	if len(reports) == 0 {
+		return model.DigestReport{}, sql.ErrNoRows
+	}
+
+	return reports[0], nil
+}
+
+// digestReportsOf returns the reports of a digest, or of every digest when
+// digestID is zero, newest first.
+func (r *repository) digestReportsOf(digestID int64) []model.DigestReport {
+	var reports []model.DigestReport
+	for _, report := range r.digestReports {
+		if digestID == 0 || report.DigestID == digestID {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if !reports[i].PeriodEnd.Equal(reports[j].PeriodEnd) {
+			return reports[i].PeriodEnd.After(reports[j].PeriodEnd)
+		}
+		return reports[i].ID > reports[j].ID
+	})
+
+	return reports
+}
+
+// GetDigestReports returns reports newest first, only those of digestID
+// unless it is zero.
+func (r *repository) GetDigestReports(ctx context.Context, digestID int64, limit, offset int) ([]model.DigestReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := r.digestReportsOf(digestID)
+	if offset >= len(reports) {
+		return nil, nil
+	}
+	reports = reports[offset:]
+	if limit < len(reports) {
+		reports = reports[:limit]
+	}
+
+	return reports, nil
+}
+
+func (r *repository) GetTotalDigestReport(ctx context.Context, digestID int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int64(len(r.digestReportsOf(digestID))), nil
+}
@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) EnqueueJob(ctx context.Context, payload model.JobPayload) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.enqueueJob(payload), nil
+}
+
+// EnqueueJobs queues all the jobs and returns their ids in order.
+func (r *repository) EnqueueJobs(ctx context.Context, payloads []model.JobPayload) ([]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int64, 0, len(payloads))
+	for _, payload := range payloads {
+		ids = append(ids, r.enqueueJob(payload))
+	}
+
+	return ids, nil
+}
+
+// enqueueJob queues a job. The caller holds r.mu.
+func (r *repository) enqueueJob(payload model.JobPayload) int64 {
+	at := now()
+	job := model.Job{
+		ID:          r.nextID("job"),
+		Kind:        payload.Kind,
+		Payload:     payload.Payload,
+		Status:      model.JobStatusPending,
+		MaxAttempts: payload.MaxAttempts,
+		RunAt:       payload.RunAt,
+		CreatedAt:   at,
+		UpdatedAt:   at,
+	}
+	r.jobs[job.ID] = job
+
+	return job.ID
+}
+
+// ClaimJob marks the oldest due pending job as running and returns it. A zero
+// Job is returned when nothing is due.
+func (r *repository) ClaimJob(ctx context.Context) (model.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at := now()
+
+	var next model.Job
+	for _, job := range r.jobs {
+		if job.Status != model.JobStatusPending || job.RunAt.After(at) {
+			continue
+		}
+		if next.ID == 0 || job.RunAt.Before(next.RunAt) || job.RunAt.Equal(next.RunAt) && job.ID < next.ID {
+			next = job
+		}
+	}
+	if next.ID == 0 {
+		return model.Job{}, nil
+	}
+
+	next.Status = model.JobStatusRunning
+	next.Attempts++
+	next.UpdatedAt = at
+	r.jobs[next.ID] = next
+
+	return next, nil
+}
+
+// updateJob applies update to the job with the given id, if there is one.
+func (r *repository) updateJob(id int64, update func(job *model.Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return
+	}
+	update(&job)
+	job.UpdatedAt = now()
+	r.jobs[id] = job
+}
+
+func (r *repository) CompleteJob(ctx context.Context, id int64, result string) error {
+	r.updateJob(id, func(job *model.Job) {
+		job.Status = model.JobStatusDone
+		job.Result = result
+		job.LastError = ""
+	})
+
+	return nil
+}
+
+// FailJob records a failed attempt. The job is put back in the queue to run
+// again at retryAt, or marked failed when retryAt is zero.
+func (r *repository) FailJob(ctx context.Context, id int64, errMessage string, retryAt time.Time) error {
+	r.updateJob(id, func(job *model.Job) {
+		job.LastError = errMessage
+		if retryAt.IsZero() {
+			job.Status = model.JobStatusFailed
+			return
+		}
+		job.Status = model.JobStatusPending
+		job.RunAt = retryAt
+	})
+
+	return nil
+}
+
+// RequeueStaleJobs returns jobs stuck in running since before the given time,
+// e.g. because their worker was killed, to the queue. Jobs out of attempts
+// are marked failed instead.
+func (r *repository) RequeueStaleJobs(ctx context.Context, before time.Time) (int64, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var requeued, failed int64
+	for id, job := range r.jobs {
+		if job.Status != model.JobStatusRunning || !job.UpdatedAt.Before(before) {
+			continue
+		}
+		if job.Attempts >= job.MaxAttempts {
+			job.Status = model.JobStatusFailed
+			job.LastError = model.JobStaleError
+			failed++
+		} else {
+			job.Status = model.JobStatusPending
+			requeued++
+		}
+		job.UpdatedAt = now()
+		r.jobs[id] = job
+	}
+
+	return requeued, failed, nil
+}
+
+// CountOverdueJobs counts pending jobs that were due before the given time
+// and have not been claimed since.
+func (r *repository) CountOverdueJobs(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for _, job := range r.jobs {
+		if job.Status == model.JobStatusPending && job.RunAt.Before(before) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *repository) GetJobByID(ctx context.Context, id int64) (model.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return model.Job{}, sql.ErrNoRows
+	}
+
+	return job, nil
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CreateRefreshRun(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run := model.RefreshRun{ID: r.nextID("refresh_run"), StartedAt: now()}
+	r.runs[run.ID] = run
+
+	return run.ID, nil
+}
+
+func (r *repository) FinishRefreshRun(ctx context.Context, run model.RefreshRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.runs[run.ID]
+	if !ok {
+		return nil
+	}
+	stored.FinishedAt = sql.NullTime{Time: now(), Valid: true}
+	stored.Attempted = run.Attempted
+	stored.Succeeded = run.Succeeded
+	stored.Failed = run.Failed
+	stored.Changed = run.Changed
+	r.runs[run.ID] = stored
+
+	return nil
+}
+
+func (r *repository) InsertRefreshRunItem(ctx context.Context, item model.RefreshRunItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item.ID = r.nextID("refresh_run_item")
+	item.ProductName = ""
+	item.CreatedAt = now()
+	r.runItems = append(r.runItems, item)
+
+	return nil
+}
+
+func (r *repository) GetRefreshRuns(ctx context.Context, limit, offset int) ([]model.RefreshRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := make([]model.RefreshRun, 0, len(r.runs))
+	for _, run := range r.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].ID > runs[j].ID
+	})
+
+	if offset >= len(runs) {
+		return nil, nil
+	}
+	runs = runs[offset:]
+	if limit < len(runs) {
+		runs = runs[:limit]
+	}
+
+	return runs, nil
+}
+
+func (r *repository) GetTotalRefreshRun(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int64(len(r.runs)), nil
+}
+
+func (r *repository) GetRefreshRunByID(ctx context.Context, id int64) (model.RefreshRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run, ok := r.runs[id]
+	if !ok {
+		return model.RefreshRun{}, sql.ErrNoRows
+	}
+
+	return run, nil
+}
+
+func (r *repository) GetRefreshRunItems(ctx context.Context, runID int64) ([]model.RefreshRunItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var items []model.RefreshRunItem
+	for _, item := range r.runItems {
+		if item.RunID == runID {
+			item.ProductName = r.products[item.ProductID].Name
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
@@ -0,0 +1,612 @@
+// Package memory keeps products, history, refresh runs and jobs in process
+// memory. It behaves like the pgsql and sqlite repositories and is meant for
+// tests and demos; everything is lost when the process exits.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const (
+	stateActive  = 1
+	stateDeleted = 0
+)
+
+type productImage struct {
+	model.ProductImage
+	status int
+}
+
+type productTag struct {
+	productID int64
+	tagID     int64
+}
+
+type collectionProduct struct {
+	collectionID int64
+	productID    int64
+}
+
+type alertStateKey struct {
+	ruleID    int64
+	productID int64
+}
+
+type rollupKey struct {
+	productID int64
+	bucket    time.Time
+}
+
+type repository struct {
+	mu sync.Mutex
+
+	lastID   map[string]int64
+	products map[int64]model.Product
+	settings map[int64]model.ProductSettings
+	images   []productImage
+
+	tags               map[int64]string
+	productTags        map[productTag]bool
+	collections        map[int64]model.Collection
+	collectionProducts map[collectionProduct]bool
+
+	alertRules  map[int64]model.AlertRule
+	alertEvents []model.AlertEvent
+	alertStates map[alertStateKey]model.AlertState
+	deliveries  map[int64]model.AlertDelivery
+
+	webhooks          map[int64]model.Webhook
+	webhookDeliveries map[int64]model.WebhookDelivery
+
+	digests       map[int64]model.Digest
+	digestReports map[int64]model.DigestReport
+
+	histories []model.PriceHistory
+	rollups   map[string]map[rollupKey]model.PriceRollup
+	// rolledUpTo is the last history id the previous rollup saw.
+	rolledUpTo int64
+
+	runs     map[int64]model.RefreshRun
+	runItems []model.RefreshRunItem
+
+	jobs map[int64]model.Job
+}
+
+// New returns an empty repository. It is safe for concurrent use.
+func New() *repository {
+	return &repository{
+		lastID:   make(map[string]int64),
+		products: make(map[int64]model.Product),
+		settings: make(map[int64]model.ProductSettings),
+
+		tags:               make(map[int64]string),
+		productTags:        make(map[productTag]bool),
+		collections:        make(map[int64]model.Collection),
+		collectionProducts: make(map[collectionProduct]bool),
+
+		alertRules:  make(map[int64]model.AlertRule),
+		alertStates: make(map[alertStateKey]model.AlertState),
+		deliveries:  make(map[int64]model.AlertDelivery),
+
+		webhooks:          make(map[int64]model.Webhook),
+		webhookDeliveries: make(map[int64]model.WebhookDelivery),
+
+		digests:       make(map[int64]model.Digest),
+		digestReports: make(map[int64]model.DigestReport),
+
+		rollups: map[string]map[rollupKey]model.PriceRollup{
+			model.RollupDaily:  {},
+			model.RollupWeekly: {},
+		},
+		runs: make(map[int64]model.RefreshRun),
+		jobs: make(map[int64]model.Job),
+	}
+}
+
+// nextID hands out ids per table the way a serial column would.
+func (r *repository) nextID(table string) int64 {
+	r.lastID[table]++
+	return r.lastID[table]
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// withSettings returns the product with its user settings, the way the
+// settings join does in SQL.
+func (r *repository) withSettings(product model.Product) model.Product {
+	settings := r.settings[product.ID]
+	product.DisplayName = settings.DisplayName
+	product.Notes = settings.Notes
+	product.TargetPrice = settings.TargetPrice
+	product.RefreshIntervalMinutes = settings.RefreshIntervalMinutes
+
+	return product
+}
+
+func (r *repository) GetProductsByID(ctx context.Context, id int64) (model.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product, ok := r.products[id]
+	if !ok {
+		return model.Product{}, sql.ErrNoRows
+	}
+	product = r.withSettings(product)
+
+	for _, image := range r.images {
+		if image.ProductID == id {
+			product.Images = append(product.Images, image.Image)
+		}
+	}
+	product.Tags = r.tagNames(id)
+
+	return product, nil
+}
+
+// GetProductsDueForRefresh returns up to limit active products that were not
+// checked within their own refresh interval, or defaultInterval when they have
+// none, as of at. The least recently checked come first.
+func (r *repository) GetProductsDueForRefresh(ctx context.Context, at time.Time, defaultInterval time.Duration, limit int) ([]model.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var products []model.Product
+	for _, product := range r.products {
+		product = r.withSettings(product)
+		interval := defaultInterval
+		if product.RefreshIntervalMinutes > 0 {
+			interval = time.Duration(product.RefreshIntervalMinutes) * time.Minute
+		}
+		if product.Status == model.ProductStatusActive && product.LastCheckedAt.Before(at.Add(-interval)) {
+			products = append(products, product)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].LastCheckedAt.Before(products[j].LastCheckedAt)
+	})
+
+	return page(products, limit, 0), nil
+}
+
+// GetProducts returns a page of the products matching filter.
+func (r *repository) GetProducts(ctx context.Context, filter model.ProductFilter, limit, offset int) ([]model.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	products := page(r.sortedProducts(filter), limit, offset)
+	for i := range products {
+		for _, image := range r.images {
+			if image.ProductID == products[i].ID && image.status == stateActive {
+				products[i].Images = append(products[i].Images, image.Image)
+			}
+		}
+		products[i].Tags = r.tagNames(products[i].ID)
+	}
+
+	return products, nil
+}
+
+// matches reports whether the product is selected by filter.
+func (r *repository) matches(filter model.ProductFilter, product model.Product) bool {
+	if product.Status != filter.Status {
+		return false
+	}
+	if filter.Tag != "" {
+		tagged := false
+		for _, name := range r.tagNames(product.ID) {
+			tagged = tagged || name == filter.Tag
+		}
+		if !tagged {
+			return false
+		}
+	}
+	if filter.CollectionID != 0 && !r.collectionProducts[collectionProduct{filter.CollectionID, product.ID}] {
+		return false
+	}
+	for _, term := range model.SearchTerms(filter.Search) {
+		if !strings.Contains(strings.ToLower(product.Name), term) && !strings.Contains(strings.ToLower(product.URL), term) &&
+			!strings.Contains(strings.ToLower(product.DisplayName), term) {
+			return false
+		}
+	}
+	if filter.MinPrice > 0 && product.CurrentPrice < filter.MinPrice ||
+		filter.MaxPrice > 0 && product.CurrentPrice > filter.MaxPrice {
+		return false
+	}
+	if filter.OnSale && product.CurrentPrice >= product.OriginalPrice {
+		return false
+	}
+	if filter.Marketplace != "" && product.Marketplace != filter.Marketplace {
+		return false
+	}
+
+	return true
+}
+
+// productSorts compare two products by each sort.
+var productSorts = map[string]func(a, b model.Product) int{
+	model.ProductSortName: func(a, b model.Product) int {
+		return strings.Compare(strings.ToLower(displayName(a)), strings.ToLower(displayName(b)))
+	},
+	model.ProductSortPrice: func(a, b model.Product) int {
+		return compareInt(a.CurrentPrice, b.CurrentPrice)
+	},
+	model.ProductSortOriginalPrice: func(a, b model.Product) int {
+		return compareInt(a.OriginalPrice, b.OriginalPrice)
+	},
+	model.ProductSortDiscount: func(a, b model.Product) int {
+		return compareInt(model.Discount(a.CurrentPrice, a.OriginalPrice), model.Discount(b.CurrentPrice, b.OriginalPrice))
+	},
+	model.ProductSortLastChange: func(a, b model.Product) int {
+		return compareInt(a.UpdatedAt.UnixNano(), b.UpdatedAt.UnixNano())
+	},
+}
+
+func displayName(product model.Product) string {
+	if product.DisplayName != "" {
+		return product.DisplayName
+	}
+	return product.Name
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sortedProducts returns the products matching filter in its order. Ties are
+// broken by id.
+func (r *repository) sortedProducts(filter model.ProductFilter) []model.Product {
+	var products []model.Product
+	for _, product := range r.products {
+		product = r.withSettings(product)
+		if r.matches(filter, product) {
+			products = append(products, product)
+		}
+	}
+
+	compare, ok := productSorts[filter.Sort]
+	if !ok {
+		compare = func(a, b model.Product) int { return compareInt(a.ID, b.ID) }
+	}
+	sort.Slice(products, func(i, j int) bool {
+		c := compare(products[i], products[j])
+		if filter.Descending {
+			c = -c
+		}
+		if c == 0 {
+			return products[i].ID < products[j].ID
+		}
+		return c < 0
+	})
+
+	return products
+}
+
+// page applies LIMIT and OFFSET to items.
+func page(items []model.Product, limit, offset int) []model.Product {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+func (r *repository) GetTotalProduct(ctx context.Context, filter model.ProductFilter) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total int64
+	for _, product := range r.products {
+		if r.matches(filter, r.withSettings(product)) {
+			total++
+		}
+	}
+
+	return total, nil
+}
+
+// GetMarketplaces returns the marketplaces products are tracked on.
+func (r *repository) GetMarketplaces(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var marketplaces []string
+	for _, product := range r.products {
+		if product.Marketplace != "" && !seen[product.Marketplace] {
+			seen[product.Marketplace] = true
+			marketplaces = append(marketplaces, product.Marketplace)
+		}
+	}
+	sort.Strings(marketplaces)
+
+	return marketplaces, nil
+}
+
+// UpsertProduct stores a product registered by its link. A product with the
+// same name is updated instead.
+func (r *repository) UpsertProduct(ctx context.Context, payload model.ProductPayload) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var previous model.Product
+	exists := false
+	for _, product := range r.products {
+		if product.Name == payload.Name {
+			previous, exists = product, true
+			break
+		}
+	}
+
+	return r.saveProduct(previous, exists, payload), nil
+}
+
+// UpdateProduct stores a refresh of the product with the given id. The
+// product is kept even when the marketplace renamed the listing.
+func (r *repository) UpdateProduct(ctx context.Context, id int64, payload model.ProductPayload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.products[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, product := range r.products {
+		if product.ID != id && product.Name == payload.Name {
+			return fmt.Errorf("product %q already exists", payload.Name)
+		}
+	}
+
+	r.saveProduct(previous, true, payload)
+	return nil
+}
+
+// saveProduct stores a scraped product over previous, or as a new product
+// when it does not exist, with its images and history.
+func (r *repository) saveProduct(previous model.Product, exists bool, payload model.ProductPayload) int64 {
+	at := now()
+
+	// Only record history when something a chart would show has changed.
+	changed := !exists || previous.CurrentPrice != payload.CurrentPrice ||
+		previous.OriginalPrice != payload.OriginalPrice || previous.InStock != payload.InStock
+
+	product := previous
+	if !exists {
+		product = model.Product{ID: r.nextID("product"), Name: payload.Name, Status: model.ProductStatusActive}
+	}
+	product.Name = payload.Name
+	product.CurrentPrice = payload.CurrentPrice
+	product.OriginalPrice = payload.OriginalPrice
+	product.URL = payload.URL
+	product.Marketplace = payload.Marketplace
+	product.InStock = payload.InStock
+	product.LastCheckedAt = at
+	product.CheckFailures = 0
+	product.LastCheckError = ""
+	if changed {
+		product.UpdatedAt = at
+	}
+	r.products[product.ID] = product
+
+	r.insertingImages(payload, product.ID)
+
+	if changed {
+		r.histories = append(r.histories, model.PriceHistory{
+			ID:            r.nextID("price_history"),
+			ProductID:     product.ID,
+			CurrentPrice:  payload.CurrentPrice,
+			OriginalPrice: payload.OriginalPrice,
+			InStock:       payload.InStock,
+			UpdateTime:    at,
+		})
+	}
+
+	return product.ID
+}
+
+func (r *repository) insertingImages(payload model.ProductPayload, productID int64) {
+	wanted := make(map[string]bool, len(payload.Images))
+	for _, image := range payload.Images {
+		wanted[image] = true
+	}
+
+	for i, image := range r.images {
+		if image.ProductID != productID {
+			continue
+		}
+		if wanted[image.Image] {
+			delete(wanted, image.Image)
+			continue
+		}
+		r.images[i].status = stateDeleted
+	}
+
+	for _, image := range payload.Images {
+		if !wanted[image] {
+			continue
+		}
+		delete(wanted, image)
+		r.images = append(r.images, productImage{
+			ProductImage: model.ProductImage{ID: r.nextID("product_images"), ProductID: productID, Image: image},
+			status:       stateActive,
+		})
+	}
+}
+
+// RecordCheckFailure marks a refresh attempt of the product as failed
+// without touching its scraped data.
+func (r *repository) RecordCheckFailure(ctx context.Context, id int64, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product, ok := r.products[id]
+	if !ok {
+		return nil
+	}
+	product.LastCheckedAt = now()
+	product.CheckFailures++
+	product.LastCheckError = message
+	r.products[id] = product
+
+	return nil
+}
+
+// UpdateProductStatus archives or restores a product.
+func (r *repository) UpdateProductStatus(ctx context.Context, id int64, status int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product, ok := r.products[id]
+	if !ok {
+		return nil
+	}
+	product.Status = status
+	r.products[id] = product
+
+	return nil
+}
+
+// UpsertProductSettings stores the user settings of a product.
+func (r *repository) UpsertProductSettings(ctx context.Context, settings model.ProductSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.products[settings.ProductID]; !ok {
+		return nil
+	}
+	r.settings[settings.ProductID] = settings
+
+	return nil
+}
+
+// DeleteProduct removes a product together with its settings, tags,
+// collection entries, history, rollups, images and refresh run items.
+func (r *repository) DeleteProduct(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.products, id)
+	delete(r.settings, id)
+
+	for key := range r.productTags {
+		if key.productID == id {
+			delete(r.productTags, key)
+		}
+	}
+	for key := range r.collectionProducts {
+		if key.productID == id {
+			delete(r.collectionProducts, key)
+		}
+	}
+	for ruleID, rule := range r.alertRules {
+		if rule.ProductID == id {
+			r.deleteAlertRule(ruleID)
+		}
+	}
+	r.deleteAlertEvents(func(event model.AlertEvent) bool {
+		return event.ProductID == id
+	})
+	for key := range r.alertStates {
+		if key.productID == id {
+			delete(r.alertStates, key)
+		}
+	}
+
+	histories := r.histories[:0]
+	for _, history := range r.histories {
+		if history.ProductID != id {
+			histories = append(histories, history)
+		}
+	}
+	r.histories = histories
+
+	images := r.images[:0]
+	for _, image := range r.images {
+		if image.ProductID != id {
+			images = append(images, image)
+		}
+	}
+	r.images = images
+
+	items := r.runItems[:0]
+	for _, item := range r.runItems {
+		if item.ProductID != id {
+			items = append(items, item)
+		}
+	}
+	r.runItems = items
+
+	for _, rollups := range r.rollups {
+		for key := range rollups {
+			if key.productID == id {
+				delete(rollups, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) InsertPriceHistory(ctx context.Context, productID int64, currentPrice int64, originalPrice int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.histories = append(r.histories, model.PriceHistory{
+		ID:            r.nextID("price_history"),
+		ProductID:     productID,
+		CurrentPrice:  currentPrice,
+		OriginalPrice: originalPrice,
+		InStock:       true,
+		UpdateTime:    now(),
+	})
+
+	return nil
+}
+
+// productHistories returns the history of the product, oldest first.
+func (r *repository) productHistories(productID int64) []model.PriceHistory {
+	var histories []model.PriceHistory
+	for _, history := range r.histories {
+		if history.ProductID == productID {
+			histories = append(histories, history)
+		}
+	}
+	sort.SliceStable(histories, func(i, j int) bool {
+		return histories[i].UpdateTime.Before(histories[j].UpdateTime)
+	})
+
+	return histories
+}
+
+func (r *repository) GetLastPriceHistory(ctx context.Context, productID int64, limit int) ([]model.PriceHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	histories := r.productHistories(productID)
+	if len(histories) > limit {
+		histories = histories[len(histories)-limit:]
+	}
+
+	return histories, nil
+}
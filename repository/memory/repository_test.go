@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/repotest"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) usecase.DBProvider {
+		return New()
+	})
+}
+
+func TestRepositoryBackdated(t *testing.T) {
+	repotest.RunBackdated(t, func(t *testing.T) (usecase.DBProvider, repotest.Backdate) {
+		r := New()
+		return r, func(t *testing.T, historyID int64, at time.Time) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for i := range r.histories {
+				if r.histories[i].ID == historyID {
+					r.histories[i].UpdateTime = at
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// truncate returns the start of the bucket t falls in, in UTC. Weeks start
+// on Monday as in Postgres.
+func truncate(resolution string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if resolution == model.RollupWeekly {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+
+	return day
+}
+
+// RollupPriceHistory recomputes the daily and weekly buckets of raw rows
+// recorded since the last run, counting the price carried into each bucket
+// from before it.
+func (r *repository) RollupPriceHistory(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	histories := make([]model.PriceHistory, len(r.histories))
+	copy(histories, r.histories)
+	sort.SliceStable(histories, func(i, j int) bool {
+		return histories[i].UpdateTime.Before(histories[j].UpdateTime)
+	})
+
+	last := r.rolledUpTo
+	for _, history := range histories {
+		if history.ID > last {
+			last = history.ID
+		}
+	}
+
+	for resolution, rollups := range r.rollups {
+		touched := make(map[rollupKey]bool)
+		for _, history := range histories {
+			if history.ID > r.rolledUpTo {
+				touched[rollupKey{productID: history.ProductID, bucket: truncate(resolution, history.UpdateTime)}] = true
+			}
+		}
+
+		sums := make(map[rollupKey]int64)
+		counts := make(map[rollupKey]int64)
+		previous := make(map[int64]model.PriceHistory)
+		for _, history := range histories {
+			key := rollupKey{productID: history.ProductID, bucket: truncate(resolution, history.UpdateTime)}
+			carried, ok := previous[history.ProductID]
+			previous[history.ProductID] = history
+			if !touched[key] {
+				continue
+			}
+
+			rollup := rollups[key]
+			if counts[key] == 0 {
+				// Touched buckets are rebuilt from scratch, starting with
+				// the price in effect when they opened.
+				rollup = model.PriceRollup{
+					ProductID: key.productID,
+					Bucket:    key.bucket,
+					OpenPrice: history.CurrentPrice,
+					MinPrice:  history.CurrentPrice,
+					MaxPrice:  history.CurrentPrice,
+				}
+				if ok {
+					rollup.OpenPrice = carried.CurrentPrice
+					rollup.MinPrice = carried.CurrentPrice
+					rollup.MaxPrice = carried.CurrentPrice
+					sums[key] += carried.CurrentPrice
+					counts[key]++
+				}
+			}
+			if history.CurrentPrice < rollup.MinPrice {
+				rollup.MinPrice = history.CurrentPrice
+			}
+			if history.CurrentPrice > rollup.MaxPrice {
+				rollup.MaxPrice = history.CurrentPrice
+			}
+			rollup.ClosePrice = history.CurrentPrice
+			rollup.OriginalPrice = history.OriginalPrice
+			rollup.InStock = history.InStock
+			rollup.Samples++
+			sums[key] += history.CurrentPrice
+			counts[key]++
+			rollup.AvgPrice = int64(math.Round(float64(sums[key]) / float64(counts[key])))
+			rollups[key] = rollup
+		}
+	}
+	r.rolledUpTo = last
+
+	return nil
+}
+
+// PrunePriceHistory deletes raw history recorded before the start of the week
+// containing olderThan, except the last row of each product before it.
+// Callers roll the rows up first.
+func (r *repository) PrunePriceHistory(ctx context.Context, olderThan time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := truncate(model.RollupWeekly, olderThan)
+
+	// inEffect is the last row of each product before the cutoff.
+	inEffect := make(map[int64]model.PriceHistory)
+	for _, history := range r.histories {
+		if !history.UpdateTime.Before(cutoff) {
+			continue
+		}
+		last, ok := inEffect[history.ProductID]
+		if !ok || history.UpdateTime.After(last.UpdateTime) ||
+			history.UpdateTime.Equal(last.UpdateTime) && history.ID > last.ID {
+			inEffect[history.ProductID] = history
+		}
+	}
+
+	kept := r.histories[:0]
+	var pruned int64
+	for _, history := range r.histories {
+		if history.UpdateTime.Before(cutoff) && inEffect[history.ProductID].ID != history.ID {
+			pruned++
+			continue
+		}
+		kept = append(kept, history)
+	}
+	r.histories = kept
+
+	return pruned, nil
+}
+
+// GetPriceHistoryBefore returns the last raw row recorded before before, or
+// sql.ErrNoRows when there is none.
+func (r *repository) GetPriceHistoryBefore(ctx context.Context, productID int64, before time.Time) (model.PriceHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	histories := r.productHistories(productID)
+	for i := len(histories) - 1; i >= 0; i-- {
+		if histories[i].UpdateTime.Before(before) {
+			return histories[i], nil
+		}
+	}
+
+	return model.PriceHistory{}, sql.ErrNoRows
+}
+
+func (r *repository) GetPriceHistoryBetween(ctx context.Context, productID int64, from, to time.Time) ([]model.PriceHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var histories []model.PriceHistory
+	for _, history := range r.productHistories(productID) {
+		if !history.UpdateTime.Before(from) && !history.UpdateTime.After(to) {
+			histories = append(histories, history)
+		}
+	}
+
+	return histories, nil
+}
+
+func (r *repository) GetPriceRollups(ctx context.Context, productID int64, resolution string, from, to time.Time) ([]model.PriceRollup, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rollups, ok := r.rollups[resolution]
+	if !ok {
+		return nil, fmt.Errorf("unknown rollup resolution %q", resolution)
+	}
+
+	from = truncate(resolution, from)
+	to = truncate(model.RollupDaily, to)
+
+	var result []model.PriceRollup
+	for key, rollup := range rollups {
+		if key.productID == productID && !key.bucket.Before(from) && !key.bucket.After(to) {
+			result = append(result, rollup)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Bucket.Before(result[j].Bucket)
+	})
+
+	return result, nil
+}
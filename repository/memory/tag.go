@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// tagNames returns the tag names of a product, sorted.
+func (r *repository) tagNames(productID int64) []string {
+	var names []string
+	for key := range r.productTags {
+		if key.productID == productID {
+			names = append(names, r.tags[key.tagID])
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// GetTags returns the tags in use with the number of products having each.
+func (r *repository) GetTags(ctx context.Context) ([]model.Tag, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[int64]int64)
+	for key := range r.productTags {
+		counts[key.tagID]++
+	}
+
+	var tags []model.Tag
+	for id, products := range counts {
+		tags = append(tags, model.Tag{ID: id, Name: r.tags[id], Products: products})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+
+	return tags, nil
+}
+
+// AddProductTags tags the products with every name, creating missing tags.
+// Unknown products are ignored.
+func (r *repository) AddProductTags(ctx context.Context, productIDs []int64, names []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		tagID := r.tagID(name)
+		if tagID == 0 {
+			tagID = r.nextID("tag")
+			r.tags[tagID] = name
+		}
+
+		for _, productID := range productIDs {
+			if _, ok := r.products[productID]; ok {
+				r.productTags[productTag{productID, tagID}] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// tagID returns the id of the named tag, or 0 when there is none.
+func (r *repository) tagID(name string) int64 {
+	for id, tagName := range r.tags {
+		if tagName == name {
+			return id
+		}
+	}
+
+	return 0
+}
+
+// RemoveProductTags removes the named tags from the products. Tags no
+// product has any more are deleted.
+func (r *repository) RemoveProductTags(ctx context.Context, productIDs []int64, names []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		tagID := r.tagID(name)
+		for _, productID := range productIDs {
+			delete(r.productTags, productTag{productID, tagID})
+		}
+	}
+
+	used := make(map[int64]bool)
+	for key := range r.productTags {
+		used[key.tagID] = true
+	}
+	for id := range r.tags {
+		if !used[id] {
+			delete(r.tags, id)
+		}
+	}
+
+	return nil
+}
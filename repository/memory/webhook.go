@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CreateWebhook(ctx context.Context, webhook model.Webhook) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook.ID = r.nextID("webhook")
+	webhook.CreatedAt = now()
+	r.webhooks[webhook.ID] = webhook
+
+	return webhook.ID, nil
+}
+
+func (r *repository) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var webhooks []model.Webhook
+	for _, webhook := range r.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+func (r *repository) GetWebhookByID(ctx context.Context, id int64) (model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook, ok := r.webhooks[id]
+	if !ok {
+		return model.Webhook{}, sql.ErrNoRows
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook with its delivery history.
+func (r *repository) DeleteWebhook(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.webhooks, id)
+	for deliveryID, delivery := range r.webhookDeliveries {
+		if delivery.WebhookID == id {
+			delete(r.webhookDeliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) InsertWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.webhooks[delivery.WebhookID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	delivery.ID = r.nextID("webhook_delivery")
+	delivery.CreatedAt = now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	r.webhookDeliveries[delivery.ID] = delivery
+
+	return delivery.ID, nil
+}
+
+func (r *repository) GetWebhookDeliveryByID(ctx context.Context, id int64) (model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delivery, ok := r.webhookDeliveries[id]
+	if !ok {
+		return model.WebhookDelivery{}, sql.ErrNoRows
+	}
+	delivery.URL = r.webhooks[delivery.WebhookID].URL
+
+	return delivery, nil
+}
+
+// RecordWebhookDeliveryAttempt counts a delivery attempt and stores its
+// outcome. statusCode is zero when no response arrived.
+func (r *repository) RecordWebhookDeliveryAttempt(ctx context.Context, id int64, status string, statusCode int, errMessage string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delivery, ok := r.webhookDeliveries[id]
+	if !ok {
+		return nil
+	}
+
+	delivery.Status = status
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	delivery.LastError = errMessage
+	delivery.UpdatedAt = now()
+	if status == model.DeliveryStatusSent {
+		delivery.DeliveredAt = sql.NullTime{Time: delivery.UpdatedAt, Valid: true}
+	}
+	r.webhookDeliveries[id] = delivery
+
+	return nil
+}
+
+// GetWebhookDeliveries returns deliveries newest first, only those of
+// webhookID unless it is zero.
+func (r *repository) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deliveries := r.deliveriesOf(webhookID)
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+
+	if offset >= len(deliveries) {
+		return nil, nil
+	}
+	deliveries = deliveries[offset:]
+	if limit < len(deliveries) {
+		deliveries = deliveries[:limit]
+	}
+	for i := range deliveries {
+		deliveries[i].URL = r.webhooks[deliveries[i].WebhookID].URL
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) GetTotalWebhookDelivery(ctx context.Context, webhookID int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int64(len(r.deliveriesOf(webhookID))), nil
+}
+
+func (r *repository) deliveriesOf(webhookID int64) []model.WebhookDelivery {
+	var deliveries []model.WebhookDelivery
+	for _, delivery := range r.webhookDeliveries {
+		if webhookID == 0 || delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries
+}
@@ -0,0 +1,298 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is one numbered schema change. Files are named
+// NNNN_description.up.sql and NNNN_description.down.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads every migration file in dir, sorted by version. Versions must
+// run from 1 without gaps, with one up and at most one down file each.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names: %s and %s", version, m.Name, match[2])
+		}
+
+		key := fmt.Sprintf("%d.%s", version, match[3])
+		if seen[key] {
+			return nil, fmt.Errorf("migration %d has more than one %s file", version, match[3])
+		}
+		seen[key] = true
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			return nil, fmt.Errorf("migration %d_%s follows %d: versions must not have gaps", m.Version, m.Name, i)
+		}
+	}
+
+	return migrations, nil
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func New(db *sqlx.DB, migrations []Migration) *Migrator {
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	sql := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL,
+		name varchar NOT NULL,
+		applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT schema_migrations_pk PRIMARY KEY (version)
+	)`
+	_, err := m.db.ExecContext(ctx, sql)
+
+	return err
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]appliedMigration, error) {
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT version, name, applied_at FROM schema_migrations`
+
+	var rows []appliedMigration
+	err = m.db.SelectContext(ctx, &rows, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// Status lists every known migration and whether it has been applied,
+// followed by versions recorded in the database that this binary does not
+// know about.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		result = append(result, status)
+	}
+
+	unknown := make([]Status, 0, len(applied))
+	for _, row := range applied {
+		unknown = append(unknown, Status{Version: row.Version, Name: row.Name, Applied: true, AppliedAt: row.AppliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Version < unknown[j].Version
+	})
+
+	return append(result, unknown...), nil
+}
+
+// Up applies every pending migration in order, each in its own transaction,
+// and returns the ones it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = m.apply(ctx, migration.Up, func(tx *sqlx.Tx) error {
+			sql := tx.Rebind(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`)
+			_, err := tx.ExecContext(ctx, sql, migration.Version, migration.Name)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if strings.TrimSpace(migration.Down) == "" {
+			return Migration{}, fmt.Errorf("migration %d_%s cannot be rolled back", migration.Version, migration.Name)
+		}
+
+		err = m.apply(ctx, migration.Down, func(tx *sqlx.Tx) error {
+			sql := tx.Rebind(`DELETE FROM schema_migrations WHERE version = ?`)
+			_, err := tx.ExecContext(ctx, sql, migration.Version)
+			return err
+		})
+		if err != nil {
+			return Migration{}, fmt.Errorf("migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		return migration, nil
+	}
+
+	return Migration{}, fmt.Errorf("no migration to roll back")
+}
+
+func (m *Migrator) apply(ctx context.Context, script string, record func(tx *sqlx.Tx) error) error {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = record(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EnsureCurrent returns an error unless the database schema matches exactly
+// the migrations known to this binary.
+func (m *Migrator) EnsureCurrent(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int64]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+	}
+
+	var pending, unknown []string
+	for _, status := range statuses {
+		name := fmt.Sprintf("%d_%s", status.Version, status.Name)
+		if !status.Applied {
+			pending = append(pending, name)
+		} else if !known[status.Version] {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is out of date, pending migrations: %s (run with -mode=migrate up)",
+			strings.Join(pending, ", "))
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("database schema is newer than this binary, unknown migrations: %s",
+			strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
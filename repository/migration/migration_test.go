@@ -0,0 +1,166 @@
+package migration
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+var testFiles = fstest.MapFS{
+	"migrations/0001_create_product.up.sql":   {Data: []byte(`CREATE TABLE product (id integer PRIMARY KEY)`)},
+	"migrations/0001_create_product.down.sql": {Data: []byte(`DROP TABLE product`)},
+	"migrations/0002_add_name.up.sql":         {Data: []byte(`ALTER TABLE product ADD COLUMN name text`)},
+	"migrations/0002_add_name.down.sql":       {Data: []byte(`ALTER TABLE product DROP COLUMN name`)},
+	"migrations/README.md":                    {Data: []byte(`not a migration`)},
+}
+
+func newMigrator(t *testing.T, migrations []Migration) (*sqlx.DB, *Migrator) {
+	db, err := sqlx.Connect("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, New(db, migrations)
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[0].Name != "create_product" ||
+		migrations[1].Version != 2 || migrations[1].Down == "" {
+		t.Errorf("migrations = %+v", migrations)
+	}
+
+	for _, test := range []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"gap", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte(`SELECT 1`)},
+			"m/0003_c.up.sql": {Data: []byte(`SELECT 1`)},
+		}, "gaps"},
+		{"not from one", fstest.MapFS{
+			"m/0002_b.up.sql": {Data: []byte(`SELECT 1`)},
+		}, "gaps"},
+		{"duplicate number", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte(`SELECT 1`)},
+			"m/0001_b.up.sql": {Data: []byte(`SELECT 1`)},
+		}, "different names"},
+		{"duplicate file", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte(`SELECT 1`)},
+			"m/001_a.up.sql":  {Data: []byte(`SELECT 1`)},
+		}, "more than one up file"},
+		{"no up file", fstest.MapFS{
+			"m/0001_a.down.sql": {Data: []byte(`SELECT 1`)},
+		}, "no up file"},
+	} {
+		_, err := Load(test.files, "m")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Load() = %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	migrations, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, m := newMigrator(t, migrations)
+
+	err = m.EnsureCurrent(ctx)
+	if err == nil || !strings.Contains(err.Error(), "pending migrations: 1_create_product, 2_add_name") {
+		t.Errorf("EnsureCurrent() before Up = %v", err)
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil || len(done) != 2 {
+		t.Fatalf("Up() = %+v, %v", done, err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || !statuses[1].Applied || statuses[1].AppliedAt.IsZero() {
+		t.Errorf("statuses after Up = %+v", statuses)
+	}
+	err = m.EnsureCurrent(ctx)
+	if err != nil {
+		t.Errorf("EnsureCurrent() after Up = %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO product (id, name) VALUES (1, 'kopi')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err = m.Up(ctx)
+	if err != nil || len(done) != 0 {
+		t.Errorf("second Up() = %+v, %v, want nothing to do", done, err)
+	}
+
+	rolledBack, err := m.Down(ctx)
+	if err != nil || rolledBack.Version != 2 {
+		t.Fatalf("Down() = %+v, %v", rolledBack, err)
+	}
+	statuses, err = m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("statuses after Down = %+v", statuses)
+	}
+	_, err = db.Exec(`SELECT name FROM product`)
+	if err == nil {
+		t.Error("product.name still exists after Down")
+	}
+	err = m.EnsureCurrent(ctx)
+	if err == nil || !strings.Contains(err.Error(), "pending migrations: 2_add_name") {
+		t.Errorf("EnsureCurrent() after Down = %v", err)
+	}
+
+	_, err = m.Down(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Down(ctx)
+	if err == nil {
+		t.Error("Down() with nothing applied succeeded")
+	}
+}
+
+func TestMigratorUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	migrations, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m := newMigrator(t, migrations)
+	_, err = m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An older binary knows only the first migration.
+	older := New(m.db, migrations[:1])
+	statuses, err := older.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[1].Version != 2 || !statuses[1].Applied {
+		t.Errorf("statuses with an unknown version = %+v", statuses)
+	}
+	err = older.EnsureCurrent(ctx)
+	if err == nil || !strings.Contains(err.Error(), "unknown migrations: 2_add_name") {
+		t.Errorf("EnsureCurrent() with an unknown version = %v", err)
+	}
+}
@@ -0,0 +1,404 @@
+// Package model holds the records shared by the storage implementations.
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type ProductPayload struct {
+	Name          string
+	CurrentPrice  int64
+	OriginalPrice int64
+	URL           string
+	Marketplace   string
+	Images        []string
+	InStock       bool
+}
+
+type Product struct {
+	ID             int64     `db:"id"`
+	Name           string    `db:"name"`
+	CurrentPrice   int64     `db:"current_price"`
+	OriginalPrice  int64     `db:"original_price"`
+	URL            string    `db:"url"`
+	Marketplace    string    `db:"marketplace"`
+	InStock        bool      `db:"in_stock"`
+	UpdatedAt      time.Time `db:"updated_at"`
+	LastCheckedAt  time.Time `db:"last_checked_at"`
+	CheckFailures  int       `db:"check_failures"`
+	LastCheckError string    `db:"last_check_error"`
+	Status         int       `db:"status"`
+	Images         []string
+	Tags           []string
+
+	// Set by the user through ProductSettings; zero when unset.
+	DisplayName            string `db:"display_name"`
+	Notes                  string `db:"notes"`
+	TargetPrice            int64  `db:"target_price"`
+	RefreshIntervalMinutes int    `db:"refresh_interval_minutes"`
+}
+
+// ProductSettings are the product fields a user edits. Refreshes never
+// overwrite them. Zero values clear a setting.
+type ProductSettings struct {
+	ProductID              int64
+	DisplayName            string
+	Notes                  string
+	TargetPrice            int64
+	RefreshIntervalMinutes int
+}
+
+// Archived products keep their history but are not listed or refreshed.
+const (
+	ProductStatusActive   = 1
+	ProductStatusArchived = 0
+)
+
+// ProductFilter selects the products to list and their order. Status always
+// has to match; the other fields match every product when empty.
+type ProductFilter struct {
+	Status       int
+	Tag          string
+	CollectionID int64
+
+	// Search matches products having every word of it in their name, URL or
+	// display name.
+	Search      string
+	MinPrice    int64
+	MaxPrice    int64
+	OnSale      bool
+	Marketplace string
+
+	// Sort is one of the ProductSort values; products are sorted by id when
+	// it is empty.
+	Sort       string
+	Descending bool
+}
+
+const (
+	ProductSortName          = "name"
+	ProductSortPrice         = "price"
+	ProductSortOriginalPrice = "original_price"
+	ProductSortDiscount      = "discount"
+	ProductSortLastChange    = "last_change"
+)
+
+// SearchTerms splits a search into lower case words of letters and digits.
+func SearchTerms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Discount is how much below the original price a price is, in whole
+// percent.
+func Discount(currentPrice, originalPrice int64) int64 {
+	if originalPrice <= 0 {
+		return 0
+	}
+	return (originalPrice - currentPrice) * 100 / originalPrice
+}
+
+type Tag struct {
+	ID       int64  `db:"id"`
+	Name     string `db:"name"`
+	Products int64  `db:"products"`
+}
+
+type Collection struct {
+	ID          int64     `db:"id"`
+	Name        string    `db:"name"`
+	Description string    `db:"description"`
+	CreatedAt   time.Time `db:"created_at"`
+	Products    int64     `db:"products"`
+}
+
+// AlertRule watches one product, or every product with Tag when ProductID is
+// zero. Its events are delivered over the Channels listed, separated by
+// commas, or over every channel when it is empty. Expression is the
+// condition of an expression rule.
+//
+// A rule fires again for a product only CooldownMinutes after it last did,
+// and with Hysteresis only after the product stopped satisfying it. Events
+// fired between QuietStart and QuietEnd ("15:04" in Timezone) are delivered
+// when the quiet hours end. A rule does not fire until SnoozedUntil.
+type AlertRule struct {
+	ID              int64        `db:"id"`
+	ProductID       int64        `db:"product_id"`
+	Tag             string       `db:"tag"`
+	Kind            string       `db:"kind"`
+	Threshold       int64        `db:"threshold"`
+	WindowHours     int          `db:"window_hours"`
+	Channels        string       `db:"channels"`
+	CooldownMinutes int          `db:"cooldown_minutes"`
+	Hysteresis      bool         `db:"hysteresis"`
+	QuietStart      string       `db:"quiet_start"`
+	QuietEnd        string       `db:"quiet_end"`
+	Timezone        string       `db:"timezone"`
+	Expression      string       `db:"expression"`
+	SnoozedUntil    sql.NullTime `db:"snoozed_until"`
+	Enabled         bool         `db:"enabled"`
+	CreatedAt       time.Time    `db:"created_at"`
+}
+
+// AlertState is where a rule stands for one product it watches. A disarmed
+// rule does not fire for the product until the product stops satisfying it.
+type AlertState struct {
+	RuleID      int64        `db:"rule_id"`
+	ProductID   int64        `db:"product_id"`
+	Armed       bool         `db:"armed"`
+	LastFiredAt sql.NullTime `db:"last_fired_at"`
+}
+
+const (
+	AlertKindBelowTarget   = "below_target"
+	AlertKindPercentDrop   = "percent_drop"
+	AlertKindAllTimeLow    = "all_time_low"
+	AlertKindDiscountAbove = "discount_above"
+	// Availability alerts fire when a product comes back rather than on its
+	// price: back in stock after being sold out, or scraped again after
+	// failing at least Threshold checks in a row.
+	AlertKindBackInStock     = "back_in_stock"
+	AlertKindListingReturned = "listing_returned"
+	// Expression alerts fire when the rule's Expression holds.
+	AlertKindExpression = "expression"
+)
+
+type AlertEvent struct {
+	ID            int64     `db:"id"`
+	RuleID        int64     `db:"rule_id"`
+	ProductID     int64     `db:"product_id"`
+	ProductName   string    `db:"product_name"`
+	Kind          string    `db:"kind"`
+	Price         int64     `db:"price"`
+	PreviousPrice int64     `db:"previous_price"`
+	Message       string    `db:"message"`
+	CreatedAt     time.Time `db:"created_at"`
+	// AcknowledgedAt is set once a user has seen the event.
+	AcknowledgedAt sql.NullTime `db:"acknowledged_at"`
+}
+
+// AlertDelivery is the delivery of an alert event over one notification
+// channel.
+type AlertDelivery struct {
+	ID          int64        `db:"id"`
+	EventID     int64        `db:"event_id"`
+	Channel     string       `db:"channel"`
+	Status      string       `db:"status"`
+	Attempts    int          `db:"attempts"`
+	LastError   string       `db:"last_error"`
+	SentAt      sql.NullTime `db:"sent_at"`
+	CreatedAt   time.Time    `db:"created_at"`
+	UpdatedAt   time.Time    `db:"updated_at"`
+	ProductName string       `db:"product_name"`
+	Message     string       `db:"message"`
+}
+
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+// Notification is a fired alert as the notifiers deliver it.
+type Notification struct {
+	EventID       int64
+	Kind          string
+	Message       string
+	ProductID     int64
+	ProductName   string
+	URL           string
+	Image         string
+	Price         int64
+	PreviousPrice int64
+	OriginalPrice int64
+	CreatedAt     time.Time
+}
+
+// Webhook posts the events listed in Events, separated by commas, to URL.
+type Webhook struct {
+	ID        int64     `db:"id"`
+	URL       string    `db:"url"`
+	Secret    string    `db:"secret"`
+	Events    string    `db:"events"`
+	Enabled   bool      `db:"enabled"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+const (
+	WebhookEventPriceChanged   = "price_changed"
+	WebhookEventAlertTriggered = "alert_triggered"
+	WebhookEventProductFailed  = "product_failed"
+)
+
+// WebhookDelivery is one event posted to a webhook. Its status is one of the
+// DeliveryStatus values.
+type WebhookDelivery struct {
+	ID          int64        `db:"id"`
+	WebhookID   int64        `db:"webhook_id"`
+	URL         string       `db:"url"`
+	Event       string       `db:"event"`
+	Payload     string       `db:"payload"`
+	Status      string       `db:"status"`
+	Attempts    int          `db:"attempts"`
+	StatusCode  int          `db:"status_code"`
+	LastError   string       `db:"last_error"`
+	DeliveredAt sql.NullTime `db:"delivered_at"`
+	CreatedAt   time.Time    `db:"created_at"`
+	UpdatedAt   time.Time    `db:"updated_at"`
+}
+
+// Digest summarizes the products of a collection, or every product when
+// CollectionID is zero, once per Period. It is delivered over the Channels
+// listed, separated by commas, or over every channel when it is empty.
+type Digest struct {
+	ID             int64     `db:"id"`
+	CollectionID   int64     `db:"collection_id"`
+	CollectionName string    `db:"collection_name"`
+	Period         string    `db:"period"`
+	Channels       string    `db:"channels"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+const (
+	DigestPeriodDaily  = "daily"
+	DigestPeriodWeekly = "weekly"
+)
+
+// DigestReport is a digest as it was sent for one period. Content is the
+// DigestSummary as JSON.
+type DigestReport struct {
+	ID          int64     `db:"id"`
+	DigestID    int64     `db:"digest_id"`
+	PeriodStart time.Time `db:"period_start"`
+	PeriodEnd   time.Time `db:"period_end"`
+	Content     string    `db:"content"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// DigestSummary is what happened to the products of a digest over a period,
+// as the notifiers deliver it.
+type DigestSummary struct {
+	Title       string       `json:"title"`
+	Period      string       `json:"period"`
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Products    int          `json:"products"`
+	Drops       []DigestItem `json:"drops"`
+	NewLows     []DigestItem `json:"new_lows"`
+	BackInStock []DigestItem `json:"back_in_stock"`
+	Broken      []DigestItem `json:"broken"`
+}
+
+// DigestItem is a product in a digest section. PreviousPrice is the price
+// at the start of the period for drops and the previous low for new lows.
+type DigestItem struct {
+	ProductID     int64  `json:"product_id"`
+	Name          string `json:"name"`
+	URL           string `json:"url"`
+	Price         int64  `json:"price"`
+	PreviousPrice int64  `json:"previous_price,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type PriceHistory struct {
+	ID            int64     `db:"id"`
+	ProductID     int64     `db:"product_id"`
+	CurrentPrice  int64     `db:"current_price"`
+	OriginalPrice int64     `db:"original_price"`
+	InStock       bool      `db:"in_stock"`
+	UpdateTime    time.Time `db:"updated_at"`
+}
+
+type ProductImage struct {
+	ID        int64  `db:"id"`
+	ProductID int64  `db:"product_id"`
+	Image     string `db:"image"`
+}
+
+type PriceRollup struct {
+	ProductID     int64     `db:"product_id"`
+	Bucket        time.Time `db:"bucket"`
+	OpenPrice     int64     `db:"open_price"`
+	ClosePrice    int64     `db:"close_price"`
+	MinPrice      int64     `db:"min_price"`
+	MaxPrice      int64     `db:"max_price"`
+	AvgPrice      int64     `db:"avg_price"`
+	OriginalPrice int64     `db:"original_price"`
+	InStock       bool      `db:"in_stock"`
+	Samples       int       `db:"samples"`
+}
+
+const (
+	RollupDaily  = "daily"
+	RollupWeekly = "weekly"
+)
+
+type RefreshRun struct {
+	ID         int64        `db:"id"`
+	StartedAt  time.Time    `db:"started_at"`
+	FinishedAt sql.NullTime `db:"finished_at"`
+	Attempted  int          `db:"attempted"`
+	Succeeded  int          `db:"succeeded"`
+	Failed     int          `db:"failed"`
+	Changed    int          `db:"changed"`
+}
+
+type RefreshRunItem struct {
+	ID          int64     `db:"id"`
+	RunID       int64     `db:"run_id"`
+	ProductID   int64     `db:"product_id"`
+	ProductName string    `db:"product_name"`
+	Status      string    `db:"status"`
+	Changed     bool      `db:"changed"`
+	OldPrice    int64     `db:"old_price"`
+	NewPrice    int64     `db:"new_price"`
+	Error       string    `db:"error"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+const (
+	RefreshStatusSuccess = "success"
+	RefreshStatusFailed  = "failed"
+)
+
+type Job struct {
+	ID          int64     `db:"id"`
+	Kind        string    `db:"kind"`
+	Payload     string    `db:"payload"`
+	Status      string    `db:"status"`
+	Attempts    int       `db:"attempts"`
+	MaxAttempts int       `db:"max_attempts"`
+	LastError   string    `db:"last_error"`
+	Result      string    `db:"result"`
+	RunAt       time.Time `db:"run_at"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+type JobPayload struct {
+	Kind        string
+	Payload     string
+	MaxAttempts int
+	RunAt       time.Time
+}
+
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// JobStaleError is recorded on a job left running by a worker that stopped,
+// once the job has no attempts left.
+const JobStaleError = "worker stopped while running the job"
+
+// OrphanCount is the number of rows in a table whose parent row is missing.
+type OrphanCount struct {
+	Table string
+	Rows  int64
+}
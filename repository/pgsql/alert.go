@@ -0,0 +1,271 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const alertRuleColumns = `r.id, coalesce(r.product_id, 0) product_id, coalesce(r.tag, '') tag, r.kind,
+	r.threshold, r.window_hours, r.channels, r.cooldown_minutes, r.hysteresis, r.quiet_start, r.quiet_end,
+	r.timezone, r.expression, r.snoozed_until, r.enabled, r.created_at`
+
+const alertEventColumns = `e.id, e.rule_id, e.product_id, p.name product_name, e.kind, e.price,
+	e.previous_price, e.message, e.created_at, e.acknowledged_at`
+
+func (r *repository) CreateAlertRule(ctx context.Context, rule model.AlertRule) (int64, error) {
+	sql := `INSERT INTO alert_rule (product_id, tag, kind, threshold, window_hours, channels, cooldown_minutes,
+		hysteresis, quiet_start, quiet_end, timezone, expression, enabled)
+		VALUES (NULLIF($1, 0), NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, rule.ProductID, rule.Tag, rule.Kind, rule.Threshold,
+		rule.WindowHours, rule.Channels, rule.CooldownMinutes, rule.Hysteresis, rule.QuietStart, rule.QuietEnd,
+		rule.Timezone, rule.Expression, rule.Enabled).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) GetAlertRules(ctx context.Context) ([]model.AlertRule, error) {
+	sql := `SELECT ` + alertRuleColumns + ` FROM alert_rule r ORDER BY r.id`
+
+	var rules []model.AlertRule
+	err := r.db.SelectContext(ctx, &rules, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (r *repository) GetAlertRuleByID(ctx context.Context, id int64) (model.AlertRule, error) {
+	sql := `SELECT ` + alertRuleColumns + ` FROM alert_rule r WHERE r.id = $1`
+
+	var rule model.AlertRule
+	err := r.db.GetContext(ctx, &rule, sql, id)
+	if err != nil {
+		return model.AlertRule{}, err
+	}
+
+	return rule, nil
+}
+
+// UpdateAlertRule stores the edited settings of a rule and forgets where it
+// stood for its products, so that its new conditions start out armed.
+func (r *repository) UpdateAlertRule(ctx context.Context, rule model.AlertRule) error {
+	sqlRule := `UPDATE alert_rule SET product_id = NULLIF($1, 0), tag = NULLIF($2, ''), kind = $3, threshold = $4,
+		window_hours = $5, channels = $6, cooldown_minutes = $7, hysteresis = $8, quiet_start = $9, quiet_end = $10,
+		timezone = $11, expression = $12, enabled = $13 WHERE id = $14`
+	sqlState := `DELETE FROM alert_state WHERE rule_id = $1`
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, sqlRule, rule.ProductID, rule.Tag, rule.Kind, rule.Threshold, rule.WindowHours,
+		rule.Channels, rule.CooldownMinutes, rule.Hysteresis, rule.QuietStart, rule.QuietEnd, rule.Timezone,
+		rule.Expression, rule.Enabled, rule.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, sqlState, rule.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAlertRule deletes a rule together with its events.
+func (r *repository) DeleteAlertRule(ctx context.Context, id int64) error {
+	sql := `DELETE FROM alert_rule WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
+// GetAlertRulesForProduct returns the enabled rules on a product and on any
+// of its tags.
+func (r *repository) GetAlertRulesForProduct(ctx context.Context, productID int64) ([]model.AlertRule, error) {
+	sql := `SELECT ` + alertRuleColumns + ` FROM alert_rule r
+		WHERE r.enabled AND (r.product_id = $1 OR r.tag IN (
+			SELECT t.name FROM product_tag pt JOIN tag t ON t.id = pt.tag_id WHERE pt.product_id = $1))
+		ORDER BY r.id`
+
+	var rules []model.AlertRule
+	err := r.db.SelectContext(ctx, &rules, sql, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// SnoozeAlertRule keeps a rule from firing until the time given, or lets it
+// fire again when until is null.
+func (r *repository) SnoozeAlertRule(ctx context.Context, id int64, until sql.NullTime) error {
+	sql := `UPDATE alert_rule SET snoozed_until = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, sql, until, id)
+
+	return err
+}
+
+// GetAlertState returns where a rule stands for a product, or sql.ErrNoRows
+// when it never fired for it.
+func (r *repository) GetAlertState(ctx context.Context, ruleID, productID int64) (model.AlertState, error) {
+	sql := `SELECT rule_id, product_id, armed, last_fired_at FROM alert_state WHERE rule_id = $1 AND product_id = $2`
+
+	var state model.AlertState
+	err := r.db.GetContext(ctx, &state, sql, ruleID, productID)
+	if err != nil {
+		return model.AlertState{}, err
+	}
+
+	return state, nil
+}
+
+func (r *repository) SaveAlertState(ctx context.Context, state model.AlertState) error {
+	sql := `INSERT INTO alert_state (rule_id, product_id, armed, last_fired_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (rule_id, product_id) DO UPDATE SET armed = $3, last_fired_at = $4`
+	_, err := r.db.ExecContext(ctx, sql, state.RuleID, state.ProductID, state.Armed, state.LastFiredAt)
+
+	return err
+}
+
+// AcknowledgeAlertEvent marks an event as seen; acknowledging it again
+// keeps the first time.
+func (r *repository) AcknowledgeAlertEvent(ctx context.Context, id int64) error {
+	sql := `UPDATE alert_event SET acknowledged_at = coalesce(acknowledged_at, now()) WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
+func (r *repository) InsertAlertEvent(ctx context.Context, event model.AlertEvent) (int64, error) {
+	sql := `INSERT INTO alert_event (rule_id, product_id, kind, price, previous_price, message)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, event.RuleID, event.ProductID, event.Kind, event.Price,
+		event.PreviousPrice, event.Message).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetAlertEvents returns events newest first, only those of productID unless
+// it is zero.
+func (r *repository) GetAlertEvents(ctx context.Context, productID int64, limit, offset int) ([]model.AlertEvent, error) {
+	sql := `SELECT ` + alertEventColumns + ` FROM alert_event e JOIN product p ON p.id = e.product_id
+		WHERE $1 = 0 OR e.product_id = $1
+		ORDER BY e.created_at DESC, e.id DESC LIMIT $2 OFFSET $3`
+
+	var events []model.AlertEvent
+	err := r.db.SelectContext(ctx, &events, sql, productID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *repository) GetTotalAlertEvent(ctx context.Context, productID int64) (int64, error) {
+	sql := `SELECT count(*) FROM alert_event WHERE $1 = 0 OR product_id = $1`
+
+	var total int64
+	err := r.db.GetContext(ctx, &total, sql, productID)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (r *repository) GetAlertEventByID(ctx context.Context, id int64) (model.AlertEvent, error) {
+	sql := `SELECT ` + alertEventColumns + ` FROM alert_event e JOIN product p ON p.id = e.product_id
+		WHERE e.id = $1`
+
+	var event model.AlertEvent
+	err := r.db.GetContext(ctx, &event, sql, id)
+	if err != nil {
+		return model.AlertEvent{}, err
+	}
+
+	return event, nil
+}
+
+const alertDeliveryColumns = `d.id, d.event_id, d.channel, d.status, d.attempts, coalesce(d.last_error, '') last_error,
+	d.sent_at, d.created_at, d.updated_at, p.name product_name, e.message`
+
+const alertDeliveryTables = `alert_delivery d JOIN alert_event e ON e.id = d.event_id
+	JOIN product p ON p.id = e.product_id`
+
+func (r *repository) InsertAlertDelivery(ctx context.Context, delivery model.AlertDelivery) (int64, error) {
+	sql := `INSERT INTO alert_delivery (event_id, channel, status) VALUES ($1, $2, $3) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, delivery.EventID, delivery.Channel, delivery.Status).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) GetAlertDeliveryByID(ctx context.Context, id int64) (model.AlertDelivery, error) {
+	sql := `SELECT ` + alertDeliveryColumns + ` FROM ` + alertDeliveryTables + ` WHERE d.id = $1`
+
+	var delivery model.AlertDelivery
+	err := r.db.GetContext(ctx, &delivery, sql, id)
+	if err != nil {
+		return model.AlertDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+// RecordAlertDeliveryAttempt counts a delivery attempt and stores its
+// outcome. An empty errMessage clears the last error.
+func (r *repository) RecordAlertDeliveryAttempt(ctx context.Context, id int64, status, errMessage string) error {
+	sql := `UPDATE alert_delivery SET status = $1, attempts = attempts + 1, last_error = NULLIF($2, ''),
+		sent_at = CASE WHEN $1 = $3 THEN now() ELSE sent_at END, updated_at = now() WHERE id = $4`
+	_, err := r.db.ExecContext(ctx, sql, status, errMessage, model.DeliveryStatusSent, id)
+
+	return err
+}
+
+// GetAlertDeliveries returns deliveries newest first, only those of events
+// on productID unless it is zero.
+func (r *repository) GetAlertDeliveries(ctx context.Context, productID int64, limit, offset int) ([]model.AlertDelivery, error) {
+	sql := `SELECT ` + alertDeliveryColumns + ` FROM ` + alertDeliveryTables + `
+		WHERE $1 = 0 OR e.product_id = $1
+		ORDER BY d.created_at DESC, d.id DESC LIMIT $2 OFFSET $3`
+
+	var deliveries []model.AlertDelivery
+	err := r.db.SelectContext(ctx, &deliveries, sql, productID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) GetTotalAlertDelivery(ctx context.Context, productID int64) (int64, error) {
+	sql := `SELECT count(*) FROM alert_delivery d JOIN alert_event e ON e.id = d.event_id
+		WHERE $1 = 0 OR e.product_id = $1`
+
+	var total int64
+	err := r.db.GetContext(ctx, &total, sql, productID)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
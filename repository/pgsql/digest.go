@@ -0,0 +1,124 @@
+package pgsql
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const digestColumns = `d.id, coalesce(d.collection_id, 0) collection_id, coalesce(c.name, '') collection_name,
+	d.period, d.channels, d.created_at`
+
+const digestReportColumns = `r.id, r.digest_id, r.period_start, r.period_end, r.content, r.created_at`
+
+func (r *repository) CreateDigest(ctx context.Context, digest model.Digest) (int64, error) {
+	sql := `INSERT INTO digest (collection_id, period, channels) VALUES (NULLIF($1, 0), $2, $3) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, digest.CollectionID, digest.Period, digest.Channels).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) GetDigests(ctx context.Context) ([]model.Digest, error) {
+	sql := `SELECT ` + digestColumns + ` FROM digest d LEFT JOIN collection c ON c.id = d.collection_id
+		ORDER BY d.id`
+
+	var digests []model.Digest
+	err := r.db.SelectContext(ctx, &digests, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+func (r *repository) GetDigestByID(ctx context.Context, id int64) (model.Digest, error) {
+	sql := `SELECT ` + digestColumns + ` FROM digest d LEFT JOIN collection c ON c.id = d.collection_id
+		WHERE d.id = $1`
+
+	var digest model.Digest
+	err := r.db.GetContext(ctx, &digest, sql, id)
+	if err != nil {
+		return model.Digest{}, err
+	}
+
+	return digest, nil
+}
+
+// DeleteDigest deletes a digest with its reports.
+func (r *repository) DeleteDigest(ctx context.Context, id int64) error {
+	sql := `DELETE FROM digest WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
+func (r *repository) InsertDigestReport(ctx context.Context, report model.DigestReport) (int64, error) {
+	sql := `INSERT INTO digest_report (digest_id, period_start, period_end, content)
+		VALUES ($1, $2, $3, $4) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, report.DigestID, report.PeriodStart, report.PeriodEnd,
+		report.Content).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) GetDigestReportByID(ctx context.Context, id int64) (model.DigestReport, error) {
+	sql := `SELECT ` + digestReportColumns + ` FROM digest_report r WHERE r.id = $1`
+
+	var report model.DigestReport
+	err := r.db.GetContext(ctx, &report, sql, id)
+	if err != nil {
+		return model.DigestReport{}, err
+	}
+
+	return report, nil
+}
+
+// GetLastDigestReport returns the report of a digest ending last, or
+// sql.ErrNoRows when it has none.
+func (r *repository) GetLastDigestReport(ctx context.Context, digestID int64) (model.DigestReport, error) {
+	sql := `SELECT ` + digestReportColumns + ` FROM digest_report r WHERE r.digest_id = $1
+		ORDER BY r.period_end DESC, r.id DESC LIMIT 1`
+
+	var report model.DigestReport
+	err := r.db.GetContext(ctx, &report, sql, digestID)
+	if err != nil {
+		return model.DigestReport{}, err
+	}
+
+	return report, nil
+}
+
+// GetDigestReports returns reports newest first, only those of digestID
+// unless it is zero.
+func (r *repository) GetDigestReports(ctx context.Context, digestID int64, limit, offset int) ([]model.DigestReport, error) {
+	sql := `SELECT ` + digestReportColumns + ` FROM digest_report r WHERE $1 = 0 OR r.digest_id = $1
+		ORDER BY r.period_end DESC, r.id DESC LIMIT $2 OFFSET $3`
+
+	var reports []model.DigestReport
+	err := r.db.SelectContext(ctx, &reports, sql, digestID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (r *repository) GetTotalDigestReport(ctx context.Context, digestID int64) (int64, error) {
+	sql := `SELECT count(*) FROM digest_report WHERE $1 = 0 OR digest_id = $1`
+
+	var total int64
+	err := r.db.GetContext(ctx, &total, sql, digestID)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
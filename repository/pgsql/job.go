@@ -0,0 +1,153 @@
+package pgsql
+
+import (
+	"context"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const jobColumns = `id, kind, payload::text payload, status, attempts, max_attempts, coalesce(last_error, '') last_error,
+	coalesce(result::text, '') result, run_at, created_at, updated_at`
+
+func (r *repository) EnqueueJob(ctx context.Context, payload model.JobPayload) (int64, error) {
+	sql := `INSERT INTO job (kind, payload, status, max_attempts, run_at) VALUES ($1, $2::jsonb, $3, $4, $5)
+		RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, payload.Kind, payload.Payload, model.JobStatusPending, payload.MaxAttempts,
+		payload.RunAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// EnqueueJobs queues all the jobs or, on error, none of them, and returns
+// their ids in order.
+func (r *repository) EnqueueJobs(ctx context.Context, payloads []model.JobPayload) ([]int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO job (kind, payload, status, max_attempts, run_at) VALUES ($1, $2::jsonb, $3, $4, $5)
+		RETURNING id`
+
+	ids := make([]int64, 0, len(payloads))
+	for _, payload := range payloads {
+		var id int64
+		err = tx.QueryRowContext(ctx, sql, payload.Kind, payload.Payload, model.JobStatusPending,
+			payload.MaxAttempts, payload.RunAt).Scan(&id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ClaimJob marks the oldest due pending job as running and returns it. A zero
+// Job is returned when nothing is due. Concurrent workers never claim the
+// same job.
+func (r *repository) ClaimJob(ctx context.Context) (model.Job, error) {
+	sql := `UPDATE job SET status = $1, attempts = attempts + 1, updated_at = now()
+		WHERE id = (SELECT id FROM job WHERE status = $2 AND run_at <= now()
+			ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING ` + jobColumns
+
+	var jobs []model.Job
+	err := r.db.SelectContext(ctx, &jobs, sql, model.JobStatusRunning, model.JobStatusPending)
+	if err != nil {
+		return model.Job{}, err
+	}
+	if len(jobs) == 0 {
+		return model.Job{}, nil
+	}
+
+	return jobs[0], nil
+}
+
+func (r *repository) CompleteJob(ctx context.Context, id int64, result string) error {
+	sql := `UPDATE job SET status = $1, result = $2::jsonb, last_error = NULL, updated_at = now() WHERE id = $3`
+	_, err := r.db.ExecContext(ctx, sql, model.JobStatusDone, result, id)
+
+	return err
+}
+
+// FailJob records a failed attempt. The job is put back in the queue to run
+// again at retryAt, or marked failed when retryAt is zero.
+func (r *repository) FailJob(ctx context.Context, id int64, errMessage string, retryAt time.Time) error {
+	if retryAt.IsZero() {
+		sql := `UPDATE job SET status = $1, last_error = $2, updated_at = now() WHERE id = $3`
+		_, err := r.db.ExecContext(ctx, sql, model.JobStatusFailed, errMessage, id)
+		return err
+	}
+
+	sql := `UPDATE job SET status = $1, last_error = $2, run_at = $3, updated_at = now() WHERE id = $4`
+	_, err := r.db.ExecContext(ctx, sql, model.JobStatusPending, errMessage, retryAt, id)
+
+	return err
+}
+
+// RequeueStaleJobs returns jobs stuck in running since before the given time,
+// e.g. because their worker was killed, to the queue. Jobs out of attempts
+// are marked failed instead, so one that crashes its worker is not retried
+// forever.
+func (r *repository) RequeueStaleJobs(ctx context.Context, before time.Time) (int64, int64, error) {
+	sqlFail := `UPDATE job SET status = $1, last_error = $2, updated_at = now()
+		WHERE status = $3 AND updated_at < $4 AND attempts >= max_attempts`
+	res, err := r.db.ExecContext(ctx, sqlFail, model.JobStatusFailed, model.JobStaleError, model.JobStatusRunning, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	failed, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sqlRequeue := `UPDATE job SET status = $1, updated_at = now()
+		WHERE status = $2 AND updated_at < $3 AND attempts < max_attempts`
+	res, err = r.db.ExecContext(ctx, sqlRequeue, model.JobStatusPending, model.JobStatusRunning, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return requeued, failed, nil
+}
+
+// CountOverdueJobs counts pending jobs that were due before the given time
+// and have not been claimed since.
+func (r *repository) CountOverdueJobs(ctx context.Context, before time.Time) (int64, error) {
+	sql := `SELECT count(*) FROM job WHERE status = $1 AND run_at < $2`
+
+	var count int64
+	err := r.db.GetContext(ctx, &count, sql, model.JobStatusPending, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *repository) GetJobByID(ctx context.Context, id int64) (model.Job, error) {
+	sql := `SELECT ` + jobColumns + ` FROM job WHERE id = $1`
+
+	var job model.Job
+	err := r.db.GetContext(ctx, &job, sql, id)
+	if err != nil {
+		return model.Job{}, err
+	}
+
+	return job, nil
+}
@@ -0,0 +1,15 @@
+package pgsql
+
+import (
+	"embed"
+
+	"github.com/ediprako/pricemonitor/repository/migration"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations returns the schema migrations for the Postgres repository.
+func Migrations() ([]migration.Migration, error) {
+	return migration.Load(migrationFiles, "migrations")
+}
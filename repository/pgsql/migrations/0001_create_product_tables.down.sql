@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.product_images;
+DROP TABLE IF EXISTS public.price_history;
+DROP TABLE IF EXISTS public.product;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS public.product (
+	id bigserial NOT NULL,
+	"name" varchar NOT NULL,
+	current_price int8 NOT NULL,
+	original_price int8 NOT NULL,
+	updated_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	url varchar NULL,
+	CONSTRAINT product_pk PRIMARY KEY (id),
+	CONSTRAINT product_un UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.price_history (
+	id bigserial NOT NULL,
+	product_id int8 NOT NULL,
+	current_price int8 NOT NULL,
+	original_price int8 NOT NULL,
+	updated_at information_schema."time_stamp" NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS public.product_images (
+	id bigserial NOT NULL,
+	product_id int8 NOT NULL,
+	image varchar NOT NULL,
+	status int NOT NULL,
+	CONSTRAINT product_images_pk PRIMARY KEY (id)
+);
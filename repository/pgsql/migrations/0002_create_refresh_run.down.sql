@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.refresh_run_item;
+DROP TABLE IF EXISTS public.refresh_run;
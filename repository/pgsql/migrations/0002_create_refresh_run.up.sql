@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS public.refresh_run (
+	id bigserial NOT NULL,
+	started_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	finished_at information_schema."time_stamp" NULL,
+	attempted int NOT NULL DEFAULT 0,
+	succeeded int NOT NULL DEFAULT 0,
+	failed int NOT NULL DEFAULT 0,
+	changed int NOT NULL DEFAULT 0,
+	CONSTRAINT refresh_run_pk PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS public.refresh_run_item (
+	id bigserial NOT NULL,
+	run_id int8 NOT NULL,
+	product_id int8 NOT NULL,
+	status varchar NOT NULL,
+	changed bool NOT NULL DEFAULT false,
+	old_price int8 NOT NULL DEFAULT 0,
+	new_price int8 NOT NULL DEFAULT 0,
+	error varchar NULL,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT refresh_run_item_pk PRIMARY KEY (id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS public.job (
+	id bigserial NOT NULL,
+	kind varchar NOT NULL,
+	payload jsonb NOT NULL DEFAULT '{}',
+	status varchar NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	max_attempts int NOT NULL DEFAULT 1,
+	last_error varchar NULL,
+	result jsonb NULL,
+	run_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	updated_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT job_pk PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS job_status_run_at_idx ON public.job (status, run_at);
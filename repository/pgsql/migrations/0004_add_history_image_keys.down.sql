@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS public.refresh_run_item_run_idx;
+ALTER TABLE public.refresh_run_item DROP CONSTRAINT IF EXISTS refresh_run_item_product_fk;
+ALTER TABLE public.refresh_run_item DROP CONSTRAINT IF EXISTS refresh_run_item_run_fk;
+
+DROP INDEX IF EXISTS public.product_images_product_status_idx;
+ALTER TABLE public.product_images DROP CONSTRAINT IF EXISTS product_images_product_fk;
+
+DROP INDEX IF EXISTS public.price_history_product_updated_idx;
+ALTER TABLE public.price_history DROP CONSTRAINT IF EXISTS price_history_product_fk;
+ALTER TABLE public.price_history DROP CONSTRAINT IF EXISTS price_history_pk;
@@ -0,0 +1,16 @@
+-- Foreign keys fail on rows pointing at products that no longer exist.
+-- Run `-mode=cleanup report` to list them and `-mode=cleanup remove` to delete them.
+ALTER TABLE public.price_history ADD CONSTRAINT price_history_pk PRIMARY KEY (id);
+ALTER TABLE public.price_history ADD CONSTRAINT price_history_product_fk
+	FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE;
+CREATE INDEX price_history_product_updated_idx ON public.price_history (product_id, updated_at);
+
+ALTER TABLE public.product_images ADD CONSTRAINT product_images_product_fk
+	FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE;
+CREATE INDEX product_images_product_status_idx ON public.product_images (product_id, status);
+
+ALTER TABLE public.refresh_run_item ADD CONSTRAINT refresh_run_item_run_fk
+	FOREIGN KEY (run_id) REFERENCES public.refresh_run (id) ON DELETE CASCADE;
+ALTER TABLE public.refresh_run_item ADD CONSTRAINT refresh_run_item_product_fk
+	FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE;
+CREATE INDEX refresh_run_item_run_idx ON public.refresh_run_item (run_id);
@@ -0,0 +1,7 @@
+ALTER TABLE public.price_history DROP COLUMN IF EXISTS in_stock;
+
+DROP INDEX IF EXISTS public.product_last_checked_idx;
+ALTER TABLE public.product DROP COLUMN IF EXISTS last_check_error;
+ALTER TABLE public.product DROP COLUMN IF EXISTS check_failures;
+ALTER TABLE public.product DROP COLUMN IF EXISTS last_checked_at;
+ALTER TABLE public.product DROP COLUMN IF EXISTS in_stock;
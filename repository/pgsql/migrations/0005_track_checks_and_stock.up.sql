@@ -0,0 +1,12 @@
+-- updated_at now only moves when a price or the stock state changes;
+-- last_checked_at records every refresh attempt.
+ALTER TABLE public.product ADD COLUMN in_stock bool NOT NULL DEFAULT true;
+ALTER TABLE public.product ADD COLUMN last_checked_at information_schema."time_stamp" NULL;
+ALTER TABLE public.product ADD COLUMN check_failures int NOT NULL DEFAULT 0;
+ALTER TABLE public.product ADD COLUMN last_check_error varchar NULL;
+UPDATE public.product SET last_checked_at = updated_at;
+ALTER TABLE public.product ALTER COLUMN last_checked_at SET NOT NULL;
+ALTER TABLE public.product ALTER COLUMN last_checked_at SET DEFAULT now();
+CREATE INDEX product_last_checked_idx ON public.product (last_checked_at);
+
+ALTER TABLE public.price_history ADD COLUMN in_stock bool NOT NULL DEFAULT true;
@@ -0,0 +1,34 @@
+-- Rollups summarise the current price per product and bucket: open/close are
+-- the first and last recorded prices, avg is over the recorded rows, and
+-- original_price/in_stock are taken from the last row.
+CREATE TABLE IF NOT EXISTS public.price_history_daily (
+	product_id int8 NOT NULL,
+	bucket date NOT NULL,
+	open_price int8 NOT NULL,
+	close_price int8 NOT NULL,
+	min_price int8 NOT NULL,
+	max_price int8 NOT NULL,
+	avg_price int8 NOT NULL,
+	original_price int8 NOT NULL,
+	in_stock bool NOT NULL,
+	samples int NOT NULL,
+	CONSTRAINT price_history_daily_pk PRIMARY KEY (product_id, bucket),
+	CONSTRAINT price_history_daily_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.price_history_weekly (
+	product_id int8 NOT NULL,
+	bucket date NOT NULL,
+	open_price int8 NOT NULL,
+	close_price int8 NOT NULL,
+	min_price int8 NOT NULL,
+	max_price int8 NOT NULL,
+	avg_price int8 NOT NULL,
+	original_price int8 NOT NULL,
+	in_stock bool NOT NULL,
+	samples int NOT NULL,
+	CONSTRAINT price_history_weekly_pk PRIMARY KEY (product_id, bucket),
+	CONSTRAINT price_history_weekly_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS price_history_updated_idx ON public.price_history (updated_at);
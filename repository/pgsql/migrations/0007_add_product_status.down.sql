@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.product_status_idx;
+ALTER TABLE public.product DROP COLUMN IF EXISTS status;
@@ -0,0 +1,3 @@
+-- Archived products keep their history but are no longer listed or refreshed.
+ALTER TABLE public.product ADD COLUMN status int NOT NULL DEFAULT 1;
+CREATE INDEX product_status_idx ON public.product (status);
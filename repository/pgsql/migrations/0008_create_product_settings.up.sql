@@ -0,0 +1,12 @@
+-- User-editable settings live apart from the scraped product columns, which
+-- every refresh overwrites.
+CREATE TABLE IF NOT EXISTS public.product_settings (
+	product_id int8 NOT NULL,
+	display_name varchar NULL,
+	notes text NULL,
+	target_price int8 NULL,
+	refresh_interval_minutes int NULL,
+	updated_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT product_settings_pk PRIMARY KEY (product_id),
+	CONSTRAINT product_settings_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE
+);
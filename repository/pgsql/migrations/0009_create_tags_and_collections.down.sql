@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.collection_product;
+DROP TABLE IF EXISTS public."collection";
+DROP TABLE IF EXISTS public.product_tag;
+DROP TABLE IF EXISTS public.tag;
@@ -0,0 +1,35 @@
+-- Tags are free-form labels shared by products; collections are named,
+-- hand-picked groups of products.
+CREATE TABLE IF NOT EXISTS public.tag (
+	id bigserial NOT NULL,
+	"name" varchar NOT NULL,
+	CONSTRAINT tag_pk PRIMARY KEY (id),
+	CONSTRAINT tag_name_un UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.product_tag (
+	product_id int8 NOT NULL,
+	tag_id int8 NOT NULL,
+	CONSTRAINT product_tag_pk PRIMARY KEY (product_id, tag_id),
+	CONSTRAINT product_tag_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE,
+	CONSTRAINT product_tag_tag_fk FOREIGN KEY (tag_id) REFERENCES public.tag (id) ON DELETE CASCADE
+);
+CREATE INDEX product_tag_tag_idx ON public.product_tag (tag_id);
+
+CREATE TABLE IF NOT EXISTS public."collection" (
+	id bigserial NOT NULL,
+	"name" varchar NOT NULL,
+	description text NULL,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT collection_pk PRIMARY KEY (id),
+	CONSTRAINT collection_name_un UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.collection_product (
+	collection_id int8 NOT NULL,
+	product_id int8 NOT NULL,
+	CONSTRAINT collection_product_pk PRIMARY KEY (collection_id, product_id),
+	CONSTRAINT collection_product_collection_fk FOREIGN KEY (collection_id) REFERENCES public."collection" (id) ON DELETE CASCADE,
+	CONSTRAINT collection_product_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE
+);
+CREATE INDEX collection_product_product_idx ON public.collection_product (product_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.product_search_idx;
+ALTER TABLE public.product DROP COLUMN IF EXISTS search_vector;
+DROP INDEX IF EXISTS public.product_marketplace_idx;
+ALTER TABLE public.product DROP COLUMN IF EXISTS marketplace;
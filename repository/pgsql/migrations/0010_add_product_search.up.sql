@@ -0,0 +1,9 @@
+-- marketplace is the host a product is sold on. search_vector indexes the
+-- name and the words of the URL for the product list search.
+ALTER TABLE public.product ADD COLUMN marketplace varchar NOT NULL DEFAULT '';
+UPDATE public.product SET marketplace = coalesce(lower(substring(url from '://(?:www\.)?([^/:?#]+)')), '');
+CREATE INDEX product_marketplace_idx ON public.product (marketplace);
+
+ALTER TABLE public.product ADD COLUMN search_vector tsvector GENERATED ALWAYS AS
+	(to_tsvector('simple', coalesce(name, '') || ' ' || regexp_replace(coalesce(url, ''), '[^[:alnum:]]+', ' ', 'g'))) STORED;
+CREATE INDEX product_search_idx ON public.product USING gin (search_vector);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.alert_event;
+DROP TABLE IF EXISTS public.alert_rule;
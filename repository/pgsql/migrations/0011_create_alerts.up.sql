@@ -0,0 +1,33 @@
+-- An alert rule watches one product, or every product with a tag, and an
+-- alert event is stored each time a price write satisfies it.
+CREATE TABLE IF NOT EXISTS public.alert_rule (
+	id bigserial NOT NULL,
+	product_id int8 NULL,
+	tag varchar NULL,
+	kind varchar NOT NULL,
+	threshold int8 NOT NULL DEFAULT 0,
+	window_hours int NOT NULL DEFAULT 0,
+	enabled bool NOT NULL DEFAULT true,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT alert_rule_pk PRIMARY KEY (id),
+	CONSTRAINT alert_rule_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE,
+	CONSTRAINT alert_rule_target_ck CHECK ((product_id IS NULL) <> (tag IS NULL))
+);
+CREATE INDEX alert_rule_product_idx ON public.alert_rule (product_id);
+CREATE INDEX alert_rule_tag_idx ON public.alert_rule (tag);
+
+CREATE TABLE IF NOT EXISTS public.alert_event (
+	id bigserial NOT NULL,
+	rule_id int8 NOT NULL,
+	product_id int8 NOT NULL,
+	kind varchar NOT NULL,
+	price int8 NOT NULL,
+	previous_price int8 NOT NULL,
+	message text NOT NULL,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT alert_event_pk PRIMARY KEY (id),
+	CONSTRAINT alert_event_rule_fk FOREIGN KEY (rule_id) REFERENCES public.alert_rule (id) ON DELETE CASCADE,
+	CONSTRAINT alert_event_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE
+);
+CREATE INDEX alert_event_product_idx ON public.alert_event (product_id, created_at);
+CREATE INDEX alert_event_created_idx ON public.alert_event (created_at);
@@ -0,0 +1,17 @@
+-- One row per fired alert and notification channel, updated on every
+-- delivery attempt.
+CREATE TABLE IF NOT EXISTS public.alert_delivery (
+	id bigserial NOT NULL,
+	event_id int8 NOT NULL,
+	channel varchar NOT NULL,
+	status varchar NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	last_error text NULL,
+	sent_at information_schema."time_stamp" NULL,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	updated_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT alert_delivery_pk PRIMARY KEY (id),
+	CONSTRAINT alert_delivery_event_fk FOREIGN KEY (event_id) REFERENCES public.alert_event (id) ON DELETE CASCADE
+);
+CREATE INDEX alert_delivery_event_idx ON public.alert_delivery (event_id);
+CREATE INDEX alert_delivery_created_idx ON public.alert_delivery (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.webhook_delivery;
+DROP TABLE IF EXISTS public.webhook;
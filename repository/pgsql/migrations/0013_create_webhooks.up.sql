@@ -0,0 +1,30 @@
+-- A webhook subscribes a URL to a comma-separated list of event types. Each
+-- event it receives is logged as a delivery holding the exact body sent, so
+-- retries send the same payload.
+CREATE TABLE IF NOT EXISTS public.webhook (
+	id bigserial NOT NULL,
+	url text NOT NULL,
+	secret varchar NOT NULL,
+	events varchar NOT NULL,
+	enabled bool NOT NULL DEFAULT true,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT webhook_pk PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS public.webhook_delivery (
+	id bigserial NOT NULL,
+	webhook_id int8 NOT NULL,
+	event varchar NOT NULL,
+	payload text NOT NULL,
+	status varchar NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	status_code int NOT NULL DEFAULT 0,
+	last_error text NULL,
+	delivered_at information_schema."time_stamp" NULL,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	updated_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT webhook_delivery_pk PRIMARY KEY (id),
+	CONSTRAINT webhook_delivery_webhook_fk FOREIGN KEY (webhook_id) REFERENCES public.webhook (id) ON DELETE CASCADE
+);
+CREATE INDEX webhook_delivery_webhook_idx ON public.webhook_delivery (webhook_id, created_at);
+CREATE INDEX webhook_delivery_created_idx ON public.webhook_delivery (created_at);
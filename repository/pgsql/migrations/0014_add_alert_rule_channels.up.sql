@@ -0,0 +1,3 @@
+-- The notification channels a rule is delivered over, separated by commas;
+-- empty means every configured channel.
+ALTER TABLE public.alert_rule ADD COLUMN channels text NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.digest_report;
+DROP TABLE IF EXISTS public.digest;
@@ -0,0 +1,24 @@
+-- A digest summarizes the products of a collection, or every product when
+-- collection_id is null, once a day or once a week. Each summary sent is kept
+-- as a report whose content is the summary as JSON.
+CREATE TABLE IF NOT EXISTS public.digest (
+	id bigserial NOT NULL,
+	collection_id int8 NULL,
+	period varchar NOT NULL,
+	channels varchar NOT NULL DEFAULT '',
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT digest_pk PRIMARY KEY (id),
+	CONSTRAINT digest_collection_fk FOREIGN KEY (collection_id) REFERENCES public.collection (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.digest_report (
+	id bigserial NOT NULL,
+	digest_id int8 NOT NULL,
+	period_start information_schema."time_stamp" NOT NULL,
+	period_end information_schema."time_stamp" NOT NULL,
+	content text NOT NULL,
+	created_at information_schema."time_stamp" NOT NULL DEFAULT now(),
+	CONSTRAINT digest_report_pk PRIMARY KEY (id),
+	CONSTRAINT digest_report_digest_fk FOREIGN KEY (digest_id) REFERENCES public.digest (id) ON DELETE CASCADE
+);
+CREATE INDEX digest_report_digest_idx ON public.digest_report (digest_id, period_end);
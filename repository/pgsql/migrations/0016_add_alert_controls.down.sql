@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS public.alert_state;
+ALTER TABLE public.alert_event DROP COLUMN IF EXISTS acknowledged_at;
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS snoozed_until;
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS timezone;
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS quiet_end;
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS quiet_start;
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS hysteresis;
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS cooldown_minutes;
@@ -0,0 +1,25 @@
+-- A rule fires again for a product only after cooldown_minutes, and with
+-- hysteresis only once the product stopped satisfying it. Its deliveries
+-- wait for the end of quiet hours, read in the rule's timezone, and it does
+-- not fire at all while snoozed.
+ALTER TABLE public.alert_rule ADD COLUMN cooldown_minutes int NOT NULL DEFAULT 0;
+ALTER TABLE public.alert_rule ADD COLUMN hysteresis bool NOT NULL DEFAULT false;
+ALTER TABLE public.alert_rule ADD COLUMN quiet_start varchar NOT NULL DEFAULT '';
+ALTER TABLE public.alert_rule ADD COLUMN quiet_end varchar NOT NULL DEFAULT '';
+ALTER TABLE public.alert_rule ADD COLUMN timezone varchar NOT NULL DEFAULT '';
+ALTER TABLE public.alert_rule ADD COLUMN snoozed_until information_schema."time_stamp" NULL;
+
+ALTER TABLE public.alert_event ADD COLUMN acknowledged_at information_schema."time_stamp" NULL;
+
+-- Where a rule stands for each product it watches. A rule is disarmed for a
+-- product when it fires with hysteresis or its event is acknowledged, and
+-- rearmed once the product no longer satisfies it.
+CREATE TABLE IF NOT EXISTS public.alert_state (
+	rule_id int8 NOT NULL,
+	product_id int8 NOT NULL,
+	armed bool NOT NULL DEFAULT true,
+	last_fired_at information_schema."time_stamp" NULL,
+	CONSTRAINT alert_state_pk PRIMARY KEY (rule_id, product_id),
+	CONSTRAINT alert_state_rule_fk FOREIGN KEY (rule_id) REFERENCES public.alert_rule (id) ON DELETE CASCADE,
+	CONSTRAINT alert_state_product_fk FOREIGN KEY (product_id) REFERENCES public.product (id) ON DELETE CASCADE
+);
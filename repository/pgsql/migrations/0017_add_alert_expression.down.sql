@@ -0,0 +1 @@
+ALTER TABLE public.alert_rule DROP COLUMN IF EXISTS expression;
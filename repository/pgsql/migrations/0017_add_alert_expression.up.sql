@@ -0,0 +1,3 @@
+-- The condition of an expression rule, such as
+-- price < 90% * avg(price, 30d).
+ALTER TABLE public.alert_rule ADD COLUMN expression varchar NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Rollups also count the price carried into a bucket from before it, and
+-- each run only recomputes the buckets of raw rows recorded after
+-- last_history_id, the last row the previous run saw.
+CREATE TABLE IF NOT EXISTS public.price_rollup_state (
+	id int4 NOT NULL,
+	last_history_id int8 NOT NULL,
+	CONSTRAINT price_rollup_state_pk PRIMARY KEY (id),
+	CONSTRAINT price_rollup_state_single CHECK (id = 1)
+);
@@ -0,0 +1 @@
+ALTER TABLE public.price_rollup_state DROP COLUMN IF EXISTS rolled_up_at;
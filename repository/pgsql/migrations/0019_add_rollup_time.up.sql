@@ -0,0 +1,4 @@
+-- History ids are taken from a sequence before their transaction commits, so
+-- a row can show up behind last_history_id. Each run also recomputes the
+-- buckets of rows recorded shortly before rolled_up_at, the previous run.
+ALTER TABLE public.price_rollup_state ADD COLUMN rolled_up_at information_schema."time_stamp" NULL;
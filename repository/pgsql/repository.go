@@ -3,8 +3,11 @@ package pgsql
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ediprako/pricemonitor/repository/model"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
@@ -19,56 +22,34 @@ func New(db *sqlx.DB) *repository {
 	}
 }
 
-type ProductPayload struct {
-	Name          string
-	CurrentPrice  int64
-	OriginalPrice int64
-	URL           string
-	Images        []string
-}
-
-type Product struct {
-	ID            int64  `db:"id"`
-	Name          string `db:"name"`
-	CurrentPrice  int64  `db:"current_price"`
-	OriginalPrice int64  `db:"original_price"`
-	URL           string `db:"url"`
-	Images        []string
-}
-
-type PriceHistory struct {
-	ID            int64     `db:"id"`
-	ProductID     int64     `db:"product_id"`
-	CurrentPrice  int64     `db:"current_price"`
-	OriginalPrice int64     `db:"original_price"`
-	UpdateTime    time.Time `db:"updated_at"`
-}
-
-type ProductImage struct {
-	ID        int64  `db:"id"`
-	ProductID int64  `db:"product_id"`
-	Image     string `db:"image"`
-}
-
 const (
 	stateActive  = 1
 	stateDeleted = 0
 )
 
-func (r *repository) GetProductsByID(ctx context.Context, id int64) (Product, error) {
-	sql := `SELECT id, name, current_price, original_price,coalesce(url,'') url FROM
-		product WHERE id=$1`
+// productColumns and productTables select a product together with its user
+// settings.
+const productColumns = `p.id, p.name, p.current_price, p.original_price, coalesce(p.url,'') url, p.marketplace, p.in_stock,
+	p.updated_at, p.last_checked_at, p.check_failures, coalesce(p.last_check_error,'') last_check_error, p.status,
+	coalesce(s.display_name,'') display_name, coalesce(s.notes,'') notes, coalesce(s.target_price,0) target_price,
+	coalesce(s.refresh_interval_minutes,0) refresh_interval_minutes`
+
+const productTables = `product p LEFT JOIN product_settings s ON s.product_id = p.id`
 
-	var product Product
+func (r *repository) GetProductsByID(ctx context.Context, id int64) (model.Product, error) {
+	sql := `SELECT ` + productColumns + ` FROM
+		` + productTables + ` WHERE p.id=$1`
+
+	var product model.Product
 	err := r.db.GetContext(ctx, &product, sql, id)
 	if err != nil {
-		return Product{}, err
+		return model.Product{}, err
 	}
 
 	sqlImage := `SELECT image FROM product_images WHERE product_id=$1`
 	rows, err := r.db.QueryxContext(ctx, sqlImage, id)
 	if err != nil {
-		return Product{}, err
+		return model.Product{}, err
 	}
 	defer rows.Close()
 
@@ -78,15 +59,26 @@ func (r *repository) GetProductsByID(ctx context.Context, id int64) (Product, er
 		product.Images = append(product.Images, image)
 	}
 
+	tags, err := r.productTags(ctx, []int64{id})
+	if err != nil {
+		return model.Product{}, err
+	}
+	product.Tags = tags[id]
+
 	return product, err
 }
 
-func (r *repository) GetProductsByUpdateTime(ctx context.Context, startTime, endTime time.Time) ([]Product, error) {
-	sql := `SELECT id, name, current_price, original_price,coalesce(url,'') url FROM
-		product WHERE updated_at between $1 AND $2`
-
-	var product []Product
-	err := r.db.SelectContext(ctx, &product, sql, startTime, endTime)
+// GetProductsDueForRefresh returns up to limit active products that were not
+// checked within their own refresh interval, or defaultInterval when they have
+// none, as of at. The least recently checked come first.
+func (r *repository) GetProductsDueForRefresh(ctx context.Context, at time.Time, defaultInterval time.Duration, limit int) ([]model.Product, error) {
+	sql := `SELECT ` + productColumns + ` FROM
+		` + productTables + ` WHERE p.status = $1
+		AND p.last_checked_at < $2::timestamptz - make_interval(secs => coalesce(s.refresh_interval_minutes * 60.0, $3::float8))
+		ORDER BY p.last_checked_at LIMIT $4`
+
+	var product []model.Product
+	err := r.db.SelectContext(ctx, &product, sql, model.ProductStatusActive, at, defaultInterval.Seconds(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -94,12 +86,79 @@ func (r *repository) GetProductsByUpdateTime(ctx context.Context, startTime, end
 	return product, nil
 }
 
-func (r *repository) GetProducts(ctx context.Context, limit, offset int) ([]Product, error) {
-	sql := `SELECT id, name, current_price, original_price, coalesce(url,'') url FROM
-		product LIMIT $1 OFFSET $2`
+// productFilterWhere returns the condition on product p matching filter and
+// its arguments, numbered from $1.
+func productFilterWhere(filter model.ProductFilter) (string, []interface{}) {
+	where := `p.status = $1`
+	args := []interface{}{filter.Status}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		where += fmt.Sprintf(` AND EXISTS (SELECT 1 FROM product_tag pt JOIN tag t ON t.id = pt.tag_id
+			WHERE pt.product_id = p.id AND t.name = $%d)`, len(args))
+	}
+	if filter.CollectionID != 0 {
+		args = append(args, filter.CollectionID)
+		where += fmt.Sprintf(` AND EXISTS (SELECT 1 FROM collection_product cp
+			WHERE cp.product_id = p.id AND cp.collection_id = $%d)`, len(args))
+	}
+	if terms := model.SearchTerms(filter.Search); len(terms) > 0 {
+		// Every word is matched as a prefix: "kop bub" finds "Kopi Bubuk".
+		args = append(args, strings.Join(terms, ":* & ")+":*")
+		where += fmt.Sprintf(` AND (p.search_vector @@ to_tsquery('simple', $%d)
+			OR to_tsvector('simple', coalesce(s.display_name, '')) @@ to_tsquery('simple', $%[1]d))`, len(args))
+	}
+	if filter.MinPrice > 0 {
+		args = append(args, filter.MinPrice)
+		where += fmt.Sprintf(` AND p.current_price >= $%d`, len(args))
+	}
+	if filter.MaxPrice > 0 {
+		args = append(args, filter.MaxPrice)
+		where += fmt.Sprintf(` AND p.current_price <= $%d`, len(args))
+	}
+	if filter.OnSale {
+		where += ` AND p.current_price < p.original_price`
+	}
+	if filter.Marketplace != "" {
+		args = append(args, filter.Marketplace)
+		where += fmt.Sprintf(` AND p.marketplace = $%d`, len(args))
+	}
+
+	return where, args
+}
+
+// productSorts are the expressions products can be ordered by.
+var productSorts = map[string]string{
+	model.ProductSortName:          `lower(coalesce(s.display_name, p.name))`,
+	model.ProductSortPrice:         `p.current_price`,
+	model.ProductSortOriginalPrice: `p.original_price`,
+	model.ProductSortDiscount: `CASE WHEN p.original_price > 0
+		THEN (p.original_price - p.current_price) * 100 / p.original_price ELSE 0 END`,
+	model.ProductSortLastChange: `p.updated_at`,
+}
+
+// productOrder returns the ORDER BY expressions for filter. Ties are broken
+// by id so that pages do not overlap.
+func productOrder(filter model.ProductFilter) string {
+	sort, ok := productSorts[filter.Sort]
+	if !ok {
+		sort = `p.id`
+	}
+	if filter.Descending {
+		sort += ` DESC`
+	}
+
+	return sort + `, p.id`
+}
 
-	var products []Product
-	err := r.db.SelectContext(ctx, &products, sql, limit, offset)
+// GetProducts returns a page of the products matching filter.
+func (r *repository) GetProducts(ctx context.Context, filter model.ProductFilter, limit, offset int) ([]model.Product, error) {
+	where, args := productFilterWhere(filter)
+	sql := `SELECT ` + productColumns + ` FROM
+		` + productTables + ` WHERE ` + where + ` ORDER BY ` + productOrder(filter) +
+		fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+
+	var products []model.Product
+	err := r.db.SelectContext(ctx, &products, sql, append(args, limit, offset)...)
 	if err != nil {
 		return nil, err
 	}
@@ -130,19 +189,26 @@ func (r *repository) GetProducts(ctx context.Context, limit, offset int) ([]Prod
 		mapProductImage[productID] = append(mapProductImage[productID], image)
 	}
 
-	for _, product := range products {
-		product.Images = mapProductImage[product.ID]
+	tags, err := r.productTags(ctx, listProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range products {
+		products[i].Images = mapProductImage[products[i].ID]
+		products[i].Tags = tags[products[i].ID]
 	}
 
 	return products, nil
 }
 
-func (r *repository) GetTotalProduct(ctx context.Context) (int64, error) {
+func (r *repository) GetTotalProduct(ctx context.Context, filter model.ProductFilter) (int64, error) {
+	where, args := productFilterWhere(filter)
 	sql := `SELECT count(*) as total FROM
-		product`
+		` + productTables + ` WHERE ` + where
 
 	var total int64
-	err := r.db.QueryRowContext(ctx, sql).Scan(&total)
+	err := r.db.QueryRowContext(ctx, sql, args...).Scan(&total)
 	if err != nil {
 		return 0, err
 	}
@@ -150,10 +216,23 @@ func (r *repository) GetTotalProduct(ctx context.Context) (int64, error) {
 	return total, nil
 }
 
-func (r *repository) GetImagesByProductID(ctx context.Context, productID int64) ([]ProductImage, error) {
+// GetMarketplaces returns the marketplaces products are tracked on.
+func (r *repository) GetMarketplaces(ctx context.Context) ([]string, error) {
+	sql := `SELECT DISTINCT marketplace FROM product WHERE marketplace <> '' ORDER BY marketplace`
+
+	var marketplaces []string
+	err := r.db.SelectContext(ctx, &marketplaces, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return marketplaces, nil
+}
+
+func (r *repository) GetImagesByProductID(ctx context.Context, productID int64) ([]model.ProductImage, error) {
 	sql := `SELECT id, product_id, image FROM product_images WHERE product_id=$1`
 
-	var images []ProductImage
+	var images []model.ProductImage
 	err := r.db.SelectContext(ctx, &images, sql, productID)
 	if err != nil {
 		return nil, err
@@ -162,7 +241,23 @@ func (r *repository) GetImagesByProductID(ctx context.Context, productID int64)
 	return images, nil
 }
 
-func (r *repository) UpsertProduct(ctx context.Context, payload ProductPayload) (int64, error) {
+// UpsertProduct stores a product registered by its link. A product with the
+// same name is updated instead.
+func (r *repository) UpsertProduct(ctx context.Context, payload model.ProductPayload) (int64, error) {
+	return r.saveProduct(ctx, 0, payload)
+}
+
+// UpdateProduct stores a refresh of the product with the given id. The row is
+// kept even when the marketplace renamed the listing. It returns
+// sql.ErrNoRows when there is no such product.
+func (r *repository) UpdateProduct(ctx context.Context, id int64, payload model.ProductPayload) error {
+	_, err := r.saveProduct(ctx, id, payload)
+	return err
+}
+
+// saveProduct stores a scraped product with its images and history. The
+// product is looked up by name when id is zero and by id otherwise.
+func (r *repository) saveProduct(ctx context.Context, id int64, payload model.ProductPayload) (int64, error) {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return 0, err
@@ -174,32 +269,60 @@ func (r *repository) UpsertProduct(ctx context.Context, payload ProductPayload)
 		}
 	}()
 
-	productID, err := r.InsertProduct(ctx, tx, payload)
+	var previous model.Product
+	if id == 0 {
+		sqlPrevious := `SELECT current_price, original_price, in_stock FROM product WHERE name = $1 FOR UPDATE`
+		err = tx.QueryRowContext(ctx, sqlPrevious, payload.Name).Scan(&previous.CurrentPrice, &previous.OriginalPrice,
+			&previous.InStock)
+	} else {
+		sqlPrevious := `SELECT current_price, original_price, in_stock FROM product WHERE id = $1 FOR UPDATE`
+		err = tx.QueryRowContext(ctx, sqlPrevious, id).Scan(&previous.CurrentPrice, &previous.OriginalPrice,
+			&previous.InStock)
+	}
+	exists := err == nil
+	if err == sql.ErrNoRows && id == 0 {
+		err = nil
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	err = r.insertingImages(ctx, tx, payload, productID)
+	productID := id
+	if id == 0 {
+		productID, err = r.InsertProduct(ctx, tx, payload)
+	} else {
+		err = r.updateProduct(ctx, tx, id, payload)
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	sqlHistory := `INSERT INTO price_history(product_id, current_price, original_price) 
-		VALUES ($1, $2, $3)`
-	_, err = tx.ExecContext(ctx, sqlHistory, productID, payload.CurrentPrice, payload.OriginalPrice)
+	err = r.insertingImages(ctx, tx, payload, productID)
 	if err != nil {
 		return 0, err
 	}
 
-	errCommit := tx.Commit()
-	if errCommit != nil {
+	// Only record history when something a chart would show has changed.
+	changed := !exists || previous.CurrentPrice != payload.CurrentPrice ||
+		previous.OriginalPrice != payload.OriginalPrice || previous.InStock != payload.InStock
+	if changed {
+		sqlHistory := `INSERT INTO price_history(product_id, current_price, original_price, in_stock)
+			VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, sqlHistory, productID, payload.CurrentPrice, payload.OriginalPrice, payload.InStock)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
 		return 0, err
 	}
 
 	return productID, nil
 }
 
-func (r *repository) insertingImages(ctx context.Context, tx *sql.Tx, payload ProductPayload, productID int64) error {
+func (r *repository) insertingImages(ctx context.Context, tx *sql.Tx, payload model.ProductPayload, productID int64) error {
 	images, err := r.GetImagesByProductID(ctx, productID)
 	if err != nil {
 		return err
@@ -240,19 +363,35 @@ func (r *repository) AddNewProductImage(ctx context.Context, tx *sql.Tx, product
 	return nil
 }
 
-func (r *repository) InsertProduct(ctx context.Context, tx *sql.Tx, payload ProductPayload) (int64, error) {
-	sql := `INSERT INTO product (name, current_price, original_price,url) VALUES 
-		( $1, $2, $3, $4) ON CONFLICT (name) DO UPDATE SET current_price = $2, original_price = $3, url = $4
+func (r *repository) InsertProduct(ctx context.Context, tx *sql.Tx, payload model.ProductPayload) (int64, error) {
+	sql := `INSERT INTO product (name, current_price, original_price, url, in_stock, last_checked_at, marketplace) VALUES
+		( $1, $2, $3, $4, $5, now(), $6) ON CONFLICT (name) DO UPDATE SET current_price = $2, original_price = $3, url = $4,
+		marketplace = $6, in_stock = $5, last_checked_at = now(), check_failures = 0, last_check_error = NULL,
+		updated_at = CASE WHEN product.current_price <> $2 OR product.original_price <> $3 OR product.in_stock <> $5
+			THEN now() ELSE product.updated_at END
 		RETURNING id`
 
 	var id int64
-	err := tx.QueryRowContext(ctx, sql, payload.Name, payload.CurrentPrice, payload.OriginalPrice, payload.URL).Scan(&id)
+	err := tx.QueryRowContext(ctx, sql, payload.Name, payload.CurrentPrice, payload.OriginalPrice, payload.URL,
+		payload.InStock, payload.Marketplace).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
 	return id, nil
 }
 
+func (r *repository) updateProduct(ctx context.Context, tx *sql.Tx, id int64, payload model.ProductPayload) error {
+	sql := `UPDATE product SET name = $1, current_price = $2, original_price = $3, url = $4, in_stock = $5,
+		marketplace = $6, last_checked_at = now(), check_failures = 0, last_check_error = NULL,
+		updated_at = CASE WHEN current_price <> $2 OR original_price <> $3 OR in_stock <> $5
+			THEN now() ELSE updated_at END
+		WHERE id = $7`
+	_, err := tx.ExecContext(ctx, sql, payload.Name, payload.CurrentPrice, payload.OriginalPrice, payload.URL,
+		payload.InStock, payload.Marketplace, id)
+
+	return err
+}
+
 func (r *repository) SoftDeleteProductImage(ctx context.Context, tx *sql.Tx, id int64) error {
 	sqlImages := `UPDATE product_images SET status = $1 WHERE id = $2`
 	_, err := tx.ExecContext(ctx, sqlImages, stateDeleted, id)
@@ -262,6 +401,47 @@ func (r *repository) SoftDeleteProductImage(ctx context.Context, tx *sql.Tx, id
 	return nil
 }
 
+// RecordCheckFailure marks a refresh attempt of the product as failed
+// without touching its scraped data.
+func (r *repository) RecordCheckFailure(ctx context.Context, id int64, message string) error {
+	sql := `UPDATE product SET last_checked_at = now(), check_failures = check_failures + 1, last_check_error = $1
+		WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, sql, message, id)
+
+	return err
+}
+
+// UpdateProductStatus archives or restores a product.
+func (r *repository) UpdateProductStatus(ctx context.Context, id int64, status int) error {
+	sql := `UPDATE product SET status = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, sql, status, id)
+
+	return err
+}
+
+// UpsertProductSettings stores the user settings of a product. Empty values
+// are stored as NULL.
+func (r *repository) UpsertProductSettings(ctx context.Context, settings model.ProductSettings) error {
+	sql := `INSERT INTO product_settings (product_id, display_name, notes, target_price, refresh_interval_minutes, updated_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, 0), now())
+		ON CONFLICT (product_id) DO UPDATE SET display_name = EXCLUDED.display_name, notes = EXCLUDED.notes,
+		target_price = EXCLUDED.target_price, refresh_interval_minutes = EXCLUDED.refresh_interval_minutes,
+		updated_at = EXCLUDED.updated_at`
+	_, err := r.db.ExecContext(ctx, sql, settings.ProductID, settings.DisplayName, settings.Notes, settings.TargetPrice,
+		settings.RefreshIntervalMinutes)
+
+	return err
+}
+
+// DeleteProduct removes a product for good. Its history, rollups, images and
+// refresh run items go with it through the foreign keys.
+func (r *repository) DeleteProduct(ctx context.Context, id int64) error {
+	sql := `DELETE FROM product WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
 func (r *repository) InsertPriceHistory(ctx context.Context, productID int64, currentPrice int64, originalPrice int64) error {
 	sql := `INSERT INTO price_history(product_id, current_price, original_price) 
 		VALUES ($1, $2, $3)`
@@ -270,12 +450,12 @@ func (r *repository) InsertPriceHistory(ctx context.Context, productID int64, cu
 	return err
 }
 
-func (r *repository) GetLastPriceHistory(ctx context.Context, productID int64, limit int) ([]PriceHistory, error) {
-	sql := `SELECT id, product_id, current_price, original_price, updated_at FROM (SELECT id, product_id, current_price, original_price, updated_at FROM
+func (r *repository) GetLastPriceHistory(ctx context.Context, productID int64, limit int) ([]model.PriceHistory, error) {
+	sql := `SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM (SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM
 		price_history WHERE product_id = $1 
 		ORDER BY updated_at DESC 
 		LIMIT $2) p ORDER BY updated_at ASC`
-	var histories []PriceHistory
+	var histories []model.PriceHistory
 	err := r.db.SelectContext(ctx, &histories, sql, productID, limit)
 	if err != nil {
 		return nil, err
@@ -283,48 +463,3 @@ func (r *repository) GetLastPriceHistory(ctx context.Context, productID int64, l
 
 	return histories, nil
 }
-
-func (r *repository) UpDatabase(ctx context.Context) error {
-	sql := `CREATE TABLE IF NOT EXISTS public.product (
-		id bigserial NOT NULL,
-		"name" varchar NOT NULL,
-		current_price int8 NOT NULL,
-		original_price int8 NOT NULL,
-		updated_at information_schema."time_stamp" NOT NULL DEFAULT now(),
-		url varchar NULL,
-		CONSTRAINT product_pk PRIMARY KEY (id),
-		CONSTRAINT product_un UNIQUE (name)
-	)`
-	_, err := r.db.ExecContext(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	sql = `CREATE TABLE IF NOT EXISTS public.price_history (
-		id bigserial NOT NULL,
-		product_id int8 NOT NULL,
-		current_price int8 NOT NULL,
-		original_price int8 NOT NULL,
-		updated_at information_schema."time_stamp" NOT NULL DEFAULT now()
-	)`
-
-	_, err = r.db.ExecContext(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	sql = `CREATE TABLE IF NOT EXISTS public.product_images (
-		id bigserial NOT NULL,
-		product_id int8 NOT NULL,
-		image varchar NOT NULL,
-		status int NOT NULL,
-		CONSTRAINT product_images_pk PRIMARY KEY (id)
-	)`
-
-	_, err = r.db.ExecContext(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
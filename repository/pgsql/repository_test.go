@@ -0,0 +1,120 @@
+package pgsql
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/migration"
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/ediprako/pricemonitor/repository/repotest"
+	"github.com/ediprako/pricemonitor/usecase"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// connect opens the database in TEST_DATABASE_DSN, e.g.
+// "user=postgres password=root dbname=price_monitor_test sslmode=disable",
+// and migrates it. Every table in it is emptied by the tests.
+func connect(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = migration.New(db, migrations).Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func truncate(t *testing.T, db *sqlx.DB) {
+	_, err := db.Exec(`TRUNCATE product, price_history, product_images, price_history_daily, price_history_weekly,
+		price_rollup_state, product_settings, tag, product_tag, collection, collection_product, alert_rule, alert_event, alert_state, alert_delivery, webhook, webhook_delivery, digest, digest_report, refresh_run, refresh_run_item, job
+		RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepository(t *testing.T) {
+	db := connect(t)
+	repotest.Run(t, func(t *testing.T) usecase.DBProvider {
+		truncate(t, db)
+		return New(db)
+	})
+}
+
+func TestRepositoryBackdated(t *testing.T) {
+	db := connect(t)
+	repotest.RunBackdated(t, func(t *testing.T) (usecase.DBProvider, repotest.Backdate) {
+		truncate(t, db)
+		return New(db), func(t *testing.T, historyID int64, at time.Time) {
+			_, err := db.Exec(`UPDATE price_history SET updated_at = $1 WHERE id = $2`, at, historyID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestRollupLateHistory rolls up a row that shows up behind the last id the
+// previous run saw.
+func TestRollupLateHistory(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	truncate(t, db)
+	repo := New(db)
+
+	id, err := repo.UpsertProduct(ctx, model.ProductPayload{Name: "gula", CurrentPrice: 100, OriginalPrice: 100,
+		InStock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range []int64{80, 90} {
+		err = repo.InsertPriceHistory(ctx, id, price, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.Exec(`DELETE FROM price_history WHERE id = 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO price_history (id, product_id, current_price, original_price, in_stock, updated_at)
+		VALUES (2, $1, 80, 100, true, now())`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	rollups, err := repo.GetPriceRollups(ctx, id, model.RollupDaily, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 || rollups[0].MinPrice != 80 || rollups[0].ClosePrice != 80 || rollups[0].Samples != 3 {
+		t.Errorf("today with a late row = %+v", rollups)
+	}
+}
@@ -0,0 +1,161 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// rollupLateWindow is how long before a rollup run rows that it could not
+// see yet may have been recorded.
+const rollupLateWindow = 24 * time.Hour
+
+// rollupTables maps a resolution to its table and date_trunc unit.
+var rollupTables = map[string]struct {
+	table string
+	unit  string
+}{
+	model.RollupDaily:  {"price_history_daily", "day"},
+	model.RollupWeekly: {"price_history_weekly", "week"},
+}
+
+// RollupPriceHistory recomputes the daily and weekly buckets of raw rows
+// recorded since the last run. Rows recorded within rollupLateWindow before
+// the last run are taken again, as one may have committed after it with a
+// lower id than the last one it saw. History only stores changes, so each
+// bucket also counts the price carried in from before it: it is the open
+// price and takes part in the min, max and avg, but not in samples.
+func (r *repository) RollupPriceHistory(ctx context.Context) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var from, to int64
+	var since sql.NullTime
+	sqlState := `SELECT coalesce((SELECT last_history_id FROM price_rollup_state WHERE id = 1), 0),
+		coalesce((SELECT max(id) FROM price_history), 0),
+		(SELECT rolled_up_at FROM price_rollup_state WHERE id = 1)`
+	err = tx.QueryRowxContext(ctx, sqlState).Scan(&from, &to, &since)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	since.Time = since.Time.Add(-rollupLateWindow)
+
+	for _, resolution := range []string{model.RollupDaily, model.RollupWeekly} {
+		rollup := rollupTables[resolution]
+		sqlRollup := fmt.Sprintf(`INSERT INTO %[1]s (product_id, bucket, open_price, close_price, min_price, max_price,
+			avg_price, original_price, in_stock, samples)
+			WITH touched AS (
+				SELECT DISTINCT product_id, date_trunc('%[2]s', updated_at)::date bucket FROM price_history
+				WHERE id > $1 AND id <= $2 OR updated_at >= $3
+			), sampled AS (
+				SELECT t.product_id, t.bucket, h.id, h.current_price, h.original_price, h.in_stock, h.updated_at,
+					false carried
+				FROM touched t JOIN price_history h ON h.product_id = t.product_id
+					AND date_trunc('%[2]s', h.updated_at)::date = t.bucket
+				UNION ALL
+				SELECT t.product_id, t.bucket, c.id, c.current_price, c.original_price, c.in_stock, c.updated_at,
+					true carried
+				FROM touched t CROSS JOIN LATERAL (SELECT id, current_price, original_price, in_stock, updated_at
+					FROM price_history p WHERE p.product_id = t.product_id AND p.updated_at < t.bucket
+					ORDER BY p.updated_at DESC, p.id DESC LIMIT 1) c
+			)
+			SELECT product_id, bucket,
+				(array_agg(current_price ORDER BY updated_at ASC, id ASC))[1],
+				(array_agg(current_price ORDER BY updated_at DESC, id DESC))[1],
+				min(current_price), max(current_price), round(avg(current_price))::int8,
+				(array_agg(original_price ORDER BY updated_at DESC, id DESC))[1],
+				(array_agg(in_stock ORDER BY updated_at DESC, id DESC))[1], count(*) FILTER (WHERE NOT carried)
+			FROM sampled GROUP BY product_id, bucket
+			ON CONFLICT (product_id, bucket) DO UPDATE SET open_price = EXCLUDED.open_price,
+				close_price = EXCLUDED.close_price, min_price = EXCLUDED.min_price, max_price = EXCLUDED.max_price,
+				avg_price = EXCLUDED.avg_price, original_price = EXCLUDED.original_price, in_stock = EXCLUDED.in_stock,
+				samples = EXCLUDED.samples`,
+			rollup.table, rollup.unit)
+
+		_, err = tx.ExecContext(ctx, sqlRollup, from, to, since)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	sqlState = `INSERT INTO price_rollup_state (id, last_history_id, rolled_up_at) VALUES (1, $1, now())
+		ON CONFLICT (id) DO UPDATE SET last_history_id = EXCLUDED.last_history_id,
+			rolled_up_at = EXCLUDED.rolled_up_at`
+	_, err = tx.ExecContext(ctx, sqlState, to)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PrunePriceHistory deletes raw history recorded before the start of the week
+// containing olderThan, except the last row of each product before it: that
+// price is still in effect afterwards. Callers roll the rows up first.
+func (r *repository) PrunePriceHistory(ctx context.Context, olderThan time.Time) (int64, error) {
+	sql := `DELETE FROM price_history WHERE updated_at < date_trunc('week', $1::timestamptz) AND id NOT IN (
+		SELECT DISTINCT ON (product_id) id FROM price_history WHERE updated_at < date_trunc('week', $1::timestamptz)
+		ORDER BY product_id, updated_at DESC, id DESC)`
+	res, err := r.db.ExecContext(ctx, sql, olderThan)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetPriceHistoryBefore returns the last raw row recorded before before, the
+// price in effect at that time, or sql.ErrNoRows when there is none.
+func (r *repository) GetPriceHistoryBefore(ctx context.Context, productID int64, before time.Time) (model.PriceHistory, error) {
+	sql := `SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM price_history
+		WHERE product_id = $1 AND updated_at < $2 ORDER BY updated_at DESC, id DESC LIMIT 1`
+
+	var history model.PriceHistory
+	err := r.db.GetContext(ctx, &history, sql, productID, before)
+	if err != nil {
+		return model.PriceHistory{}, err
+	}
+
+	return history, nil
+}
+
+func (r *repository) GetPriceHistoryBetween(ctx context.Context, productID int64, from, to time.Time) ([]model.PriceHistory, error) {
+	sql := `SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM price_history
+		WHERE product_id = $1 AND updated_at BETWEEN $2 AND $3 ORDER BY updated_at ASC`
+
+	var histories []model.PriceHistory
+	err := r.db.SelectContext(ctx, &histories, sql, productID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+func (r *repository) GetPriceRollups(ctx context.Context, productID int64, resolution string, from, to time.Time) ([]model.PriceRollup, error) {
+	rollup, ok := rollupTables[resolution]
+	if !ok {
+		return nil, fmt.Errorf("unknown rollup resolution %q", resolution)
+	}
+
+	sql := fmt.Sprintf(`SELECT product_id, bucket, open_price, close_price, min_price, max_price, avg_price,
+		original_price, in_stock, samples FROM %s WHERE product_id = $1
+		AND bucket >= date_trunc('%s', $2::timestamptz)::date AND bucket <= $3::date
+		ORDER BY bucket ASC`, rollup.table, rollup.unit)
+
+	var rollups []model.PriceRollup
+	err := r.db.SelectContext(ctx, &rollups, sql, productID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return rollups, nil
+}
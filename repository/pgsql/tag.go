@@ -0,0 +1,110 @@
+package pgsql
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/lib/pq"
+)
+
+// productTags returns the tag names of each product, sorted by name.
+func (r *repository) productTags(ctx context.Context, productIDs []int64) (map[int64][]string, error) {
+	sql := `SELECT pt.product_id, t.name FROM product_tag pt JOIN tag t ON t.id = pt.tag_id
+		WHERE pt.product_id = ANY($1) ORDER BY t.name`
+	rows, err := r.db.QueryxContext(ctx, sql, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var productID int64
+		var name string
+		err = rows.Scan(&productID, &name)
+		if err != nil {
+			return nil, err
+		}
+		tags[productID] = append(tags[productID], name)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTags returns the tags in use with the number of products having each.
+func (r *repository) GetTags(ctx context.Context) ([]model.Tag, error) {
+	sql := `SELECT t.id, t.name, count(*) products FROM
+		tag t JOIN product_tag pt ON pt.tag_id = t.id GROUP BY t.id, t.name ORDER BY t.name`
+
+	var tags []model.Tag
+	err := r.db.SelectContext(ctx, &tags, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// AddProductTags tags the products with every name, creating missing tags.
+// Unknown products are ignored.
+func (r *repository) AddProductTags(ctx context.Context, productIDs []int64, names []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, name := range names {
+		var tagID int64
+		sqlTag := `INSERT INTO tag (name) VALUES ($1) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id`
+		err = tx.QueryRowContext(ctx, sqlTag, name).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+
+		sqlProductTag := `INSERT INTO product_tag (product_id, tag_id)
+			SELECT id, $1 FROM product WHERE id = ANY($2) ON CONFLICT DO NOTHING`
+		_, err = tx.ExecContext(ctx, sqlProductTag, tagID, pq.Array(productIDs))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// RemoveProductTags removes the named tags from the products. Tags no
+// product has any more are deleted.
+func (r *repository) RemoveProductTags(ctx context.Context, productIDs []int64, names []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	sql := `DELETE FROM product_tag WHERE product_id = ANY($1) AND tag_id IN (SELECT id FROM tag WHERE name = ANY($2))`
+	_, err = tx.ExecContext(ctx, sql, pq.Array(productIDs), pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	sqlUnused := `DELETE FROM tag t WHERE NOT EXISTS (SELECT 1 FROM product_tag pt WHERE pt.tag_id = t.id)`
+	_, err = tx.ExecContext(ctx, sqlUnused)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
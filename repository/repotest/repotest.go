@@ -0,0 +1,1518 @@
+// Package repotest is the behaviour every repository has to share. Each
+// repository runs it from its own tests; newRepository must return an empty
+// store for every call.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/ediprako/pricemonitor/usecase"
+)
+
+// tick is long enough for timestamps taken before and after it to differ in
+// every backend.
+const tick = 20 * time.Millisecond
+
+// active lists every active product.
+var active = model.ProductFilter{Status: model.ProductStatusActive}
+
+func Run(t *testing.T, newRepository func(t *testing.T) usecase.DBProvider) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo usecase.DBProvider)
+	}{
+		{"UpsertProduct", testUpsertProduct},
+		{"UpsertProductImages", testUpsertProductImages},
+		{"UpdateRenamedProduct", testUpdateRenamedProduct},
+		{"GetProducts", testGetProducts},
+		{"GetProductsDueForRefresh", testGetProductsDueForRefresh},
+		{"ProductSettings", testProductSettings},
+		{"RecordCheckFailure", testRecordCheckFailure},
+		{"ArchiveProduct", testArchiveProduct},
+		{"DeleteProduct", testDeleteProduct},
+		{"SearchAndSort", testSearchAndSort},
+		{"Tags", testTags},
+		{"Collections", testCollections},
+		{"Alerts", testAlerts},
+		{"AlertDeliveries", testAlertDeliveries},
+		{"AlertControls", testAlertControls},
+		{"Webhooks", testWebhooks},
+		{"Digests", testDigests},
+		{"PriceHistory", testPriceHistory},
+		{"Rollups", testRollups},
+		{"RefreshRuns", testRefreshRuns},
+		{"Jobs", testJobs},
+		{"StaleJobs", testStaleJobs},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+// Backdate moves the raw price history row with the given id to at. Rows are
+// always recorded at the current time, so only a repository's own tests can
+// spread history over earlier buckets.
+type Backdate func(t *testing.T, historyID int64, at time.Time)
+
+// RunBackdated runs the tests that need history recorded in the past.
+// newRepository must return an empty store for every call, with a way to
+// backdate its history.
+func RunBackdated(t *testing.T, newRepository func(t *testing.T) (usecase.DBProvider, Backdate)) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo usecase.DBProvider, backdate Backdate)
+	}{
+		{"CarriedPrices", testCarriedPrices},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			repo, backdate := newRepository(t)
+			tt.test(t, repo, backdate)
+		})
+	}
+}
+
+func upsert(t *testing.T, repo usecase.DBProvider, payload model.ProductPayload) int64 {
+	t.Helper()
+
+	id, err := repo.UpsertProduct(context.Background(), payload)
+	if err != nil {
+		t.Fatalf("UpsertProduct(%q): %v", payload.Name, err)
+	}
+	return id
+}
+
+func getProduct(t *testing.T, repo usecase.DBProvider, id int64) model.Product {
+	t.Helper()
+
+	product, err := repo.GetProductsByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetProductsByID(%d): %v", id, err)
+	}
+	return product
+}
+
+func history(t *testing.T, repo usecase.DBProvider, id int64) []model.PriceHistory {
+	t.Helper()
+
+	histories, err := repo.GetLastPriceHistory(context.Background(), id, 100)
+	if err != nil {
+		t.Fatalf("GetLastPriceHistory(%d): %v", id, err)
+	}
+	return histories
+}
+
+func testUpsertProduct(t *testing.T, repo usecase.DBProvider) {
+	payload := model.ProductPayload{Name: "kopi", CurrentPrice: 100, OriginalPrice: 120, URL: "http://shop/kopi", InStock: true}
+	id := upsert(t, repo, payload)
+
+	first := getProduct(t, repo, id)
+	if first.Name != "kopi" || first.CurrentPrice != 100 || first.OriginalPrice != 120 || first.URL != "http://shop/kopi" ||
+		!first.InStock {
+		t.Fatalf("stored product = %+v", first)
+	}
+	if len(history(t, repo, id)) != 1 {
+		t.Fatalf("a new product records one history row")
+	}
+
+	time.Sleep(tick)
+	if again := upsert(t, repo, payload); again != id {
+		t.Fatalf("upserting the same name returned id %d, want %d", again, id)
+	}
+	same := getProduct(t, repo, id)
+	if !same.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Errorf("updated_at moved without a change: %v -> %v", first.UpdatedAt, same.UpdatedAt)
+	}
+	if !same.LastCheckedAt.After(first.LastCheckedAt) {
+		t.Errorf("last_checked_at did not move: %v -> %v", first.LastCheckedAt, same.LastCheckedAt)
+	}
+	if len(history(t, repo, id)) != 1 {
+		t.Errorf("an unchanged product must not record history")
+	}
+
+	time.Sleep(tick)
+	payload.InStock = false
+	upsert(t, repo, payload)
+	changed := getProduct(t, repo, id)
+	if !changed.UpdatedAt.After(same.UpdatedAt) || changed.InStock {
+		t.Errorf("stock change not stored: %+v", changed)
+	}
+
+	payload.CurrentPrice = 90
+	upsert(t, repo, payload)
+	histories := history(t, repo, id)
+	if len(histories) != 3 {
+		t.Fatalf("got %d history rows, want 3", len(histories))
+	}
+	last := histories[len(histories)-1]
+	if last.CurrentPrice != 90 || last.OriginalPrice != 120 || last.InStock {
+		t.Errorf("last history row = %+v", last)
+	}
+
+	total, err := repo.GetTotalProduct(context.Background(), active)
+	if err != nil || total != 1 {
+		t.Errorf("GetTotalProduct() = %d, %v, want 1", total, err)
+	}
+
+	_, err = repo.GetProductsByID(context.Background(), id+1000)
+	if err == nil {
+		t.Errorf("GetProductsByID of a missing product did not fail")
+	}
+}
+
+func testUpdateRenamedProduct(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	payload := model.ProductPayload{Name: "kopi", CurrentPrice: 100, OriginalPrice: 120, URL: "http://shop/kopi", InStock: true}
+	id := upsert(t, repo, payload)
+	other := upsert(t, repo, model.ProductPayload{Name: "teh", CurrentPrice: 10, OriginalPrice: 10})
+	first := getProduct(t, repo, id)
+
+	// The marketplace renamed the listing.
+	time.Sleep(tick)
+	payload.Name = "Kopi Bubuk 200g"
+	err := repo.UpdateProduct(ctx, id, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := getProduct(t, repo, id)
+	if renamed.Name != "Kopi Bubuk 200g" || !renamed.LastCheckedAt.After(first.LastCheckedAt) ||
+		!renamed.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Errorf("renamed product = %+v, was %+v", renamed, first)
+	}
+	total, err := repo.GetTotalProduct(ctx, active)
+	if err != nil || total != 2 {
+		t.Errorf("GetTotalProduct() after a rename = %d, %v, want 2", total, err)
+	}
+	if len(history(t, repo, id)) != 1 {
+		t.Errorf("a rename alone must not record history")
+	}
+
+	payload.CurrentPrice = 90
+	err = repo.UpdateProduct(ctx, id, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if histories := history(t, repo, id); len(histories) != 2 || histories[1].CurrentPrice != 90 {
+		t.Errorf("history after a price change = %+v", histories)
+	}
+	if again := upsert(t, repo, payload); again != id {
+		t.Errorf("registering the new name returned id %d, want %d", again, id)
+	}
+
+	payload.Name = "teh"
+	if err = repo.UpdateProduct(ctx, id, payload); err == nil {
+		t.Errorf("renaming onto product %d did not fail", other)
+	}
+	if err = repo.UpdateProduct(ctx, id+1000, payload); err != sql.ErrNoRows {
+		t.Errorf("updating a missing product = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func testUpsertProductImages(t *testing.T, repo usecase.DBProvider) {
+	payload := model.ProductPayload{Name: "teh", CurrentPrice: 10, OriginalPrice: 10, Images: []string{"a.jpg", "b.jpg"}}
+	id := upsert(t, repo, payload)
+
+	payload.Images = []string{"b.jpg", "c.jpg"}
+	upsert(t, repo, payload)
+
+	products, err := repo.GetProducts(context.Background(), active, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].ID != id {
+		t.Fatalf("GetProducts() = %+v", products)
+	}
+
+	images := append([]string(nil), products[0].Images...)
+	sort.Strings(images)
+	if len(images) != 2 || images[0] != "b.jpg" || images[1] != "c.jpg" {
+		t.Errorf("active images = %v, want [b.jpg c.jpg]", images)
+	}
+}
+
+func testGetProducts(t *testing.T, repo usecase.DBProvider) {
+	for _, name := range []string{"a", "b", "c"} {
+		upsert(t, repo, model.ProductPayload{Name: name, CurrentPrice: 1, OriginalPrice: 1})
+	}
+
+	seen := make(map[int64]bool)
+	for offset := 0; offset < 3; offset += 2 {
+		products, err := repo.GetProducts(context.Background(), active, 2, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, product := range products {
+			seen[product.ID] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("paging saw %d products, want 3", len(seen))
+	}
+
+	products, err := repo.GetProducts(context.Background(), active, 10, 10)
+	if err != nil || len(products) != 0 {
+		t.Errorf("GetProducts past the end = %v, %v", products, err)
+	}
+}
+
+func testGetProductsDueForRefresh(t *testing.T, repo usecase.DBProvider) {
+	older := upsert(t, repo, model.ProductPayload{Name: "older", CurrentPrice: 1, OriginalPrice: 1})
+	time.Sleep(tick)
+	newer := upsert(t, repo, model.ProductPayload{Name: "newer", CurrentPrice: 1, OriginalPrice: 1})
+	time.Sleep(tick)
+	cutoff := time.Now()
+	time.Sleep(tick)
+	upsert(t, repo, model.ProductPayload{Name: "fresh", CurrentPrice: 1, OriginalPrice: 1})
+
+	due, err := repo.GetProductsDueForRefresh(context.Background(), cutoff, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != older || due[1].ID != newer {
+		t.Errorf("due products = %+v, want ids %d, %d", due, older, newer)
+	}
+
+	due, err = repo.GetProductsDueForRefresh(context.Background(), cutoff, 0, 1)
+	if err != nil || len(due) != 1 || due[0].ID != older {
+		t.Errorf("limited due products = %+v, %v", due, err)
+	}
+
+	due, err = repo.GetProductsDueForRefresh(context.Background(), cutoff, time.Hour, 10)
+	if err != nil || len(due) != 0 {
+		t.Errorf("due products within the default interval = %+v, %v", due, err)
+	}
+
+	// A product's own interval replaces the default one.
+	err = repo.UpsertProductSettings(context.Background(), model.ProductSettings{ProductID: older,
+		RefreshIntervalMinutes: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, err = repo.GetProductsDueForRefresh(context.Background(), cutoff, 0, 10)
+	if err != nil || len(due) != 1 || due[0].ID != newer {
+		t.Errorf("due products with an hourly product = %+v, %v, want id %d", due, err, newer)
+	}
+	due, err = repo.GetProductsDueForRefresh(context.Background(), cutoff.Add(2*time.Hour), 24*time.Hour, 10)
+	if err != nil || len(due) != 1 || due[0].ID != older {
+		t.Errorf("due products two hours later = %+v, %v, want id %d", due, err, older)
+	}
+}
+
+func testProductSettings(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	payload := model.ProductPayload{Name: "teh celup", CurrentPrice: 9000, OriginalPrice: 9000}
+	id := upsert(t, repo, payload)
+	other := upsert(t, repo, model.ProductPayload{Name: "teh tubruk", CurrentPrice: 1, OriginalPrice: 1})
+
+	if product := getProduct(t, repo, id); product.DisplayName != "" || product.RefreshIntervalMinutes != 0 {
+		t.Fatalf("a new product has settings: %+v", product)
+	}
+
+	settings := model.ProductSettings{ProductID: id, DisplayName: "Teh", Notes: "for the office", TargetPrice: 8000,
+		RefreshIntervalMinutes: 30}
+	err := repo.UpsertProductSettings(ctx, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshing the scraped fields keeps the settings.
+	payload.CurrentPrice = 8500
+	upsert(t, repo, payload)
+	product := getProduct(t, repo, id)
+	if product.DisplayName != "Teh" || product.Notes != "for the office" || product.TargetPrice != 8000 ||
+		product.RefreshIntervalMinutes != 30 || product.Name != "teh celup" || product.CurrentPrice != 8500 {
+		t.Errorf("product with settings = %+v", product)
+	}
+
+	products, err := repo.GetProducts(ctx, active, 10, 0)
+	if err != nil || len(products) != 2 || products[0].DisplayName != "Teh" || products[1].DisplayName != "" {
+		t.Errorf("GetProducts() = %+v, %v", products, err)
+	}
+
+	err = repo.UpsertProductSettings(ctx, model.ProductSettings{ProductID: id, DisplayName: "Teh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	product = getProduct(t, repo, id)
+	if product.DisplayName != "Teh" || product.Notes != "" || product.TargetPrice != 0 || product.RefreshIntervalMinutes != 0 {
+		t.Errorf("zero settings must be cleared: %+v", product)
+	}
+
+	err = repo.DeleteProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product := getProduct(t, repo, other); product.DisplayName != "" {
+		t.Errorf("settings leaked to another product: %+v", product)
+	}
+}
+
+func testRecordCheckFailure(t *testing.T, repo usecase.DBProvider) {
+	payload := model.ProductPayload{Name: "gula", CurrentPrice: 5, OriginalPrice: 5}
+	id := upsert(t, repo, payload)
+	before := getProduct(t, repo, id)
+
+	time.Sleep(tick)
+	for i := 0; i < 2; i++ {
+		err := repo.RecordCheckFailure(context.Background(), id, "timeout")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed := getProduct(t, repo, id)
+	if failed.CheckFailures != 2 || failed.LastCheckError != "timeout" {
+		t.Errorf("after failures: %+v", failed)
+	}
+	if !failed.LastCheckedAt.After(before.LastCheckedAt) || !failed.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("a failure must only move last_checked_at: %+v", failed)
+	}
+	if failed.CurrentPrice != 5 {
+		t.Errorf("a failure changed the price: %+v", failed)
+	}
+
+	upsert(t, repo, payload)
+	recovered := getProduct(t, repo, id)
+	if recovered.CheckFailures != 0 || recovered.LastCheckError != "" {
+		t.Errorf("a successful check must reset failures: %+v", recovered)
+	}
+}
+
+func testArchiveProduct(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	kept := upsert(t, repo, model.ProductPayload{Name: "kept", CurrentPrice: 1, OriginalPrice: 1})
+	archived := upsert(t, repo, model.ProductPayload{Name: "archived", CurrentPrice: 1, OriginalPrice: 1})
+	if status := getProduct(t, repo, archived).Status; status != model.ProductStatusActive {
+		t.Fatalf("a new product has status %d", status)
+	}
+
+	err := repo.UpdateProductStatus(ctx, archived, model.ProductStatusArchived)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		status int
+		want   int64
+	}{{model.ProductStatusActive, kept}, {model.ProductStatusArchived, archived}} {
+		products, err := repo.GetProducts(ctx, model.ProductFilter{Status: tt.status}, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(products) != 1 || products[0].ID != tt.want {
+			t.Errorf("products with status %d = %+v, want id %d", tt.status, products, tt.want)
+		}
+
+		total, err := repo.GetTotalProduct(ctx, model.ProductFilter{Status: tt.status})
+		if err != nil || total != 1 {
+			t.Errorf("GetTotalProduct(%d) = %d, %v, want 1", tt.status, total, err)
+		}
+	}
+
+	due, err := repo.GetProductsDueForRefresh(ctx, time.Now().Add(time.Hour), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != kept {
+		t.Errorf("due products = %+v, archived products must not be refreshed", due)
+	}
+	if len(history(t, repo, archived)) != 1 {
+		t.Errorf("archiving dropped the history")
+	}
+
+	err = repo.UpdateProductStatus(ctx, archived, model.ProductStatusActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err := repo.GetTotalProduct(ctx, active)
+	if err != nil || total != 2 {
+		t.Errorf("after restore GetTotalProduct() = %d, %v, want 2", total, err)
+	}
+}
+
+func testDeleteProduct(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	kept := upsert(t, repo, model.ProductPayload{Name: "kept", CurrentPrice: 1, OriginalPrice: 1})
+	id := upsert(t, repo, model.ProductPayload{Name: "purged", CurrentPrice: 1, OriginalPrice: 1, Images: []string{"a.jpg"}})
+
+	run, err := repo.CreateRefreshRun(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, productID := range []int64{kept, id} {
+		err = repo.InsertRefreshRunItem(ctx, model.RefreshRunItem{RunID: run, ProductID: productID,
+			Status: model.RefreshStatusSuccess})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.DeleteProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.GetProductsByID(ctx, id)
+	if err == nil {
+		t.Errorf("a purged product is still stored")
+	}
+	if len(history(t, repo, id)) != 0 {
+		t.Errorf("a purged product kept its history")
+	}
+	rollups, err := repo.GetPriceRollups(ctx, id, model.RollupDaily, time.Now().AddDate(0, 0, -1), time.Now())
+	if err != nil || len(rollups) != 0 {
+		t.Errorf("a purged product kept its rollups: %+v, %v", rollups, err)
+	}
+	items, err := repo.GetRefreshRunItems(ctx, run)
+	if err != nil || len(items) != 1 || items[0].ProductID != kept {
+		t.Errorf("run items after purge = %+v, %v", items, err)
+	}
+	if len(history(t, repo, kept)) != 1 {
+		t.Errorf("purging one product touched another")
+	}
+}
+
+func testPriceHistory(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	id := upsert(t, repo, model.ProductPayload{Name: "beras", CurrentPrice: 10, OriginalPrice: 10, InStock: true})
+	for _, price := range []int64{11, 12, 13} {
+		time.Sleep(tick)
+		err := repo.InsertPriceHistory(ctx, id, price, 15)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := repo.GetLastPriceHistory(ctx, id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || last[0].CurrentPrice != 12 || last[1].CurrentPrice != 13 {
+		t.Errorf("last two rows oldest first = %+v", last)
+	}
+	if !last[1].InStock || last[1].OriginalPrice != 15 {
+		t.Errorf("inserted history row = %+v", last[1])
+	}
+
+	all := history(t, repo, id)
+	between, err := repo.GetPriceHistoryBetween(ctx, id, all[1].UpdateTime, all[2].UpdateTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(between) != 2 || between[0].CurrentPrice != 11 || between[1].CurrentPrice != 12 {
+		t.Errorf("history between rows 2 and 3 = %+v", between)
+	}
+}
+
+func testRollups(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	id := upsert(t, repo, model.ProductPayload{Name: "minyak", CurrentPrice: 10, OriginalPrice: 20, InStock: true})
+	for _, price := range []int64{30, 20} {
+		time.Sleep(tick)
+		err := repo.InsertPriceHistory(ctx, id, price, 25)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Running it twice must not count rows twice.
+	for i := 0; i < 2; i++ {
+		err := repo.RollupPriceHistory(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	for _, resolution := range []string{model.RollupDaily, model.RollupWeekly} {
+		rollups, err := repo.GetPriceRollups(ctx, id, resolution, now.AddDate(0, 0, -1), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rollups) != 1 {
+			t.Fatalf("%s rollups = %+v, want one bucket", resolution, rollups)
+		}
+
+		got := rollups[0]
+		want := model.PriceRollup{ProductID: id, OpenPrice: 10, ClosePrice: 20, MinPrice: 10, MaxPrice: 30, AvgPrice: 20,
+			OriginalPrice: 25, InStock: true, Samples: 3}
+		got.Bucket = time.Time{}
+		if got != want {
+			t.Errorf("%s rollup = %+v, want %+v", resolution, got, want)
+		}
+	}
+
+	_, err := repo.GetPriceRollups(ctx, id, "hourly", now, now)
+	if err == nil {
+		t.Errorf("an unknown resolution did not fail")
+	}
+
+	pruned, err := repo.PrunePriceHistory(ctx, now.AddDate(0, 0, -8))
+	if err != nil || pruned != 0 {
+		t.Errorf("pruning older history = %d, %v, want 0", pruned, err)
+	}
+	// The last price stays, as it is still in effect.
+	pruned, err = repo.PrunePriceHistory(ctx, now.AddDate(0, 0, 8))
+	if err != nil || pruned != 2 {
+		t.Errorf("pruning everything = %d, %v, want 2", pruned, err)
+	}
+	if kept := history(t, repo, id); len(kept) != 1 || kept[0].CurrentPrice != 20 {
+		t.Errorf("history after pruning everything = %+v", kept)
+	}
+}
+
+func testCarriedPrices(t *testing.T, repo usecase.DBProvider, backdate Backdate) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	// week is a Monday two to three weeks ago; rows are set at noon so
+	// buckets are the same in any time zone a database may use.
+	week := time.Date(now.Year(), now.Month(), now.Day()-14, 0, 0, 0, 0, time.UTC)
+	week = week.AddDate(0, 0, -(int(week.Weekday())+6)%7)
+	noon := func(days int) time.Time {
+		return week.AddDate(0, 0, days).Add(12 * time.Hour)
+	}
+
+	id := upsert(t, repo, model.ProductPayload{Name: "gula", CurrentPrice: 100, OriginalPrice: 100, InStock: true})
+	for _, price := range []int64{80, 90} {
+		err := repo.InsertPriceHistory(ctx, id, price, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows := history(t, repo, id)
+	// 100 from the Friday before, 80 on Wednesday and 90 on Thursday.
+	for i, days := range []int{-3, 2, 3} {
+		backdate(t, rows[i].ID, noon(days))
+	}
+
+	err := repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollup := func(resolution string, from, to time.Time) []model.PriceRollup {
+		t.Helper()
+		rollups, err := repo.GetPriceRollups(ctx, id, resolution, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range rollups {
+			rollups[i].Bucket = time.Time{}
+		}
+		return rollups
+	}
+	bucket := func(open, close, min, max, avg int64, samples int) model.PriceRollup {
+		return model.PriceRollup{ProductID: id, OpenPrice: open, ClosePrice: close, MinPrice: min, MaxPrice: max,
+			AvgPrice: avg, OriginalPrice: 100, InStock: true, Samples: samples}
+	}
+
+	weekly := rollup(model.RollupWeekly, week, noon(6))
+	if len(weekly) != 1 || weekly[0] != bucket(100, 90, 80, 100, 90, 2) {
+		t.Errorf("week with a price carried in = %+v", weekly)
+	}
+	daily := rollup(model.RollupDaily, noon(2), noon(4))
+	if len(daily) != 2 || daily[0] != bucket(100, 80, 80, 100, 90, 1) || daily[1] != bucket(80, 90, 80, 90, 85, 1) {
+		t.Errorf("days with a price carried in = %+v", daily)
+	}
+
+	// Buckets untouched since the last run are left alone, even when their
+	// rows change afterwards.
+	backdate(t, rows[2].ID, noon(4))
+	err = repo.InsertPriceHistory(ctx, id, 70, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily = rollup(model.RollupDaily, noon(2), noon(4))
+	if len(daily) != 2 || daily[1] != bucket(80, 90, 80, 90, 85, 1) {
+		t.Errorf("days rolled up again = %+v", daily)
+	}
+	today := rollup(model.RollupDaily, now, now)
+	if len(today) != 1 || today[0] != bucket(90, 70, 70, 90, 80, 1) {
+		t.Errorf("today after a steady week = %+v", today)
+	}
+
+	before, err := repo.GetPriceHistoryBefore(ctx, id, week)
+	if err != nil || before.CurrentPrice != 100 {
+		t.Errorf("price before the week = %+v, %v", before, err)
+	}
+	_, err = repo.GetPriceHistoryBefore(ctx, id, noon(-4))
+	if err != sql.ErrNoRows {
+		t.Errorf("price before any history = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	pruned, err := repo.PrunePriceHistory(ctx, now)
+	if err != nil || pruned != 2 {
+		t.Errorf("pruning before this week = %d, %v, want 2", pruned, err)
+	}
+	kept := history(t, repo, id)
+	if len(kept) != 2 || kept[0].CurrentPrice != 90 || kept[1].CurrentPrice != 70 {
+		t.Errorf("history after pruning = %+v", kept)
+	}
+}
+
+func testRefreshRuns(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	id := upsert(t, repo, model.ProductPayload{Name: "susu", CurrentPrice: 1, OriginalPrice: 1})
+
+	first, err := repo.CreateRefreshRun(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.CreateRefreshRun(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.InsertRefreshRunItem(ctx, model.RefreshRunItem{RunID: first, ProductID: id,
+		Status: model.RefreshStatusFailed, Error: "boom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.InsertRefreshRunItem(ctx, model.RefreshRunItem{RunID: first, ProductID: id,
+		Status: model.RefreshStatusSuccess, Changed: true, OldPrice: 2, NewPrice: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.FinishRefreshRun(ctx, model.RefreshRun{ID: first, Attempted: 2, Succeeded: 1, Failed: 1, Changed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := repo.GetRefreshRuns(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != second || runs[1].ID != first {
+		t.Fatalf("runs newest first = %+v", runs)
+	}
+	if runs[0].FinishedAt.Valid {
+		t.Errorf("unfinished run has finished_at: %+v", runs[0])
+	}
+
+	run, err := repo.GetRefreshRunByID(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.FinishedAt.Valid || run.Attempted != 2 || run.Succeeded != 1 || run.Failed != 1 || run.Changed != 1 {
+		t.Errorf("finished run = %+v", run)
+	}
+
+	total, err := repo.GetTotalRefreshRun(ctx)
+	if err != nil || total != 2 {
+		t.Errorf("GetTotalRefreshRun() = %d, %v, want 2", total, err)
+	}
+
+	items, err := repo.GetRefreshRunItems(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("run items = %+v", items)
+	}
+	if items[0].Status != model.RefreshStatusFailed || items[0].Error != "boom" || items[0].ProductName != "susu" {
+		t.Errorf("first item = %+v", items[0])
+	}
+	if !items[1].Changed || items[1].OldPrice != 2 || items[1].NewPrice != 1 || items[1].Error != "" {
+		t.Errorf("second item = %+v", items[1])
+	}
+}
+
+func testJobs(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+
+	later, err := repo.EnqueueJob(ctx, model.JobPayload{Kind: "scrape", Payload: `{"link":"later"}`, MaxAttempts: 3,
+		RunAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, err := repo.EnqueueJob(ctx, model.JobPayload{Kind: "scrape", Payload: `{"link":"now"}`, MaxAttempts: 3,
+		RunAt: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := repo.EnqueueJobs(ctx, []model.JobPayload{
+		{Kind: "scrape", Payload: `{"link":"a"}`, MaxAttempts: 3, RunAt: time.Now().Add(time.Hour)},
+		{Kind: "scrape", Payload: `{"link":"b"}`, MaxAttempts: 3, RunAt: time.Now().Add(time.Hour)},
+	})
+	if err != nil || len(batch) != 2 || batch[0] != due+1 || batch[1] != due+2 {
+		t.Fatalf("batch of jobs = %v, %v", batch, err)
+	}
+	job, err := repo.GetJobByID(ctx, batch[1])
+	if err != nil || job.Status != model.JobStatusPending || job.Payload != `{"link":"b"}` || job.MaxAttempts != 3 {
+		t.Errorf("second job of the batch = %+v, %v", job, err)
+	}
+
+	overdue, err := repo.CountOverdueJobs(ctx, time.Now())
+	if err != nil || overdue != 1 {
+		t.Fatalf("overdue jobs = %d, %v, want 1", overdue, err)
+	}
+	overdue, err = repo.CountOverdueJobs(ctx, time.Now().Add(-time.Minute))
+	if err != nil || overdue != 0 {
+		t.Fatalf("overdue jobs a minute ago = %d, %v, want 0", overdue, err)
+	}
+
+	job, err = repo.ClaimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != due || job.Status != model.JobStatusRunning || job.Attempts != 1 || job.Kind != "scrape" ||
+		job.Payload != `{"link":"now"}` {
+		t.Fatalf("claimed job = %+v", job)
+	}
+
+	job, err = repo.ClaimJob(ctx)
+	if err != nil || job.ID != 0 {
+		t.Fatalf("second claim = %+v, %v, want nothing due", job, err)
+	}
+
+	err = repo.FailJob(ctx, due, "try again", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err = repo.ClaimJob(ctx)
+	if err != nil || job.ID != due || job.Attempts != 2 || job.LastError != "try again" {
+		t.Fatalf("retried job = %+v, %v", job, err)
+	}
+
+	err = repo.CompleteJob(ctx, due, `{"product_id":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err = repo.GetJobByID(ctx, due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != model.JobStatusDone || job.LastError != "" || job.Result != `{"product_id":1}` {
+		t.Errorf("completed job = %+v", job)
+	}
+
+	err = repo.FailJob(ctx, later, "gave up", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err = repo.GetJobByID(ctx, later)
+	if err != nil || job.Status != model.JobStatusFailed || job.LastError != "gave up" {
+		t.Errorf("failed job = %+v, %v", job, err)
+	}
+
+	_, err = repo.GetJobByID(ctx, later+1000)
+	if err == nil {
+		t.Errorf("GetJobByID of a missing job did not fail")
+	}
+}
+
+func testStaleJobs(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+
+	id, err := repo.EnqueueJob(ctx, model.JobPayload{Kind: "refresh", Payload: `{}`, MaxAttempts: 2,
+		RunAt: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.ClaimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requeued, failed, err := repo.RequeueStaleJobs(ctx, time.Now().Add(-time.Minute))
+	if err != nil || requeued != 0 || failed != 0 {
+		t.Errorf("requeue of a fresh job = %d, %d, %v, want 0, 0", requeued, failed, err)
+	}
+
+	time.Sleep(tick)
+	requeued, failed, err = repo.RequeueStaleJobs(ctx, time.Now())
+	if err != nil || requeued != 1 || failed != 0 {
+		t.Fatalf("requeue of a stale job = %d, %d, %v, want 1, 0", requeued, failed, err)
+	}
+
+	job, err := repo.GetJobByID(ctx, id)
+	if err != nil || job.Status != model.JobStatusPending {
+		t.Errorf("requeued job = %+v, %v", job, err)
+	}
+
+	// The second attempt is the last one, so a worker stopping during it
+	// fails the job.
+	job, err = repo.ClaimJob(ctx)
+	if err != nil || job.ID != id || job.Attempts != 2 {
+		t.Fatalf("claim of the requeued job = %+v, %v", job, err)
+	}
+	time.Sleep(tick)
+	requeued, failed, err = repo.RequeueStaleJobs(ctx, time.Now())
+	if err != nil || requeued != 0 || failed != 1 {
+		t.Fatalf("requeue of a stale job out of attempts = %d, %d, %v, want 0, 1", requeued, failed, err)
+	}
+
+	job, err = repo.GetJobByID(ctx, id)
+	if err != nil || job.Status != model.JobStatusFailed || job.LastError != model.JobStaleError {
+		t.Errorf("stale job out of attempts = %+v, %v", job, err)
+	}
+}
+
+func productIDs(products []model.Product) []int64 {
+	ids := make([]int64, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	return ids
+}
+
+func testTags(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	coffee := upsert(t, repo, model.ProductPayload{Name: "kopi", CurrentPrice: 1, OriginalPrice: 1})
+	tea := upsert(t, repo, model.ProductPayload{Name: "teh", CurrentPrice: 1, OriginalPrice: 1})
+	upsert(t, repo, model.ProductPayload{Name: "gula", CurrentPrice: 1, OriginalPrice: 1})
+
+	err := repo.AddProductTags(ctx, []int64{coffee, tea, 999}, []string{"drink", "pantry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tagging twice is harmless.
+	err = repo.AddProductTags(ctx, []int64{coffee}, []string{"drink", "morning"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags := getProduct(t, repo, coffee).Tags; len(tags) != 3 || tags[0] != "drink" || tags[2] != "pantry" {
+		t.Errorf("coffee tags = %v", tags)
+	}
+
+	tags, err := repo.GetTags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 || tags[0].Name != "drink" || tags[0].Products != 2 || tags[1].Name != "morning" ||
+		tags[1].Products != 1 {
+		t.Errorf("GetTags() = %+v", tags)
+	}
+
+	filter := model.ProductFilter{Status: model.ProductStatusActive, Tag: "drink"}
+	products, err := repo.GetProducts(ctx, filter, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := productIDs(products); len(ids) != 2 || ids[0] != coffee || ids[1] != tea {
+		t.Errorf("products tagged drink = %v", ids)
+	}
+	if len(products[1].Tags) != 2 {
+		t.Errorf("listed tags of tea = %v", products[1].Tags)
+	}
+	total, err := repo.GetTotalProduct(ctx, filter)
+	if err != nil || total != 2 {
+		t.Errorf("GetTotalProduct(%+v) = %d, %v, want 2", filter, total, err)
+	}
+
+	err = repo.RemoveProductTags(ctx, []int64{coffee, tea}, []string{"drink", "morning"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err = repo.GetTags(ctx)
+	if err != nil || len(tags) != 1 || tags[0].Name != "pantry" {
+		t.Errorf("GetTags() after removing = %+v, %v", tags, err)
+	}
+	total, err = repo.GetTotalProduct(ctx, filter)
+	if err != nil || total != 0 {
+		t.Errorf("GetTotalProduct(%+v) after removing = %d, %v, want 0", filter, total, err)
+	}
+}
+
+func testCollections(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	coffee := upsert(t, repo, model.ProductPayload{Name: "kopi", CurrentPrice: 1, OriginalPrice: 1})
+	tea := upsert(t, repo, model.ProductPayload{Name: "teh", CurrentPrice: 1, OriginalPrice: 1})
+
+	breakfast, err := repo.CreateCollection(ctx, "breakfast", "things for the morning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := repo.CreateCollection(ctx, "another", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.AddCollectionProducts(ctx, breakfast, []int64{coffee, tea, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.AddCollectionProducts(ctx, breakfast, []int64{coffee})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collections, err := repo.GetCollections(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 2 || collections[0].ID != empty || collections[0].Products != 0 ||
+		collections[1].Products != 2 || collections[1].Description != "things for the morning" ||
+		collections[1].CreatedAt.IsZero() {
+		t.Errorf("GetCollections() = %+v", collections)
+	}
+
+	err = repo.RemoveCollectionProducts(ctx, breakfast, []int64{tea})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := model.ProductFilter{Status: model.ProductStatusActive, CollectionID: breakfast}
+	products, err := repo.GetProducts(ctx, filter, 10, 0)
+	if ids := productIDs(products); err != nil || len(ids) != 1 || ids[0] != coffee {
+		t.Errorf("products in the collection = %v, %v", ids, err)
+	}
+
+	err = repo.DeleteCollection(ctx, breakfast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetCollectionByID(ctx, breakfast)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetCollectionByID() of a deleted collection = %v, want %v", err, sql.ErrNoRows)
+	}
+	total, err := repo.GetTotalProduct(ctx, active)
+	if err != nil || total != 2 {
+		t.Errorf("deleting a collection must keep its products: %d, %v", total, err)
+	}
+}
+
+func testAlerts(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	coffee := upsert(t, repo, model.ProductPayload{Name: "kopi", CurrentPrice: 1, OriginalPrice: 1})
+	tea := upsert(t, repo, model.ProductPayload{Name: "teh", CurrentPrice: 1, OriginalPrice: 1})
+	err := repo.AddProductTags(ctx, []int64{tea}, []string{"drinks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onCoffee, err := repo.CreateAlertRule(ctx, model.AlertRule{
+		ProductID: coffee, Kind: model.AlertKindBelowTarget, Threshold: 100, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDrinks, err := repo.CreateAlertRule(ctx, model.AlertRule{
+		Tag: "drinks", Kind: model.AlertKindPercentDrop, Threshold: 10, WindowHours: 24, Channels: "slack,telegram",
+		Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.CreateAlertRule(ctx, model.AlertRule{
+		ProductID: tea, Kind: model.AlertKindExpression, Expression: "not in_stock", Enabled: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := repo.GetAlertRuleByID(ctx, onDrinks)
+	if err != nil || rule.ProductID != 0 || rule.Tag != "drinks" || rule.Threshold != 10 ||
+		rule.WindowHours != 24 || rule.Channels != "slack,telegram" || !rule.Enabled || rule.CreatedAt.IsZero() {
+		t.Errorf("GetAlertRuleByID() = %+v, %v", rule, err)
+	}
+	rules, err := repo.GetAlertRules(ctx)
+	if err != nil || len(rules) != 3 || rules[2].Expression != "not in_stock" || rules[0].Expression != "" {
+		t.Errorf("GetAlertRules() = %+v, %v", rules, err)
+	}
+
+	// Disabled rules are left out and tag rules follow the product's tags.
+	rules, err = repo.GetAlertRulesForProduct(ctx, tea)
+	if err != nil || len(rules) != 1 || rules[0].ID != onDrinks {
+		t.Errorf("GetAlertRulesForProduct(tea) = %+v, %v", rules, err)
+	}
+	rules, err = repo.GetAlertRulesForProduct(ctx, coffee)
+	if err != nil || len(rules) != 1 || rules[0].ID != onCoffee {
+		t.Errorf("GetAlertRulesForProduct(coffee) = %+v, %v", rules, err)
+	}
+
+	for i, event := range []model.AlertEvent{
+		{RuleID: onCoffee, ProductID: coffee, Kind: model.AlertKindBelowTarget, Price: 90, PreviousPrice: 110, Message: "first"},
+		{RuleID: onDrinks, ProductID: tea, Kind: model.AlertKindPercentDrop, Price: 80, PreviousPrice: 100, Message: "second"},
+		{RuleID: onCoffee, ProductID: coffee, Kind: model.AlertKindBelowTarget, Price: 80, PreviousPrice: 90, Message: "third"},
+	} {
+		if i > 0 {
+			time.Sleep(tick)
+		}
+		_, err = repo.InsertAlertEvent(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := repo.GetAlertEvents(ctx, 0, 2, 0)
+	if err != nil || len(events) != 2 || events[0].Message != "third" || events[1].Message != "second" ||
+		events[1].ProductName != "teh" || events[1].PreviousPrice != 100 || events[1].CreatedAt.IsZero() {
+		t.Errorf("GetAlertEvents() = %+v, %v", events, err)
+	}
+	events, err = repo.GetAlertEvents(ctx, coffee, 10, 1)
+	if err != nil || len(events) != 1 || events[0].Message != "first" {
+		t.Errorf("GetAlertEvents(coffee, offset 1) = %+v, %v", events, err)
+	}
+	total, err := repo.GetTotalAlertEvent(ctx, coffee)
+	if err != nil || total != 2 {
+		t.Errorf("GetTotalAlertEvent(coffee) = %d, %v", total, err)
+	}
+
+	err = repo.DeleteAlertRule(ctx, onCoffee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetAlertRuleByID(ctx, onCoffee)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetAlertRuleByID() of a deleted rule = %v, want %v", err, sql.ErrNoRows)
+	}
+	total, err = repo.GetTotalAlertEvent(ctx, 0)
+	if err != nil || total != 1 {
+		t.Errorf("deleting a rule must delete its events: %d left, %v", total, err)
+	}
+
+	err = repo.DeleteProduct(ctx, tea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err = repo.GetTotalAlertEvent(ctx, 0)
+	if err != nil || total != 0 {
+		t.Errorf("deleting a product must delete its events: %d left, %v", total, err)
+	}
+}
+
+func testAlertControls(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	coffee := upsert(t, repo, model.ProductPayload{Name: "kopi", CurrentPrice: 1, OriginalPrice: 1})
+	ruleID, err := repo.CreateAlertRule(ctx, model.AlertRule{
+		ProductID: coffee, Kind: model.AlertKindBelowTarget, Threshold: 100, CooldownMinutes: 60, Hysteresis: true,
+		QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Asia/Jakarta", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := repo.GetAlertRuleByID(ctx, ruleID)
+	if err != nil || rule.CooldownMinutes != 60 || !rule.Hysteresis || rule.QuietStart != "22:00" ||
+		rule.QuietEnd != "07:00" || rule.Timezone != "Asia/Jakarta" || rule.SnoozedUntil.Valid {
+		t.Errorf("GetAlertRuleByID() = %+v, %v", rule, err)
+	}
+	until := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	err = repo.SnoozeAlertRule(ctx, ruleID, sql.NullTime{Time: until, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err = repo.GetAlertRuleByID(ctx, ruleID)
+	if err != nil || !rule.SnoozedUntil.Valid || !rule.SnoozedUntil.Time.Equal(until) {
+		t.Errorf("snoozed rule = %+v, %v", rule, err)
+	}
+	err = repo.SnoozeAlertRule(ctx, ruleID, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err = repo.GetAlertRuleByID(ctx, ruleID)
+	if err != nil || rule.SnoozedUntil.Valid {
+		t.Errorf("rule after the snooze is lifted = %+v, %v", rule, err)
+	}
+
+	_, err = repo.GetAlertState(ctx, ruleID, coffee)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetAlertState() before the rule fired = %v, want %v", err, sql.ErrNoRows)
+	}
+	fired := sql.NullTime{Time: until, Valid: true}
+	for _, armed := range []bool{true, false} {
+		err = repo.SaveAlertState(ctx, model.AlertState{RuleID: ruleID, ProductID: coffee, Armed: armed, LastFiredAt: fired})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := repo.GetAlertState(ctx, ruleID, coffee)
+	if err != nil || state.Armed || !state.LastFiredAt.Valid || !state.LastFiredAt.Time.Equal(until) {
+		t.Errorf("GetAlertState() = %+v, %v", state, err)
+	}
+
+	eventID, err := repo.InsertAlertEvent(ctx, model.AlertEvent{RuleID: ruleID, ProductID: coffee,
+		Kind: model.AlertKindBelowTarget, Price: 90, PreviousPrice: 110, Message: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := repo.GetAlertEventByID(ctx, eventID)
+	if err != nil || event.AcknowledgedAt.Valid {
+		t.Errorf("GetAlertEventByID() = %+v, %v", event, err)
+	}
+	err = repo.AcknowledgeAlertEvent(ctx, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err = repo.GetAlertEventByID(ctx, eventID)
+	if err != nil || !event.AcknowledgedAt.Valid {
+		t.Errorf("acknowledged event = %+v, %v", event, err)
+	}
+	acknowledgedAt := event.AcknowledgedAt.Time
+	time.Sleep(tick)
+	err = repo.AcknowledgeAlertEvent(ctx, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err = repo.GetAlertEventByID(ctx, eventID)
+	if err != nil || !event.AcknowledgedAt.Time.Equal(acknowledgedAt) {
+		t.Errorf("acknowledging again must keep the first time: %v, was %v", event.AcknowledgedAt.Time, acknowledgedAt)
+	}
+
+	// Editing a rule keeps its snooze and forgets where it stood.
+	err = repo.SnoozeAlertRule(ctx, ruleID, sql.NullTime{Time: until, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateAlertRule(ctx, model.AlertRule{ID: ruleID, Tag: "drinks", Kind: model.AlertKindExpression,
+		Expression: "price < avg(price, 30d)", Channels: "email", Enabled: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err = repo.GetAlertRuleByID(ctx, ruleID)
+	if err != nil || rule.ProductID != 0 || rule.Tag != "drinks" || rule.Kind != model.AlertKindExpression ||
+		rule.Expression != "price < avg(price, 30d)" || rule.Channels != "email" || rule.CooldownMinutes != 0 ||
+		rule.Hysteresis || rule.QuietStart != "" || rule.Timezone != "" || rule.Enabled ||
+		!rule.SnoozedUntil.Time.Equal(until) {
+		t.Errorf("updated rule = %+v, %v", rule, err)
+	}
+	_, err = repo.GetAlertState(ctx, ruleID, coffee)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetAlertState() of an updated rule = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	err = repo.DeleteAlertRule(ctx, ruleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetAlertState(ctx, ruleID, coffee)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetAlertState() of a deleted rule = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func testAlertDeliveries(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	coffee := upsert(t, repo, model.ProductPayload{Name: "kopi", CurrentPrice: 1, OriginalPrice: 1})
+	ruleID, err := repo.CreateAlertRule(ctx, model.AlertRule{
+		ProductID: coffee, Kind: model.AlertKindAllTimeLow, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventID, err := repo.InsertAlertEvent(ctx, model.AlertEvent{RuleID: ruleID, ProductID: coffee,
+		Kind: model.AlertKindAllTimeLow, Price: 1, PreviousPrice: 2, Message: "kopi is cheap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := repo.GetAlertEventByID(ctx, eventID)
+	if err != nil || event.ProductName != "kopi" || event.Message != "kopi is cheap" || event.PreviousPrice != 2 {
+		t.Errorf("GetAlertEventByID() = %+v, %v", event, err)
+	}
+
+	email, err := repo.InsertAlertDelivery(ctx, model.AlertDelivery{EventID: eventID, Channel: "email",
+		Status: model.DeliveryStatusPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(tick)
+	webhook, err := repo.InsertAlertDelivery(ctx, model.AlertDelivery{EventID: eventID, Channel: "webhook",
+		Status: model.DeliveryStatusPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.RecordAlertDeliveryAttempt(ctx, email, model.DeliveryStatusPending, "connection refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := repo.GetAlertDeliveryByID(ctx, email)
+	if err != nil || delivery.Status != model.DeliveryStatusPending || delivery.Attempts != 1 ||
+		delivery.LastError != "connection refused" || delivery.SentAt.Valid {
+		t.Errorf("delivery after a failed attempt = %+v, %v", delivery, err)
+	}
+
+	err = repo.RecordAlertDeliveryAttempt(ctx, email, model.DeliveryStatusSent, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err = repo.GetAlertDeliveryByID(ctx, email)
+	if err != nil || delivery.Status != model.DeliveryStatusSent || delivery.Attempts != 2 ||
+		delivery.LastError != "" || !delivery.SentAt.Valid || delivery.ProductName != "kopi" ||
+		delivery.Message != "kopi is cheap" {
+		t.Errorf("delivery after it was sent = %+v, %v", delivery, err)
+	}
+
+	deliveries, err := repo.GetAlertDeliveries(ctx, 0, 10, 0)
+	if err != nil || len(deliveries) != 2 || deliveries[0].ID != webhook || deliveries[1].ID != email ||
+		deliveries[0].Channel != "webhook" || deliveries[0].CreatedAt.IsZero() {
+		t.Errorf("GetAlertDeliveries() = %+v, %v", deliveries, err)
+	}
+	deliveries, err = repo.GetAlertDeliveries(ctx, coffee, 10, 1)
+	if err != nil || len(deliveries) != 1 || deliveries[0].ID != email {
+		t.Errorf("GetAlertDeliveries(coffee, offset 1) = %+v, %v", deliveries, err)
+	}
+	deliveries, err = repo.GetAlertDeliveries(ctx, coffee+1, 10, 0)
+	if err != nil || len(deliveries) != 0 {
+		t.Errorf("GetAlertDeliveries() of another product = %+v, %v", deliveries, err)
+	}
+	total, err := repo.GetTotalAlertDelivery(ctx, coffee)
+	if err != nil || total != 2 {
+		t.Errorf("GetTotalAlertDelivery(coffee) = %d, %v", total, err)
+	}
+	total, err = repo.GetTotalAlertDelivery(ctx, coffee+1)
+	if err != nil || total != 0 {
+		t.Errorf("GetTotalAlertDelivery() of another product = %d, %v", total, err)
+	}
+
+	err = repo.DeleteAlertRule(ctx, ruleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err = repo.GetTotalAlertDelivery(ctx, 0)
+	if err != nil || total != 0 {
+		t.Errorf("deleting a rule must delete the deliveries of its events: %d left, %v", total, err)
+	}
+}
+
+func testDigests(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	breakfast, err := repo.CreateCollection(ctx, "breakfast", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	everything, err := repo.CreateDigest(ctx, model.Digest{Period: model.DigestPeriodWeekly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	morning, err := repo.CreateDigest(ctx, model.Digest{CollectionID: breakfast, Period: model.DigestPeriodDaily,
+		Channels: "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := repo.GetDigestByID(ctx, morning)
+	if err != nil || digest.CollectionID != breakfast || digest.CollectionName != "breakfast" ||
+		digest.Period != model.DigestPeriodDaily || digest.Channels != "email" || digest.CreatedAt.IsZero() {
+		t.Errorf("GetDigestByID() = %+v, %v", digest, err)
+	}
+	digests, err := repo.GetDigests(ctx)
+	if err != nil || len(digests) != 2 || digests[0].ID != everything || digests[0].CollectionID != 0 ||
+		digests[0].CollectionName != "" {
+		t.Errorf("GetDigests() = %+v, %v", digests, err)
+	}
+
+	_, err = repo.GetLastDigestReport(ctx, morning)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetLastDigestReport() without reports = %v, want %v", err, sql.ErrNoRows)
+	}
+	day := time.Date(2021, 9, 1, 7, 0, 0, 0, time.UTC)
+	var reports []int64
+	for i := 0; i < 2; i++ {
+		id, err := repo.InsertDigestReport(ctx, model.DigestReport{DigestID: morning,
+			PeriodStart: day.AddDate(0, 0, i-1), PeriodEnd: day.AddDate(0, 0, i), Content: fmt.Sprintf(`{"n":%d}`, i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, id)
+	}
+	_, err = repo.InsertDigestReport(ctx, model.DigestReport{DigestID: everything,
+		PeriodStart: day.AddDate(0, 0, -7), PeriodEnd: day, Content: "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := repo.GetDigestReportByID(ctx, reports[0])
+	if err != nil || report.DigestID != morning || !report.PeriodStart.Equal(day.AddDate(0, 0, -1)) ||
+		!report.PeriodEnd.Equal(day) || report.Content != `{"n":0}` || report.CreatedAt.IsZero() {
+		t.Errorf("GetDigestReportByID() = %+v, %v", report, err)
+	}
+	last, err := repo.GetLastDigestReport(ctx, morning)
+	if err != nil || last.ID != reports[1] {
+		t.Errorf("GetLastDigestReport() = %+v, %v", last, err)
+	}
+	list, err := repo.GetDigestReports(ctx, morning, 10, 0)
+	if err != nil || len(list) != 2 || list[0].ID != reports[1] || list[1].ID != reports[0] {
+		t.Errorf("GetDigestReports(morning) = %+v, %v", list, err)
+	}
+	total, err := repo.GetTotalDigestReport(ctx, 0)
+	if err != nil || total != 3 {
+		t.Errorf("GetTotalDigestReport() = %d, %v", total, err)
+	}
+
+	// Deleting the collection deletes its digest and the digest's reports.
+	err = repo.DeleteCollection(ctx, breakfast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetDigestByID(ctx, morning)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetDigestByID() of a deleted collection's digest = %v, want %v", err, sql.ErrNoRows)
+	}
+	err = repo.DeleteDigest(ctx, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err = repo.GetTotalDigestReport(ctx, 0)
+	if err != nil || total != 0 {
+		t.Errorf("deleting digests must delete their reports: %d left, %v", total, err)
+	}
+}
+
+func testWebhooks(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+
+	hookID, err := repo.CreateWebhook(ctx, model.Webhook{URL: "https://example.com/hook", Secret: "s3cret",
+		Events: "price_changed,product_failed", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := repo.CreateWebhook(ctx, model.Webhook{URL: "https://example.com/other", Secret: "x",
+		Events: "alert_triggered"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := repo.GetWebhookByID(ctx, hookID)
+	if err != nil || hook.URL != "https://example.com/hook" || hook.Secret != "s3cret" ||
+		hook.Events != "price_changed,product_failed" || !hook.Enabled || hook.CreatedAt.IsZero() {
+		t.Errorf("GetWebhookByID() = %+v, %v", hook, err)
+	}
+	hooks, err := repo.GetWebhooks(ctx)
+	if err != nil || len(hooks) != 2 || hooks[0].ID != hookID || hooks[1].Enabled {
+		t.Errorf("GetWebhooks() = %+v, %v", hooks, err)
+	}
+
+	first, err := repo.InsertWebhookDelivery(ctx, model.WebhookDelivery{WebhookID: hookID,
+		Event: model.WebhookEventPriceChanged, Payload: `{"n":1}`, Status: model.DeliveryStatusPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(tick)
+	_, err = repo.InsertWebhookDelivery(ctx, model.WebhookDelivery{WebhookID: otherID,
+		Event: model.WebhookEventAlertTriggered, Payload: `{"n":2}`, Status: model.DeliveryStatusPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.RecordWebhookDeliveryAttempt(ctx, first, model.DeliveryStatusPending, 502, "bad gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := repo.GetWebhookDeliveryByID(ctx, first)
+	if err != nil || delivery.Attempts != 1 || delivery.StatusCode != 502 || delivery.LastError != "bad gateway" ||
+		delivery.DeliveredAt.Valid || delivery.URL != "https://example.com/hook" || delivery.Payload != `{"n":1}` {
+		t.Errorf("delivery after a failed attempt = %+v, %v", delivery, err)
+	}
+	err = repo.RecordWebhookDeliveryAttempt(ctx, first, model.DeliveryStatusSent, 204, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err = repo.GetWebhookDeliveryByID(ctx, first)
+	if err != nil || delivery.Status != model.DeliveryStatusSent || delivery.Attempts != 2 ||
+		delivery.StatusCode != 204 || delivery.LastError != "" || !delivery.DeliveredAt.Valid {
+		t.Errorf("delivery after it was sent = %+v, %v", delivery, err)
+	}
+
+	deliveries, err := repo.GetWebhookDeliveries(ctx, 0, 10, 0)
+	if err != nil || len(deliveries) != 2 || deliveries[0].WebhookID != otherID ||
+		deliveries[0].URL != "https://example.com/other" || deliveries[1].ID != first {
+		t.Errorf("GetWebhookDeliveries() = %+v, %v", deliveries, err)
+	}
+	deliveries, err = repo.GetWebhookDeliveries(ctx, hookID, 10, 0)
+	if err != nil || len(deliveries) != 1 || deliveries[0].ID != first {
+		t.Errorf("GetWebhookDeliveries(hook) = %+v, %v", deliveries, err)
+	}
+
+	err = repo.DeleteWebhook(ctx, hookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetWebhookByID(ctx, hookID)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetWebhookByID() of a deleted webhook = %v, want %v", err, sql.ErrNoRows)
+	}
+	total, err := repo.GetTotalWebhookDelivery(ctx, 0)
+	if err != nil || total != 1 {
+		t.Errorf("deleting a webhook must delete its deliveries: %d left, %v", total, err)
+	}
+}
+
+func testSearchAndSort(t *testing.T, repo usecase.DBProvider) {
+	ctx := context.Background()
+	coffee := upsert(t, repo, model.ProductPayload{Name: "Kopi Bubuk 200g", CurrentPrice: 50000, OriginalPrice: 60000,
+		URL: "https://www.tokopedia.com/toko/kopi-bubuk", Marketplace: "tokopedia.com"})
+	time.Sleep(tick)
+	tea := upsert(t, repo, model.ProductPayload{Name: "Teh Celup", CurrentPrice: 9000, OriginalPrice: 9000,
+		URL: "https://shopee.co.id/teh-celup", Marketplace: "shopee.co.id"})
+	time.Sleep(tick)
+	sugar := upsert(t, repo, model.ProductPayload{Name: "Gula Pasir", CurrentPrice: 15000, OriginalPrice: 20000,
+		URL: "https://tokopedia.com/toko/gula", Marketplace: "tokopedia.com"})
+	err := repo.UpsertProductSettings(ctx, model.ProductSettings{ProductID: sugar, DisplayName: "Sugar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		filter model.ProductFilter
+		want   []int64
+	}{
+		{"search name", model.ProductFilter{Search: "kopi"}, []int64{coffee}},
+		{"search word prefixes", model.ProductFilter{Search: "kop bub"}, []int64{coffee}},
+		{"search any case and order", model.ProductFilter{Search: "CELUP teh"}, []int64{tea}},
+		{"search url", model.ProductFilter{Search: "shopee"}, []int64{tea}},
+		{"search display name", model.ProductFilter{Search: "sugar"}, []int64{sugar}},
+		{"search needs every word", model.ProductFilter{Search: "kopi teh"}, nil},
+		{"min price", model.ProductFilter{MinPrice: 10000}, []int64{coffee, sugar}},
+		{"max price", model.ProductFilter{MaxPrice: 15000}, []int64{tea, sugar}},
+		{"on sale", model.ProductFilter{OnSale: true}, []int64{coffee, sugar}},
+		{"marketplace", model.ProductFilter{Marketplace: "shopee.co.id"}, []int64{tea}},
+		{"price", model.ProductFilter{Sort: model.ProductSortPrice}, []int64{tea, sugar, coffee}},
+		{"price descending", model.ProductFilter{Sort: model.ProductSortPrice, Descending: true}, []int64{coffee, sugar, tea}},
+		{"original price", model.ProductFilter{Sort: model.ProductSortOriginalPrice}, []int64{tea, sugar, coffee}},
+		{"discount", model.ProductFilter{Sort: model.ProductSortDiscount, Descending: true}, []int64{sugar, coffee, tea}},
+		{"display name", model.ProductFilter{Sort: model.ProductSortName}, []int64{coffee, sugar, tea}},
+		{"last change", model.ProductFilter{Sort: model.ProductSortLastChange, Descending: true}, []int64{sugar, tea, coffee}},
+		{"sorted and filtered", model.ProductFilter{Marketplace: "tokopedia.com", Sort: model.ProductSortPrice},
+			[]int64{sugar, coffee}},
+	} {
+		tt.filter.Status = model.ProductStatusActive
+		products, err := repo.GetProducts(ctx, tt.filter, 10, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ids := productIDs(products); fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: GetProducts(%+v) = %v, want %v", tt.name, tt.filter, ids, tt.want)
+		}
+
+		total, err := repo.GetTotalProduct(ctx, tt.filter)
+		if err != nil || total != int64(len(tt.want)) {
+			t.Errorf("%s: GetTotalProduct(%+v) = %d, %v, want %d", tt.name, tt.filter, total, err, len(tt.want))
+		}
+	}
+
+	products, err := repo.GetProducts(ctx, model.ProductFilter{Status: model.ProductStatusActive,
+		Sort: model.ProductSortPrice}, 1, 1)
+	if ids := productIDs(products); err != nil || len(ids) != 1 || ids[0] != sugar {
+		t.Errorf("second page by price = %v, %v, want [%d]", ids, err, sugar)
+	}
+
+	marketplaces, err := repo.GetMarketplaces(ctx)
+	if err != nil || fmt.Sprint(marketplaces) != "[shopee.co.id tokopedia.com]" {
+		t.Errorf("GetMarketplaces() = %v, %v", marketplaces, err)
+	}
+	if product := getProduct(t, repo, coffee); product.Marketplace != "tokopedia.com" {
+		t.Errorf("product marketplace = %q", product.Marketplace)
+	}
+}
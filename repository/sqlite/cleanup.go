@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// orphanQueries selects, per table, the rows that reference a product or
+// refresh run that does not exist.
+var orphanQueries = []struct {
+	table string
+	where string
+}{
+	{"price_history", `NOT EXISTS (SELECT 1 FROM product p WHERE p.id = t.product_id)`},
+	{"product_images", `NOT EXISTS (SELECT 1 FROM product p WHERE p.id = t.product_id)`},
+	{"refresh_run_item", `NOT EXISTS (SELECT 1 FROM product p WHERE p.id = t.product_id)
+		OR NOT EXISTS (SELECT 1 FROM refresh_run r WHERE r.id = t.run_id)`},
+}
+
+func (r *repository) CountOrphans(ctx context.Context) ([]model.OrphanCount, error) {
+	result := make([]model.OrphanCount, 0, len(orphanQueries))
+	for _, q := range orphanQueries {
+		sql := `SELECT count(*) FROM ` + q.table + ` AS t WHERE ` + q.where
+
+		count := model.OrphanCount{Table: q.table}
+		err := r.db.QueryRowContext(ctx, sql).Scan(&count.Rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, count)
+	}
+
+	return result, nil
+}
+
+// DeleteOrphans removes every orphaned row in a single transaction and
+// reports how many were deleted per table.
+func (r *repository) DeleteOrphans(ctx context.Context) ([]model.OrphanCount, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]model.OrphanCount, 0, len(orphanQueries))
+	for _, q := range orphanQueries {
+		sql := `DELETE FROM ` + q.table + ` AS t WHERE ` + q.where
+
+		res, err := tx.ExecContext(ctx, sql)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		result = append(result, model.OrphanCount{Table: q.table, Rows: rows})
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
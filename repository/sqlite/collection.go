@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const collectionColumns = `c.id, c.name, coalesce(c.description,'') description, c.created_at,
+	(SELECT count(*) FROM collection_product cp WHERE cp.collection_id = c.id) products`
+
+func (r *repository) CreateCollection(ctx context.Context, name, description string) (int64, error) {
+	sql := `INSERT INTO collection (name, description, created_at) VALUES (?, NULLIF(?, ''), ?) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, name, description, now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetCollections returns every collection by name with its product count.
+func (r *repository) GetCollections(ctx context.Context) ([]model.Collection, error) {
+	sql := `SELECT ` + collectionColumns + ` FROM collection c ORDER BY c.name`
+
+	var collections []model.Collection
+	err := r.db.SelectContext(ctx, &collections, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+func (r *repository) GetCollectionByID(ctx context.Context, id int64) (model.Collection, error) {
+	sql := `SELECT ` + collectionColumns + ` FROM collection c WHERE c.id = ?`
+
+	var collection model.Collection
+	err := r.db.GetContext(ctx, &collection, sql, id)
+	if err != nil {
+		return model.Collection{}, err
+	}
+
+	return collection, nil
+}
+
+// DeleteCollection deletes a collection. Its products are kept.
+func (r *repository) DeleteCollection(ctx context.Context, id int64) error {
+	sql := `DELETE FROM collection WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
+// AddCollectionProducts adds the products to a collection. Unknown products
+// are ignored.
+func (r *repository) AddCollectionProducts(ctx context.Context, collectionID int64, productIDs []int64) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	sql, args, err := sqlx.In(`INSERT INTO collection_product (collection_id, product_id)
+		SELECT ?, id FROM product WHERE id IN (?) ON CONFLICT DO NOTHING`, collectionID, productIDs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, sql, args...)
+
+	return err
+}
+
+func (r *repository) RemoveCollectionProducts(ctx context.Context, collectionID int64, productIDs []int64) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	sql, args, err := sqlx.In(`DELETE FROM collection_product WHERE collection_id = ? AND product_id IN (?)`,
+		collectionID, productIDs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, sql, args...)
+
+	return err
+}
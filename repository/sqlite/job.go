@@ -0,0 +1,158 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, coalesce(last_error, '') last_error,
+	coalesce(result, '') result, run_at, created_at, updated_at`
+
+func (r *repository) EnqueueJob(ctx context.Context, payload model.JobPayload) (int64, error) {
+	sql := `INSERT INTO job (kind, payload, status, max_attempts, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	at := now()
+	res, err := r.db.ExecContext(ctx, sql, payload.Kind, payload.Payload, model.JobStatusPending, payload.MaxAttempts,
+		payload.RunAt.UTC(), at, at)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// EnqueueJobs queues all the jobs or, on error, none of them, and returns
+// their ids in order.
+func (r *repository) EnqueueJobs(ctx context.Context, payloads []model.JobPayload) ([]int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO job (kind, payload, status, max_attempts, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	at := now()
+	ids := make([]int64, 0, len(payloads))
+	for _, payload := range payloads {
+		res, err := tx.ExecContext(ctx, sql, payload.Kind, payload.Payload, model.JobStatusPending,
+			payload.MaxAttempts, payload.RunAt.UTC(), at, at)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ClaimJob marks the oldest due pending job as running and returns it. A zero
+// Job is returned when nothing is due. SQLite runs the single UPDATE under
+// its write lock, so concurrent workers never claim the same job.
+func (r *repository) ClaimJob(ctx context.Context) (model.Job, error) {
+	sql := `UPDATE job SET status = ?1, attempts = attempts + 1, updated_at = ?3
+		WHERE id = (SELECT id FROM job WHERE status = ?2 AND run_at <= ?3 ORDER BY run_at, id LIMIT 1)
+		RETURNING ` + jobColumns
+
+	var jobs []model.Job
+	err := r.db.SelectContext(ctx, &jobs, sql, model.JobStatusRunning, model.JobStatusPending, now())
+	if err != nil {
+		return model.Job{}, err
+	}
+	if len(jobs) == 0 {
+		return model.Job{}, nil
+	}
+
+	return jobs[0], nil
+}
+
+func (r *repository) CompleteJob(ctx context.Context, id int64, result string) error {
+	sql := `UPDATE job SET status = ?, result = ?, last_error = NULL, updated_at = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, model.JobStatusDone, result, now(), id)
+
+	return err
+}
+
+// FailJob records a failed attempt. The job is put back in the queue to run
+// again at retryAt, or marked failed when retryAt is zero.
+func (r *repository) FailJob(ctx context.Context, id int64, errMessage string, retryAt time.Time) error {
+	if retryAt.IsZero() {
+		sql := `UPDATE job SET status = ?, last_error = ?, updated_at = ? WHERE id = ?`
+		_, err := r.db.ExecContext(ctx, sql, model.JobStatusFailed, errMessage, now(), id)
+		return err
+	}
+
+	sql := `UPDATE job SET status = ?, last_error = ?, run_at = ?, updated_at = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, model.JobStatusPending, errMessage, retryAt.UTC(), now(), id)
+
+	return err
+}
+
+// RequeueStaleJobs returns jobs stuck in running since before the given time,
+// e.g. because their worker was killed, to the queue. Jobs out of attempts
+// are marked failed instead, so one that crashes its worker is not retried
+// forever.
+func (r *repository) RequeueStaleJobs(ctx context.Context, before time.Time) (int64, int64, error) {
+	sqlFail := `UPDATE job SET status = ?, last_error = ?, updated_at = ?
+		WHERE status = ? AND updated_at < ? AND attempts >= max_attempts`
+	res, err := r.db.ExecContext(ctx, sqlFail, model.JobStatusFailed, model.JobStaleError, now(),
+		model.JobStatusRunning, before.UTC())
+	if err != nil {
+		return 0, 0, err
+	}
+	failed, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sqlRequeue := `UPDATE job SET status = ?, updated_at = ?
+		WHERE status = ? AND updated_at < ? AND attempts < max_attempts`
+	res, err = r.db.ExecContext(ctx, sqlRequeue, model.JobStatusPending, now(), model.JobStatusRunning, before.UTC())
+	if err != nil {
+		return 0, 0, err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return requeued, failed, nil
+}
+
+// CountOverdueJobs counts pending jobs that were due before the given time
+// and have not been claimed since.
+func (r *repository) CountOverdueJobs(ctx context.Context, before time.Time) (int64, error) {
+	sql := `SELECT count(*) FROM job WHERE status = ? AND run_at < ?`
+
+	var count int64
+	err := r.db.GetContext(ctx, &count, sql, model.JobStatusPending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *repository) GetJobByID(ctx context.Context, id int64) (model.Job, error) {
+	sql := `SELECT ` + jobColumns + ` FROM job WHERE id = ?`
+
+	var job model.Job
+	err := r.db.GetContext(ctx, &job, sql, id)
+	if err != nil {
+		return model.Job{}, err
+	}
+
+	return job, nil
+}
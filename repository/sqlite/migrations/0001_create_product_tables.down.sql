@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS product_images;
+DROP TABLE IF EXISTS price_history;
+DROP TABLE IF EXISTS product;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS product (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text NOT NULL,
+	current_price integer NOT NULL,
+	original_price integer NOT NULL,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	url text NULL,
+	CONSTRAINT product_un UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS price_history (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	product_id integer NOT NULL,
+	current_price integer NOT NULL,
+	original_price integer NOT NULL,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS product_images (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	product_id integer NOT NULL,
+	image text NOT NULL,
+	status integer NOT NULL
+);
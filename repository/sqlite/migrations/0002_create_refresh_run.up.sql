@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS refresh_run (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	started_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at datetime NULL,
+	attempted integer NOT NULL DEFAULT 0,
+	succeeded integer NOT NULL DEFAULT 0,
+	failed integer NOT NULL DEFAULT 0,
+	changed integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS refresh_run_item (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	run_id integer NOT NULL,
+	product_id integer NOT NULL,
+	status text NOT NULL,
+	changed boolean NOT NULL DEFAULT false,
+	old_price integer NOT NULL DEFAULT 0,
+	new_price integer NOT NULL DEFAULT 0,
+	error text NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS job (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	kind text NOT NULL,
+	payload text NOT NULL DEFAULT '{}',
+	status text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	max_attempts integer NOT NULL DEFAULT 1,
+	last_error text NULL,
+	result text NULL,
+	run_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS job_status_run_at_idx ON job (status, run_at);
@@ -0,0 +1,4 @@
+-- The foreign keys stay in place; only the indexes are dropped.
+DROP INDEX IF EXISTS refresh_run_item_run_idx;
+DROP INDEX IF EXISTS product_images_product_status_idx;
+DROP INDEX IF EXISTS price_history_product_updated_idx;
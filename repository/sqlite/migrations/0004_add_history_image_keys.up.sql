@@ -0,0 +1,49 @@
+-- SQLite cannot add foreign keys to an existing table, so the tables are
+-- rebuilt. Rows pointing at products or runs that no longer exist are left
+-- behind; run `-mode=cleanup report` first to see them.
+CREATE TABLE price_history_new (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	current_price integer NOT NULL,
+	original_price integer NOT NULL,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO price_history_new (id, product_id, current_price, original_price, updated_at)
+	SELECT h.id, h.product_id, h.current_price, h.original_price, h.updated_at FROM price_history h
+	WHERE EXISTS (SELECT 1 FROM product p WHERE p.id = h.product_id);
+DROP TABLE price_history;
+ALTER TABLE price_history_new RENAME TO price_history;
+CREATE INDEX price_history_product_updated_idx ON price_history (product_id, updated_at);
+
+CREATE TABLE product_images_new (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	image text NOT NULL,
+	status integer NOT NULL
+);
+INSERT INTO product_images_new (id, product_id, image, status)
+	SELECT i.id, i.product_id, i.image, i.status FROM product_images i
+	WHERE EXISTS (SELECT 1 FROM product p WHERE p.id = i.product_id);
+DROP TABLE product_images;
+ALTER TABLE product_images_new RENAME TO product_images;
+CREATE INDEX product_images_product_status_idx ON product_images (product_id, status);
+
+CREATE TABLE refresh_run_item_new (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	run_id integer NOT NULL REFERENCES refresh_run (id) ON DELETE CASCADE,
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	status text NOT NULL,
+	changed boolean NOT NULL DEFAULT false,
+	old_price integer NOT NULL DEFAULT 0,
+	new_price integer NOT NULL DEFAULT 0,
+	error text NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO refresh_run_item_new (id, run_id, product_id, status, changed, old_price, new_price, error, created_at)
+	SELECT i.id, i.run_id, i.product_id, i.status, i.changed, i.old_price, i.new_price, i.error, i.created_at
+	FROM refresh_run_item i
+	WHERE EXISTS (SELECT 1 FROM product p WHERE p.id = i.product_id)
+		AND EXISTS (SELECT 1 FROM refresh_run r WHERE r.id = i.run_id);
+DROP TABLE refresh_run_item;
+ALTER TABLE refresh_run_item_new RENAME TO refresh_run_item;
+CREATE INDEX refresh_run_item_run_idx ON refresh_run_item (run_id);
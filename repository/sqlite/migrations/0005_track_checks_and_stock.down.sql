@@ -0,0 +1,7 @@
+ALTER TABLE price_history DROP COLUMN in_stock;
+
+DROP INDEX IF EXISTS product_last_checked_idx;
+ALTER TABLE product DROP COLUMN last_check_error;
+ALTER TABLE product DROP COLUMN check_failures;
+ALTER TABLE product DROP COLUMN last_checked_at;
+ALTER TABLE product DROP COLUMN in_stock;
@@ -0,0 +1,10 @@
+-- updated_at now only moves when a price or the stock state changes;
+-- last_checked_at records every refresh attempt.
+ALTER TABLE product ADD COLUMN in_stock boolean NOT NULL DEFAULT true;
+ALTER TABLE product ADD COLUMN last_checked_at datetime NULL;
+ALTER TABLE product ADD COLUMN check_failures integer NOT NULL DEFAULT 0;
+ALTER TABLE product ADD COLUMN last_check_error text NULL;
+UPDATE product SET last_checked_at = updated_at;
+CREATE INDEX product_last_checked_idx ON product (last_checked_at);
+
+ALTER TABLE price_history ADD COLUMN in_stock boolean NOT NULL DEFAULT true;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS price_history_updated_idx;
+DROP TABLE IF EXISTS price_history_weekly;
+DROP TABLE IF EXISTS price_history_daily;
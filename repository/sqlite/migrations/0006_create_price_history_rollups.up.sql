@@ -0,0 +1,32 @@
+-- Rollups summarise the current price per product and bucket: open/close are
+-- the first and last recorded prices, avg is over the recorded rows, and
+-- original_price/in_stock are taken from the last row.
+CREATE TABLE IF NOT EXISTS price_history_daily (
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	bucket date NOT NULL,
+	open_price integer NOT NULL,
+	close_price integer NOT NULL,
+	min_price integer NOT NULL,
+	max_price integer NOT NULL,
+	avg_price integer NOT NULL,
+	original_price integer NOT NULL,
+	in_stock boolean NOT NULL,
+	samples integer NOT NULL,
+	PRIMARY KEY (product_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS price_history_weekly (
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	bucket date NOT NULL,
+	open_price integer NOT NULL,
+	close_price integer NOT NULL,
+	min_price integer NOT NULL,
+	max_price integer NOT NULL,
+	avg_price integer NOT NULL,
+	original_price integer NOT NULL,
+	in_stock boolean NOT NULL,
+	samples integer NOT NULL,
+	PRIMARY KEY (product_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS price_history_updated_idx ON price_history (updated_at);
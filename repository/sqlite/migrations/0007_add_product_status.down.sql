@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS product_status_idx;
+ALTER TABLE product DROP COLUMN status;
@@ -0,0 +1,3 @@
+-- Archived products keep their history but are no longer listed or refreshed.
+ALTER TABLE product ADD COLUMN status integer NOT NULL DEFAULT 1;
+CREATE INDEX product_status_idx ON product (status);
@@ -0,0 +1,10 @@
+-- User-editable settings live apart from the scraped product columns, which
+-- every refresh overwrites.
+CREATE TABLE IF NOT EXISTS product_settings (
+	product_id integer PRIMARY KEY REFERENCES product (id) ON DELETE CASCADE,
+	display_name text NULL,
+	notes text NULL,
+	target_price integer NULL,
+	refresh_interval_minutes integer NULL,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS collection_product;
+DROP TABLE IF EXISTS collection;
+DROP TABLE IF EXISTS product_tag;
+DROP TABLE IF EXISTS tag;
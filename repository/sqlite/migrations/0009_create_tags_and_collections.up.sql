@@ -0,0 +1,27 @@
+-- Tags are free-form labels shared by products; collections are named,
+-- hand-picked groups of products.
+CREATE TABLE IF NOT EXISTS tag (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS product_tag (
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	tag_id integer NOT NULL REFERENCES tag (id) ON DELETE CASCADE,
+	PRIMARY KEY (product_id, tag_id)
+);
+CREATE INDEX product_tag_tag_idx ON product_tag (tag_id);
+
+CREATE TABLE IF NOT EXISTS collection (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text NOT NULL UNIQUE,
+	description text NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS collection_product (
+	collection_id integer NOT NULL REFERENCES collection (id) ON DELETE CASCADE,
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	PRIMARY KEY (collection_id, product_id)
+);
+CREATE INDEX collection_product_product_idx ON collection_product (product_id);
@@ -0,0 +1,8 @@
+-- marketplace is the host a product is sold on, without a leading "www.".
+ALTER TABLE product ADD COLUMN marketplace text NOT NULL DEFAULT '';
+UPDATE product SET marketplace = h.host FROM (
+	SELECT id, CASE WHEN host LIKE 'www.%' THEN substr(host, 5) ELSE host END host FROM (
+		SELECT id, lower(substr(rest, 1, instr(rest || '/', '/') - 1)) host FROM (
+			SELECT id, substr(url, instr(url, '://') + 3) rest FROM product WHERE instr(url, '://') > 0))
+) h WHERE h.id = product.id;
+CREATE INDEX product_marketplace_idx ON product (marketplace);
@@ -0,0 +1,28 @@
+-- An alert rule watches one product, or every product with a tag, and an
+-- alert event is stored each time a price write satisfies it.
+CREATE TABLE IF NOT EXISTS alert_rule (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	product_id integer NULL REFERENCES product (id) ON DELETE CASCADE,
+	tag text NULL,
+	kind text NOT NULL,
+	threshold integer NOT NULL DEFAULT 0,
+	window_hours integer NOT NULL DEFAULT 0,
+	enabled boolean NOT NULL DEFAULT true,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CHECK ((product_id IS NULL) <> (tag IS NULL))
+);
+CREATE INDEX alert_rule_product_idx ON alert_rule (product_id);
+CREATE INDEX alert_rule_tag_idx ON alert_rule (tag);
+
+CREATE TABLE IF NOT EXISTS alert_event (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	rule_id integer NOT NULL REFERENCES alert_rule (id) ON DELETE CASCADE,
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	kind text NOT NULL,
+	price integer NOT NULL,
+	previous_price integer NOT NULL,
+	message text NOT NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX alert_event_product_idx ON alert_event (product_id, created_at);
+CREATE INDEX alert_event_created_idx ON alert_event (created_at);
@@ -0,0 +1,15 @@
+-- One row per fired alert and notification channel, updated on every
+-- delivery attempt.
+CREATE TABLE IF NOT EXISTS alert_delivery (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	event_id integer NOT NULL REFERENCES alert_event (id) ON DELETE CASCADE,
+	channel text NOT NULL,
+	status text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text NULL,
+	sent_at datetime NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX alert_delivery_event_idx ON alert_delivery (event_id);
+CREATE INDEX alert_delivery_created_idx ON alert_delivery (created_at);
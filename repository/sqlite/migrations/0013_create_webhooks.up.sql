@@ -0,0 +1,27 @@
+-- A webhook subscribes a URL to a comma-separated list of event types. Each
+-- event it receives is logged as a delivery holding the exact body sent, so
+-- retries send the same payload.
+CREATE TABLE IF NOT EXISTS webhook (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	url text NOT NULL,
+	secret text NOT NULL,
+	events text NOT NULL,
+	enabled boolean NOT NULL DEFAULT true,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	webhook_id integer NOT NULL REFERENCES webhook (id) ON DELETE CASCADE,
+	event text NOT NULL,
+	payload text NOT NULL,
+	status text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	status_code integer NOT NULL DEFAULT 0,
+	last_error text NULL,
+	delivered_at datetime NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX webhook_delivery_webhook_idx ON webhook_delivery (webhook_id, created_at);
+CREATE INDEX webhook_delivery_created_idx ON webhook_delivery (created_at);
@@ -0,0 +1 @@
+ALTER TABLE alert_rule DROP COLUMN channels;
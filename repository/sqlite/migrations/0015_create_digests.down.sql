@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS digest_report;
+DROP TABLE IF EXISTS digest;
@@ -0,0 +1,20 @@
+-- A digest summarizes the products of a collection, or every product when
+-- collection_id is null, once a day or once a week. Each summary sent is kept
+-- as a report whose content is the summary as JSON.
+CREATE TABLE IF NOT EXISTS digest (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	collection_id integer NULL REFERENCES collection (id) ON DELETE CASCADE,
+	period text NOT NULL,
+	channels text NOT NULL DEFAULT '',
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS digest_report (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	digest_id integer NOT NULL REFERENCES digest (id) ON DELETE CASCADE,
+	period_start datetime NOT NULL,
+	period_end datetime NOT NULL,
+	content text NOT NULL,
+	created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX digest_report_digest_idx ON digest_report (digest_id, period_end);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS alert_state;
+ALTER TABLE alert_event DROP COLUMN acknowledged_at;
+ALTER TABLE alert_rule DROP COLUMN snoozed_until;
+ALTER TABLE alert_rule DROP COLUMN timezone;
+ALTER TABLE alert_rule DROP COLUMN quiet_end;
+ALTER TABLE alert_rule DROP COLUMN quiet_start;
+ALTER TABLE alert_rule DROP COLUMN hysteresis;
+ALTER TABLE alert_rule DROP COLUMN cooldown_minutes;
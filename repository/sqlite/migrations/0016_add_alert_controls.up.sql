@@ -0,0 +1,23 @@
+-- A rule fires again for a product only after cooldown_minutes, and with
+-- hysteresis only once the product stopped satisfying it. Its deliveries
+-- wait for the end of quiet hours, read in the rule's timezone, and it does
+-- not fire at all while snoozed.
+ALTER TABLE alert_rule ADD COLUMN cooldown_minutes integer NOT NULL DEFAULT 0;
+ALTER TABLE alert_rule ADD COLUMN hysteresis boolean NOT NULL DEFAULT false;
+ALTER TABLE alert_rule ADD COLUMN quiet_start text NOT NULL DEFAULT '';
+ALTER TABLE alert_rule ADD COLUMN quiet_end text NOT NULL DEFAULT '';
+ALTER TABLE alert_rule ADD COLUMN timezone text NOT NULL DEFAULT '';
+ALTER TABLE alert_rule ADD COLUMN snoozed_until datetime NULL;
+
+ALTER TABLE alert_event ADD COLUMN acknowledged_at datetime NULL;
+
+-- Where a rule stands for each product it watches. A rule is disarmed for a
+-- product when it fires with hysteresis or its event is acknowledged, and
+-- rearmed once the product no longer satisfies it.
+CREATE TABLE IF NOT EXISTS alert_state (
+	rule_id integer NOT NULL REFERENCES alert_rule (id) ON DELETE CASCADE,
+	product_id integer NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+	armed boolean NOT NULL DEFAULT true,
+	last_fired_at datetime NULL,
+	PRIMARY KEY (rule_id, product_id)
+);
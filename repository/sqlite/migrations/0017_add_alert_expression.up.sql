@@ -0,0 +1,3 @@
+-- The condition of an expression rule, such as
+-- price < 90% * avg(price, 30d).
+ALTER TABLE alert_rule ADD COLUMN expression text NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- Rollups also count the price carried into a bucket from before it, and
+-- each run only recomputes the buckets of raw rows recorded after
+-- last_history_id, the last row the previous run saw.
+CREATE TABLE IF NOT EXISTS price_rollup_state (
+	id integer PRIMARY KEY CHECK (id = 1),
+	last_history_id integer NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE price_rollup_state DROP COLUMN rolled_up_at;
@@ -0,0 +1,4 @@
+-- Each run also recomputes the buckets of rows recorded shortly before
+-- rolled_up_at, the previous run, in case one committed after it behind
+-- last_history_id.
+ALTER TABLE price_rollup_state ADD COLUMN rolled_up_at datetime NULL;
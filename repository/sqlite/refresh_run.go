@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+func (r *repository) CreateRefreshRun(ctx context.Context) (int64, error) {
+	sql := `INSERT INTO refresh_run (started_at, attempted, succeeded, failed, changed) VALUES (?, 0, 0, 0, 0)`
+
+	res, err := r.db.ExecContext(ctx, sql, now())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *repository) FinishRefreshRun(ctx context.Context, run model.RefreshRun) error {
+	sql := `UPDATE refresh_run SET finished_at = ?, attempted = ?, succeeded = ?, failed = ?, changed = ?
+		WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, now(), run.Attempted, run.Succeeded, run.Failed, run.Changed, run.ID)
+
+	return err
+}
+
+func (r *repository) InsertRefreshRunItem(ctx context.Context, item model.RefreshRunItem) error {
+	sql := `INSERT INTO refresh_run_item (run_id, product_id, status, changed, old_price, new_price, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`
+	_, err := r.db.ExecContext(ctx, sql, item.RunID, item.ProductID, item.Status, item.Changed, item.OldPrice,
+		item.NewPrice, item.Error, now())
+
+	return err
+}
+
+func (r *repository) GetRefreshRuns(ctx context.Context, limit, offset int) ([]model.RefreshRun, error) {
+	sql := `SELECT id, started_at, finished_at, attempted, succeeded, failed, changed FROM
+		refresh_run ORDER BY id DESC LIMIT ? OFFSET ?`
+
+	var runs []model.RefreshRun
+	err := r.db.SelectContext(ctx, &runs, sql, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+func (r *repository) GetTotalRefreshRun(ctx context.Context) (int64, error) {
+	sql := `SELECT count(*) as total FROM refresh_run`
+
+	var total int64
+	err := r.db.QueryRowContext(ctx, sql).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (r *repository) GetRefreshRunByID(ctx context.Context, id int64) (model.RefreshRun, error) {
+	sql := `SELECT id, started_at, finished_at, attempted, succeeded, failed, changed FROM
+		refresh_run WHERE id = ?`
+
+	var run model.RefreshRun
+	err := r.db.GetContext(ctx, &run, sql, id)
+	if err != nil {
+		return model.RefreshRun{}, err
+	}
+
+	return run, nil
+}
+
+func (r *repository) GetRefreshRunItems(ctx context.Context, runID int64) ([]model.RefreshRunItem, error) {
+	sql := `SELECT i.id, i.run_id, i.product_id, coalesce(p.name, '') product_name, i.status, i.changed,
+		i.old_price, i.new_price, coalesce(i.error, '') error, i.created_at
+		FROM refresh_run_item i LEFT JOIN product p ON p.id = i.product_id
+		WHERE i.run_id = ? ORDER BY i.id ASC`
+
+	var items []model.RefreshRunItem
+	err := r.db.SelectContext(ctx, &items, sql, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
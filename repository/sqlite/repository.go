@@ -0,0 +1,472 @@
+// Package sqlite stores products, history, refresh runs and jobs in a local
+// SQLite file. It mirrors the pgsql repository query for query.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+type repository struct {
+	db *sqlx.DB
+}
+
+// New returns a repository on db. SQLite allows a single writer, so db is
+// expected to hold at most one open connection.
+func New(db *sqlx.DB) *repository {
+	return &repository{
+		db: db,
+	}
+}
+
+const (
+	stateActive  = 1
+	stateDeleted = 0
+)
+
+// productColumns and productTables select a product together with its user
+// settings.
+const productColumns = `p.id, p.name, p.current_price, p.original_price, coalesce(p.url,'') url, p.marketplace, p.in_stock,
+	p.updated_at, p.last_checked_at, p.check_failures, coalesce(p.last_check_error,'') last_check_error, p.status,
+	coalesce(s.display_name,'') display_name, coalesce(s.notes,'') notes, coalesce(s.target_price,0) target_price,
+	coalesce(s.refresh_interval_minutes,0) refresh_interval_minutes`
+
+const productTables = `product p LEFT JOIN product_settings s ON s.product_id = p.id`
+
+// now is the timestamp written by every query. Times are always stored in
+// UTC so that they compare correctly as text.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func (r *repository) GetProductsByID(ctx context.Context, id int64) (model.Product, error) {
+	sql := `SELECT ` + productColumns + ` FROM
+		` + productTables + ` WHERE p.id=?`
+
+	var product model.Product
+	err := r.db.GetContext(ctx, &product, sql, id)
+	if err != nil {
+		return model.Product{}, err
+	}
+
+	sqlImage := `SELECT image FROM product_images WHERE product_id=?`
+	rows, err := r.db.QueryxContext(ctx, sqlImage, id)
+	if err != nil {
+		return model.Product{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var image string
+		rows.Scan(&image)
+		product.Images = append(product.Images, image)
+	}
+
+	tags, err := r.productTags(ctx, []int64{id})
+	if err != nil {
+		return model.Product{}, err
+	}
+	product.Tags = tags[id]
+
+	return product, err
+}
+
+// GetProductsDueForRefresh returns up to limit active products that were not
+// checked within their own refresh interval, or defaultInterval when they have
+// none, as of at. The least recently checked come first.
+func (r *repository) GetProductsDueForRefresh(ctx context.Context, at time.Time, defaultInterval time.Duration, limit int) ([]model.Product, error) {
+	sql := `SELECT ` + productColumns + ` FROM
+		` + productTables + ` WHERE p.status = ?
+		AND julianday(p.last_checked_at) < julianday(?) - coalesce(s.refresh_interval_minutes * 60.0, ?) / 86400.0
+		ORDER BY p.last_checked_at LIMIT ?`
+
+	var product []model.Product
+	err := r.db.SelectContext(ctx, &product, sql, model.ProductStatusActive, at.UTC(), defaultInterval.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// productFilterWhere returns the condition on product p matching filter and
+// its arguments.
+func productFilterWhere(filter model.ProductFilter) (string, []interface{}) {
+	where := `p.status = ?`
+	args := []interface{}{filter.Status}
+	if filter.Tag != "" {
+		where += ` AND EXISTS (SELECT 1 FROM product_tag pt JOIN tag t ON t.id = pt.tag_id
+			WHERE pt.product_id = p.id AND t.name = ?)`
+		args = append(args, filter.Tag)
+	}
+	if filter.CollectionID != 0 {
+		where += ` AND EXISTS (SELECT 1 FROM collection_product cp
+			WHERE cp.product_id = p.id AND cp.collection_id = ?)`
+		args = append(args, filter.CollectionID)
+	}
+	// SQLite has no tsvector; every word has to appear somewhere in the name,
+	// URL or display name. Search terms hold no LIKE wildcards. A plain ?
+	// after ?N is numbered N+1, so the placeholders can be mixed.
+	for _, term := range model.SearchTerms(filter.Search) {
+		args = append(args, "%"+term+"%")
+		where += fmt.Sprintf(` AND (lower(p.name) LIKE ?%d OR lower(coalesce(p.url, '')) LIKE ?%[1]d
+			OR lower(coalesce(s.display_name, '')) LIKE ?%[1]d)`, len(args))
+	}
+	if filter.MinPrice > 0 {
+		where += ` AND p.current_price >= ?`
+		args = append(args, filter.MinPrice)
+	}
+	if filter.MaxPrice > 0 {
+		where += ` AND p.current_price <= ?`
+		args = append(args, filter.MaxPrice)
+	}
+	if filter.OnSale {
+		where += ` AND p.current_price < p.original_price`
+	}
+	if filter.Marketplace != "" {
+		where += ` AND p.marketplace = ?`
+		args = append(args, filter.Marketplace)
+	}
+
+	return where, args
+}
+
+// productSorts are the expressions products can be ordered by.
+var productSorts = map[string]string{
+	model.ProductSortName:          `lower(coalesce(s.display_name, p.name))`,
+	model.ProductSortPrice:         `p.current_price`,
+	model.ProductSortOriginalPrice: `p.original_price`,
+	model.ProductSortDiscount: `CASE WHEN p.original_price > 0
+		THEN (p.original_price - p.current_price) * 100 / p.original_price ELSE 0 END`,
+	model.ProductSortLastChange: `p.updated_at`,
+}
+
+// productOrder returns the ORDER BY expressions for filter. Ties are broken
+// by id so that pages do not overlap.
+func productOrder(filter model.ProductFilter) string {
+	sort, ok := productSorts[filter.Sort]
+	if !ok {
+		sort = `p.id`
+	}
+	if filter.Descending {
+		sort += ` DESC`
+	}
+
+	return sort + `, p.id`
+}
+
+// GetProducts returns a page of the products matching filter.
+func (r *repository) GetProducts(ctx context.Context, filter model.ProductFilter, limit, offset int) ([]model.Product, error) {
+	where, args := productFilterWhere(filter)
+	sql := `SELECT ` + productColumns + ` FROM
+		` + productTables + ` WHERE ` + where + ` ORDER BY ` + productOrder(filter) + ` LIMIT ? OFFSET ?`
+
+	var products []model.Product
+	err := r.db.SelectContext(ctx, &products, sql, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return products, nil
+	}
+
+	listProductID := make([]int64, len(products))
+	for i, product := range products {
+		listProductID[i] = product.ID
+	}
+
+	sqlImage, args, err := sqlx.In(`SELECT product_id,image FROM product_images WHERE product_id IN (?) and status=?`,
+		listProductID, stateActive)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryxContext(ctx, sqlImage, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mapProductImage := make(map[int64][]string)
+	for rows.Next() {
+		var productID int64
+		var image string
+		err = rows.Scan(&productID, &image)
+		if err != nil {
+			return nil, err
+		}
+		mapProductImage[productID] = append(mapProductImage[productID], image)
+	}
+
+	tags, err := r.productTags(ctx, listProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range products {
+		products[i].Images = mapProductImage[products[i].ID]
+		products[i].Tags = tags[products[i].ID]
+	}
+
+	return products, nil
+}
+
+func (r *repository) GetTotalProduct(ctx context.Context, filter model.ProductFilter) (int64, error) {
+	where, args := productFilterWhere(filter)
+	sql := `SELECT count(*) as total FROM
+		` + productTables + ` WHERE ` + where
+
+	var total int64
+	err := r.db.QueryRowContext(ctx, sql, args...).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// GetMarketplaces returns the marketplaces products are tracked on.
+func (r *repository) GetMarketplaces(ctx context.Context) ([]string, error) {
+	sql := `SELECT DISTINCT marketplace FROM product WHERE marketplace <> '' ORDER BY marketplace`
+
+	var marketplaces []string
+	err := r.db.SelectContext(ctx, &marketplaces, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return marketplaces, nil
+}
+
+// UpsertProduct stores a product registered by its link. A product with the
+// same name is updated instead.
+func (r *repository) UpsertProduct(ctx context.Context, payload model.ProductPayload) (int64, error) {
+	return r.saveProduct(ctx, 0, payload)
+}
+
+// UpdateProduct stores a refresh of the product with the given id. The row is
+// kept even when the marketplace renamed the listing. It returns
+// sql.ErrNoRows when there is no such product.
+func (r *repository) UpdateProduct(ctx context.Context, id int64, payload model.ProductPayload) error {
+	_, err := r.saveProduct(ctx, id, payload)
+	return err
+}
+
+// saveProduct stores a scraped product with its images and history. The
+// product is looked up by name when id is zero and by id otherwise.
+func (r *repository) saveProduct(ctx context.Context, id int64, payload model.ProductPayload) (int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var previous model.Product
+	if id == 0 {
+		sqlPrevious := `SELECT current_price, original_price, in_stock FROM product WHERE name = ?`
+		err = tx.QueryRowContext(ctx, sqlPrevious, payload.Name).Scan(&previous.CurrentPrice, &previous.OriginalPrice,
+			&previous.InStock)
+	} else {
+		sqlPrevious := `SELECT current_price, original_price, in_stock FROM product WHERE id = ?`
+		err = tx.QueryRowContext(ctx, sqlPrevious, id).Scan(&previous.CurrentPrice, &previous.OriginalPrice,
+			&previous.InStock)
+	}
+	exists := err == nil
+	if err == sql.ErrNoRows && id == 0 {
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	productID := id
+	if id == 0 {
+		productID, err = r.InsertProduct(ctx, tx, payload)
+	} else {
+		err = r.updateProduct(ctx, tx, id, payload)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.insertingImages(ctx, tx, payload, productID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only record history when something a chart would show has changed.
+	changed := !exists || previous.CurrentPrice != payload.CurrentPrice ||
+		previous.OriginalPrice != payload.OriginalPrice || previous.InStock != payload.InStock
+	if changed {
+		sqlHistory := `INSERT INTO price_history(product_id, current_price, original_price, in_stock, updated_at)
+			VALUES (?, ?, ?, ?, ?)`
+		_, err = tx.ExecContext(ctx, sqlHistory, productID, payload.CurrentPrice, payload.OriginalPrice, payload.InStock,
+			now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return productID, nil
+}
+
+// insertingImages reads the current images through tx: with a single
+// connection a query on r.db would wait for the transaction forever.
+func (r *repository) insertingImages(ctx context.Context, tx *sqlx.Tx, payload model.ProductPayload, productID int64) error {
+	var images []model.ProductImage
+	err := tx.SelectContext(ctx, &images, `SELECT id, product_id, image FROM product_images WHERE product_id=?`,
+		productID)
+	if err != nil {
+		return err
+	}
+
+	mapImages := make(map[string]int64)
+	for _, image := range images {
+		mapImages[image.Image] = image.ID
+	}
+
+	for _, image := range payload.Images {
+		if _, ok := mapImages[image]; ok {
+			delete(mapImages, image)
+			continue
+		}
+
+		sqlImages := `INSERT INTO product_images (product_id, image, status) VALUES (?, ?, ?)`
+		_, err = tx.ExecContext(ctx, sqlImages, productID, image, stateActive)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, val := range mapImages {
+		sqlImages := `UPDATE product_images SET status = ? WHERE id = ?`
+		_, err = tx.ExecContext(ctx, sqlImages, stateDeleted, val)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *repository) InsertProduct(ctx context.Context, tx *sqlx.Tx, payload model.ProductPayload) (int64, error) {
+	sql := `INSERT INTO product (name, current_price, original_price, url, in_stock, updated_at, last_checked_at,
+		marketplace) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?6, ?7) ON CONFLICT (name) DO UPDATE SET current_price = ?2,
+		original_price = ?3, url = ?4, marketplace = ?7, in_stock = ?5, last_checked_at = ?6, check_failures = 0,
+		last_check_error = NULL,
+		updated_at = CASE WHEN product.current_price <> ?2 OR product.original_price <> ?3 OR product.in_stock <> ?5
+			THEN ?6 ELSE product.updated_at END
+		RETURNING id`
+
+	var id int64
+	err := tx.QueryRowContext(ctx, sql, payload.Name, payload.CurrentPrice, payload.OriginalPrice, payload.URL,
+		payload.InStock, now(), payload.Marketplace).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) updateProduct(ctx context.Context, tx *sqlx.Tx, id int64, payload model.ProductPayload) error {
+	sql := `UPDATE product SET name = ?1, current_price = ?2, original_price = ?3, url = ?4, in_stock = ?5,
+		last_checked_at = ?6, marketplace = ?7, check_failures = 0, last_check_error = NULL,
+		updated_at = CASE WHEN current_price <> ?2 OR original_price <> ?3 OR in_stock <> ?5
+			THEN ?6 ELSE updated_at END
+		WHERE id = ?8`
+	_, err := tx.ExecContext(ctx, sql, payload.Name, payload.CurrentPrice, payload.OriginalPrice, payload.URL,
+		payload.InStock, now(), payload.Marketplace, id)
+
+	return err
+}
+
+// RecordCheckFailure marks a refresh attempt of the product as failed
+// without touching its scraped data.
+func (r *repository) RecordCheckFailure(ctx context.Context, id int64, message string) error {
+	sql := `UPDATE product SET last_checked_at = ?, check_failures = check_failures + 1, last_check_error = ?
+		WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, now(), message, id)
+
+	return err
+}
+
+// UpdateProductStatus archives or restores a product.
+func (r *repository) UpdateProductStatus(ctx context.Context, id int64, status int) error {
+	sql := `UPDATE product SET status = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, status, id)
+
+	return err
+}
+
+// UpsertProductSettings stores the user settings of a product. Empty values
+// are stored as NULL.
+func (r *repository) UpsertProductSettings(ctx context.Context, settings model.ProductSettings) error {
+	sql := `INSERT INTO product_settings (product_id, display_name, notes, target_price, refresh_interval_minutes, updated_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0), ?)
+		ON CONFLICT (product_id) DO UPDATE SET display_name = EXCLUDED.display_name, notes = EXCLUDED.notes,
+		target_price = EXCLUDED.target_price, refresh_interval_minutes = EXCLUDED.refresh_interval_minutes,
+		updated_at = EXCLUDED.updated_at`
+	_, err := r.db.ExecContext(ctx, sql, settings.ProductID, settings.DisplayName, settings.Notes, settings.TargetPrice,
+		settings.RefreshIntervalMinutes, now())
+
+	return err
+}
+
+// DeleteProduct removes a product for good. Its history, rollups, images and
+// refresh run items go with it through the foreign keys.
+func (r *repository) DeleteProduct(ctx context.Context, id int64) error {
+	sql := `DELETE FROM product WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
+func (r *repository) InsertPriceHistory(ctx context.Context, productID int64, currentPrice int64, originalPrice int64) error {
+	sql := `INSERT INTO price_history(product_id, current_price, original_price, updated_at)
+		VALUES (?, ?, ?, ?)`
+	_, err := r.db.ExecContext(ctx, sql, productID, currentPrice, originalPrice, now())
+
+	return err
+}
+
+func (r *repository) GetLastPriceHistory(ctx context.Context, productID int64, limit int) ([]model.PriceHistory, error) {
+	sql := `SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM (SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM
+		price_history WHERE product_id = ?
+		ORDER BY updated_at DESC
+		LIMIT ?) p ORDER BY updated_at ASC`
+	var histories []model.PriceHistory
+	err := r.db.SelectContext(ctx, &histories, sql, productID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+// Open opens the database file at path, creating it if needed. SQLite has a
+// single writer, so the pool is limited to one connection and other
+// processes on the same file wait for the lock instead of failing.
+func Open(path string) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("sqlite",
+		"file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
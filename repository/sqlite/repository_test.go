@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/migration"
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/ediprako/pricemonitor/repository/repotest"
+	"github.com/ediprako/pricemonitor/usecase"
+	"github.com/jmoiron/sqlx"
+)
+
+func newRepository(t *testing.T) (*sqlx.DB, usecase.DBProvider) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = migration.New(db, migrations).Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, New(db)
+}
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) usecase.DBProvider {
+		_, repo := newRepository(t)
+		return repo
+	})
+}
+
+func TestRepositoryBackdated(t *testing.T) {
+	repotest.RunBackdated(t, func(t *testing.T) (usecase.DBProvider, repotest.Backdate) {
+		db, repo := newRepository(t)
+		return repo, func(t *testing.T, historyID int64, at time.Time) {
+			_, err := db.Exec(`UPDATE price_history SET updated_at = ? WHERE id = ?`, at.UTC(), historyID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestRollupLateHistory rolls up a row that shows up behind the last id the
+// previous run saw.
+func TestRollupLateHistory(t *testing.T) {
+	ctx := context.Background()
+	db, repo := newRepository(t)
+
+	id, err := repo.UpsertProduct(ctx, model.ProductPayload{Name: "gula", CurrentPrice: 100, OriginalPrice: 100,
+		InStock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range []int64{80, 90} {
+		err = repo.InsertPriceHistory(ctx, id, price, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.Exec(`DELETE FROM price_history WHERE id = 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO price_history (id, product_id, current_price, original_price, in_stock, updated_at)
+		VALUES (2, ?, 80, 100, true, ?)`, id, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.RollupPriceHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	rollups, err := repo.GetPriceRollups(ctx, id, model.RollupDaily, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 || rollups[0].MinPrice != 80 || rollups[0].ClosePrice != 80 || rollups[0].Samples != 3 {
+		t.Errorf("today with a late row = %+v", rollups)
+	}
+}
@@ -0,0 +1,167 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// rollupLateWindow is how long before a rollup run rows that it could not
+// see yet may have been recorded.
+const rollupLateWindow = 24 * time.Hour
+
+// rollupTables maps a resolution to its table and the date expression that
+// truncates a timestamp to its bucket. Weeks start on Monday as in Postgres.
+var rollupTables = map[string]struct {
+	table  string
+	bucket string
+}{
+	model.RollupDaily:  {"price_history_daily", "date(%s)"},
+	model.RollupWeekly: {"price_history_weekly", "date(%s, 'weekday 0', '-6 days')"},
+}
+
+// RollupPriceHistory recomputes the daily and weekly buckets of raw rows
+// recorded since the last run. Rows recorded within rollupLateWindow before
+// the last run are taken again, as one may have committed after it with a
+// lower id than the last one it saw. History only stores changes, so each
+// bucket also counts the price carried in from before it: it is the open
+// price and takes part in the min, max and avg, but not in samples.
+func (r *repository) RollupPriceHistory(ctx context.Context) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var from, to int64
+	var since sql.NullTime
+	sqlState := `SELECT coalesce((SELECT last_history_id FROM price_rollup_state WHERE id = 1), 0),
+		coalesce((SELECT max(id) FROM price_history), 0),
+		(SELECT rolled_up_at FROM price_rollup_state WHERE id = 1)`
+	err = tx.QueryRowxContext(ctx, sqlState).Scan(&from, &to, &since)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	since.Time = since.Time.Add(-rollupLateWindow).UTC()
+
+	for _, resolution := range []string{model.RollupDaily, model.RollupWeekly} {
+		rollup := rollupTables[resolution]
+		bucket := fmt.Sprintf(rollup.bucket, "h.updated_at")
+		sqlRollup := fmt.Sprintf(`INSERT INTO %[1]s (product_id, bucket, open_price, close_price, min_price, max_price,
+			avg_price, original_price, in_stock, samples)
+			WITH touched AS (
+				SELECT DISTINCT h.product_id, %[2]s bucket FROM price_history h WHERE h.id > ?1 AND h.id <= ?2
+					OR h.updated_at >= ?3
+			), sampled AS (
+				SELECT t.product_id, t.bucket, h.id, h.current_price, h.original_price, h.in_stock, h.updated_at,
+					0 carried
+				FROM touched t JOIN price_history h ON h.product_id = t.product_id AND %[2]s = t.bucket
+				UNION ALL
+				SELECT t.product_id, t.bucket, h.id, h.current_price, h.original_price, h.in_stock, h.updated_at,
+					1 carried
+				FROM touched t JOIN price_history h ON h.id = (SELECT p.id FROM price_history p
+					WHERE p.product_id = t.product_id AND date(p.updated_at) < t.bucket
+					ORDER BY p.updated_at DESC, p.id DESC LIMIT 1)
+			)
+			SELECT product_id, bucket,
+				max(CASE WHEN first_rank = 1 THEN current_price END),
+				max(CASE WHEN last_rank = 1 THEN current_price END),
+				min(current_price), max(current_price), cast(round(avg(current_price)) AS integer),
+				max(CASE WHEN last_rank = 1 THEN original_price END),
+				max(CASE WHEN last_rank = 1 THEN in_stock END), sum(1 - carried)
+			FROM (SELECT product_id, bucket, current_price, original_price, in_stock, carried,
+				row_number() OVER (PARTITION BY product_id, bucket ORDER BY updated_at ASC, id ASC) first_rank,
+				row_number() OVER (PARTITION BY product_id, bucket ORDER BY updated_at DESC, id DESC) last_rank
+				FROM sampled) s
+			WHERE true GROUP BY product_id, bucket
+			ON CONFLICT (product_id, bucket) DO UPDATE SET open_price = excluded.open_price,
+				close_price = excluded.close_price, min_price = excluded.min_price, max_price = excluded.max_price,
+				avg_price = excluded.avg_price, original_price = excluded.original_price, in_stock = excluded.in_stock,
+				samples = excluded.samples`,
+			rollup.table, bucket)
+
+		_, err = tx.ExecContext(ctx, sqlRollup, from, to, since)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	sqlState = `INSERT INTO price_rollup_state (id, last_history_id, rolled_up_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_history_id = excluded.last_history_id,
+			rolled_up_at = excluded.rolled_up_at`
+	_, err = tx.ExecContext(ctx, sqlState, to, now())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PrunePriceHistory deletes raw history recorded before the start of the week
+// containing olderThan, except the last row of each product before it: that
+// price is still in effect afterwards. Callers roll the rows up first.
+func (r *repository) PrunePriceHistory(ctx context.Context, olderThan time.Time) (int64, error) {
+	cutoff := fmt.Sprintf(rollupTables[model.RollupWeekly].bucket, "?1")
+	sql := `DELETE FROM price_history WHERE updated_at < ` + cutoff + ` AND id NOT IN (
+		SELECT id FROM (SELECT id, row_number() OVER (PARTITION BY product_id ORDER BY updated_at DESC, id DESC) n
+			FROM price_history WHERE updated_at < ` + cutoff + `) p WHERE n = 1)`
+	res, err := r.db.ExecContext(ctx, sql, olderThan.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetPriceHistoryBefore returns the last raw row recorded before before, the
+// price in effect at that time, or sql.ErrNoRows when there is none.
+func (r *repository) GetPriceHistoryBefore(ctx context.Context, productID int64, before time.Time) (model.PriceHistory, error) {
+	sql := `SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM price_history
+		WHERE product_id = ? AND updated_at < ? ORDER BY updated_at DESC, id DESC LIMIT 1`
+
+	var history model.PriceHistory
+	err := r.db.GetContext(ctx, &history, sql, productID, before.UTC())
+	if err != nil {
+		return model.PriceHistory{}, err
+	}
+
+	return history, nil
+}
+
+func (r *repository) GetPriceHistoryBetween(ctx context.Context, productID int64, from, to time.Time) ([]model.PriceHistory, error) {
+	sql := `SELECT id, product_id, current_price, original_price, in_stock, updated_at FROM price_history
+		WHERE product_id = ? AND updated_at BETWEEN ? AND ? ORDER BY updated_at ASC`
+
+	var histories []model.PriceHistory
+	err := r.db.SelectContext(ctx, &histories, sql, productID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+func (r *repository) GetPriceRollups(ctx context.Context, productID int64, resolution string, from, to time.Time) ([]model.PriceRollup, error) {
+	rollup, ok := rollupTables[resolution]
+	if !ok {
+		return nil, fmt.Errorf("unknown rollup resolution %q", resolution)
+	}
+
+	sql := fmt.Sprintf(`SELECT product_id, bucket, open_price, close_price, min_price, max_price, avg_price,
+		original_price, in_stock, samples FROM %s WHERE product_id = ?
+		AND bucket >= %s AND bucket <= date(?)
+		ORDER BY bucket ASC`, rollup.table, fmt.Sprintf(rollup.bucket, "?"))
+
+	var rollups []model.PriceRollup
+	err := r.db.SelectContext(ctx, &rollups, sql, productID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return rollups, nil
+}
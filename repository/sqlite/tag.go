@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// productTags returns the tag names of each product, sorted by name.
+func (r *repository) productTags(ctx context.Context, productIDs []int64) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+	if len(productIDs) == 0 {
+		return tags, nil
+	}
+
+	sql, args, err := sqlx.In(`SELECT pt.product_id, t.name FROM product_tag pt JOIN tag t ON t.id = pt.tag_id
+		WHERE pt.product_id IN (?) ORDER BY t.name`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryxContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID int64
+		var name string
+		err = rows.Scan(&productID, &name)
+		if err != nil {
+			return nil, err
+		}
+		tags[productID] = append(tags[productID], name)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTags returns the tags in use with the number of products having each.
+func (r *repository) GetTags(ctx context.Context) ([]model.Tag, error) {
+	sql := `SELECT t.id, t.name, count(*) products FROM
+		tag t JOIN product_tag pt ON pt.tag_id = t.id GROUP BY t.id, t.name ORDER BY t.name`
+
+	var tags []model.Tag
+	err := r.db.SelectContext(ctx, &tags, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// AddProductTags tags the products with every name, creating missing tags.
+// Unknown products are ignored.
+func (r *repository) AddProductTags(ctx context.Context, productIDs []int64, names []string) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, name := range names {
+		var tagID int64
+		sqlTag := `INSERT INTO tag (name) VALUES (?) ON CONFLICT (name) DO UPDATE SET name = excluded.name RETURNING id`
+		err = tx.QueryRowContext(ctx, sqlTag, name).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+
+		var sqlProductTag string
+		var args []interface{}
+		sqlProductTag, args, err = sqlx.In(`INSERT INTO product_tag (product_id, tag_id)
+			SELECT id, ? FROM product WHERE id IN (?) ON CONFLICT DO NOTHING`, tagID, productIDs)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, sqlProductTag, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// RemoveProductTags removes the named tags from the products. Tags no
+// product has any more are deleted.
+func (r *repository) RemoveProductTags(ctx context.Context, productIDs []int64, names []string) error {
+	if len(productIDs) == 0 || len(names) == 0 {
+		return nil
+	}
+
+	sql, args, err := sqlx.In(`DELETE FROM product_tag WHERE product_id IN (?)
+		AND tag_id IN (SELECT id FROM tag WHERE name IN (?))`, productIDs, names)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	sqlUnused := `DELETE FROM tag AS t WHERE NOT EXISTS (SELECT 1 FROM product_tag pt WHERE pt.tag_id = t.id)`
+	_, err = tx.ExecContext(ctx, sqlUnused)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const webhookColumns = `w.id, w.url, w.secret, w.events, w.enabled, w.created_at`
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, w.url, d.event, d.payload, d.status, d.attempts,
+	d.status_code, coalesce(d.last_error, '') last_error, d.delivered_at, d.created_at, d.updated_at`
+
+func (r *repository) CreateWebhook(ctx context.Context, webhook model.Webhook) (int64, error) {
+	sql := `INSERT INTO webhook (url, secret, events, enabled, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, webhook.URL, webhook.Secret, webhook.Events, webhook.Enabled,
+		now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	sql := `SELECT ` + webhookColumns + ` FROM webhook w ORDER BY w.id`
+
+	var webhooks []model.Webhook
+	err := r.db.SelectContext(ctx, &webhooks, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *repository) GetWebhookByID(ctx context.Context, id int64) (model.Webhook, error) {
+	sql := `SELECT ` + webhookColumns + ` FROM webhook w WHERE w.id = ?`
+
+	var webhook model.Webhook
+	err := r.db.GetContext(ctx, &webhook, sql, id)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook with its delivery history.
+func (r *repository) DeleteWebhook(ctx context.Context, id int64) error {
+	sql := `DELETE FROM webhook WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, sql, id)
+
+	return err
+}
+
+func (r *repository) InsertWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (int64, error) {
+	sql := `INSERT INTO webhook_delivery (webhook_id, event, payload, status, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?5) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, sql, delivery.WebhookID, delivery.Event, delivery.Payload,
+		delivery.Status, now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repository) GetWebhookDeliveryByID(ctx context.Context, id int64) (model.WebhookDelivery, error) {
+	sql := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_delivery d JOIN webhook w ON w.id = d.webhook_id
+		WHERE d.id = ?`
+
+	var delivery model.WebhookDelivery
+	err := r.db.GetContext(ctx, &delivery, sql, id)
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+// RecordWebhookDeliveryAttempt counts a delivery attempt and stores its
+// outcome. statusCode is zero when no response arrived.
+func (r *repository) RecordWebhookDeliveryAttempt(ctx context.Context, id int64, status string, statusCode int, errMessage string) error {
+	sql := `UPDATE webhook_delivery SET status = ?1, attempts = attempts + 1, status_code = ?2,
+		last_error = NULLIF(?3, ''), delivered_at = CASE WHEN ?1 = ?4 THEN ?5 ELSE delivered_at END,
+		updated_at = ?5 WHERE id = ?6`
+	_, err := r.db.ExecContext(ctx, sql, status, statusCode, errMessage, model.DeliveryStatusSent, now(), id)
+
+	return err
+}
+
+// GetWebhookDeliveries returns deliveries newest first, only those of
+// webhookID unless it is zero.
+func (r *repository) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]model.WebhookDelivery, error) {
+	sql := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_delivery d JOIN webhook w ON w.id = d.webhook_id
+		WHERE ?1 = 0 OR d.webhook_id = ?1
+		ORDER BY d.created_at DESC, d.id DESC LIMIT ?2 OFFSET ?3`
+
+	var deliveries []model.WebhookDelivery
+	err := r.db.SelectContext(ctx, &deliveries, sql, webhookID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) GetTotalWebhookDelivery(ctx context.Context, webhookID int64) (int64, error) {
+	sql := `SELECT count(*) FROM webhook_delivery WHERE ?1 = 0 OR webhook_id = ?1`
+
+	var total int64
+	err := r.db.GetContext(ctx, &total, sql, webhookID)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
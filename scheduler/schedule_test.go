@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		msg  string
+	}{
+		{"* * * *", "expected 5 fields, got 4"},
+		{"@often", "expected 5 fields, got 1"},
+		{"60 * * * *", "value out of range 0-59 in minute field"},
+		{"* 24 * * *", "value out of range 0-23 in hour field"},
+		{"* * 0 * *", "value out of range 1-31 in day of month field"},
+		{"* * * 13 *", "value out of range 1-12 in month field"},
+		{"* * * * 8", "value out of range 0-7 in day of week field"},
+		{"5-1 * * * *", "value out of range"},
+		{"*/0 * * * *", `invalid step "0" in minute field`},
+		{"a * * * *", `invalid value "a" in minute field`},
+		{"1-x * * * *", `invalid value "x" in minute field`},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("Parse(%q) = %v, want %q", tt.spec, err, tt.msg)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"*/15 * * * *", "2021-09-01 10:07", "2021-09-01 10:15"},
+		{"*/15 * * * *", "2021-09-01 10:15", "2021-09-01 10:30"},
+		{"0 9-17/4 * * *", "2021-09-01 10:00", "2021-09-01 13:00"},
+		{"5 10-11 * * *", "2021-09-01 11:05", "2021-09-02 10:05"},
+		{"30 8 1,15 * *", "2021-01-15 09:00", "2021-02-01 08:30"},
+		{"0 0 31 * *", "2021-04-01 00:00", "2021-05-31 00:00"},
+		{"@hourly", "2021-09-01 23:30", "2021-09-02 00:00"},
+		{"@yearly", "2021-12-31 23:59", "2022-01-01 00:00"},
+		{"@monthly", "2021-12-15 12:00", "2022-01-01 00:00"},
+		{"0 0 29 2 *", "2021-03-01 00:00", "2024-02-29 00:00"},
+		// Sunday may be written as 7.
+		{"0 12 * * 7", "2021-09-01 00:00", "2021-09-05 12:00"},
+		{"0 0 * * 1-5", "2021-09-03 12:00", "2021-09-06 00:00"},
+		// Both day fields restricted: either one matches, so the 13th
+		// or any Friday.
+		{"0 0 13 * 5", "2021-09-01 00:00", "2021-09-03 00:00"},
+		{"0 0 13 * 5", "2021-09-10 00:00", "2021-09-13 00:00"},
+		// A day field starting with "*" is unrestricted, so both must
+		// match: an odd day that is a Monday.
+		{"0 0 */2 * 1", "2021-09-01 00:00", "2021-09-13 00:00"},
+		{"0 0 1 * */2", "2021-09-02 00:00", "2022-01-01 00:00"},
+	}
+
+	for _, tt := range tests {
+		schedule, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) = %v", tt.spec, err)
+			continue
+		}
+		got := schedule.Next(at(tt.from))
+		if !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	schedule, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := schedule.Next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("Next() = %s, want never", next)
+	}
+}
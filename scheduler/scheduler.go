@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cancelGrace is how long jobs still running after the drain timeout get to
+// return once cancelled.
+const cancelGrace = 10 * time.Second
+
+// Job is a unit of scheduled work. The context is cancelled when the
+// scheduler gives up waiting for running jobs during shutdown.
+type Job func(ctx context.Context) error
+
+type entry struct {
+	name     string
+	schedule Schedule
+	job      Job
+	running  int32
+}
+
+type Scheduler struct {
+	entries      []*entry
+	drainTimeout time.Duration
+	inFlight     sync.WaitGroup
+}
+
+// New creates a scheduler that, once stopped, waits up to drainTimeout for
+// running jobs to finish before cancelling them.
+func New(drainTimeout time.Duration) *Scheduler {
+	return &Scheduler{
+		drainTimeout: drainTimeout,
+	}
+}
+
+// Add registers job to run on the given cron expression. It must be called
+// before Run.
+func (s *Scheduler) Add(name, spec string, job Job) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", name, err)
+	}
+
+	s.entries = append(s.entries, &entry{
+		name:     name,
+		schedule: schedule,
+		job:      job,
+	})
+	return nil
+}
+
+// Run starts every registered job on its schedule and blocks until ctx is
+// done. It then stops starting new runs and drains the ones in flight.
+func (s *Scheduler) Run(ctx context.Context) error {
+	jobCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var loops sync.WaitGroup
+	for _, e := range s.entries {
+		loops.Add(1)
+		go func(e *entry) {
+			defer loops.Done()
+			s.loop(ctx, jobCtx, e)
+		}(e)
+	}
+	loops.Wait()
+
+	log.Println("scheduler stopping, waiting for running jobs")
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("scheduler stopped")
+		return nil
+	case <-time.After(s.drainTimeout):
+		cancel()
+	}
+
+	// Cancelled jobs get a short while to return; one that ignores its
+	// context must not hold the shutdown forever.
+	select {
+	case <-done:
+		return fmt.Errorf("scheduler: running jobs cancelled after %s drain timeout", s.drainTimeout)
+	case <-time.After(cancelGrace):
+		return fmt.Errorf("scheduler: running jobs abandoned %s after cancelling them", cancelGrace)
+	}
+}
+
+func (s *Scheduler) loop(ctx, jobCtx context.Context, e *entry) {
+	for {
+		next := e.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("job %s: schedule never fires", e.name)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.dispatch(jobCtx, e)
+		}
+	}
+}
+
+// dispatch starts e unless its previous run is still going.
+func (s *Scheduler) dispatch(ctx context.Context, e *entry) {
+	if !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+		log.Printf("job %s: previous run still in progress, skipping", e.name)
+		return
+	}
+
+	s.inFlight.Add(1)
+	go func() {
+		defer s.inFlight.Done()
+		defer atomic.StoreInt32(&e.running, 0)
+
+		err := e.job(ctx)
+		if err != nil {
+			log.Printf("job %s: %v", e.name, err)
+		}
+	}()
+}
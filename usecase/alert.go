@@ -0,0 +1,853 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	// Quiet hours are read in time zones that hosts without a zoneinfo
+	// database would not know.
+	_ "time/tzdata"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ediprako/pricemonitor/condition"
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const (
+	defaultAlertWindowHours = 24
+	maxAlertWindowHours     = 24 * 365
+	maxAlertCooldownMinutes = 30 * 24 * 60
+	maxAlertSnoozeMinutes   = 30 * 24 * 60
+	maxAlertFailedChecks    = 1000
+	quietHoursLayout        = "15:04"
+)
+
+type AlertRule struct {
+	ID          int64    `json:"id"`
+	ProductID   int64    `json:"product_id,omitempty"`
+	Tag         string   `json:"tag,omitempty"`
+	Kind        string   `json:"kind"`
+	Threshold   int64    `json:"threshold"`
+	WindowHours int      `json:"window_hours,omitempty"`
+	Expression  string   `json:"expression,omitempty"`
+	Channels    []string `json:"channels,omitempty"`
+	AlertControls
+	SnoozedUntil string `json:"snoozed_until,omitempty"`
+	Enabled      bool   `json:"enabled"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// AlertControls keep a rule from flooding its channels. A rule fires again
+// for a product only CooldownMinutes after it last did and, with
+// Hysteresis, only once the product stopped satisfying it in between.
+// Events fired between QuietStart and QuietEnd ("22:00" and "07:00", read in
+// Timezone, UTC by default) are delivered when the quiet hours end.
+type AlertControls struct {
+	CooldownMinutes int    `json:"cooldown_minutes,omitempty"`
+	Hysteresis      bool   `json:"hysteresis,omitempty"`
+	QuietStart      string `json:"quiet_start,omitempty"`
+	QuietEnd        string `json:"quiet_end,omitempty"`
+	Timezone        string `json:"timezone,omitempty"`
+}
+
+// AlertRulePayload is a new or edited alert rule. It watches either
+// ProductID or every product tagged with Tag. Threshold is a price for
+// below_target, where zero means the product's own target price, a
+// percentage for percent_drop and discount_above, and the failed checks in a
+// row after which a listing counts as removed for listing_returned (1 when
+// zero). Expression is the condition of an expression rule, such as
+// "price < 90% * avg(price, 30d)". Its events are delivered over the
+// notifier Channels given, or over every notifier when there are none. A
+// rule is enabled unless Enabled is false.
+type AlertRulePayload struct {
+	ProductID   int64    `json:"product_id"`
+	Tag         string   `json:"tag"`
+	Kind        string   `json:"kind"`
+	Threshold   int64    `json:"threshold"`
+	WindowHours int      `json:"window_hours"`
+	Expression  string   `json:"expression"`
+	Channels    []string `json:"channels"`
+	Enabled     *bool    `json:"enabled"`
+	AlertControls
+}
+
+// AlertTestResult is how sending a test alert over one channel went.
+type AlertTestResult struct {
+	Channel string `json:"channel"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+type AlertEvent struct {
+	ID                  int64  `json:"id"`
+	RuleID              int64  `json:"rule_id"`
+	ProductID           int64  `json:"product_id"`
+	ProductName         string `json:"product_name"`
+	Kind                string `json:"kind"`
+	Price               int64  `json:"price"`
+	PriceString         string `json:"price_string"`
+	PreviousPrice       int64  `json:"previous_price"`
+	PreviousPriceString string `json:"previous_price_string"`
+	Message             string `json:"message"`
+	CreatedAt           string `json:"created_at"`
+	AcknowledgedAt      string `json:"acknowledged_at,omitempty"`
+}
+
+type PaginateAlertEvent struct {
+	Draw            string       `json:"draw"`
+	RecordsTotal    int64        `json:"recordsTotal"`
+	RecordsFiltered int64        `json:"recordsFiltered"`
+	Events          []AlertEvent `json:"data"`
+}
+
+func convertAlertRule(rule model.AlertRule) AlertRule {
+	result := AlertRule{
+		ID:          rule.ID,
+		ProductID:   rule.ProductID,
+		Tag:         rule.Tag,
+		Kind:        rule.Kind,
+		Threshold:   rule.Threshold,
+		WindowHours: rule.WindowHours,
+		Expression:  rule.Expression,
+		AlertControls: AlertControls{
+			CooldownMinutes: rule.CooldownMinutes,
+			Hysteresis:      rule.Hysteresis,
+			QuietStart:      rule.QuietStart,
+			QuietEnd:        rule.QuietEnd,
+			Timezone:        rule.Timezone,
+		},
+		Enabled:   rule.Enabled,
+		CreatedAt: rule.CreatedAt.Format(runTimeFormat),
+	}
+	if rule.Channels != "" {
+		result.Channels = strings.Split(rule.Channels, ",")
+	}
+	if rule.SnoozedUntil.Valid && rule.SnoozedUntil.Time.After(time.Now()) {
+		result.SnoozedUntil = rule.SnoozedUntil.Time.Format(runTimeFormat)
+	}
+
+	return result
+}
+
+func (u *usecase) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	rules, err := u.db.GetAlertRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]AlertRule, len(rules))
+	for i, rule := range rules {
+		result[i] = convertAlertRule(rule)
+	}
+
+	return result, nil
+}
+
+func (u *usecase) CreateAlertRule(ctx context.Context, payload AlertRulePayload) (AlertRule, error) {
+	rule, err := u.validateAlertRule(ctx, payload)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	id, err := u.db.CreateAlertRule(ctx, rule)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	rule, err = u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	return convertAlertRule(rule), nil
+}
+
+// UpdateAlertRule replaces the settings of a rule. It keeps its snooze but
+// starts out armed for every product, as if it never fired.
+func (u *usecase) UpdateAlertRule(ctx context.Context, id int64, payload AlertRulePayload) (AlertRule, error) {
+	_, err := u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	rule, err := u.validateAlertRule(ctx, payload)
+	if err != nil {
+		return AlertRule{}, err
+	}
+	rule.ID = id
+
+	err = u.db.UpdateAlertRule(ctx, rule)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	rule, err = u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	return convertAlertRule(rule), nil
+}
+
+// TestAlertRule sends a made-up alert of a rule over its channels right away
+// and reports how each went. Nothing is stored, so test alerts do not count
+// towards the rule's controls or show up in the delivery log. Tag rules are
+// tested on the first product with the tag.
+func (u *usecase) TestAlertRule(ctx context.Context, id int64) ([]AlertTestResult, error) {
+	rule, err := u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := u.channels()
+	if rule.Channels != "" {
+		channels = strings.Split(rule.Channels, ",")
+	}
+	if len(channels) == 0 {
+		return nil, ValidationError{"channels", "no notifier is configured"}
+	}
+
+	var product model.Product
+	if rule.ProductID != 0 {
+		product, err = u.db.GetProductsByID(ctx, rule.ProductID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		products, err := u.db.GetProducts(ctx, model.ProductFilter{Status: model.ProductStatusActive, Tag: rule.Tag}, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(products) == 0 {
+			return nil, ValidationError{"tag", fmt.Sprintf("no product is tagged %s to test the rule on", rule.Tag)}
+		}
+		product = products[0]
+	}
+
+	notification := productNotification(product)
+	notification.Kind = rule.Kind
+	notification.Message = fmt.Sprintf("Test of alert rule #%d (%s) on %s", rule.ID, rule.Kind, notification.ProductName)
+	notification.Price, notification.PreviousPrice = product.CurrentPrice, product.CurrentPrice
+	notification.CreatedAt = time.Now()
+
+	results := make([]AlertTestResult, len(channels))
+	for i, channel := range channels {
+		results[i] = AlertTestResult{Channel: channel, Status: model.DeliveryStatusSent}
+		notifier, ok := u.notifiers[channel]
+		if !ok {
+			results[i].Status, results[i].Error = model.DeliveryStatusFailed, fmt.Sprintf("no %s notifier is configured", channel)
+			continue
+		}
+		err = notifier.Notify(ctx, notification)
+		if err != nil {
+			results[i].Status, results[i].Error = model.DeliveryStatusFailed, err.Error()
+		}
+	}
+
+	return results, nil
+}
+
+// validateAlertRule checks a new rule and fills in its defaults.
+func (u *usecase) validateAlertRule(ctx context.Context, payload AlertRulePayload) (model.AlertRule, error) {
+	rule := model.AlertRule{
+		ProductID: payload.ProductID,
+		Tag:       normalizeTag(payload.Tag),
+		Kind:      payload.Kind,
+		Threshold: payload.Threshold,
+		Enabled:   payload.Enabled == nil || *payload.Enabled,
+	}
+
+	switch {
+	case (rule.ProductID == 0) == (rule.Tag == ""):
+		return model.AlertRule{}, ValidationError{"product_id", "set either a product or a tag"}
+	case utf8.RuneCountInString(rule.Tag) > maxTagLength:
+		return model.AlertRule{}, ValidationError{"tag", fmt.Sprintf("must be at most %d characters", maxTagLength)}
+	}
+	if rule.ProductID != 0 {
+		_, err := u.db.GetProductsByID(ctx, rule.ProductID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.AlertRule{}, ValidationError{"product_id", fmt.Sprintf("product %d does not exist", rule.ProductID)}
+		}
+		if err != nil {
+			return model.AlertRule{}, err
+		}
+	}
+
+	switch rule.Kind {
+	case model.AlertKindBelowTarget:
+		if rule.Threshold < 0 {
+			return model.AlertRule{}, ValidationError{"threshold", "must not be negative"}
+		}
+	case model.AlertKindPercentDrop:
+		if rule.Threshold < 1 || rule.Threshold > 99 {
+			return model.AlertRule{}, ValidationError{"threshold", "must be a percentage between 1 and 99"}
+		}
+		rule.WindowHours = payload.WindowHours
+		if rule.WindowHours == 0 {
+			rule.WindowHours = defaultAlertWindowHours
+		}
+		if rule.WindowHours < 1 || rule.WindowHours > maxAlertWindowHours {
+			return model.AlertRule{}, ValidationError{"window_hours", fmt.Sprintf("must be between 1 and %d", maxAlertWindowHours)}
+		}
+	case model.AlertKindAllTimeLow:
+		rule.Threshold = 0
+	case model.AlertKindDiscountAbove:
+		if rule.Threshold < 1 || rule.Threshold > 99 {
+			return model.AlertRule{}, ValidationError{"threshold", "must be a percentage between 1 and 99"}
+		}
+	case model.AlertKindBackInStock:
+		rule.Threshold = 0
+	case model.AlertKindListingReturned:
+		if rule.Threshold == 0 {
+			rule.Threshold = 1
+		}
+		if rule.Threshold < 1 || rule.Threshold > maxAlertFailedChecks {
+			return model.AlertRule{}, ValidationError{"threshold", fmt.Sprintf("must be a number of failed checks between 1 and %d", maxAlertFailedChecks)}
+		}
+	case model.AlertKindExpression:
+		rule.Threshold = 0
+		rule.Expression = strings.TrimSpace(payload.Expression)
+		_, err := condition.Parse(rule.Expression)
+		if err != nil {
+			return model.AlertRule{}, ValidationError{"expression", err.Error()}
+		}
+	default:
+		kinds := []string{model.AlertKindBelowTarget, model.AlertKindPercentDrop, model.AlertKindAllTimeLow,
+			model.AlertKindDiscountAbove, model.AlertKindBackInStock, model.AlertKindListingReturned,
+			model.AlertKindExpression}
+		return model.AlertRule{}, ValidationError{"kind", "must be one of " + strings.Join(kinds, ", ")}
+	}
+
+	channels, err := u.selectChannels(payload.Channels)
+	if err != nil {
+		return model.AlertRule{}, err
+	}
+	rule.Channels = channels
+
+	err = validateAlertControls(&rule, payload.AlertControls)
+	if err != nil {
+		return model.AlertRule{}, err
+	}
+
+	return rule, nil
+}
+
+// validateAlertControls checks the controls of a rule and sets them on it.
+func validateAlertControls(rule *model.AlertRule, controls AlertControls) error {
+	if controls.CooldownMinutes < 0 || controls.CooldownMinutes > maxAlertCooldownMinutes {
+		return ValidationError{"cooldown_minutes", fmt.Sprintf("must be between 0 and %d", maxAlertCooldownMinutes)}
+	}
+	rule.CooldownMinutes = controls.CooldownMinutes
+	rule.Hysteresis = controls.Hysteresis
+
+	if controls.QuietStart == "" && controls.QuietEnd == "" {
+		return nil
+	}
+	for _, field := range []struct{ name, value string }{
+		{"quiet_start", controls.QuietStart},
+		{"quiet_end", controls.QuietEnd},
+	} {
+		_, err := time.Parse(quietHoursLayout, field.value)
+		if err != nil {
+			return ValidationError{field.name, "must be a time of day such as 22:00"}
+		}
+	}
+	if controls.QuietStart == controls.QuietEnd {
+		return ValidationError{"quiet_end", "must differ from quiet_start"}
+	}
+	timezone := controls.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		return ValidationError{"timezone", fmt.Sprintf("unknown time zone %q, use a name such as Asia/Jakarta", timezone)}
+	}
+	rule.QuietStart, rule.QuietEnd, rule.Timezone = controls.QuietStart, controls.QuietEnd, timezone
+
+	return nil
+}
+
+// SnoozeAlertRule keeps a rule from firing for the minutes given; zero
+// minutes lets it fire again.
+func (u *usecase) SnoozeAlertRule(ctx context.Context, id int64, minutes int) (AlertRule, error) {
+	if minutes < 0 || minutes > maxAlertSnoozeMinutes {
+		return AlertRule{}, ValidationError{"minutes", fmt.Sprintf("must be between 0 and %d", maxAlertSnoozeMinutes)}
+	}
+	_, err := u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	var until sql.NullTime
+	if minutes > 0 {
+		until = sql.NullTime{Time: time.Now().Add(time.Duration(minutes) * time.Minute), Valid: true}
+	}
+	err = u.db.SnoozeAlertRule(ctx, id, until)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	rule, err := u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	return convertAlertRule(rule), nil
+}
+
+// AcknowledgeAlertEvent marks an event as seen and keeps its rule from
+// firing again for the product until the product stops satisfying it.
+func (u *usecase) AcknowledgeAlertEvent(ctx context.Context, id int64) error {
+	event, err := u.db.GetAlertEventByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = u.db.AcknowledgeAlertEvent(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	state, err := u.db.GetAlertState(ctx, event.RuleID, event.ProductID)
+	if errors.Is(err, sql.ErrNoRows) {
+		state = model.AlertState{RuleID: event.RuleID, ProductID: event.ProductID,
+			LastFiredAt: sql.NullTime{Time: event.CreatedAt, Valid: true}}
+	} else if err != nil {
+		return err
+	}
+	state.Armed = false
+
+	return u.db.SaveAlertState(ctx, state)
+}
+
+func (u *usecase) DeleteAlertRule(ctx context.Context, id int64) error {
+	_, err := u.db.GetAlertRuleByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.DeleteAlertRule(ctx, id)
+}
+
+// ListAlertEvents lists fired alerts newest first, those of one product
+// unless productID is zero.
+func (u *usecase) ListAlertEvents(ctx context.Context, draw string, productID int64, offset, limit int) (PaginateAlertEvent, error) {
+	if limit == 0 {
+		limit = 10
+	}
+
+	events, err := u.db.GetAlertEvents(ctx, productID, limit, offset)
+	if err != nil {
+		return PaginateAlertEvent{}, err
+	}
+
+	total, err := u.db.GetTotalAlertEvent(ctx, productID)
+	if err != nil {
+		return PaginateAlertEvent{}, err
+	}
+
+	result := make([]AlertEvent, len(events))
+	for i, event := range events {
+		result[i] = AlertEvent{
+			ID:                  event.ID,
+			RuleID:              event.RuleID,
+			ProductID:           event.ProductID,
+			ProductName:         event.ProductName,
+			Kind:                event.Kind,
+			Price:               event.Price,
+			PriceString:         "Rp. " + humanize.Comma(event.Price),
+			PreviousPrice:       event.PreviousPrice,
+			PreviousPriceString: "Rp. " + humanize.Comma(event.PreviousPrice),
+			Message:             event.Message,
+			CreatedAt:           event.CreatedAt.Format(runTimeFormat),
+		}
+		if event.AcknowledgedAt.Valid {
+			result[i].AcknowledgedAt = event.AcknowledgedAt.Time.Format(runTimeFormat)
+		}
+	}
+
+	return PaginateAlertEvent{
+		Draw:            draw,
+		RecordsTotal:    total,
+		RecordsFiltered: total,
+		Events:          result,
+	}, nil
+}
+
+// priceRecord is what alert rules compare a new price against: the prices a
+// product had before it was refreshed.
+type priceRecord struct {
+	history []model.PriceHistory
+	// lowest is the lowest price ever stored, including days whose raw
+	// history was pruned after being rolled up.
+	lowest int64
+}
+
+// aggregate returns the average, minimum or maximum of a history field
+// ("price" or "original_price") over the window before at, ending with the
+// refreshed value. The average weighs each value by how long it was in
+// effect, counting the one in effect when the window opened; with no
+// earlier value in the window it is the refreshed value. Zero prices, from
+// checks out of stock, are left out.
+func (record priceRecord) aggregate(fn, field string, window time.Duration, refreshed int64, at time.Time) float64 {
+	from := at.Add(-window)
+	lowest, highest := refreshed, refreshed
+	var weighted, total float64
+	for i, h := range record.history {
+		end := at
+		if i+1 < len(record.history) {
+			end = record.history[i+1].UpdateTime
+		}
+		value := h.CurrentPrice
+		if field == "original_price" {
+			value = h.OriginalPrice
+		}
+		if value <= 0 || !end.After(from) {
+			continue
+		}
+
+		if lowest <= 0 || value < lowest {
+			lowest = value
+		}
+		if value > highest {
+			highest = value
+		}
+		start := h.UpdateTime
+		if start.Before(from) {
+			start = from
+		}
+		weighted += float64(value) * end.Sub(start).Seconds()
+		total += end.Sub(start).Seconds()
+	}
+
+	switch fn {
+	case "min":
+		return float64(lowest)
+	case "max":
+		return float64(highest)
+	}
+	if total == 0 {
+		return float64(refreshed)
+	}
+	return weighted / total
+}
+
+// conditionEnv is what the expression of a rule reads: the refreshed
+// product, how it was before the refresh, and its price record.
+func conditionEnv(product model.Product, payload ProductPayload, record priceRecord, at time.Time) condition.Env {
+	return condition.Env{
+		Numbers: map[string]float64{
+			"price":                   float64(payload.CurrentPrice),
+			"original_price":          float64(payload.OriginalPrice),
+			"previous_price":          float64(product.CurrentPrice),
+			"previous_original_price": float64(product.OriginalPrice),
+			"target_price":            float64(product.TargetPrice),
+			"discount":                float64(model.Discount(payload.CurrentPrice, payload.OriginalPrice)),
+			"check_failures":          float64(product.CheckFailures),
+		},
+		Bools: map[string]bool{
+			"in_stock":     payload.InStock,
+			"was_in_stock": product.InStock,
+		},
+		Aggregate: func(fn, field string, window time.Duration) float64 {
+			refreshed := payload.CurrentPrice
+			if field == "original_price" {
+				refreshed = payload.OriginalPrice
+			}
+			return record.aggregate(fn, field, window, refreshed, at)
+		},
+	}
+}
+
+// loadAlerts returns the rules watching a product and, when there are any,
+// its price record. Alerts must never fail a refresh, so errors are only
+// logged.
+func (u *usecase) loadAlerts(ctx context.Context, product model.Product, at time.Time) ([]model.AlertRule, priceRecord) {
+	rules, err := u.db.GetAlertRulesForProduct(ctx, product.ID)
+	if err != nil {
+		log.Println(err)
+		return nil, priceRecord{}
+	}
+	if len(rules) == 0 {
+		return nil, priceRecord{}
+	}
+
+	history, err := u.db.GetPriceHistoryBetween(ctx, product.ID, time.Time{}, at)
+	if err != nil {
+		log.Println(err)
+		return nil, priceRecord{}
+	}
+	rollups, err := u.db.GetPriceRollups(ctx, product.ID, model.RollupDaily, time.Time{}, at)
+	if err != nil {
+		log.Println(err)
+		return nil, priceRecord{}
+	}
+
+	record := priceRecord{history: history, lowest: product.CurrentPrice}
+	for _, h := range history {
+		if h.CurrentPrice > 0 && h.CurrentPrice < record.lowest {
+			record.lowest = h.CurrentPrice
+		}
+	}
+	for _, rollup := range rollups {
+		if rollup.MinPrice > 0 && rollup.MinPrice < record.lowest {
+			record.lowest = rollup.MinPrice
+		}
+	}
+
+	return rules, record
+}
+
+// evaluateAlerts stores an event for every rule the refreshed product
+// satisfies and queues its deliveries and webhooks. Products out of stock
+// never alert on their price, only on availability and expression rules.
+// When steadySince is set, prices have not changed since that check, and an
+// expression rule that already held then does not fire again.
+func (u *usecase) evaluateAlerts(ctx context.Context, product model.Product, payload ProductPayload,
+	rules []model.AlertRule, record priceRecord, at, steadySince time.Time) {
+	priced := payload.InStock && payload.CurrentPrice > 0
+
+	for _, rule := range rules {
+		if !priced && !isAvailabilityAlert(rule.Kind) && rule.Kind != model.AlertKindExpression {
+			continue
+		}
+		message, ok := checkAlertRule(rule, product, payload, record, at)
+		if ok && rule.Kind == model.AlertKindExpression && !steadySince.IsZero() {
+			// Window aggregates move as time passes, so a rule can start
+			// to hold on a steady price; it is only news when it does.
+			if _, held := checkAlertRule(rule, product, payload, record, steadySince); held {
+				continue
+			}
+		}
+		if !u.shouldFire(ctx, rule, product.ID, ok, at) {
+			continue
+		}
+
+		id, err := u.db.InsertAlertEvent(ctx, model.AlertEvent{
+			RuleID:        rule.ID,
+			ProductID:     product.ID,
+			Kind:          rule.Kind,
+			Price:         payload.CurrentPrice,
+			PreviousPrice: product.CurrentPrice,
+			Message:       message,
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		u.queueAlertDeliveries(ctx, id, rule)
+
+		body := refreshedWebhookBody(model.WebhookEventAlertTriggered, product, payload)
+		body.Alert = &webhookAlert{EventID: id, RuleID: rule.ID, Kind: rule.Kind, Message: message}
+		u.queueWebhooks(ctx, body)
+	}
+}
+
+// steadyAlerts returns the rules to check when a product's prices did not
+// change: expression rules, whose window aggregates move with time, and,
+// when the product is listed again after failed checks, the rules that
+// watch whether it can be bought.
+func steadyAlerts(rules []model.AlertRule, recovered bool) []model.AlertRule {
+	var result []model.AlertRule
+	for _, rule := range rules {
+		if rule.Kind == model.AlertKindExpression || recovered && isAvailabilityAlert(rule.Kind) {
+			result = append(result, rule)
+		}
+	}
+
+	return result
+}
+
+// isAvailabilityAlert reports whether rules of the kind watch whether a
+// product can be bought rather than its price.
+func isAvailabilityAlert(kind string) bool {
+	return kind == model.AlertKindBackInStock || kind == model.AlertKindListingReturned
+}
+
+// shouldFire applies the controls of a rule to a product that satisfies it
+// or not, and records when it fires. A product that stops satisfying a
+// disarmed rule rearms it.
+func (u *usecase) shouldFire(ctx context.Context, rule model.AlertRule, productID int64, satisfied bool,
+	at time.Time) bool {
+	state, err := u.db.GetAlertState(ctx, rule.ID, productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		state, err = model.AlertState{RuleID: rule.ID, ProductID: productID, Armed: true}, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if !satisfied {
+		if !state.Armed {
+			state.Armed = true
+			err = u.db.SaveAlertState(ctx, state)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return false
+	}
+
+	cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
+	switch {
+	case rule.SnoozedUntil.Valid && at.Before(rule.SnoozedUntil.Time):
+		return false
+	case !state.Armed:
+		return false
+	case state.LastFiredAt.Valid && at.Before(state.LastFiredAt.Time.Add(cooldown)):
+		return false
+	}
+
+	state.Armed = !rule.Hysteresis
+	state.LastFiredAt = sql.NullTime{Time: at, Valid: true}
+	err = u.db.SaveAlertState(ctx, state)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return true
+}
+
+// quietUntil returns when the quiet hours of a rule end if at falls within
+// them, and at itself otherwise.
+func quietUntil(rule model.AlertRule, at time.Time) time.Time {
+	if rule.QuietStart == "" || rule.QuietEnd == "" {
+		return at
+	}
+	location, err := time.LoadLocation(rule.Timezone)
+	if err != nil {
+		log.Println(err)
+		return at
+	}
+	start, errStart := time.Parse(quietHoursLayout, rule.QuietStart)
+	end, errEnd := time.Parse(quietHoursLayout, rule.QuietEnd)
+	if errStart != nil || errEnd != nil {
+		return at
+	}
+
+	local := at.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute, endMinute := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	quiet := minute >= startMinute && minute < endMinute
+	if startMinute > endMinute {
+		// The quiet hours span midnight.
+		quiet = minute >= startMinute || minute < endMinute
+	}
+	if !quiet {
+		return at
+	}
+
+	ends := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+	if !ends.After(local) {
+		ends = ends.AddDate(0, 0, 1)
+	}
+	return ends
+}
+
+// checkAlertRule reports whether the refreshed price satisfies a rule, with
+// the message to store when it does.
+func checkAlertRule(rule model.AlertRule, product model.Product, payload ProductPayload, record priceRecord,
+	at time.Time) (string, bool) {
+	name := product.DisplayName
+	if name == "" {
+		name = payload.Name
+	}
+	price := payload.CurrentPrice
+
+	switch rule.Kind {
+	case model.AlertKindBelowTarget:
+		target := rule.Threshold
+		if target == 0 {
+			target = product.TargetPrice
+		}
+		if target == 0 || price > target {
+			return "", false
+		}
+		return fmt.Sprintf("%s is Rp. %s, at or below the target of Rp. %s",
+			name, humanize.Comma(price), humanize.Comma(target)), true
+
+	case model.AlertKindPercentDrop:
+		// The price in effect when the window opened counts, as well as
+		// every price set since.
+		from := at.Add(-time.Duration(rule.WindowHours) * time.Hour)
+		highest := product.CurrentPrice
+		for i, h := range record.history {
+			inWindow := !h.UpdateTime.Before(from)
+			openedWindow := i+1 < len(record.history) && !record.history[i+1].UpdateTime.Before(from)
+			if (inWindow || openedWindow) && h.CurrentPrice > highest {
+				highest = h.CurrentPrice
+			}
+		}
+		if highest <= 0 || price >= highest {
+			return "", false
+		}
+		drop := (highest - price) * 100 / highest
+		if drop < rule.Threshold {
+			return "", false
+		}
+		return fmt.Sprintf("%s dropped %d%% to Rp. %s from Rp. %s within %d hours",
+			name, drop, humanize.Comma(price), humanize.Comma(highest), rule.WindowHours), true
+
+	case model.AlertKindAllTimeLow:
+		if record.lowest <= 0 || price >= record.lowest {
+			return "", false
+		}
+		return fmt.Sprintf("%s is at an all-time low of Rp. %s, below the previous low of Rp. %s",
+			name, humanize.Comma(price), humanize.Comma(record.lowest)), true
+
+	case model.AlertKindDiscountAbove:
+		discount := model.Discount(price, payload.OriginalPrice)
+		if discount <= rule.Threshold {
+			return "", false
+		}
+		return fmt.Sprintf("%s is %d%% off at Rp. %s, more than %d%%",
+			name, discount, humanize.Comma(price), rule.Threshold), true
+
+	case model.AlertKindBackInStock:
+		// Stock is only known from successful checks, so a listing that
+		// was sold out before it failed counts as well.
+		if product.InStock || !payload.InStock {
+			return "", false
+		}
+		return fmt.Sprintf("%s is back in stock at Rp. %s", name, humanize.Comma(price)), true
+
+	case model.AlertKindListingReturned:
+		if int64(product.CheckFailures) < rule.Threshold {
+			return "", false
+		}
+		if !payload.InStock {
+			return fmt.Sprintf("%s is listed again after %d failed checks, but out of stock",
+				name, product.CheckFailures), true
+		}
+		return fmt.Sprintf("%s is listed again after %d failed checks, at Rp. %s",
+			name, product.CheckFailures, humanize.Comma(price)), true
+
+	case model.AlertKindExpression:
+		expr, err := condition.Parse(rule.Expression)
+		if err != nil {
+			log.Printf("alert rule %d: %v", rule.ID, err)
+			return "", false
+		}
+		ok, err := expr.Eval(conditionEnv(product, payload, record, at))
+		if err != nil {
+			log.Printf("alert rule %d on product %d: %v", rule.ID, product.ID, err)
+			return "", false
+		}
+		if !ok {
+			return "", false
+		}
+		if !payload.InStock {
+			return fmt.Sprintf("%s matched %s, out of stock", name, rule.Expression), true
+		}
+		return fmt.Sprintf("%s matched %s at Rp. %s", name, rule.Expression, humanize.Comma(price)), true
+	}
+
+	return "", false
+}
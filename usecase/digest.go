@@ -0,0 +1,467 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const JobKindDigest = "digest"
+
+const (
+	// digestSectionLimit caps the products listed in each digest section.
+	digestSectionLimit = 10
+	// digestSlack lets a digest go out on schedule even when the run before
+	// it started a little late.
+	digestSlack = time.Hour
+	digestPage  = 100
+)
+
+var digestPeriods = map[string]time.Duration{
+	model.DigestPeriodDaily:  24 * time.Hour,
+	model.DigestPeriodWeekly: 7 * 24 * time.Hour,
+}
+
+// digestTitles names each period at the start of a digest's title.
+var digestTitles = map[string]string{
+	model.DigestPeriodDaily:  "Daily",
+	model.DigestPeriodWeekly: "Weekly",
+}
+
+type Digest struct {
+	ID             int64    `json:"id"`
+	CollectionID   int64    `json:"collection_id,omitempty"`
+	CollectionName string   `json:"collection_name,omitempty"`
+	Period         string   `json:"period"`
+	Channels       []string `json:"channels,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+// DigestPayload is a new digest of the products in CollectionID, or of every
+// product when it is zero. It is delivered over the notifier Channels given,
+// or over every notifier when there are none.
+type DigestPayload struct {
+	CollectionID int64    `json:"collection_id"`
+	Period       string   `json:"period"`
+	Channels     []string `json:"channels"`
+}
+
+// DigestReport is a digest as it was sent. Summary is only filled in by
+// GetDigestReport.
+type DigestReport struct {
+	ID          int64                `json:"id"`
+	DigestID    int64                `json:"digest_id"`
+	Title       string               `json:"title"`
+	PeriodStart string               `json:"period_start"`
+	PeriodEnd   string               `json:"period_end"`
+	CreatedAt   string               `json:"created_at"`
+	Summary     *model.DigestSummary `json:"summary,omitempty"`
+}
+
+type PaginateDigestReport struct {
+	Draw            string         `json:"draw"`
+	RecordsTotal    int64          `json:"recordsTotal"`
+	RecordsFiltered int64          `json:"recordsFiltered"`
+	Reports         []DigestReport `json:"data"`
+}
+
+type digestJob struct {
+	ReportID int64  `json:"report_id"`
+	Channel  string `json:"channel"`
+}
+
+func convertDigest(digest model.Digest) Digest {
+	result := Digest{
+		ID:             digest.ID,
+		CollectionID:   digest.CollectionID,
+		CollectionName: digest.CollectionName,
+		Period:         digest.Period,
+		CreatedAt:      digest.CreatedAt.Format(runTimeFormat),
+	}
+	if digest.Channels != "" {
+		result.Channels = strings.Split(digest.Channels, ",")
+	}
+
+	return result
+}
+
+func convertDigestReport(report model.DigestReport) (DigestReport, model.DigestSummary, error) {
+	var summary model.DigestSummary
+	err := json.Unmarshal([]byte(report.Content), &summary)
+	if err != nil {
+		return DigestReport{}, model.DigestSummary{}, err
+	}
+
+	return DigestReport{
+		ID:          report.ID,
+		DigestID:    report.DigestID,
+		Title:       summary.Title,
+		PeriodStart: report.PeriodStart.Format(runTimeFormat),
+		PeriodEnd:   report.PeriodEnd.Format(runTimeFormat),
+		CreatedAt:   report.CreatedAt.Format(runTimeFormat),
+	}, summary, nil
+}
+
+func (u *usecase) ListDigests(ctx context.Context) ([]Digest, error) {
+	digests, err := u.db.GetDigests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Digest, len(digests))
+	for i, digest := range digests {
+		result[i] = convertDigest(digest)
+	}
+
+	return result, nil
+}
+
+func (u *usecase) CreateDigest(ctx context.Context, payload DigestPayload) (Digest, error) {
+	if _, ok := digestPeriods[payload.Period]; !ok {
+		return Digest{}, ValidationError{"period", fmt.Sprintf("must be %s or %s", model.DigestPeriodDaily,
+			model.DigestPeriodWeekly)}
+	}
+	if payload.CollectionID != 0 {
+		_, err := u.db.GetCollectionByID(ctx, payload.CollectionID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Digest{}, ValidationError{"collection_id", fmt.Sprintf("collection %d does not exist", payload.CollectionID)}
+		}
+		if err != nil {
+			return Digest{}, err
+		}
+	}
+	channels, err := u.selectChannels(payload.Channels)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	id, err := u.db.CreateDigest(ctx, model.Digest{
+		CollectionID: payload.CollectionID,
+		Period:       payload.Period,
+		Channels:     channels,
+	})
+	if err != nil {
+		return Digest{}, err
+	}
+
+	digest, err := u.db.GetDigestByID(ctx, id)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	return convertDigest(digest), nil
+}
+
+func (u *usecase) DeleteDigest(ctx context.Context, id int64) error {
+	_, err := u.db.GetDigestByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.DeleteDigest(ctx, id)
+}
+
+// ListDigestReports lists sent digests newest first, those of one digest
+// unless digestID is zero.
+func (u *usecase) ListDigestReports(ctx context.Context, draw string, digestID int64, offset, limit int) (PaginateDigestReport, error) {
+	if limit == 0 {
+		limit = 10
+	}
+
+	reports, err := u.db.GetDigestReports(ctx, digestID, limit, offset)
+	if err != nil {
+		return PaginateDigestReport{}, err
+	}
+
+	total, err := u.db.GetTotalDigestReport(ctx, digestID)
+	if err != nil {
+		return PaginateDigestReport{}, err
+	}
+
+	result := make([]DigestReport, len(reports))
+	for i, report := range reports {
+		result[i], _, err = convertDigestReport(report)
+		if err != nil {
+			return PaginateDigestReport{}, err
+		}
+	}
+
+	return PaginateDigestReport{
+		Draw:            draw,
+		RecordsTotal:    total,
+		RecordsFiltered: total,
+		Reports:         result,
+	}, nil
+}
+
+func (u *usecase) GetDigestReport(ctx context.Context, id int64) (DigestReport, error) {
+	report, err := u.db.GetDigestReportByID(ctx, id)
+	if err != nil {
+		return DigestReport{}, err
+	}
+
+	result, summary, err := convertDigestReport(report)
+	if err != nil {
+		return DigestReport{}, err
+	}
+	result.Summary = &summary
+
+	return result, nil
+}
+
+// SendDigests builds and queues every digest whose period is over and
+// returns how many it sent. A digest is due a period after the end of its
+// last report; its first report covers the period before it was due.
+func (u *usecase) SendDigests(ctx context.Context) (int, error) {
+	return u.sendDigests(ctx, time.Now())
+}
+
+func (u *usecase) sendDigests(ctx context.Context, now time.Time) (int, error) {
+	digests, err := u.db.GetDigests(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, digest := range digests {
+		period := digestPeriods[digest.Period]
+		from := now.Add(-period)
+		last, err := u.db.GetLastDigestReport(ctx, digest.ID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return sent, err
+		case now.Before(last.PeriodEnd.Add(period - digestSlack)):
+			continue
+		default:
+			from = last.PeriodEnd
+		}
+
+		err = u.sendDigest(ctx, digest, from, now)
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// sendDigest stores the digest of one period as a report and queues a job
+// delivering it for every notifier the digest is sent over.
+func (u *usecase) sendDigest(ctx context.Context, digest model.Digest, from, to time.Time) error {
+	summary, err := u.buildDigest(ctx, digest, from, to)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	id, err := u.db.InsertDigestReport(ctx, model.DigestReport{
+		DigestID:    digest.ID,
+		PeriodStart: from,
+		PeriodEnd:   to,
+		Content:     string(content),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, channel := range u.channels() {
+		if digest.Channels != "" && !containsString(strings.Split(digest.Channels, ","), channel) {
+			continue
+		}
+
+		_, err = u.enqueueJob(ctx, JobKindDigest, digestJob{ReportID: id, Channel: channel})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// buildDigest summarizes what happened to the active products of a digest
+// between from and to.
+func (u *usecase) buildDigest(ctx context.Context, digest model.Digest, from, to time.Time) (model.DigestSummary, error) {
+	title := digestTitles[digest.Period] + " digest: all products"
+	if digest.CollectionID != 0 {
+		title = digestTitles[digest.Period] + " digest: " + digest.CollectionName
+	}
+	summary := model.DigestSummary{
+		Title:  title,
+		Period: digest.Period,
+		From:   from.UTC(),
+		To:     to.UTC(),
+	}
+
+	var percents []int64
+	filter := model.ProductFilter{Status: model.ProductStatusActive, CollectionID: digest.CollectionID}
+	for offset := 0; ; offset += digestPage {
+		products, err := u.db.GetProducts(ctx, filter, digestPage, offset)
+		if err != nil {
+			return model.DigestSummary{}, err
+		}
+
+		for _, product := range products {
+			summary.Products++
+			item := model.DigestItem{ProductID: product.ID, Name: product.Name, URL: product.URL,
+				Price: product.CurrentPrice}
+			if product.DisplayName != "" {
+				item.Name = product.DisplayName
+			}
+
+			if product.CheckFailures > 0 && !product.LastCheckedAt.Before(from) {
+				broken := item
+				broken.Error = product.LastCheckError
+				summary.Broken = append(summary.Broken, broken)
+			}
+
+			changes, err := u.digestChanges(ctx, product, from, to)
+			if err != nil {
+				return model.DigestSummary{}, err
+			}
+			if changes.start > 0 && changes.end > 0 && changes.end < changes.start {
+				drop := item
+				drop.Price, drop.PreviousPrice = changes.end, changes.start
+				summary.Drops = append(summary.Drops, drop)
+				percents = append(percents, (changes.start-changes.end)*100/changes.start)
+			}
+			if changes.previousLow > 0 && changes.low > 0 && changes.low < changes.previousLow {
+				low := item
+				low.Price, low.PreviousPrice = changes.low, changes.previousLow
+				summary.NewLows = append(summary.NewLows, low)
+			}
+			if changes.restocked && product.InStock {
+				summary.BackInStock = append(summary.BackInStock, item)
+			}
+		}
+
+		if len(products) < digestPage {
+			break
+		}
+	}
+
+	sort.Stable(byDrop{summary.Drops, percents})
+	summary.Drops = limitDigestItems(summary.Drops)
+	summary.NewLows = limitDigestItems(summary.NewLows)
+	summary.BackInStock = limitDigestItems(summary.BackInStock)
+	summary.Broken = limitDigestItems(summary.Broken)
+
+	return summary, nil
+}
+
+// digestChanges is how the price history of a product moved over a digest's
+// period. Prices are zero when the product has none.
+type digestChanges struct {
+	// start is the price when the period started, or the first one in it
+	// when the product was added during the period.
+	start, end int64
+	// low is the lowest price in the period and previousLow the lowest one
+	// before it.
+	low, previousLow int64
+	// restocked is set when the product came back in stock in the period.
+	restocked bool
+}
+
+func (u *usecase) digestChanges(ctx context.Context, product model.Product, from, to time.Time) (digestChanges, error) {
+	history, err := u.db.GetPriceHistoryBetween(ctx, product.ID, time.Time{}, to)
+	if err != nil {
+		return digestChanges{}, err
+	}
+	rollups, err := u.db.GetPriceRollups(ctx, product.ID, model.RollupDaily, time.Time{}, from)
+	if err != nil {
+		return digestChanges{}, err
+	}
+
+	var changes digestChanges
+	for _, rollup := range rollups {
+		changes.previousLow = lowerPrice(changes.previousLow, rollup.MinPrice)
+	}
+
+	outOfStock := false
+	for _, h := range history {
+		if h.UpdateTime.Before(from) {
+			if h.CurrentPrice > 0 {
+				changes.start = h.CurrentPrice
+			}
+			changes.previousLow = lowerPrice(changes.previousLow, h.CurrentPrice)
+			outOfStock = !h.InStock
+			continue
+		}
+
+		if changes.start == 0 {
+			changes.start = h.CurrentPrice
+		}
+		if h.CurrentPrice > 0 {
+			changes.end = h.CurrentPrice
+		}
+		changes.low = lowerPrice(changes.low, h.CurrentPrice)
+		if h.InStock && outOfStock {
+			changes.restocked = true
+		}
+		outOfStock = !h.InStock
+	}
+
+	return changes, nil
+}
+
+// lowerPrice returns the lower of two prices, ignoring zero ones.
+func lowerPrice(low, price int64) int64 {
+	if price > 0 && (low == 0 || price < low) {
+		return price
+	}
+	return low
+}
+
+// byDrop sorts drops from the largest percentage down.
+type byDrop struct {
+	items    []model.DigestItem
+	percents []int64
+}
+
+func (d byDrop) Len() int           { return len(d.items) }
+func (d byDrop) Less(i, j int) bool { return d.percents[i] > d.percents[j] }
+func (d byDrop) Swap(i, j int) {
+	d.items[i], d.items[j] = d.items[j], d.items[i]
+	d.percents[i], d.percents[j] = d.percents[j], d.percents[i]
+}
+
+func limitDigestItems(items []model.DigestItem) []model.DigestItem {
+	if len(items) > digestSectionLimit {
+		return items[:digestSectionLimit]
+	}
+	return items
+}
+
+// deliverDigest sends a stored digest over one channel.
+func (u *usecase) deliverDigest(ctx context.Context, payload digestJob) error {
+	notifier, ok := u.notifiers[payload.Channel]
+	if !ok {
+		return fmt.Errorf("no %s notifier is configured", payload.Channel)
+	}
+
+	report, err := u.db.GetDigestReportByID(ctx, payload.ReportID)
+	if err != nil {
+		return err
+	}
+
+	var summary model.DigestSummary
+	err = json.Unmarshal([]byte(report.Content), &summary)
+	if err != nil {
+		return err
+	}
+
+	return notifier.NotifyDigest(ctx, summary)
+}
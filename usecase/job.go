@@ -0,0 +1,283 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const (
+	JobKindScrape  = "scrape"
+	JobKindRefresh = "refresh"
+	JobKindImport  = "import"
+	JobKindNotify  = "notify"
+)
+
+const (
+	jobMaxAttempts = 5
+	jobBaseBackoff = 30 * time.Second
+	jobStaleAfter  = 10 * time.Minute
+	// jobOverdueAfter is how long a due job may wait before enqueueing
+	// warns that no worker seems to be running.
+	jobOverdueAfter = 5 * time.Minute
+	// jobRecordTimeout bounds storing the outcome of a job, which must
+	// happen even when the job's own context was cancelled.
+	jobRecordTimeout = 10 * time.Second
+)
+
+// permanentError is a job error that retrying cannot fix, such as a link
+// that is not a product page. The job fails without further attempts.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Error       string          `json:"error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+}
+
+type scrapeJob struct {
+	Link string `json:"link"`
+}
+
+type refreshJob struct {
+	ProductID int64 `json:"product_id"`
+}
+
+type importJob struct {
+	Links []string `json:"links"`
+}
+
+type notifyJob struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+func (u *usecase) EnqueueScrape(ctx context.Context, link string) (int64, error) {
+	return u.enqueueJob(ctx, JobKindScrape, scrapeJob{Link: link})
+}
+
+func (u *usecase) EnqueueRefresh(ctx context.Context, productID int64) (int64, error) {
+	return u.enqueueJob(ctx, JobKindRefresh, refreshJob{ProductID: productID})
+}
+
+func (u *usecase) EnqueueImport(ctx context.Context, links []string) (int64, error) {
+	return u.enqueueJob(ctx, JobKindImport, importJob{Links: links})
+}
+
+func (u *usecase) enqueueJob(ctx context.Context, kind string, payload interface{}) (int64, error) {
+	return u.enqueueJobAt(ctx, kind, payload, time.Now())
+}
+
+// enqueueJobAt queues a job that is not run before runAt. It warns when
+// earlier jobs have gone unclaimed for long, as only -mode=worker and
+// -mode=all run them.
+func (u *usecase) enqueueJobAt(ctx context.Context, kind string, payload interface{}, runAt time.Time) (int64, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := u.db.EnqueueJob(ctx, model.JobPayload{
+		Kind:        kind,
+		Payload:     string(body),
+		MaxAttempts: jobMaxAttempts,
+		RunAt:       runAt,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	overdue, err := u.db.CountOverdueJobs(ctx, time.Now().Add(-jobOverdueAfter))
+	if err != nil {
+		log.Println(err)
+	} else if overdue > 0 {
+		log.Printf("warning: %d jobs have waited over %s to run; is a worker running (-mode=worker or -mode=all)?",
+			overdue, jobOverdueAfter)
+	}
+
+	return id, nil
+}
+
+func (u *usecase) GetJob(ctx context.Context, id int64) (Job, error) {
+	job, err := u.db.GetJobByID(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+
+	result := Job{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		Status:      job.Status,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		Error:       job.LastError,
+		CreatedAt:   job.CreatedAt.Format(runTimeFormat),
+		UpdatedAt:   job.UpdatedAt.Format(runTimeFormat),
+	}
+	if job.Result != "" {
+		result.Result = json.RawMessage(job.Result)
+	}
+
+	return result, nil
+}
+
+// ProcessNextJob claims one due job and runs it. It reports false when the
+// queue had nothing to do. A failing job is retried with exponential backoff
+// until it runs out of attempts or fails permanently. The outcome is stored even when ctx ends
+// while the job runs, so that the job is not taken for stale and run again.
+func (u *usecase) ProcessNextJob(ctx context.Context) (bool, error) {
+	requeued, failed, err := u.db.RequeueStaleJobs(ctx, time.Now().Add(-jobStaleAfter))
+	if err != nil {
+		return false, err
+	}
+	if requeued > 0 {
+		log.Printf("requeued %d stale jobs", requeued)
+	}
+	if failed > 0 {
+		log.Printf("failed %d stale jobs out of attempts", failed)
+	}
+
+	job, err := u.db.ClaimJob(ctx)
+	if err != nil {
+		return false, err
+	}
+	if job.ID == 0 {
+		return false, nil
+	}
+
+	var body []byte
+	result, err := u.runJob(ctx, job)
+	if err == nil {
+		body, err = json.Marshal(result)
+	}
+
+	recordCtx, cancel := context.WithTimeout(context.Background(), jobRecordTimeout)
+	defer cancel()
+	if err != nil {
+		log.Printf("job %d (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, err)
+
+		var retryAt time.Time
+		var permanent permanentError
+		if job.Attempts < job.MaxAttempts && !errors.As(err, &permanent) {
+			retryAt = time.Now().Add(jobBaseBackoff << uint(job.Attempts-1))
+		}
+		return true, u.db.FailJob(recordCtx, job.ID, err.Error(), retryAt)
+	}
+
+	return true, u.db.CompleteJob(recordCtx, job.ID, string(body))
+}
+
+func (u *usecase) runJob(ctx context.Context, job model.Job) (interface{}, error) {
+	switch job.Kind {
+	case JobKindScrape:
+		var payload scrapeJob
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := u.RegisterProduct(ctx, payload.Link)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			ProductID int64 `json:"product_id"`
+		}{id}, nil
+	case JobKindRefresh:
+		var payload refreshJob
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return u.RefreshProduct(ctx, payload.ProductID)
+	case JobKindImport:
+		var payload importJob
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		// Each link becomes its own scrape job so one bad link is retried
+		// on its own instead of failing the whole import. They are queued
+		// together, so retrying a failed import never queues a link twice.
+		scrapes := make([]model.JobPayload, 0, len(payload.Links))
+		for _, link := range payload.Links {
+			body, err := json.Marshal(scrapeJob{Link: link})
+			if err != nil {
+				return nil, err
+			}
+			scrapes = append(scrapes, model.JobPayload{
+				Kind:        JobKindScrape,
+				Payload:     string(body),
+				MaxAttempts: jobMaxAttempts,
+				RunAt:       time.Now(),
+			})
+		}
+		jobIDs, err := u.db.EnqueueJobs(ctx, scrapes)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			JobIDs []int64 `json:"job_ids"`
+		}{jobIDs}, nil
+	case JobKindNotify:
+		var payload notifyJob
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		err = u.deliverAlert(ctx, payload.DeliveryID, job.Attempts >= job.MaxAttempts)
+		if err != nil {
+			return nil, err
+		}
+		return payload, nil
+	case JobKindWebhook:
+		var payload webhookJob
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		err = u.deliverWebhook(ctx, payload.DeliveryID, job.Attempts >= job.MaxAttempts)
+		if err != nil {
+			return nil, err
+		}
+		return payload, nil
+	case JobKindDigest:
+		var payload digestJob
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		err = u.deliverDigest(ctx, payload)
+		if err != nil {
+			return nil, err
+		}
+		return payload, nil
+	default:
+		return nil, fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
@@ -0,0 +1,234 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// Notifier delivers fired alerts and digests over one channel, such as
+// email.
+type Notifier interface {
+	// Channel names the channel in the delivery log.
+	Channel() string
+	Notify(ctx context.Context, notification model.Notification) error
+	NotifyDigest(ctx context.Context, summary model.DigestSummary) error
+}
+
+type AlertDelivery struct {
+	ID          int64  `json:"id"`
+	EventID     int64  `json:"event_id"`
+	Channel     string `json:"channel"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	Error       string `json:"error,omitempty"`
+	ProductName string `json:"product_name"`
+	Message     string `json:"message"`
+	CreatedAt   string `json:"created_at"`
+	SentAt      string `json:"sent_at,omitempty"`
+}
+
+type PaginateAlertDelivery struct {
+	Draw            string          `json:"draw"`
+	RecordsTotal    int64           `json:"recordsTotal"`
+	RecordsFiltered int64           `json:"recordsFiltered"`
+	Deliveries      []AlertDelivery `json:"data"`
+}
+
+// channels lists the channels of the configured notifiers.
+func (u *usecase) channels() []string {
+	channels := make([]string, 0, len(u.notifiers))
+	for channel := range u.notifiers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+// selectChannels checks channels chosen by the user and joins them, in the
+// order of u.channels, as they are stored.
+func (u *usecase) selectChannels(requested []string) (string, error) {
+	channels := u.channels()
+	for _, channel := range requested {
+		if !containsString(channels, channel) {
+			if len(channels) == 0 {
+				return "", ValidationError{"channels", "no notifier is configured"}
+			}
+			return "", ValidationError{"channels", "must be some of " + strings.Join(channels, ", ")}
+		}
+	}
+
+	var selected []string
+	for _, channel := range channels {
+		if containsString(requested, channel) {
+			selected = append(selected, channel)
+		}
+	}
+
+	return strings.Join(selected, ","), nil
+}
+
+// queueAlertDeliveries logs a delivery of the event for every notifier the
+// rule is sent over and queues a job sending it, so a failed send is retried
+// by the job queue. During the rule's quiet hours the jobs wait for them to
+// end.
+func (u *usecase) queueAlertDeliveries(ctx context.Context, eventID int64, rule model.AlertRule) {
+	runAt := quietUntil(rule, time.Now())
+	for _, channel := range u.channels() {
+		if rule.Channels != "" && !containsString(strings.Split(rule.Channels, ","), channel) {
+			continue
+		}
+
+		id, err := u.db.InsertAlertDelivery(ctx, model.AlertDelivery{
+			EventID: eventID,
+			Channel: channel,
+			Status:  model.DeliveryStatusPending,
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		_, err = u.enqueueJobAt(ctx, JobKindNotify, notifyJob{DeliveryID: id}, runAt)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// deliverAlert sends one delivery and logs the attempt. A delivery that fails
+// stays pending until its last attempt.
+func (u *usecase) deliverAlert(ctx context.Context, deliveryID int64, lastAttempt bool) error {
+	delivery, err := u.db.GetAlertDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery.Status != model.DeliveryStatusPending {
+		return nil
+	}
+
+	err = u.sendAlert(ctx, delivery)
+	status, errMessage := model.DeliveryStatusSent, ""
+	if err != nil {
+		status, errMessage = model.DeliveryStatusPending, err.Error()
+		if lastAttempt {
+			status = model.DeliveryStatusFailed
+		}
+	}
+
+	errRecord := u.db.RecordAlertDeliveryAttempt(ctx, deliveryID, status, errMessage)
+	if errRecord != nil {
+		log.Println(errRecord)
+	}
+
+	return err
+}
+
+func (u *usecase) sendAlert(ctx context.Context, delivery model.AlertDelivery) error {
+	notifier, ok := u.notifiers[delivery.Channel]
+	if !ok {
+		return fmt.Errorf("no %s notifier is configured", delivery.Channel)
+	}
+
+	notification, err := u.notification(ctx, delivery.EventID)
+	if err != nil {
+		return err
+	}
+
+	return notifier.Notify(ctx, notification)
+}
+
+// notification describes an alert event with its product as it is now.
+func (u *usecase) notification(ctx context.Context, eventID int64) (model.Notification, error) {
+	event, err := u.db.GetAlertEventByID(ctx, eventID)
+	if err != nil {
+		return model.Notification{}, err
+	}
+
+	product, err := u.db.GetProductsByID(ctx, event.ProductID)
+	if err != nil {
+		return model.Notification{}, err
+	}
+
+	notification := productNotification(product)
+	notification.EventID = event.ID
+	notification.Kind = event.Kind
+	notification.Message = event.Message
+	notification.Price = event.Price
+	notification.PreviousPrice = event.PreviousPrice
+	notification.CreatedAt = event.CreatedAt
+
+	return notification, nil
+}
+
+// productNotification describes the product of an alert.
+func productNotification(product model.Product) model.Notification {
+	notification := model.Notification{
+		ProductID:     product.ID,
+		ProductName:   product.Name,
+		URL:           product.URL,
+		OriginalPrice: product.OriginalPrice,
+	}
+	if product.DisplayName != "" {
+		notification.ProductName = product.DisplayName
+	}
+	if len(product.Images) > 0 {
+		notification.Image = product.Images[0]
+	}
+
+	return notification
+}
+
+// AlertChannels lists the channels alerts can be delivered over.
+func (u *usecase) AlertChannels() []string {
+	return u.channels()
+}
+
+// ListAlertDeliveries lists the delivery log newest first, the deliveries of
+// one product's alerts unless productID is zero.
+func (u *usecase) ListAlertDeliveries(ctx context.Context, draw string, productID int64, offset, limit int) (PaginateAlertDelivery, error) {
+	if limit == 0 {
+		limit = 10
+	}
+
+	deliveries, err := u.db.GetAlertDeliveries(ctx, productID, limit, offset)
+	if err != nil {
+		return PaginateAlertDelivery{}, err
+	}
+
+	total, err := u.db.GetTotalAlertDelivery(ctx, productID)
+	if err != nil {
+		return PaginateAlertDelivery{}, err
+	}
+
+	result := make([]AlertDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		result[i] = AlertDelivery{
+			ID:          delivery.ID,
+			EventID:     delivery.EventID,
+			Channel:     delivery.Channel,
+			Status:      delivery.Status,
+			Attempts:    delivery.Attempts,
+			Error:       delivery.LastError,
+			ProductName: delivery.ProductName,
+			Message:     delivery.Message,
+			CreatedAt:   delivery.CreatedAt.Format(runTimeFormat),
+		}
+		if delivery.SentAt.Valid {
+			result[i].SentAt = delivery.SentAt.Time.Format(runTimeFormat)
+		}
+	}
+
+	return PaginateAlertDelivery{
+		Draw:            draw,
+		RecordsTotal:    total,
+		RecordsFiltered: total,
+		Deliveries:      result,
+	}, nil
+}
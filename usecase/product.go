@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+var (
+	ErrProductArchived    = ConflictError{"product is archived"}
+	ErrProductNotArchived = ConflictError{"product must be archived before it can be purged"}
+)
+
+// ConflictError reports an action that does not fit the current state of
+// what it acts on, such as purging a product that is still tracked.
+type ConflictError struct {
+	Message string
+}
+
+func (e ConflictError) Error() string {
+	return e.Message
+}
+
+// ArchiveProduct stops listing and refreshing a product. Its history is kept.
+func (u *usecase) ArchiveProduct(ctx context.Context, id int64) error {
+	_, err := u.db.GetProductsByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.UpdateProductStatus(ctx, id, model.ProductStatusArchived)
+}
+
+func (u *usecase) RestoreProduct(ctx context.Context, id int64) error {
+	_, err := u.db.GetProductsByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.UpdateProductStatus(ctx, id, model.ProductStatusActive)
+}
+
+// PurgeProduct deletes an archived product with its history and images.
+func (u *usecase) PurgeProduct(ctx context.Context, id int64) error {
+	product, err := u.db.GetProductsByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if product.Status != model.ProductStatusArchived {
+		return ErrProductNotArchived
+	}
+
+	return u.db.DeleteProduct(ctx, id)
+}
+
+// Limits on the product settings a user can set.
+const (
+	maxDisplayNameLength      = 200
+	maxNotesLength            = 2000
+	minRefreshIntervalMinutes = 5
+	maxRefreshIntervalMinutes = 7 * 24 * 60
+)
+
+// ValidationError reports a field of a request that cannot be accepted.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ProductSettingsPatch holds the settings to change. Nil fields are left as
+// they are; empty or zero values clear a setting.
+type ProductSettingsPatch struct {
+	DisplayName            *string `json:"display_name"`
+	Notes                  *string `json:"notes"`
+	TargetPrice            *int64  `json:"target_price"`
+	RefreshIntervalMinutes *int    `json:"refresh_interval_minutes"`
+}
+
+// UpdateProductSettings applies patch to the user settings of a product and
+// returns the updated product.
+func (u *usecase) UpdateProductSettings(ctx context.Context, id int64, patch ProductSettingsPatch) (Product, error) {
+	product, err := u.db.GetProductsByID(ctx, id)
+	if err != nil {
+		return Product{}, err
+	}
+
+	settings := model.ProductSettings{
+		ProductID:              id,
+		DisplayName:            product.DisplayName,
+		Notes:                  product.Notes,
+		TargetPrice:            product.TargetPrice,
+		RefreshIntervalMinutes: product.RefreshIntervalMinutes,
+	}
+	if patch.DisplayName != nil {
+		settings.DisplayName = strings.TrimSpace(*patch.DisplayName)
+	}
+	if patch.Notes != nil {
+		settings.Notes = strings.TrimSpace(*patch.Notes)
+	}
+	if patch.TargetPrice != nil {
+		settings.TargetPrice = *patch.TargetPrice
+	}
+	if patch.RefreshIntervalMinutes != nil {
+		settings.RefreshIntervalMinutes = *patch.RefreshIntervalMinutes
+	}
+
+	err = validateProductSettings(settings)
+	if err != nil {
+		return Product{}, err
+	}
+
+	err = u.db.UpsertProductSettings(ctx, settings)
+	if err != nil {
+		return Product{}, err
+	}
+
+	return u.GetProductDetail(ctx, id)
+}
+
+func validateProductSettings(settings model.ProductSettings) error {
+	switch {
+	case utf8.RuneCountInString(settings.DisplayName) > maxDisplayNameLength:
+		return ValidationError{"display_name", fmt.Sprintf("must be at most %d characters", maxDisplayNameLength)}
+	case utf8.RuneCountInString(settings.Notes) > maxNotesLength:
+		return ValidationError{"notes", fmt.Sprintf("must be at most %d characters", maxNotesLength)}
+	case settings.TargetPrice < 0:
+		return ValidationError{"target_price", "must not be negative"}
+	case settings.RefreshIntervalMinutes != 0 && (settings.RefreshIntervalMinutes < minRefreshIntervalMinutes ||
+		settings.RefreshIntervalMinutes > maxRefreshIntervalMinutes):
+		return ValidationError{"refresh_interval_minutes", fmt.Sprintf("must be 0 for the default or between %d and %d",
+			minRefreshIntervalMinutes, maxRefreshIntervalMinutes)}
+	}
+
+	return nil
+}
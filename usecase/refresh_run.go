@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const runTimeFormat = "2006-01-02 15:04:05"
+
+type RefreshRun struct {
+	ID         int64            `json:"id"`
+	StartedAt  string           `json:"started_at"`
+	FinishedAt string           `json:"finished_at"`
+	Attempted  int              `json:"attempted"`
+	Succeeded  int              `json:"succeeded"`
+	Failed     int              `json:"failed"`
+	Changed    int              `json:"changed"`
+	Items      []RefreshRunItem `json:"items,omitempty"`
+}
+
+type RefreshRunItem struct {
+	ID          int64  `json:"id"`
+	ProductID   int64  `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Status      string `json:"status"`
+	Changed     bool   `json:"changed"`
+	OldPrice    int64  `json:"old_price"`
+	NewPrice    int64  `json:"new_price"`
+	Error       string `json:"error,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type PaginateRefreshRun struct {
+	Draw            string       `json:"draw"`
+	RecordsTotal    int64        `json:"recordsTotal"`
+	RecordsFiltered int64        `json:"recordsFiltered"`
+	Runs            []RefreshRun `json:"data"`
+}
+
+func (u *usecase) ListRefreshRun(ctx context.Context, draw string, offset, limit int) (PaginateRefreshRun, error) {
+	if limit == 0 {
+		limit = 10
+	}
+
+	runs, err := u.db.GetRefreshRuns(ctx, limit, offset)
+	if err != nil {
+		return PaginateRefreshRun{}, err
+	}
+
+	total, err := u.db.GetTotalRefreshRun(ctx)
+	if err != nil {
+		return PaginateRefreshRun{}, err
+	}
+
+	result := make([]RefreshRun, len(runs))
+	for i, run := range runs {
+		result[i] = convertRefreshRun(run)
+	}
+
+	return PaginateRefreshRun{
+		Draw:            draw,
+		RecordsTotal:    total,
+		RecordsFiltered: total,
+		Runs:            result,
+	}, nil
+}
+
+func (u *usecase) GetRefreshRunDetail(ctx context.Context, id int64) (RefreshRun, error) {
+	run, err := u.db.GetRefreshRunByID(ctx, id)
+	if err != nil {
+		return RefreshRun{}, err
+	}
+
+	items, err := u.db.GetRefreshRunItems(ctx, id)
+	if err != nil {
+		return RefreshRun{}, err
+	}
+
+	result := convertRefreshRun(run)
+	result.Items = make([]RefreshRunItem, len(items))
+	for i, item := range items {
+		result.Items[i] = RefreshRunItem{
+			ID:          item.ID,
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			Status:      item.Status,
+			Changed:     item.Changed,
+			OldPrice:    item.OldPrice,
+			NewPrice:    item.NewPrice,
+			Error:       item.Error,
+			CreatedAt:   item.CreatedAt.Format(runTimeFormat),
+		}
+	}
+
+	return result, nil
+}
+
+func convertRefreshRun(run model.RefreshRun) RefreshRun {
+	result := RefreshRun{
+		ID:        run.ID,
+		StartedAt: run.StartedAt.Format(runTimeFormat),
+		Attempted: run.Attempted,
+		Succeeded: run.Succeeded,
+		Failed:    run.Failed,
+		Changed:   run.Changed,
+	}
+	if run.FinishedAt.Valid {
+		result.FinishedAt = run.FinishedAt.Time.Format(runTimeFormat)
+	}
+
+	return result
+}
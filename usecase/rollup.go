@@ -0,0 +1,124 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const (
+	// Ranges up to rawHistoryDays are served from raw rows, up to
+	// dailyHistoryDays from daily rollups and anything longer from weekly ones.
+	rawHistoryDays   = 31
+	dailyHistoryDays = 366
+)
+
+// RollupPriceHistory refreshes the daily and weekly rollups and then, when
+// retention is positive, prunes raw history older than it.
+func (u *usecase) RollupPriceHistory(ctx context.Context, retention time.Duration) error {
+	err := u.db.RollupPriceHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	if retention <= 0 {
+		return nil
+	}
+
+	pruned, err := u.db.PrunePriceHistory(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	log.Printf("pruned %d raw price history rows", pruned)
+
+	return nil
+}
+
+// listPriceHistoryRange covers the last days days. History only stores
+// changes, so the range starts with the price in effect when it opened and
+// ends with the price still in effect now.
+func (u *usecase) listPriceHistoryRange(ctx context.Context, productID int64, days int) ([]PriceHistory, error) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -days)
+
+	seed, err := u.db.GetPriceHistoryBefore(ctx, productID, from)
+	seeded := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	resolution := model.RollupWeekly
+	switch {
+	case days <= rawHistoryDays:
+		histories, err := u.db.GetPriceHistoryBetween(ctx, productID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if seeded {
+			seed.ID = 0
+			seed.UpdateTime = from
+			histories = append([]model.PriceHistory{seed}, histories...)
+		}
+		// Raw rows may already be pruned for old products; rollups still
+		// have them.
+		if len(histories) > 0 {
+			last := histories[len(histories)-1]
+			last.ID = 0
+			last.UpdateTime = to
+			return convertPriceHistories(append(histories, last)), nil
+		}
+		resolution = model.RollupDaily
+	case days <= dailyHistoryDays:
+		resolution = model.RollupDaily
+	}
+
+	rollups, err := u.db.GetPriceRollups(ctx, productID, resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if seeded && (len(rollups) == 0 || rollups[0].Bucket.After(from)) {
+		rollups = append([]model.PriceRollup{steadyRollup(seed, from)}, rollups...)
+	}
+	if n := len(rollups); n > 0 && rollups[n-1].Bucket.Format("2006-01-02") != to.UTC().Format("2006-01-02") {
+		rollups = append(rollups, steadyRollup(model.PriceHistory{
+			ProductID:     productID,
+			CurrentPrice:  rollups[n-1].ClosePrice,
+			OriginalPrice: rollups[n-1].OriginalPrice,
+			InStock:       rollups[n-1].InStock,
+		}, to))
+	}
+
+	result := make([]PriceHistory, len(rollups))
+	for i, rollup := range rollups {
+		result[i] = PriceHistory{
+			ProductID:     rollup.ProductID,
+			CurrentPrice:  rollup.ClosePrice,
+			OriginalPrice: rollup.OriginalPrice,
+			InStock:       rollup.InStock,
+			MinPrice:      rollup.MinPrice,
+			MaxPrice:      rollup.MaxPrice,
+			AvgPrice:      rollup.AvgPrice,
+			UpdateTime:    rollup.Bucket.Format("2006-01-02"),
+		}
+	}
+
+	return result, nil
+}
+
+// steadyRollup is a bucket at at where the price of history held throughout.
+func steadyRollup(history model.PriceHistory, at time.Time) model.PriceRollup {
+	return model.PriceRollup{
+		ProductID:     history.ProductID,
+		Bucket:        at,
+		OpenPrice:     history.CurrentPrice,
+		ClosePrice:    history.CurrentPrice,
+		MinPrice:      history.CurrentPrice,
+		MaxPrice:      history.CurrentPrice,
+		AvgPrice:      history.CurrentPrice,
+		OriginalPrice: history.OriginalPrice,
+		InStock:       history.InStock,
+	}
+}
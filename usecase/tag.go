@@ -0,0 +1,188 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const (
+	maxTagLength            = 50
+	maxCollectionNameLength = 100
+)
+
+type Tag struct {
+	Name     string `json:"name"`
+	Products int64  `json:"products"`
+}
+
+type Collection struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	Products    int64  `json:"products"`
+}
+
+// normalizeTag makes tags that differ only in case or spacing the same tag.
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// normalizeTags normalizes every tag and checks that it can be stored.
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		switch {
+		case tag == "":
+			return nil, ValidationError{"tags", "must not be empty"}
+		case strings.Contains(tag, ","):
+			return nil, ValidationError{"tags", fmt.Sprintf("%q must not contain a comma", tag)}
+		case utf8.RuneCountInString(tag) > maxTagLength:
+			return nil, ValidationError{"tags", fmt.Sprintf("%q is longer than %d characters", tag, maxTagLength)}
+		}
+		result = append(result, tag)
+	}
+
+	return result, nil
+}
+
+func (u *usecase) ListTags(ctx context.Context) ([]Tag, error) {
+	tags, err := u.db.GetTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Tag, len(tags))
+	for i, tag := range tags {
+		result[i] = Tag{Name: tag.Name, Products: tag.Products}
+	}
+
+	return result, nil
+}
+
+// TagProducts adds and removes tags on every given product at once.
+func (u *usecase) TagProducts(ctx context.Context, productIDs []int64, add, remove []string) error {
+	if len(productIDs) == 0 {
+		return ValidationError{"product_ids", "must not be empty"}
+	}
+
+	add, err := normalizeTags(add)
+	if err != nil {
+		return err
+	}
+	remove, err = normalizeTags(remove)
+	if err != nil {
+		return err
+	}
+
+	if len(add) > 0 {
+		err = u.db.AddProductTags(ctx, productIDs, add)
+		if err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		err = u.db.RemoveProductTags(ctx, productIDs, remove)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func convertCollection(collection model.Collection) Collection {
+	return Collection{
+		ID:          collection.ID,
+		Name:        collection.Name,
+		Description: collection.Description,
+		CreatedAt:   collection.CreatedAt.Format(runTimeFormat),
+		Products:    collection.Products,
+	}
+}
+
+func (u *usecase) ListCollections(ctx context.Context) ([]Collection, error) {
+	collections, err := u.db.GetCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Collection, len(collections))
+	for i, collection := range collections {
+		result[i] = convertCollection(collection)
+	}
+
+	return result, nil
+}
+
+// CreateCollection creates an empty collection with a unique name.
+func (u *usecase) CreateCollection(ctx context.Context, name, description string) (Collection, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return Collection{}, ValidationError{"name", "must not be empty"}
+	case utf8.RuneCountInString(name) > maxCollectionNameLength:
+		return Collection{}, ValidationError{"name", fmt.Sprintf("must be at most %d characters", maxCollectionNameLength)}
+	}
+
+	collections, err := u.db.GetCollections(ctx)
+	if err != nil {
+		return Collection{}, err
+	}
+	for _, collection := range collections {
+		if strings.EqualFold(collection.Name, name) {
+			return Collection{}, ValidationError{"name", fmt.Sprintf("collection %q already exists", collection.Name)}
+		}
+	}
+
+	id, err := u.db.CreateCollection(ctx, name, strings.TrimSpace(description))
+	if err != nil {
+		return Collection{}, err
+	}
+
+	collection, err := u.db.GetCollectionByID(ctx, id)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return convertCollection(collection), nil
+}
+
+// DeleteCollection deletes a collection but keeps its products.
+func (u *usecase) DeleteCollection(ctx context.Context, id int64) error {
+	_, err := u.db.GetCollectionByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.DeleteCollection(ctx, id)
+}
+
+func (u *usecase) AddToCollection(ctx context.Context, id int64, productIDs []int64) error {
+	if len(productIDs) == 0 {
+		return ValidationError{"product_ids", "must not be empty"}
+	}
+	_, err := u.db.GetCollectionByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.AddCollectionProducts(ctx, id, productIDs)
+}
+
+func (u *usecase) RemoveFromCollection(ctx context.Context, id int64, productIDs []int64) error {
+	if len(productIDs) == 0 {
+		return ValidationError{"product_ids", "must not be empty"}
+	}
+	_, err := u.db.GetCollectionByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.RemoveCollectionProducts(ctx, id, productIDs)
+}
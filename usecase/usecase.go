@@ -2,8 +2,11 @@ package usecase
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,31 +14,120 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/dustin/go-humanize"
-	"github.com/ediprako/pricemonitor/repository/pgsql"
+	"github.com/ediprako/pricemonitor/repository/model"
 )
 
-type dbProvider interface {
-	GetProductsByID(ctx context.Context, id int64) (pgsql.Product, error)
-	GetProductsByUpdateTime(ctx context.Context, startTime, endTime time.Time) ([]pgsql.Product, error)
-	GetProducts(ctx context.Context, limit, offset int) ([]pgsql.Product, error)
-	UpsertProduct(ctx context.Context, payload pgsql.ProductPayload) (int64, error)
+// DBProvider is the storage the usecases run on. It is implemented by the
+// pgsql and sqlite repositories.
+type DBProvider interface {
+	GetProductsByID(ctx context.Context, id int64) (model.Product, error)
+	GetProductsDueForRefresh(ctx context.Context, at time.Time, defaultInterval time.Duration, limit int) ([]model.Product, error)
+	GetProducts(ctx context.Context, filter model.ProductFilter, limit, offset int) ([]model.Product, error)
+	UpsertProduct(ctx context.Context, payload model.ProductPayload) (int64, error)
+	UpdateProduct(ctx context.Context, id int64, payload model.ProductPayload) error
+	RecordCheckFailure(ctx context.Context, id int64, message string) error
+	UpdateProductStatus(ctx context.Context, id int64, status int) error
+	UpsertProductSettings(ctx context.Context, settings model.ProductSettings) error
+	DeleteProduct(ctx context.Context, id int64) error
 	InsertPriceHistory(ctx context.Context, productID int64, currentPrice int64, originalPrice int64) error
-	GetTotalProduct(ctx context.Context) (int64, error)
-	GetLastPriceHistory(ctx context.Context, productID int64, limit int) ([]pgsql.PriceHistory, error)
-	UpDatabase(ctx context.Context) error
+	GetTotalProduct(ctx context.Context, filter model.ProductFilter) (int64, error)
+	GetMarketplaces(ctx context.Context) ([]string, error)
+	GetTags(ctx context.Context) ([]model.Tag, error)
+	AddProductTags(ctx context.Context, productIDs []int64, names []string) error
+	RemoveProductTags(ctx context.Context, productIDs []int64, names []string) error
+	CreateCollection(ctx context.Context, name, description string) (int64, error)
+	GetCollections(ctx context.Context) ([]model.Collection, error)
+	GetCollectionByID(ctx context.Context, id int64) (model.Collection, error)
+	DeleteCollection(ctx context.Context, id int64) error
+	AddCollectionProducts(ctx context.Context, collectionID int64, productIDs []int64) error
+	RemoveCollectionProducts(ctx context.Context, collectionID int64, productIDs []int64) error
+	CreateAlertRule(ctx context.Context, rule model.AlertRule) (int64, error)
+	GetAlertRules(ctx context.Context) ([]model.AlertRule, error)
+	GetAlertRuleByID(ctx context.Context, id int64) (model.AlertRule, error)
+	UpdateAlertRule(ctx context.Context, rule model.AlertRule) error
+	DeleteAlertRule(ctx context.Context, id int64) error
+	GetAlertRulesForProduct(ctx context.Context, productID int64) ([]model.AlertRule, error)
+	SnoozeAlertRule(ctx context.Context, id int64, until sql.NullTime) error
+	GetAlertState(ctx context.Context, ruleID, productID int64) (model.AlertState, error)
+	SaveAlertState(ctx context.Context, state model.AlertState) error
+	AcknowledgeAlertEvent(ctx context.Context, id int64) error
+	InsertAlertEvent(ctx context.Context, event model.AlertEvent) (int64, error)
+	GetAlertEvents(ctx context.Context, productID int64, limit, offset int) ([]model.AlertEvent, error)
+	GetTotalAlertEvent(ctx context.Context, productID int64) (int64, error)
+	GetAlertEventByID(ctx context.Context, id int64) (model.AlertEvent, error)
+	InsertAlertDelivery(ctx context.Context, delivery model.AlertDelivery) (int64, error)
+	GetAlertDeliveryByID(ctx context.Context, id int64) (model.AlertDelivery, error)
+	RecordAlertDeliveryAttempt(ctx context.Context, id int64, status, errMessage string) error
+	GetAlertDeliveries(ctx context.Context, productID int64, limit, offset int) ([]model.AlertDelivery, error)
+	GetTotalAlertDelivery(ctx context.Context, productID int64) (int64, error)
+	CreateWebhook(ctx context.Context, webhook model.Webhook) (int64, error)
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+	GetWebhookByID(ctx context.Context, id int64) (model.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	InsertWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (int64, error)
+	GetWebhookDeliveryByID(ctx context.Context, id int64) (model.WebhookDelivery, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, id int64, status string, statusCode int, errMessage string) error
+	GetWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]model.WebhookDelivery, error)
+	GetTotalWebhookDelivery(ctx context.Context, webhookID int64) (int64, error)
+	CreateDigest(ctx context.Context, digest model.Digest) (int64, error)
+	GetDigests(ctx context.Context) ([]model.Digest, error)
+	GetDigestByID(ctx context.Context, id int64) (model.Digest, error)
+	DeleteDigest(ctx context.Context, id int64) error
+	InsertDigestReport(ctx context.Context, report model.DigestReport) (int64, error)
+	GetDigestReportByID(ctx context.Context, id int64) (model.DigestReport, error)
+	GetLastDigestReport(ctx context.Context, digestID int64) (model.DigestReport, error)
+	GetDigestReports(ctx context.Context, digestID int64, limit, offset int) ([]model.DigestReport, error)
+	GetTotalDigestReport(ctx context.Context, digestID int64) (int64, error)
+	GetLastPriceHistory(ctx context.Context, productID int64, limit int) ([]model.PriceHistory, error)
+	GetPriceHistoryBetween(ctx context.Context, productID int64, from, to time.Time) ([]model.PriceHistory, error)
+	GetPriceHistoryBefore(ctx context.Context, productID int64, before time.Time) (model.PriceHistory, error)
+	GetPriceRollups(ctx context.Context, productID int64, resolution string, from, to time.Time) ([]model.PriceRollup, error)
+	RollupPriceHistory(ctx context.Context) error
+	PrunePriceHistory(ctx context.Context, olderThan time.Time) (int64, error)
+	CreateRefreshRun(ctx context.Context) (int64, error)
+	FinishRefreshRun(ctx context.Context, run model.RefreshRun) error
+	InsertRefreshRunItem(ctx context.Context, item model.RefreshRunItem) error
+	GetRefreshRuns(ctx context.Context, limit, offset int) ([]model.RefreshRun, error)
+	GetTotalRefreshRun(ctx context.Context) (int64, error)
+	GetRefreshRunByID(ctx context.Context, id int64) (model.RefreshRun, error)
+	GetRefreshRunItems(ctx context.Context, runID int64) ([]model.RefreshRunItem, error)
+	EnqueueJob(ctx context.Context, payload model.JobPayload) (int64, error)
+	EnqueueJobs(ctx context.Context, payloads []model.JobPayload) ([]int64, error)
+	ClaimJob(ctx context.Context) (model.Job, error)
+	CompleteJob(ctx context.Context, id int64, result string) error
+	FailJob(ctx context.Context, id int64, errMessage string, retryAt time.Time) error
+	RequeueStaleJobs(ctx context.Context, before time.Time) (requeued, failed int64, err error)
+	CountOverdueJobs(ctx context.Context, before time.Time) (int64, error)
+	GetJobByID(ctx context.Context, id int64) (model.Job, error)
 }
 
 type usecase struct {
-	db dbProvider
+	db        DBProvider
+	notifiers map[string]Notifier
 }
 
-func New(db dbProvider) *usecase {
-	return &usecase{
-		db: db,
+// New returns the usecases. Every alert that fires is delivered by each of
+// the notifiers.
+func New(db DBProvider, notifiers ...Notifier) *usecase {
+	u := &usecase{
+		db:        db,
+		notifiers: make(map[string]Notifier),
 	}
+	for _, notifier := range notifiers {
+		u.notifiers[notifier.Channel()] = notifier
+	}
+
+	return u
 }
 
-type ProductPayload pgsql.ProductPayload
+type ProductPayload model.ProductPayload
+
+const (
+	// refreshInterval is how long a product goes unchecked before the cron
+	// refreshes it again, unless the product has its own interval.
+	refreshInterval  = time.Hour
+	refreshBatchSize = 100
+)
 
 type Product struct {
 	ID                  int64    `json:"id"`
@@ -45,7 +137,21 @@ type Product struct {
 	OriginalPrice       int64    `json:"original_price"`
 	OriginalPriceString string   `json:"original_price_string"`
 	URL                 string   `json:"url"`
+	Marketplace         string   `json:"marketplace"`
+	Discount            int64    `json:"discount"`
+	InStock             bool     `json:"in_stock"`
+	LastChangedAt       string   `json:"last_changed_at"`
+	LastCheckedAt       string   `json:"last_checked_at"`
+	LastCheckError      string   `json:"last_check_error,omitempty"`
+	Archived            bool     `json:"archived"`
 	Images              []string `json:"images,omitempty"`
+	Tags                []string `json:"tags"`
+
+	DisplayName            string `json:"display_name"`
+	Notes                  string `json:"notes"`
+	TargetPrice            int64  `json:"target_price"`
+	TargetPriceString      string `json:"target_price_string,omitempty"`
+	RefreshIntervalMinutes int    `json:"refresh_interval_minutes"`
 }
 
 type PaginateData struct {
@@ -55,21 +161,42 @@ type PaginateData struct {
 	Products        []Product `json:"data"`
 }
 
+type RefreshResult struct {
+	ProductID           int64  `json:"product_id"`
+	CurrentPrice        int64  `json:"current_price"`
+	CurrentPriceString  string `json:"current_price_string"`
+	OriginalPrice       int64  `json:"original_price"`
+	OriginalPriceString string `json:"original_price_string"`
+	PreviousPrice       int64  `json:"previous_price"`
+	InStock             bool   `json:"in_stock"`
+	Changed             bool   `json:"changed"`
+}
+
 type PriceHistory struct {
 	ID            int64  `json:"id"`
 	ProductID     int64  `json:"product_id"`
 	CurrentPrice  int64  `json:"current_price"`
 	OriginalPrice int64  `json:"original_price"`
+	InStock       bool   `json:"in_stock"`
+	MinPrice      int64  `json:"min_price,omitempty"`
+	MaxPrice      int64  `json:"max_price,omitempty"`
+	AvgPrice      int64  `json:"avg_price,omitempty"`
 	UpdateTime    string `json:"update_time"`
 }
 
 func (u *usecase) RegisterProduct(ctx context.Context, link string) (int64, error) {
-	product, err := u.getProductFromLink(link)
+	product, err := u.getProductFromLink(ctx, link)
 	if err != nil {
 		return 0, err
 	}
 
-	id, err := u.db.UpsertProduct(ctx, pgsql.ProductPayload(product))
+	id, err := u.db.UpsertProduct(ctx, model.ProductPayload(product))
+	if err != nil {
+		return 0, err
+	}
+
+	// Adding the link of an archived product tracks it again.
+	err = u.db.UpdateProductStatus(ctx, id, model.ProductStatusActive)
 	if err != nil {
 		return 0, err
 	}
@@ -88,32 +215,106 @@ func (u *usecase) GetProductDetail(ctx context.Context, id int64) (Product, erro
 		CurrentPrice:        product.CurrentPrice,
 		OriginalPrice:       product.OriginalPrice,
 		Images:              product.Images,
+		Tags:                product.Tags,
 		URL:                 product.URL,
+		Marketplace:         product.Marketplace,
+		Discount:            model.Discount(product.CurrentPrice, product.OriginalPrice),
+		InStock:             product.InStock,
+		LastChangedAt:       product.UpdatedAt.Format(runTimeFormat),
+		LastCheckedAt:       product.LastCheckedAt.Format(runTimeFormat),
+		LastCheckError:      product.LastCheckError,
+		Archived:            product.Status == model.ProductStatusArchived,
 		OriginalPriceString: "Rp. " + humanize.Comma(product.OriginalPrice),
 		CurrentPriceString:  "Rp. " + humanize.Comma(product.CurrentPrice),
+
+		DisplayName:            product.DisplayName,
+		Notes:                  product.Notes,
+		TargetPrice:            product.TargetPrice,
+		RefreshIntervalMinutes: product.RefreshIntervalMinutes,
+	}
+	if product.TargetPrice > 0 {
+		result.TargetPriceString = "Rp. " + humanize.Comma(product.TargetPrice)
 	}
 
 	return result, nil
 }
 
-func (u *usecase) ListProduct(ctx context.Context, draw string, page, pagesize int) (PaginateData, error) {
-	if page == 0 {
-		page = 1
+// ProductFilter narrows ListProduct to archived products, to products with a
+// tag or in a collection, or to those matching a search, price range or
+// marketplace. Sort names the column of the list to order by.
+type ProductFilter struct {
+	Archived     bool
+	Tag          string
+	CollectionID int64
+	Search       string
+	MinPrice     int64
+	MaxPrice     int64
+	OnSale       bool
+	Marketplace  string
+	Sort         string
+	Descending   bool
+}
+
+// productSorts maps the sortable columns of the list to repository sorts.
+var productSorts = map[string]string{
+	"name":            model.ProductSortName,
+	"current_price":   model.ProductSortPrice,
+	"original_price":  model.ProductSortOriginalPrice,
+	"discount":        model.ProductSortDiscount,
+	"last_changed_at": model.ProductSortLastChange,
+}
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// ListProduct lists a page of the products matching filter, starting at
+// offset.
+func (u *usecase) ListProduct(ctx context.Context, draw string, offset, limit int, filter ProductFilter) (PaginateData, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
 	}
-	offset := (page - 1) * pagesize
-	products, err := u.db.GetProducts(ctx, pagesize, offset)
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	archived := filter.Archived
+	status := model.ProductStatusActive
+	if archived {
+		status = model.ProductStatusArchived
+	}
+	productFilter := model.ProductFilter{
+		Status:       status,
+		Tag:          normalizeTag(filter.Tag),
+		CollectionID: filter.CollectionID,
+		Search:       filter.Search,
+		MinPrice:     filter.MinPrice,
+		MaxPrice:     filter.MaxPrice,
+		OnSale:       filter.OnSale,
+		Marketplace:  filter.Marketplace,
+		Sort:         productSorts[filter.Sort],
+		Descending:   filter.Descending,
+	}
+
+	products, err := u.db.GetProducts(ctx, productFilter, limit, offset)
 	if err != nil {
 		return PaginateData{}, err
 	}
-	total, err := u.db.GetTotalProduct(ctx)
-
+	total, err := u.db.GetTotalProduct(ctx, model.ProductFilter{Status: status})
+	if err != nil {
+		return PaginateData{}, err
+	}
+	filtered, err := u.db.GetTotalProduct(ctx, productFilter)
 	if err != nil {
 		return PaginateData{}, err
 	}
 
 	var paging PaginateData
 	paging.RecordsTotal = total
-	paging.RecordsFiltered = total
+	paging.RecordsFiltered = filtered
 	paging.Draw = draw
 
 	var result []Product
@@ -124,6 +325,14 @@ func (u *usecase) ListProduct(ctx context.Context, draw string, page, pagesize i
 			CurrentPrice:  product.CurrentPrice,
 			OriginalPrice: product.OriginalPrice,
 			URL:           product.URL,
+			Marketplace:   product.Marketplace,
+			Discount:      model.Discount(product.CurrentPrice, product.OriginalPrice),
+			InStock:       product.InStock,
+			LastChangedAt: product.UpdatedAt.Format(runTimeFormat),
+			Archived:      archived,
+			DisplayName:   product.DisplayName,
+			TargetPrice:   product.TargetPrice,
+			Tags:          product.Tags,
 		})
 	}
 
@@ -132,11 +341,56 @@ func (u *usecase) ListProduct(ctx context.Context, draw string, page, pagesize i
 	return paging, nil
 }
 
-func (u *usecase) getProductFromLink(link string) (ProductPayload, error) {
-	response, err := http.Get(link)
+// ListMarketplaces returns the marketplaces products are tracked on.
+func (u *usecase) ListMarketplaces(ctx context.Context) ([]string, error) {
+	return u.db.GetMarketplaces(ctx)
+}
+
+// marketplaceOf returns the host a product link points to, without "www.".
+func marketplaceOf(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// scrapeClient fetches product pages, so that a marketplace that stops
+// answering cannot hold a refresh, or a shutdown, forever.
+var scrapeClient = &http.Client{Timeout: 30 * time.Second}
+
+// getProductFromLink scrapes the product page at link. Errors that fetching
+// the page again cannot fix, such as a link that is not a web address or a
+// page without a product, are permanent.
+func (u *usecase) getProductFromLink(ctx context.Context, link string) (ProductPayload, error) {
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return ProductPayload{}, permanentError{ValidationError{Field: "link",
+			Message: fmt.Sprintf("%q is not a web address", link)}}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return ProductPayload{}, permanentError{err}
+	}
+	response, err := scrapeClient.Do(req)
 	if err != nil {
 		return ProductPayload{}, err
 	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("fetching %s: unexpected status %s", link, response.Status)
+		// Client errors other than timeouts and rate limits will not go
+		// away on their own.
+		if response.StatusCode >= 400 && response.StatusCode < 500 &&
+			response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+			return ProductPayload{}, permanentError{err}
+		}
+		return ProductPayload{}, err
+	}
+
 	doc, err := goquery.NewDocumentFromReader(response.Body)
 	if err != nil {
 		return ProductPayload{}, err
@@ -144,7 +398,10 @@ func (u *usecase) getProductFromLink(link string) (ProductPayload, error) {
 
 	var product ProductPayload
 
-	product.Name = doc.Find("h1#product-name").Text()
+	product.Name = strings.TrimSpace(doc.Find("h1#product-name").Text())
+	if product.Name == "" {
+		return ProductPayload{}, permanentError{fmt.Errorf("fetching %s: no product found on page", link)}
+	}
 	product.CurrentPrice = convertToAngka(doc.Find("div#product-final-price").First().Text())
 	product.OriginalPrice = convertToAngka(doc.Find("div#product-discount-price").First().Text())
 	if product.OriginalPrice == 0 {
@@ -160,10 +417,33 @@ func (u *usecase) getProductFromLink(link string) (ProductPayload, error) {
 		}
 	})
 
+	product.InStock = isInStock(doc)
 	product.URL = link
+	product.Marketplace = marketplaceOf(link)
 	return product, err
 }
 
+var outOfStockPhrases = []string{"stok habis", "out of stock", "sold out"}
+
+// isInStock reads the availability the page advertises for crawlers and
+// falls back to looking for an out of stock notice near the price.
+func isInStock(doc *goquery.Document) bool {
+	if availability, ok := doc.Find(`meta[property="product:availability"]`).Attr("content"); ok {
+		return !strings.Contains(strings.ToLower(availability), "out")
+	}
+	if availability, ok := doc.Find(`[itemprop="availability"]`).Attr("href"); ok {
+		return !strings.Contains(availability, "OutOfStock")
+	}
+
+	text := strings.ToLower(doc.Find("div#product-final-price").Parent().Parent().Text())
+	for _, phrase := range outOfStockPhrases {
+		if strings.Contains(text, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
 func convertToAngka(rupiah string) int64 {
 	m1 := regexp.MustCompile(`,.*|\D`)
 	str := m1.ReplaceAllString(rupiah, "")
@@ -174,7 +454,14 @@ func convertToAngka(rupiah string) int64 {
 	return n
 }
 
-func (u *usecase) ListPriceHistory(ctx context.Context, productID int64, limit int) ([]PriceHistory, error) {
+// ListPriceHistory returns the latest limit raw history rows when days is
+// zero. Otherwise it covers the last days days, using raw rows for short
+// ranges and daily or weekly rollups for longer ones.
+func (u *usecase) ListPriceHistory(ctx context.Context, productID int64, limit int, days int) ([]PriceHistory, error) {
+	if days > 0 {
+		return u.listPriceHistoryRange(ctx, productID, days)
+	}
+
 	if limit == 0 {
 		limit = 100
 	}
@@ -183,6 +470,10 @@ func (u *usecase) ListPriceHistory(ctx context.Context, productID int64, limit i
 		return nil, err
 	}
 
+	return convertPriceHistories(histories), nil
+}
+
+func convertPriceHistories(histories []model.PriceHistory) []PriceHistory {
 	result := make([]PriceHistory, len(histories))
 	for i, history := range histories {
 		result[i] = PriceHistory{
@@ -190,38 +481,163 @@ func (u *usecase) ListPriceHistory(ctx context.Context, productID int64, limit i
 			ProductID:     history.ProductID,
 			CurrentPrice:  history.CurrentPrice,
 			OriginalPrice: history.OriginalPrice,
+			InStock:       history.InStock,
 			UpdateTime:    history.UpdateTime.Format("2006-01-02 15:04"),
 		}
 	}
 
-	return result, nil
+	return result
 }
 
-func (u *usecase) UpDatabase(ctx context.Context) error {
-	return u.db.UpDatabase(ctx)
-}
-
-func (u *usecase) RefreshProductInformation(ctx context.Context) error {
-	startDate := time.Now().Add(time.Duration(-1) * time.Hour)
-	startDate = startDate.Add(time.Duration(startDate.Second()*-1) * time.Second)
-	endDate := startDate.Add(time.Duration(1) * time.Minute)
-
-	products, err := u.db.GetProductsByUpdateTime(ctx, startDate, endDate)
+func (u *usecase) RefreshProductInformation(ctx context.Context) (RefreshRun, error) {
+	products, err := u.db.GetProductsDueForRefresh(ctx, time.Now(), refreshInterval, refreshBatchSize)
 	if err != nil {
-		return err
+		return RefreshRun{}, err
 	}
 
 	if len(products) == 0 {
-		log.Println("no product updated")
-		return nil
+		log.Println("no product due for refresh")
+		return RefreshRun{}, nil
+	}
+
+	runID, err := u.db.CreateRefreshRun(ctx)
+	if err != nil {
+		return RefreshRun{}, err
 	}
 
+	run := model.RefreshRun{ID: runID}
 	for _, product := range products {
-		_, err = u.RegisterProduct(ctx, product.URL)
+		if ctx.Err() != nil {
+			break
+		}
+
+		item := model.RefreshRunItem{
+			RunID:     runID,
+			ProductID: product.ID,
+			OldPrice:  product.CurrentPrice,
+			Status:    model.RefreshStatusSuccess,
+		}
+
+		run.Attempted++
+		payload, changed, err := u.refreshProduct(ctx, product)
+		if err != nil {
+			log.Println(err)
+			item.Status = model.RefreshStatusFailed
+			item.Error = err.Error()
+			item.NewPrice = product.CurrentPrice
+			run.Failed++
+		} else {
+			item.Changed = changed
+			item.NewPrice = payload.CurrentPrice
+			run.Succeeded++
+			if changed {
+				run.Changed++
+			}
+		}
+
+		// A lost item still counts towards the run, which is finished
+		// below either way.
+		err = u.db.InsertRefreshRunItem(ctx, item)
 		if err != nil {
-			return err
+			log.Println(err)
 		}
 	}
 
-	return nil
+	if ctx.Err() != nil {
+		// Still close the run so it does not show as running forever.
+		finishCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = u.db.FinishRefreshRun(finishCtx, run)
+		if err != nil {
+			log.Println(err)
+		}
+		return RefreshRun{}, ctx.Err()
+	}
+
+	err = u.db.FinishRefreshRun(ctx, run)
+	if err != nil {
+		return RefreshRun{}, err
+	}
+
+	return u.GetRefreshRunDetail(ctx, runID)
+}
+
+func (u *usecase) RefreshProduct(ctx context.Context, id int64) (RefreshResult, error) {
+	product, err := u.db.GetProductsByID(ctx, id)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+	if product.Status == model.ProductStatusArchived {
+		return RefreshResult{}, ErrProductArchived
+	}
+
+	payload, changed, err := u.refreshProduct(ctx, product)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	return RefreshResult{
+		ProductID:           product.ID,
+		CurrentPrice:        payload.CurrentPrice,
+		CurrentPriceString:  "Rp. " + humanize.Comma(payload.CurrentPrice),
+		OriginalPrice:       payload.OriginalPrice,
+		OriginalPriceString: "Rp. " + humanize.Comma(payload.OriginalPrice),
+		PreviousPrice:       product.CurrentPrice,
+		InStock:             payload.InStock,
+		Changed:             changed,
+	}, nil
+}
+
+// recordCheckFailure stores a failed refresh against the product and tells
+// the webhooks about it.
+func (u *usecase) recordCheckFailure(ctx context.Context, product model.Product, err error) {
+	errRecord := u.db.RecordCheckFailure(ctx, product.ID, err.Error())
+	if errRecord != nil {
+		log.Println(errRecord)
+	}
+	body := newWebhookBody(model.WebhookEventProductFailed, product)
+	body.Error = err.Error()
+	u.queueWebhooks(ctx, body)
+}
+
+// refreshProduct re-scrapes a tracked product and stores the result, reporting
+// whether either price or the stock state differs from what was stored
+// before. A failed scrape is recorded against the product. When the new
+// prices are stored the product's alert rules are checked against them.
+// Otherwise only the rules that can start to hold without a price change
+// are checked.
+func (u *usecase) refreshProduct(ctx context.Context, product model.Product) (ProductPayload, bool, error) {
+	payload, err := u.getProductFromLink(ctx, product.URL)
+	if err != nil {
+		u.recordCheckFailure(ctx, product, err)
+		return ProductPayload{}, false, err
+	}
+
+	// The price record is read before the write so that it holds only the
+	// earlier prices.
+	at := time.Now()
+	rules, record := u.loadAlerts(ctx, product, at)
+
+	// The product is updated by id, so a listing the marketplace renamed
+	// stays the same product.
+	err = u.db.UpdateProduct(ctx, product.ID, model.ProductPayload(payload))
+	if err != nil {
+		// Still counts as a check, or the product would be due again on
+		// every pass.
+		u.recordCheckFailure(ctx, product, err)
+		return ProductPayload{}, false, err
+	}
+
+	changed := payload.CurrentPrice != product.CurrentPrice || payload.OriginalPrice != product.OriginalPrice ||
+		payload.InStock != product.InStock
+	if payload.CurrentPrice != product.CurrentPrice || payload.OriginalPrice != product.OriginalPrice {
+		u.queueWebhooks(ctx, refreshedWebhookBody(model.WebhookEventPriceChanged, product, payload))
+	}
+	if changed {
+		u.evaluateAlerts(ctx, product, payload, rules, record, at, time.Time{})
+	} else {
+		u.evaluateAlerts(ctx, product, payload, steadyAlerts(rules, product.CheckFailures > 0), record, at,
+			product.LastCheckedAt)
+	}
+	return payload, changed, nil
 }
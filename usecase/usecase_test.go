@@ -0,0 +1,1794 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/memory"
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+// shop serves a single product page whose price and stock can be changed.
+// The product is named Kopi Bubuk 200g unless name is set.
+type shop struct {
+	mu      sync.Mutex
+	name    string
+	price   int64
+	inStock bool
+	broken  bool
+}
+
+func (s *shop) set(price int64, inStock bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.price, s.inStock = price, inStock
+}
+
+func (s *shop) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.broken {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+
+	availability := "in stock"
+	if !s.inStock {
+		availability = "out of stock"
+	}
+	name := s.name
+	if name == "" {
+		name = "Kopi Bubuk 200g"
+	}
+	fmt.Fprintf(w, `<html><head><meta property="product:availability" content="%s"></head><body>
+		<h1 id="product-name">%s</h1>
+		<div id="product-final-price">Rp%d</div>
+		<div id="product-discount-price">Rp60.000</div>
+		</body></html>`, availability, name, s.price)
+}
+
+func newTestUsecase(t *testing.T) (*usecase, *shop, string) {
+	s := &shop{price: 50000, inStock: true}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	return New(memory.New()), s, server.URL + "/kopi"
+}
+
+func TestRegisterProduct(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	product, err := u.GetProductDetail(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Name != "Kopi Bubuk 200g" || product.CurrentPrice != 50000 || product.OriginalPrice != 60000 ||
+		product.URL != link || !product.InStock {
+		t.Errorf("registered product = %+v", product)
+	}
+
+	list, err := u.ListProduct(ctx, "3", 0, 10, ProductFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Draw != "3" || list.RecordsTotal != 1 || len(list.Products) != 1 || list.Products[0].ID != id {
+		t.Errorf("ListProduct() = %+v", list)
+	}
+}
+
+func TestRefreshProduct(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed {
+		t.Errorf("refresh of an unchanged page reported a change: %+v", result)
+	}
+
+	s.set(45000, false)
+	result, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Changed || result.PreviousPrice != 50000 || result.CurrentPrice != 45000 || result.InStock {
+		t.Errorf("refresh after a price drop = %+v", result)
+	}
+
+	histories, err := u.ListPriceHistory(ctx, id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || histories[1].CurrentPrice != 45000 {
+		t.Errorf("history = %+v", histories)
+	}
+
+	// A listing renamed by the marketplace stays the same product.
+	s.mu.Lock()
+	s.name = "Kopi Bubuk Arabika 200g"
+	s.mu.Unlock()
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := u.GetProductDetail(ctx, id)
+	if err != nil || product.Name != "Kopi Bubuk Arabika 200g" {
+		t.Errorf("renamed product = %+v, %v", product, err)
+	}
+	list, err := u.ListProduct(ctx, "", 0, 10, ProductFilter{})
+	if err != nil || list.RecordsTotal != 1 {
+		t.Errorf("products after a rename = %+v, %v", list, err)
+	}
+}
+
+// pastHistory is a repository where the product with past's id had the
+// price of past before its stored history began.
+type pastHistory struct {
+	DBProvider
+	past model.PriceHistory
+}
+
+func (p pastHistory) GetPriceHistoryBefore(ctx context.Context, productID int64, before time.Time) (model.PriceHistory, error) {
+	if productID == p.past.ProductID && p.past.UpdateTime.Before(before) {
+		return p.past, nil
+	}
+	return p.DBProvider.GetPriceHistoryBefore(ctx, productID, before)
+}
+
+func TestListPriceHistoryRange(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.db = pastHistory{DBProvider: u.db, past: model.PriceHistory{ID: 1, ProductID: id, CurrentPrice: 60000,
+		OriginalPrice: 60000, InStock: true, UpdateTime: time.Now().AddDate(0, 0, -400)}}
+
+	// The range opens with the price then in effect and closes with the
+	// one still in effect.
+	histories, err := u.ListPriceHistory(ctx, id, 0, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 3 || histories[0].CurrentPrice != 60000 || histories[0].ID != 0 ||
+		histories[1].CurrentPrice != 50000 || histories[2].CurrentPrice != 50000 {
+		t.Errorf("7 day history = %+v", histories)
+	}
+
+	err = u.RollupPriceHistory(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	histories, err = u.ListPriceHistory(ctx, id, 0, 365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || histories[0].CurrentPrice != 60000 || histories[0].MinPrice != 60000 ||
+		histories[1].CurrentPrice != 50000 {
+		t.Errorf("1 year history = %+v", histories)
+	}
+}
+
+// lostItems is a repository that refreshes every product, as if an hour had
+// passed, and cannot store refresh run items.
+type lostItems struct {
+	DBProvider
+}
+
+func (l lostItems) GetProductsDueForRefresh(ctx context.Context, at time.Time, defaultInterval time.Duration, limit int) ([]model.Product, error) {
+	return l.DBProvider.GetProductsDueForRefresh(ctx, at.Add(2*defaultInterval), defaultInterval, limit)
+}
+
+func (l lostItems) InsertRefreshRunItem(ctx context.Context, item model.RefreshRunItem) error {
+	return errors.New("disk full")
+}
+
+func TestRefreshProductInformationLostItems(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	_, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.set(45000, true)
+	u.db = lostItems{u.db}
+
+	run, err := u.RefreshProductInformation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.FinishedAt == "" || run.Attempted != 1 || run.Succeeded != 1 || run.Changed != 1 || len(run.Items) != 0 {
+		t.Errorf("run that lost its items = %+v", run)
+	}
+}
+
+func TestRefreshProductRecordsFailure(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	s.broken = true
+	s.mu.Unlock()
+	_, err = u.RefreshProduct(ctx, id)
+	if err == nil {
+		t.Fatal("refresh of a broken page did not fail")
+	}
+
+	product, err := u.GetProductDetail(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.LastCheckError == "" || product.CurrentPrice != 50000 {
+		t.Errorf("product after a failed refresh = %+v", product)
+	}
+}
+
+func TestArchiveProduct(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = u.PurgeProduct(ctx, id)
+	if err != ErrProductNotArchived {
+		t.Fatalf("purging an active product = %v, want %v", err, ErrProductNotArchived)
+	}
+
+	err = u.ArchiveProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.RefreshProduct(ctx, id)
+	if err != ErrProductArchived {
+		t.Errorf("refreshing an archived product = %v, want %v", err, ErrProductArchived)
+	}
+	list, err := u.ListProduct(ctx, "", 0, 10, ProductFilter{Archived: true})
+	if err != nil || len(list.Products) != 1 || !list.Products[0].Archived {
+		t.Errorf("archived list = %+v, %v", list, err)
+	}
+
+	// Adding the link again tracks the product again.
+	_, err = u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := u.GetProductDetail(ctx, id)
+	if err != nil || product.Archived {
+		t.Errorf("re-added product = %+v, %v", product, err)
+	}
+
+	err = u.ArchiveProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.PurgeProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.GetProductDetail(ctx, id)
+	if err == nil {
+		t.Errorf("a purged product can still be read")
+	}
+}
+
+func TestListProductPagesAndSorts(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New()
+	u := New(repo)
+
+	for i, price := range []int64{300, 100, 500, 200, 400} {
+		_, err := repo.UpsertProduct(ctx, model.ProductPayload{Name: fmt.Sprintf("kopi %d", i+1), CurrentPrice: price,
+			OriginalPrice: 500, URL: fmt.Sprintf("https://www.tokopedia.com/kopi-%d", i+1), InStock: i != 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// DataTables sends the offset of the first row, not a page number.
+	list, err := u.ListProduct(ctx, "", 2, 2, ProductFilter{})
+	if err != nil || len(list.Products) != 2 || list.Products[0].ID != 3 || list.Products[1].ID != 4 {
+		t.Fatalf("rows 3 and 4 = %+v, %v", list.Products, err)
+	}
+	if !list.Products[0].InStock || list.Products[1].InStock {
+		t.Errorf("stock of rows 3 and 4 = %v, %v, want true, false", list.Products[0].InStock, list.Products[1].InStock)
+	}
+
+	list, err = u.ListProduct(ctx, "", 0, 10, ProductFilter{Sort: "discount", Descending: true, MaxPrice: 300})
+	if err != nil || list.RecordsTotal != 5 || list.RecordsFiltered != 3 {
+		t.Fatalf("filtered list = %+v, %v", list, err)
+	}
+	if list.Products[0].CurrentPrice != 100 || list.Products[0].Discount != 80 || list.Products[2].CurrentPrice != 300 {
+		t.Errorf("products by discount = %+v", list.Products)
+	}
+
+	list, err = u.ListProduct(ctx, "", 0, 10, ProductFilter{Search: "Kopi-4"})
+	if err != nil || len(list.Products) != 1 || list.Products[0].ID != 4 {
+		t.Errorf("search = %+v, %v", list.Products, err)
+	}
+}
+
+func TestMarketplaceOf(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://www.Tokopedia.com/toko/kopi": "tokopedia.com",
+		"http://shopee.co.id:8080/teh":        "shopee.co.id",
+		"not a link":                          "",
+	} {
+		if got := marketplaceOf(link); got != want {
+			t.Errorf("marketplaceOf(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestUpdateProductSettings(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, target, interval := "  Kopi kantor ", int64(45000), 30
+	product, err := u.UpdateProductSettings(ctx, id, ProductSettingsPatch{DisplayName: &name, TargetPrice: &target,
+		RefreshIntervalMinutes: &interval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.DisplayName != "Kopi kantor" || product.TargetPrice != 45000 || product.RefreshIntervalMinutes != 30 ||
+		product.Name != "Kopi Bubuk 200g" {
+		t.Errorf("updated product = %+v", product)
+	}
+
+	// Fields left out of the patch keep their value.
+	notes := "buy two"
+	product, err = u.UpdateProductSettings(ctx, id, ProductSettingsPatch{Notes: &notes})
+	if err != nil || product.DisplayName != "Kopi kantor" || product.Notes != "buy two" {
+		t.Errorf("patched product = %+v, %v", product, err)
+	}
+
+	negative, tooOften, tooRarely := int64(-1), 1, maxRefreshIntervalMinutes+1
+	for _, patch := range []ProductSettingsPatch{
+		{TargetPrice: &negative},
+		{RefreshIntervalMinutes: &tooOften},
+		{RefreshIntervalMinutes: &tooRarely},
+	} {
+		_, err = u.UpdateProductSettings(ctx, id, patch)
+		if _, ok := err.(ValidationError); !ok {
+			t.Errorf("UpdateProductSettings(%+v) = %v, want a ValidationError", patch, err)
+		}
+	}
+
+	_, err = u.UpdateProductSettings(ctx, id+1, ProductSettingsPatch{Notes: &notes})
+	if err != sql.ErrNoRows {
+		t.Errorf("updating an unknown product = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestTagProducts(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = u.TagProducts(ctx, []int64{id}, []string{"  Coffee   Beans ", "pantry"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := u.ListProduct(ctx, "", 0, 10, ProductFilter{Tag: "coffee beans"})
+	if err != nil || len(list.Products) != 1 || len(list.Products[0].Tags) != 2 || list.Products[0].Tags[0] != "coffee beans" {
+		t.Errorf("products tagged coffee beans = %+v, %v", list, err)
+	}
+
+	err = u.TagProducts(ctx, []int64{id}, nil, []string{"PANTRY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err = u.ListProduct(ctx, "", 0, 10, ProductFilter{Tag: "pantry"})
+	if err != nil || len(list.Products) != 0 || list.RecordsTotal != 1 || list.RecordsFiltered != 0 {
+		t.Errorf("products tagged pantry after removing = %+v, %v", list, err)
+	}
+
+	for _, tags := range [][]string{{" "}, {"a,b"}, {strings.Repeat("x", maxTagLength+1)}} {
+		err = u.TagProducts(ctx, []int64{id}, tags, nil)
+		if _, ok := err.(ValidationError); !ok {
+			t.Errorf("TagProducts(%q) = %v, want a ValidationError", tags, err)
+		}
+	}
+}
+
+func TestCollections(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err := u.CreateCollection(ctx, " Breakfast ", "")
+	if err != nil || collection.Name != "Breakfast" {
+		t.Fatalf("CreateCollection() = %+v, %v", collection, err)
+	}
+	_, err = u.CreateCollection(ctx, "breakfast", "")
+	if _, ok := err.(ValidationError); !ok {
+		t.Errorf("creating a duplicate collection = %v, want a ValidationError", err)
+	}
+
+	err = u.AddToCollection(ctx, collection.ID, []int64{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := u.ListProduct(ctx, "", 0, 10, ProductFilter{CollectionID: collection.ID})
+	if err != nil || len(list.Products) != 1 {
+		t.Errorf("products in the collection = %+v, %v", list, err)
+	}
+
+	err = u.AddToCollection(ctx, collection.ID+1, []int64{id})
+	if err != sql.ErrNoRows {
+		t.Errorf("adding to an unknown collection = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestProcessNextJob(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	ok, err := u.ProcessNextJob(ctx)
+	if err != nil || ok {
+		t.Fatalf("ProcessNextJob() on an empty queue = %v, %v", ok, err)
+	}
+
+	importID, err := u.EnqueueImport(ctx, []string{link})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		ok, err = u.ProcessNextJob(ctx)
+		if err != nil || !ok {
+			t.Fatalf("ProcessNextJob() #%d = %v, %v", i, ok, err)
+		}
+	}
+
+	job, err := u.GetJob(ctx, importID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != model.JobStatusDone || string(job.Result) != `{"job_ids":[2]}` {
+		t.Errorf("import job = %+v", job)
+	}
+
+	job, err = u.GetJob(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Kind != JobKindScrape || job.Status != model.JobStatusDone || string(job.Result) != `{"product_id":1}` {
+		t.Errorf("scrape job = %+v", job)
+	}
+}
+
+// contextJobs is a repository that, like the SQL ones, cannot store the
+// outcome of a job on a context that is done.
+type contextJobs struct {
+	DBProvider
+}
+
+func (c contextJobs) FailJob(ctx context.Context, id int64, errMessage string, retryAt time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return c.DBProvider.FailJob(ctx, id, errMessage, retryAt)
+}
+
+func TestProcessNextJobCancelled(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobID, err := u.EnqueueRefresh(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.db = contextJobs{u.db}
+
+	// The worker gave up on the job, which still gets its failure stored.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	ok, err := u.ProcessNextJob(cancelled)
+	if err != nil || !ok {
+		t.Fatalf("ProcessNextJob() with a cancelled context = %v, %v", ok, err)
+	}
+
+	job, err := u.GetJob(ctx, jobID)
+	if err != nil || job.Status != model.JobStatusPending || !strings.Contains(job.Error, "context canceled") {
+		t.Errorf("cancelled job = %+v, %v", job, err)
+	}
+}
+
+func TestProcessNextJobRetries(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New()
+	u := New(repo)
+
+	id, err := repo.EnqueueJob(ctx, model.JobPayload{Kind: "unknown", Payload: `{}`, MaxAttempts: 2,
+		RunAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := u.ProcessNextJob(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ProcessNextJob() = %v, %v", ok, err)
+	}
+
+	job, err := repo.GetJobByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != model.JobStatusPending || job.LastError == "" || !job.RunAt.After(time.Now().Add(jobBaseBackoff/2)) {
+		t.Fatalf("job after a first failure = %+v", job)
+	}
+
+	// Nothing is due until the backoff has passed.
+	ok, err = u.ProcessNextJob(ctx)
+	if err != nil || ok {
+		t.Fatalf("ProcessNextJob() during backoff = %v, %v", ok, err)
+	}
+
+	err = repo.FailJob(ctx, id, job.LastError, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.ProcessNextJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = repo.GetJobByID(ctx, id)
+	if err != nil || job.Status != model.JobStatusFailed || job.Attempts != 2 {
+		t.Errorf("job after its last attempt = %+v, %v", job, err)
+	}
+}
+
+// flakyJobs is a repository that fails to queue the first batch of jobs.
+type flakyJobs struct {
+	DBProvider
+	failed bool
+}
+
+func (f *flakyJobs) EnqueueJobs(ctx context.Context, payloads []model.JobPayload) ([]int64, error) {
+	if !f.failed {
+		f.failed = true
+		return nil, errors.New("database is locked")
+	}
+	return f.DBProvider.EnqueueJobs(ctx, payloads)
+}
+
+func TestProcessNextJobImportRetry(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New()
+	u := New(&flakyJobs{DBProvider: repo})
+
+	importID, err := u.EnqueueImport(ctx, []string{"https://shop.example/a", "https://shop.example/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := u.ProcessNextJob(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ProcessNextJob() = %v, %v", ok, err)
+	}
+	job, err := repo.GetJobByID(ctx, importID)
+	if err != nil || job.Status != model.JobStatusPending {
+		t.Fatalf("import job after a failure = %+v, %v", job, err)
+	}
+
+	err = repo.FailJob(ctx, importID, job.LastError, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.ProcessNextJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := u.GetJob(ctx, importID)
+	if err != nil || result.Status != model.JobStatusDone || string(result.Result) != `{"job_ids":[2,3]}` {
+		t.Errorf("retried import job = %+v, %v", result, err)
+	}
+	_, err = repo.GetJobByID(ctx, 4)
+	if err != sql.ErrNoRows {
+		t.Errorf("job 4 = %v, want no more scrape jobs than links", err)
+	}
+}
+
+func TestProcessNextJobPermanentFailure(t *testing.T) {
+	ctx := context.Background()
+	u := New(memory.New())
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	for _, link := range []string{"kopi bubuk", "ftp://shop.example/kopi", server.URL + "/kopi"} {
+		id, err := u.EnqueueScrape(ctx, link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := u.ProcessNextJob(ctx)
+		if err != nil || !ok {
+			t.Fatalf("ProcessNextJob() = %v, %v", ok, err)
+		}
+
+		job, err := u.GetJob(ctx, id)
+		if err != nil || job.Status != model.JobStatusFailed || job.Attempts != 1 {
+			t.Errorf("scrape of %q = %+v, %v, want failed without retries", link, job, err)
+		}
+	}
+}
+
+func TestCreateAlertRule(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []AlertRulePayload{
+		{Kind: model.AlertKindAllTimeLow},
+		{ProductID: id, Tag: "coffee", Kind: model.AlertKindAllTimeLow},
+		{ProductID: id + 1, Kind: model.AlertKindAllTimeLow},
+		{ProductID: id, Kind: "cheaper"},
+		{ProductID: id, Kind: model.AlertKindBelowTarget, Threshold: -1},
+		{ProductID: id, Kind: model.AlertKindPercentDrop, Threshold: 100},
+		{ProductID: id, Kind: model.AlertKindPercentDrop, Threshold: 10, WindowHours: -1},
+		{Tag: "coffee", Kind: model.AlertKindDiscountAbove},
+		{ProductID: id, Kind: model.AlertKindListingReturned, Threshold: -1},
+		{ProductID: id, Kind: model.AlertKindExpression},
+		{ProductID: id, Kind: model.AlertKindExpression, Expression: "prise < 100"},
+	} {
+		_, err := u.CreateAlertRule(ctx, payload)
+		if _, ok := err.(ValidationError); !ok {
+			t.Errorf("CreateAlertRule(%+v) error = %v, want a ValidationError", payload, err)
+		}
+	}
+
+	rule, err := u.CreateAlertRule(ctx, AlertRulePayload{Tag: " Fresh  Coffee", Kind: model.AlertKindPercentDrop, Threshold: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Tag != "fresh coffee" || rule.WindowHours != defaultAlertWindowHours || !rule.Enabled {
+		t.Errorf("created rule = %+v", rule)
+	}
+
+	_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindExpression, Expression: "price < avg(price 30d)"})
+	if verr, ok := err.(ValidationError); !ok || verr.Field != "expression" || !strings.Contains(verr.Message, "at character 19") {
+		t.Errorf("invalid expression error = %v", err)
+	}
+	expression, err := u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindExpression,
+		Expression: "  price < 90% * avg(price, 30d) ", Threshold: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Expression != "price < 90% * avg(price, 30d)" || expression.Threshold != 0 {
+		t.Errorf("created expression rule = %+v", expression)
+	}
+
+	err = u.DeleteAlertRule(ctx, rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.DeleteAlertRule(ctx, rule.ID); err != sql.ErrNoRows {
+		t.Errorf("deleting a deleted rule = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestRefreshProductFiresAlerts(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.TagProducts(ctx, []int64{id}, []string{"Coffee"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []AlertRulePayload{
+		{ProductID: id, Kind: model.AlertKindBelowTarget, Threshold: 45000},
+		{ProductID: id, Kind: model.AlertKindAllTimeLow},
+		{ProductID: id, Kind: model.AlertKindPercentDrop, Threshold: 10},
+		{Tag: "coffee", Kind: model.AlertKindDiscountAbove, Threshold: 30},
+	} {
+		_, err = u.CreateAlertRule(ctx, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		price   int64
+		inStock bool
+		kinds   []string
+	}{
+		// 12% down and 26% off.
+		{44000, true, []string{model.AlertKindBelowTarget, model.AlertKindAllTimeLow, model.AlertKindPercentDrop}},
+		{40000, false, nil},
+		// Back in stock 20% below the day's high, but no lower than before.
+		{40000, true, []string{model.AlertKindBelowTarget, model.AlertKindPercentDrop, model.AlertKindDiscountAbove}},
+		{40000, true, nil},
+		{48000, true, nil},
+	}
+	total := 0
+	for _, step := range steps {
+		s.set(step.price, step.inStock)
+		_, err = u.RefreshProduct(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fired := events.Events[:int(events.RecordsTotal)-total]
+		total = int(events.RecordsTotal)
+
+		var kinds []string
+		for i := len(fired) - 1; i >= 0; i-- {
+			kinds = append(kinds, fired[i].Kind)
+		}
+		if strings.Join(kinds, ",") != strings.Join(step.kinds, ",") {
+			t.Errorf("refresh to %d (in stock %v) fired %v, want %v", step.price, step.inStock, kinds, step.kinds)
+		}
+	}
+}
+
+// steadyHistory is a repository where the product was at 60,000 until 90
+// minutes ago and at 50,000 since, and was last checked checkedAgo ago.
+type steadyHistory struct {
+	DBProvider
+	checkedAgo time.Duration
+}
+
+func (s *steadyHistory) GetProductsByID(ctx context.Context, id int64) (model.Product, error) {
+	product, err := s.DBProvider.GetProductsByID(ctx, id)
+	product.LastCheckedAt = time.Now().Add(-s.checkedAgo)
+	return product, err
+}
+
+func (s *steadyHistory) GetPriceHistoryBetween(ctx context.Context, productID int64, from, to time.Time) ([]model.PriceHistory, error) {
+	now := time.Now()
+	return []model.PriceHistory{
+		{ProductID: productID, CurrentPrice: 60000, OriginalPrice: 60000, InStock: true, UpdateTime: now.Add(-3 * time.Hour)},
+		{ProductID: productID, CurrentPrice: 50000, OriginalPrice: 60000, InStock: true, UpdateTime: now.Add(-90 * time.Minute)},
+	}, nil
+}
+
+func TestRefreshProductFiresSteadyExpression(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindExpression,
+		Expression: "avg(price, 2h) < 55000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &steadyHistory{DBProvider: u.db, checkedAgo: time.Hour}
+	u.db = repo
+
+	// The 2 hour average fell from 57,500 at the last check to 52,500 with
+	// the price unchanged; the next check finds it still below.
+	for _, step := range []struct {
+		checkedAgo time.Duration
+		want       int64
+	}{
+		{time.Hour, 1},
+		{time.Minute, 1},
+	} {
+		repo.checkedAgo = step.checkedAgo
+		result, err := u.RefreshProduct(ctx, id)
+		if err != nil || result.Changed {
+			t.Fatalf("RefreshProduct() = %+v, %v, want no change", result, err)
+		}
+
+		events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+		if err != nil || events.RecordsTotal != step.want {
+			t.Errorf("events after a steady check %s after the last = %+v, %v, want %d", step.checkedAgo, events,
+				err, step.want)
+		}
+	}
+}
+
+func TestRefreshProductFiresAvailabilityAlerts(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []AlertRulePayload{
+		{ProductID: id, Kind: model.AlertKindBackInStock},
+		{ProductID: id, Kind: model.AlertKindListingReturned, Threshold: 2},
+		{ProductID: id, Kind: model.AlertKindBelowTarget, Threshold: 60000},
+	} {
+		_, err = u.CreateAlertRule(ctx, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		// failures is how many refreshes fail before this one.
+		failures int
+		inStock  bool
+		kinds    []string
+	}{
+		{0, false, nil},
+		{0, true, []string{model.AlertKindBackInStock, model.AlertKindBelowTarget}},
+		// One failure is not a removed listing, and unchanged prices do
+		// not fire price alerts.
+		{1, true, nil},
+		{2, true, []string{model.AlertKindListingReturned}},
+		{3, false, []string{model.AlertKindListingReturned}},
+		{0, true, []string{model.AlertKindBackInStock, model.AlertKindBelowTarget}},
+	}
+	total := 0
+	for i, step := range steps {
+		s.mu.Lock()
+		s.broken = true
+		s.mu.Unlock()
+		for j := 0; j < step.failures; j++ {
+			_, err = u.RefreshProduct(ctx, id)
+			if err == nil {
+				t.Fatal("refresh of a broken page did not fail")
+			}
+		}
+		s.mu.Lock()
+		s.broken = false
+		s.mu.Unlock()
+
+		s.set(50000, step.inStock)
+		_, err = u.RefreshProduct(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fired := events.Events[:int(events.RecordsTotal)-total]
+		total = int(events.RecordsTotal)
+
+		var kinds []string
+		for j := len(fired) - 1; j >= 0; j-- {
+			kinds = append(kinds, fired[j].Kind)
+		}
+		if strings.Join(kinds, ",") != strings.Join(step.kinds, ",") {
+			t.Errorf("step %d fired %v, want %v", i, kinds, step.kinds)
+		}
+		if i == 4 && (len(fired) != 1 || !strings.Contains(fired[0].Message, "after 3 failed checks, but out of stock")) {
+			t.Errorf("listing returned out of stock = %+v", fired)
+		}
+	}
+}
+
+func TestCheckAlertRulePercentDropWindow(t *testing.T) {
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	rule := model.AlertRule{Kind: model.AlertKindPercentDrop, Threshold: 20, WindowHours: 24}
+	product := model.Product{Name: "kopi", CurrentPrice: 90}
+	payload := ProductPayload{Name: "kopi", CurrentPrice: 75, InStock: true}
+
+	tests := []struct {
+		name    string
+		history []model.PriceHistory
+		want    bool
+	}{
+		{"no earlier prices", nil, false},
+		{"high before the window", []model.PriceHistory{
+			{CurrentPrice: 100, UpdateTime: at.Add(-48 * time.Hour)},
+			{CurrentPrice: 90, UpdateTime: at.Add(-30 * time.Hour)},
+		}, false},
+		{"high when the window opened", []model.PriceHistory{
+			{CurrentPrice: 100, UpdateTime: at.Add(-48 * time.Hour)},
+			{CurrentPrice: 90, UpdateTime: at.Add(-12 * time.Hour)},
+		}, true},
+		{"high inside the window", []model.PriceHistory{
+			{CurrentPrice: 100, UpdateTime: at.Add(-6 * time.Hour)},
+			{CurrentPrice: 90, UpdateTime: at.Add(-1 * time.Hour)},
+		}, true},
+	}
+
+	for _, tt := range tests {
+		message, got := checkAlertRule(rule, product, payload, priceRecord{history: tt.history}, at)
+		if got != tt.want {
+			t.Errorf("%s: checkAlertRule() = %q, %v, want %v", tt.name, message, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAlertRuleExpression(t *testing.T) {
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	product := model.Product{Name: "kopi", CurrentPrice: 100, OriginalPrice: 100, InStock: true}
+	payload := ProductPayload{Name: "kopi", CurrentPrice: 80, OriginalPrice: 100, InStock: true}
+	// 120 for a day before the window, then 100 for the last 12 hours, with
+	// a check out of stock in between.
+	record := priceRecord{history: []model.PriceHistory{
+		{CurrentPrice: 120, OriginalPrice: 100, UpdateTime: at.Add(-36 * time.Hour)},
+		{CurrentPrice: 0, OriginalPrice: 100, UpdateTime: at.Add(-13 * time.Hour)},
+		{CurrentPrice: 100, OriginalPrice: 100, UpdateTime: at.Add(-12 * time.Hour)},
+	}}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{"price < 90% * avg(price, 24h)", true},
+		// 11 hours at 120 and 12 at 100 average to about 109.6.
+		{"avg(price, 24h) > 109 and avg(price, 24h) < 110", true},
+		{"avg(price, 1h) == 100 and max(price, 2h) == 100", true},
+		{"min(price, 30d) == price and max(price, 30d) == 120", true},
+		{"min(original_price, 30d) == max(original_price, 30d)", true},
+		{"price < 70% * avg(price, 24h)", false},
+		{"discount == 20 and previous_price - price == 20 and was_in_stock", true},
+		{"price / target_price < 1", false},
+	}
+
+	for _, tt := range tests {
+		rule := model.AlertRule{Kind: model.AlertKindExpression, Expression: tt.expression}
+		message, got := checkAlertRule(rule, product, payload, record, at)
+		if got != tt.want {
+			t.Errorf("%q: checkAlertRule() = %q, %v, want %v", tt.expression, message, got, tt.want)
+		}
+	}
+
+	// Expression rules are checked out of stock too.
+	rule := model.AlertRule{Kind: model.AlertKindExpression, Expression: "was_in_stock and not in_stock"}
+	message, ok := checkAlertRule(rule, product, ProductPayload{Name: "kopi"}, record, at)
+	if !ok || message != "kopi matched was_in_stock and not in_stock, out of stock" {
+		t.Errorf("out of stock expression = %q, %v", message, ok)
+	}
+}
+
+// fakeNotifier records what it is sent and fails while failures is above
+// zero. Its channel is "fake" unless channel is set.
+type fakeNotifier struct {
+	channel  string
+	failures int
+	sent     []model.Notification
+	digests  []model.DigestSummary
+}
+
+func (n *fakeNotifier) Channel() string {
+	if n.channel != "" {
+		return n.channel
+	}
+	return "fake"
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, notification model.Notification) error {
+	if n.failures > 0 {
+		n.failures--
+		return fmt.Errorf("fake notifier is down")
+	}
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func (n *fakeNotifier) NotifyDigest(ctx context.Context, summary model.DigestSummary) error {
+	if n.failures > 0 {
+		n.failures--
+		return fmt.Errorf("fake notifier is down")
+	}
+	n.digests = append(n.digests, summary)
+	return nil
+}
+
+func TestAlertRuleChannels(t *testing.T) {
+	ctx := context.Background()
+	s := &shop{price: 50000, inStock: true}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	repo := memory.New()
+	chat, mail := &fakeNotifier{channel: "chat"}, &fakeNotifier{channel: "mail"}
+	u := New(repo, chat, mail)
+
+	id, err := u.RegisterProduct(ctx, server.URL+"/kopi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 45000, Channels: []string{"sms"}})
+	if verr, ok := err.(ValidationError); !ok || verr.Field != "channels" {
+		t.Errorf("CreateAlertRule() over an unknown channel = %v, want a validation error", err)
+	}
+	rule, err := u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 45000, Channels: []string{"chat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.Channels) != 1 || rule.Channels[0] != "chat" {
+		t.Errorf("CreateAlertRule() = %+v", rule)
+	}
+	_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindAllTimeLow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.set(44000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ok, err := u.ProcessNextJob(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+	}
+
+	// The rule on chat only is not mailed; the rule without channels goes
+	// everywhere.
+	if len(chat.sent) != 2 || len(mail.sent) != 1 || mail.sent[0].Kind != model.AlertKindAllTimeLow {
+		t.Errorf("chat got %+v, mail got %+v", chat.sent, mail.sent)
+	}
+}
+
+func TestUpdateAlertRule(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 45000, AlertControls: AlertControls{Hysteresis: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = u.UpdateAlertRule(ctx, rule.ID+1, AlertRulePayload{ProductID: id, Kind: model.AlertKindAllTimeLow})
+	if err != sql.ErrNoRows {
+		t.Errorf("updating a missing rule = %v, want %v", err, sql.ErrNoRows)
+	}
+	_, err = u.UpdateAlertRule(ctx, rule.ID, AlertRulePayload{ProductID: id, Kind: model.AlertKindPercentDrop})
+	if verr, ok := err.(ValidationError); !ok || verr.Field != "threshold" {
+		t.Errorf("UpdateAlertRule() with a bad threshold = %v, want a validation error", err)
+	}
+
+	s.set(44000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Raising the target rearms the rule, which fires on the next change.
+	rule, err = u.UpdateAlertRule(ctx, rule.ID, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 48000, AlertControls: AlertControls{Hysteresis: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Threshold != 48000 || !rule.Enabled || !rule.Hysteresis {
+		t.Errorf("UpdateAlertRule() = %+v", rule)
+	}
+	s.set(43000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := false
+	rule, err = u.UpdateAlertRule(ctx, rule.ID, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 48000, Enabled: &disabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Enabled {
+		t.Errorf("disabled rule = %+v", rule)
+	}
+	s.set(42000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.RecordsTotal != 2 {
+		t.Errorf("fired %d alerts, want 2: %+v", events.RecordsTotal, events.Events)
+	}
+}
+
+func TestTestAlertRule(t *testing.T) {
+	ctx := context.Background()
+	s := &shop{price: 50000, inStock: true}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	chat, mail := &fakeNotifier{channel: "chat"}, &fakeNotifier{channel: "mail", failures: 1}
+	u := New(memory.New(), chat, mail)
+
+	id, err := u.RegisterProduct(ctx, server.URL+"/kopi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.TagProducts(ctx, []int64{id}, []string{"coffee"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onCoffee, err := u.CreateAlertRule(ctx, AlertRulePayload{Tag: "coffee", Kind: model.AlertKindAllTimeLow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := u.TestAlertRule(ctx, onCoffee.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Channel != "chat" || results[0].Status != model.DeliveryStatusSent ||
+		results[1].Channel != "mail" || results[1].Status != model.DeliveryStatusFailed || results[1].Error == "" {
+		t.Errorf("TestAlertRule() = %+v", results)
+	}
+	if len(chat.sent) != 1 || chat.sent[0].ProductID != id || chat.sent[0].Price != 50000 ||
+		!strings.HasPrefix(chat.sent[0].Message, "Test of alert rule") {
+		t.Errorf("test alert = %+v", chat.sent)
+	}
+
+	onTea, err := u.CreateAlertRule(ctx, AlertRulePayload{Tag: "tea", Kind: model.AlertKindAllTimeLow,
+		Channels: []string{"mail"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.TestAlertRule(ctx, onTea.ID)
+	if verr, ok := err.(ValidationError); !ok || verr.Field != "tag" {
+		t.Errorf("TestAlertRule() without a tagged product = %v, want a validation error", err)
+	}
+
+	// Test alerts are neither stored nor logged.
+	events, err := u.ListAlertEvents(ctx, "", 0, 0, 10)
+	if err != nil || events.RecordsTotal != 0 {
+		t.Errorf("ListAlertEvents() after a test = %+v, %v", events, err)
+	}
+	deliveries, err := u.ListAlertDeliveries(ctx, "", 0, 0, 10)
+	if err != nil || deliveries.RecordsTotal != 0 {
+		t.Errorf("ListAlertDeliveries() after a test = %+v, %v", deliveries, err)
+	}
+}
+
+func TestAlertDelivery(t *testing.T) {
+	ctx := context.Background()
+	s := &shop{price: 50000, inStock: true}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	repo := memory.New()
+	notifier := &fakeNotifier{failures: 1}
+	u := New(repo, notifier)
+
+	id, err := u.RegisterProduct(ctx, server.URL+"/kopi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget, Threshold: 45000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.set(44000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt fails and is retried after the backoff.
+	_, err = u.ProcessNextJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := u.ListAlertDeliveries(ctx, "", 0, 0, 10)
+	if err != nil || len(deliveries.Deliveries) != 1 {
+		t.Fatalf("ListAlertDeliveries() = %+v, %v", deliveries, err)
+	}
+	delivery := deliveries.Deliveries[0]
+	if delivery.Channel != "fake" || delivery.Status != model.DeliveryStatusPending || delivery.Attempts != 1 ||
+		delivery.Error != "fake notifier is down" {
+		t.Errorf("delivery after a failed attempt = %+v", delivery)
+	}
+
+	job, err := repo.GetJobByID(ctx, 1)
+	if err != nil || job.Kind != JobKindNotify {
+		t.Fatalf("notify job = %+v, %v", job, err)
+	}
+	err = repo.FailJob(ctx, job.ID, job.LastError, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.ProcessNextJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err = u.ListAlertDeliveries(ctx, "", 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery = deliveries.Deliveries[0]
+	if delivery.Status != model.DeliveryStatusSent || delivery.Attempts != 2 || delivery.Error != "" || delivery.SentAt == "" {
+		t.Errorf("delivery after it was sent = %+v", delivery)
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].Price != 44000 || notifier.sent[0].PreviousPrice != 50000 ||
+		notifier.sent[0].ProductName != "Kopi Bubuk 200g" || notifier.sent[0].URL != server.URL+"/kopi" {
+		t.Errorf("sent notifications = %+v", notifier.sent)
+	}
+
+	// A delivery that still fails on its last attempt is given up.
+	deliveryID, err := repo.InsertAlertDelivery(ctx, model.AlertDelivery{EventID: delivery.EventID, Channel: "sms",
+		Status: model.DeliveryStatusPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.deliverAlert(ctx, deliveryID, true)
+	if err == nil {
+		t.Error("delivering over a channel without a notifier succeeded")
+	}
+	failed, err := repo.GetAlertDeliveryByID(ctx, deliveryID)
+	if err != nil || failed.Status != model.DeliveryStatusFailed || failed.LastError == "" {
+		t.Errorf("delivery after its last attempt = %+v, %v", failed, err)
+	}
+}
+
+// receiver is a webhook endpoint that fails while failures is above zero
+// and keeps the requests it accepted.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.failures > 0 {
+		rc.failures--
+		http.Error(w, "try later", http.StatusInternalServerError)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestCreateWebhook(t *testing.T) {
+	ctx := context.Background()
+	u, _, _ := newTestUsecase(t)
+
+	for _, payload := range []WebhookPayload{
+		{URL: "ftp://example.com/hook"},
+		{URL: "not a url"},
+		{URL: "https://example.com/hook", Events: []string{"price_dropped"}},
+	} {
+		_, err := u.CreateWebhook(ctx, payload)
+		if _, ok := err.(ValidationError); !ok {
+			t.Errorf("CreateWebhook(%+v) = %v, want a validation error", payload, err)
+		}
+	}
+
+	webhook, err := u.CreateWebhook(ctx, WebhookPayload{URL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.Secret) != 64 || len(webhook.Events) != 3 || !webhook.Enabled {
+		t.Errorf("CreateWebhook() = %+v, want every event and a generated secret", webhook)
+	}
+
+	webhooks, err := u.ListWebhooks(ctx)
+	if err != nil || len(webhooks) != 1 || webhooks[0].Secret != "" {
+		t.Errorf("ListWebhooks() = %+v, %v, want the webhook without its secret", webhooks, err)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+	rc := &receiver{failures: 1}
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := u.CreateWebhook(ctx, WebhookPayload{URL: server.URL, Secret: "s3cret",
+		Events: []string{model.WebhookEventPriceChanged}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing is not an event this webhook subscribed to.
+	s.broken = true
+	_, err = u.RefreshProduct(ctx, id)
+	if err == nil {
+		t.Fatal("refreshing a broken page succeeded")
+	}
+	s.broken = false
+	s.set(45000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt is answered with an error and retried after the
+	// backoff.
+	_, err = u.ProcessNextJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := u.ListWebhookDeliveries(ctx, "", webhook.ID, 0, 10)
+	if err != nil || len(deliveries.Deliveries) != 1 {
+		t.Fatalf("ListWebhookDeliveries() = %+v, %v", deliveries, err)
+	}
+	delivery := deliveries.Deliveries[0]
+	if delivery.Event != model.WebhookEventPriceChanged || delivery.Status != model.DeliveryStatusPending ||
+		delivery.Attempts != 1 || delivery.StatusCode != http.StatusInternalServerError || delivery.Error == "" {
+		t.Errorf("delivery after a failed attempt = %+v", delivery)
+	}
+
+	job, err := u.db.GetJobByID(ctx, 1)
+	if err != nil || job.Kind != JobKindWebhook {
+		t.Fatalf("webhook job = %+v, %v", job, err)
+	}
+	err = u.db.FailJob(ctx, job.ID, job.LastError, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.ProcessNextJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err = u.ListWebhookDeliveries(ctx, "", 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery = deliveries.Deliveries[0]
+	if delivery.Status != model.DeliveryStatusSent || delivery.Attempts != 2 ||
+		delivery.StatusCode != http.StatusNoContent || delivery.Error != "" || delivery.DeliveredAt == "" {
+		t.Errorf("delivery after it was sent = %+v", delivery)
+	}
+
+	rc.mu.Lock()
+	if len(rc.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(rc.requests))
+	}
+	req, body := rc.requests[0], rc.bodies[0]
+	rc.failures = 1
+	rc.mu.Unlock()
+	if req.Header.Get(WebhookSignatureHeader) != SignWebhook("s3cret", body) {
+		t.Errorf("signature %q does not match the body", req.Header.Get(WebhookSignatureHeader))
+	}
+	if req.Header.Get(WebhookEventHeader) != model.WebhookEventPriceChanged ||
+		req.Header.Get(WebhookDeliveryHeader) != fmt.Sprint(delivery.ID) ||
+		req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("webhook headers = %v", req.Header)
+	}
+	var posted webhookBody
+	err = json.Unmarshal(body, &posted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posted.Event != model.WebhookEventPriceChanged || posted.Product.ID != id ||
+		posted.Product.CurrentPrice != 45000 || posted.Previous == nil || posted.Previous.CurrentPrice != 50000 ||
+		posted.Timestamp == "" {
+		t.Errorf("posted body = %s", body)
+	}
+
+	// A delivery that still fails on its last attempt is given up.
+	err = u.deliverWebhook(ctx, insertWebhookDelivery(t, u, webhook.ID), true)
+	if err == nil {
+		t.Error("delivering to a failing webhook succeeded")
+	}
+	deliveries, err = u.ListWebhookDeliveries(ctx, "", webhook.ID, 0, 1)
+	if err != nil || deliveries.Deliveries[0].Status != model.DeliveryStatusFailed {
+		t.Errorf("delivery after its last attempt = %+v, %v", deliveries, err)
+	}
+}
+
+func insertWebhookDelivery(t *testing.T, u *usecase, webhookID int64) int64 {
+	id, err := u.db.InsertWebhookDelivery(context.Background(), model.WebhookDelivery{WebhookID: webhookID,
+		Event: model.WebhookEventPriceChanged, Payload: "{}", Status: model.DeliveryStatusPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSendDigests(t *testing.T) {
+	ctx := context.Background()
+	chat, mail := &fakeNotifier{channel: "chat"}, &fakeNotifier{channel: "mail"}
+	u := New(memory.New(), chat, mail)
+
+	kopi := &shop{price: 50000, inStock: true}
+	teh := &shop{name: "Teh Melati", price: 30000}
+	gula := &shop{name: "Gula Aren", price: 20000, inStock: true}
+	var ids []int64
+	for _, s := range []*shop{kopi, teh, gula} {
+		server := httptest.NewServer(s)
+		t.Cleanup(server.Close)
+		id, err := u.RegisterProduct(ctx, server.URL+"/product")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	collection, err := u.CreateCollection(ctx, "Breakfast", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.AddToCollection(ctx, collection.ID, ids[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = u.CreateDigest(ctx, DigestPayload{Period: "monthly"})
+	if verr, ok := err.(ValidationError); !ok || verr.Field != "period" {
+		t.Errorf("CreateDigest() with an unknown period = %v, want a validation error", err)
+	}
+	_, err = u.CreateDigest(ctx, DigestPayload{CollectionID: collection.ID + 1, Period: model.DigestPeriodDaily})
+	if verr, ok := err.(ValidationError); !ok || verr.Field != "collection_id" {
+		t.Errorf("CreateDigest() of an unknown collection = %v, want a validation error", err)
+	}
+	all, err := u.CreateDigest(ctx, DigestPayload{Period: model.DigestPeriodDaily})
+	if err != nil {
+		t.Fatal(err)
+	}
+	breakfast, err := u.CreateDigest(ctx, DigestPayload{CollectionID: collection.ID, Period: model.DigestPeriodWeekly,
+		Channels: []string{"chat"}})
+	if err != nil || breakfast.CollectionName != "Breakfast" {
+		t.Fatalf("CreateDigest() = %+v, %v", breakfast, err)
+	}
+
+	// The first digests go out at once.
+	start := time.Now()
+	sent, err := u.sendDigests(ctx, start)
+	if err != nil || sent != 2 {
+		t.Fatalf("sendDigests() = %d, %v, want 2 digests", sent, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	kopi.set(40000, true)
+	teh.set(30000, true)
+	gula.mu.Lock()
+	gula.broken = true
+	gula.mu.Unlock()
+	for _, id := range ids {
+		_, err = u.RefreshProduct(ctx, id)
+		if err != nil && id != ids[2] {
+			t.Fatal(err)
+		}
+	}
+
+	sent, err = u.sendDigests(ctx, start.Add(time.Hour))
+	if err != nil || sent != 0 {
+		t.Errorf("sendDigests() before a period is over = %d, %v", sent, err)
+	}
+	end := start.Add(23 * time.Hour)
+	sent, err = u.sendDigests(ctx, end)
+	if err != nil || sent != 1 {
+		t.Fatalf("sendDigests() once the daily period is over = %d, %v", sent, err)
+	}
+
+	reports, err := u.ListDigestReports(ctx, "", all.ID, 0, 10)
+	if err != nil || reports.RecordsTotal != 2 {
+		t.Fatalf("ListDigestReports() = %+v, %v", reports, err)
+	}
+	report, err := u.GetDigestReport(ctx, reports.Reports[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := report.Summary
+	if summary.Title != "Daily digest: all products" || summary.Products != 3 || !summary.From.Equal(start.UTC()) ||
+		!summary.To.Equal(end.UTC()) {
+		t.Errorf("digest = %+v", summary)
+	}
+	if len(summary.Drops) != 1 || summary.Drops[0].ProductID != ids[0] || summary.Drops[0].Price != 40000 ||
+		summary.Drops[0].PreviousPrice != 50000 {
+		t.Errorf("drops = %+v", summary.Drops)
+	}
+	if len(summary.NewLows) != 1 || summary.NewLows[0].ProductID != ids[0] || summary.NewLows[0].PreviousPrice != 50000 {
+		t.Errorf("new lows = %+v", summary.NewLows)
+	}
+	if len(summary.BackInStock) != 1 || summary.BackInStock[0].ProductID != ids[1] {
+		t.Errorf("back in stock = %+v", summary.BackInStock)
+	}
+	if len(summary.Broken) != 1 || summary.Broken[0].ProductID != ids[2] || summary.Broken[0].Error == "" {
+		t.Errorf("broken links = %+v", summary.Broken)
+	}
+
+	// Each report is sent over the digest's channels.
+	for {
+		ran, err := u.ProcessNextJob(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ran {
+			break
+		}
+	}
+	if len(chat.digests) != 3 || len(mail.digests) != 2 {
+		t.Errorf("digests sent: chat %d, mail %d, want 3 and 2", len(chat.digests), len(mail.digests))
+	}
+	if mail.digests[1].Title != "Daily digest: all products" || len(mail.digests[1].Drops) != 1 {
+		t.Errorf("last digest mailed = %+v", mail.digests[1])
+	}
+}
+
+func TestAlertControls(t *testing.T) {
+	ctx := context.Background()
+	u, s, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		controls AlertControls
+		field    string
+	}{
+		{AlertControls{CooldownMinutes: -1}, "cooldown_minutes"},
+		{AlertControls{QuietStart: "25:00", QuietEnd: "07:00"}, "quiet_start"},
+		{AlertControls{QuietStart: "22:00"}, "quiet_end"},
+		{AlertControls{QuietStart: "22:00", QuietEnd: "22:00"}, "quiet_end"},
+		{AlertControls{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Mars/Olympus"}, "timezone"},
+	} {
+		_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+			Threshold: 45000, AlertControls: test.controls})
+		if verr, ok := err.(ValidationError); !ok || verr.Field != test.field {
+			t.Errorf("CreateAlertRule() with %+v = %v, want a validation error on %s", test.controls, err, test.field)
+		}
+	}
+
+	rule, err := u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 45000, AlertControls: AlertControls{Hysteresis: true, QuietStart: "22:00", QuietEnd: "07:00"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Hysteresis || rule.Timezone != "UTC" {
+		t.Errorf("CreateAlertRule() = %+v", rule)
+	}
+
+	// With hysteresis the rule fires again only after the price went back
+	// above the threshold.
+	steps := []struct {
+		price  int64
+		snooze int
+		fires  bool
+	}{
+		{44000, 0, true},
+		{43000, 0, false},
+		{46000, 0, false},
+		{44000, 0, true},
+		{46000, 60, false},
+		{44000, 0, false},
+	}
+	total := int64(0)
+	for _, step := range steps {
+		if step.snooze > 0 {
+			snoozed, err := u.SnoozeAlertRule(ctx, rule.ID, step.snooze)
+			if err != nil || snoozed.SnoozedUntil == "" {
+				t.Fatalf("SnoozeAlertRule() = %+v, %v", snoozed, err)
+			}
+		}
+		s.set(step.price, true)
+		_, err = u.RefreshProduct(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fired := events.RecordsTotal > total; fired != step.fires {
+			t.Errorf("refresh to %d fired %v, want %v", step.price, fired, step.fires)
+		}
+		total = events.RecordsTotal
+	}
+	// Once the snooze is lifted the rule is armed again, since the product
+	// stopped satisfying it while snoozed.
+	_, err = u.SnoozeAlertRule(ctx, rule.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.set(43000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+	if err != nil || events.RecordsTotal != total+1 {
+		t.Errorf("refresh after the snooze was lifted left %d events, want %d", events.RecordsTotal, total+1)
+	}
+}
+
+func TestShouldFire(t *testing.T) {
+	ctx := context.Background()
+	u, _, link := newTestUsecase(t)
+
+	id, err := u.RegisterProduct(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget,
+		Threshold: 45000, AlertControls: AlertControls{CooldownMinutes: 60}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := u.db.GetAlertRuleByID(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now()
+	steps := []struct {
+		after     time.Duration
+		satisfied bool
+		want      bool
+	}{
+		{0, true, true},
+		{30 * time.Minute, true, false},
+		{61 * time.Minute, true, true},
+		// A product that stops satisfying the rule does not reset the
+		// cooldown.
+		{62 * time.Minute, false, false},
+		{90 * time.Minute, true, false},
+	}
+	for _, step := range steps {
+		got := u.shouldFire(ctx, rule, id, step.satisfied, at.Add(step.after))
+		if got != step.want {
+			t.Errorf("shouldFire(satisfied %v) %v after the first = %v, want %v", step.satisfied, step.after, got, step.want)
+		}
+	}
+
+	// An acknowledged event disarms the rule until the product stops
+	// satisfying it.
+	eventID, err := u.db.InsertAlertEvent(ctx, model.AlertEvent{RuleID: rule.ID, ProductID: id, Kind: rule.Kind,
+		Price: 44000, PreviousPrice: 50000, Message: "fired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.AcknowledgeAlertEvent(ctx, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := u.ListAlertEvents(ctx, "", id, 0, 10)
+	if err != nil || events.Events[0].AcknowledgedAt == "" {
+		t.Errorf("acknowledged events = %+v, %v", events, err)
+	}
+	later := at.Add(5 * time.Hour)
+	if u.shouldFire(ctx, rule, id, true, later) {
+		t.Error("shouldFire() right after an acknowledgement = true")
+	}
+	u.shouldFire(ctx, rule, id, false, later.Add(time.Minute))
+	if !u.shouldFire(ctx, rule, id, true, later.Add(2*time.Minute)) {
+		t.Error("shouldFire() once the acknowledged product recovered = false")
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	overnight := model.AlertRule{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Asia/Jakarta"}
+	lunch := model.AlertRule{QuietStart: "12:00", QuietEnd: "13:00", Timezone: "UTC"}
+	morning := time.Date(2021, 9, 2, 0, 0, 0, 0, time.UTC) // 07:00 in Jakarta
+
+	for _, test := range []struct {
+		rule model.AlertRule
+		at   time.Time
+		want time.Time
+	}{
+		{overnight, time.Date(2021, 9, 1, 16, 0, 0, 0, time.UTC), morning},
+		{overnight, time.Date(2021, 9, 1, 23, 30, 0, 0, time.UTC), morning},
+		{overnight, morning, morning},
+		{overnight, time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC), time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)},
+		{lunch, time.Date(2021, 9, 1, 12, 30, 0, 0, time.UTC), time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC)},
+		{lunch, time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC), time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC)},
+		{model.AlertRule{}, morning, morning},
+	} {
+		got := quietUntil(test.rule, test.at)
+		if !got.Equal(test.want) {
+			t.Errorf("quietUntil(%s-%s %s, %v) = %v, want %v", test.rule.QuietStart, test.rule.QuietEnd,
+				test.rule.Timezone, test.at, got, test.want)
+		}
+	}
+}
+
+func TestQuietHoursDeferDelivery(t *testing.T) {
+	ctx := context.Background()
+	s := &shop{price: 50000, inStock: true}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	repo := memory.New()
+	notifier := &fakeNotifier{}
+	u := New(repo, notifier)
+
+	id, err := u.RegisterProduct(ctx, server.URL+"/kopi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	_, err = u.CreateAlertRule(ctx, AlertRulePayload{ProductID: id, Kind: model.AlertKindBelowTarget, Threshold: 45000,
+		AlertControls: AlertControls{QuietStart: now.Add(-time.Hour).Format("15:04"),
+			QuietEnd: now.Add(time.Hour).Format("15:04")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.set(44000, true)
+	_, err = u.RefreshProduct(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran, err := u.ProcessNextJob(ctx)
+	if err != nil || ran {
+		t.Errorf("ProcessNextJob() during quiet hours = %v, %v, want nothing to run", ran, err)
+	}
+	job, err := repo.GetJobByID(ctx, 1)
+	if err != nil || job.Kind != JobKindNotify || job.RunAt.Before(now.Add(59*time.Minute)) {
+		t.Errorf("notify job = %+v, %v, want it to run when the quiet hours end", job, err)
+	}
+	deliveries, err := u.ListAlertDeliveries(ctx, "", 0, 0, 10)
+	if err != nil || len(deliveries.Deliveries) != 1 || deliveries.Deliveries[0].Status != model.DeliveryStatusPending {
+		t.Errorf("deliveries during quiet hours = %+v, %v", deliveries, err)
+	}
+}
@@ -0,0 +1,412 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ediprako/pricemonitor/repository/model"
+)
+
+const (
+	JobKindWebhook = "webhook"
+
+	// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// request body keyed with the webhook's secret.
+	WebhookSignatureHeader = "X-Signature-256"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+var webhookEvents = []string{model.WebhookEventPriceChanged, model.WebhookEventAlertTriggered, model.WebhookEventProductFailed}
+
+// webhookClient posts webhooks. Receivers are expected to answer quickly and
+// do their work afterwards.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type Webhook struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only shown when the webhook is created.
+	Secret    string `json:"secret,omitempty"`
+	Enabled   bool   `json:"enabled"`
+	CreatedAt string `json:"created_at"`
+}
+
+// WebhookPayload is a new webhook. It subscribes to every event when Events
+// is empty and gets a random secret when Secret is empty.
+type WebhookPayload struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+type WebhookDelivery struct {
+	ID          int64           `json:"id"`
+	WebhookID   int64           `json:"webhook_id"`
+	URL         string          `json:"url"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	StatusCode  int             `json:"status_code,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	DeliveredAt string          `json:"delivered_at,omitempty"`
+}
+
+type PaginateWebhookDelivery struct {
+	Draw            string            `json:"draw"`
+	RecordsTotal    int64             `json:"recordsTotal"`
+	RecordsFiltered int64             `json:"recordsFiltered"`
+	Deliveries      []WebhookDelivery `json:"data"`
+}
+
+type webhookJob struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// webhookBody is the JSON posted for every event. Previous is set for
+// price_changed and alert_triggered, Alert for alert_triggered and Error for
+// product_failed.
+type webhookBody struct {
+	Event     string         `json:"event"`
+	Timestamp string         `json:"timestamp"`
+	Product   webhookProduct `json:"product"`
+	Previous  *webhookPrice  `json:"previous,omitempty"`
+	Alert     *webhookAlert  `json:"alert,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type webhookProduct struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	URL           string `json:"url"`
+	Marketplace   string `json:"marketplace"`
+	CurrentPrice  int64  `json:"current_price"`
+	OriginalPrice int64  `json:"original_price"`
+	InStock       bool   `json:"in_stock"`
+}
+
+type webhookPrice struct {
+	CurrentPrice  int64 `json:"current_price"`
+	OriginalPrice int64 `json:"original_price"`
+	InStock       bool  `json:"in_stock"`
+}
+
+type webhookAlert struct {
+	EventID int64  `json:"event_id"`
+	RuleID  int64  `json:"rule_id"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func convertWebhook(webhook model.Webhook) Webhook {
+	return Webhook{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    strings.Split(webhook.Events, ","),
+		Enabled:   webhook.Enabled,
+		CreatedAt: webhook.CreatedAt.Format(runTimeFormat),
+	}
+}
+
+func (u *usecase) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhooks, err := u.db.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Webhook, len(webhooks))
+	for i, webhook := range webhooks {
+		result[i] = convertWebhook(webhook)
+	}
+
+	return result, nil
+}
+
+func (u *usecase) CreateWebhook(ctx context.Context, payload WebhookPayload) (Webhook, error) {
+	webhook, err := validateWebhook(payload)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	id, err := u.db.CreateWebhook(ctx, webhook)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	webhook, err = u.db.GetWebhookByID(ctx, id)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	result := convertWebhook(webhook)
+	result.Secret = webhook.Secret
+	return result, nil
+}
+
+// validateWebhook checks a new webhook and fills in its defaults.
+func validateWebhook(payload WebhookPayload) (model.Webhook, error) {
+	link, err := url.Parse(strings.TrimSpace(payload.URL))
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		return model.Webhook{}, ValidationError{"url", "must be an http or https URL"}
+	}
+
+	events := webhookEvents
+	if len(payload.Events) > 0 {
+		subscribed := make(map[string]bool)
+		for _, event := range payload.Events {
+			if !containsString(webhookEvents, event) {
+				return model.Webhook{}, ValidationError{"events", "must be some of " + strings.Join(webhookEvents, ", ")}
+			}
+			subscribed[event] = true
+		}
+		events = nil
+		for _, event := range webhookEvents {
+			if subscribed[event] {
+				events = append(events, event)
+			}
+		}
+	}
+
+	secret := payload.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			return model.Webhook{}, err
+		}
+		secret = hex.EncodeToString(key)
+	}
+
+	return model.Webhook{
+		URL:     link.String(),
+		Secret:  secret,
+		Events:  strings.Join(events, ","),
+		Enabled: true,
+	}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *usecase) DeleteWebhook(ctx context.Context, id int64) error {
+	_, err := u.db.GetWebhookByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return u.db.DeleteWebhook(ctx, id)
+}
+
+// ListWebhookDeliveries lists posted webhooks newest first, those of one
+// webhook unless webhookID is zero.
+func (u *usecase) ListWebhookDeliveries(ctx context.Context, draw string, webhookID int64, offset, limit int) (PaginateWebhookDelivery, error) {
+	if limit == 0 {
+		limit = 10
+	}
+
+	deliveries, err := u.db.GetWebhookDeliveries(ctx, webhookID, limit, offset)
+	if err != nil {
+		return PaginateWebhookDelivery{}, err
+	}
+
+	total, err := u.db.GetTotalWebhookDelivery(ctx, webhookID)
+	if err != nil {
+		return PaginateWebhookDelivery{}, err
+	}
+
+	result := make([]WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		result[i] = WebhookDelivery{
+			ID:         delivery.ID,
+			WebhookID:  delivery.WebhookID,
+			URL:        delivery.URL,
+			Event:      delivery.Event,
+			Payload:    json.RawMessage(delivery.Payload),
+			Status:     delivery.Status,
+			Attempts:   delivery.Attempts,
+			StatusCode: delivery.StatusCode,
+			Error:      delivery.LastError,
+			CreatedAt:  delivery.CreatedAt.Format(runTimeFormat),
+		}
+		if delivery.DeliveredAt.Valid {
+			result[i].DeliveredAt = delivery.DeliveredAt.Time.Format(runTimeFormat)
+		}
+	}
+
+	return PaginateWebhookDelivery{
+		Draw:            draw,
+		RecordsTotal:    total,
+		RecordsFiltered: total,
+		Deliveries:      result,
+	}, nil
+}
+
+func newWebhookBody(event string, product model.Product) webhookBody {
+	body := webhookBody{
+		Event:     event,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Product: webhookProduct{
+			ID:            product.ID,
+			Name:          product.Name,
+			URL:           product.URL,
+			Marketplace:   product.Marketplace,
+			CurrentPrice:  product.CurrentPrice,
+			OriginalPrice: product.OriginalPrice,
+			InStock:       product.InStock,
+		},
+	}
+	if product.DisplayName != "" {
+		body.Product.Name = product.DisplayName
+	}
+
+	return body
+}
+
+// refreshedWebhookBody describes a product as a refresh left it, with the
+// prices it had before.
+func refreshedWebhookBody(event string, product model.Product, payload ProductPayload) webhookBody {
+	body := newWebhookBody(event, product)
+	body.Product.CurrentPrice = payload.CurrentPrice
+	body.Product.OriginalPrice = payload.OriginalPrice
+	body.Product.InStock = payload.InStock
+	body.Previous = &webhookPrice{
+		CurrentPrice:  product.CurrentPrice,
+		OriginalPrice: product.OriginalPrice,
+		InStock:       product.InStock,
+	}
+
+	return body
+}
+
+// queueWebhooks logs a delivery of the event for every enabled webhook
+// subscribed to it and queues a job posting it. Webhooks must never fail
+// what triggered them, so errors are only logged.
+func (u *usecase) queueWebhooks(ctx context.Context, body webhookBody) {
+	webhooks, err := u.db.GetWebhooks(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Enabled || !containsString(strings.Split(webhook.Events, ","), body.Event) {
+			continue
+		}
+
+		if payload == nil {
+			payload, err = json.Marshal(body)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+
+		id, err := u.db.InsertWebhookDelivery(ctx, model.WebhookDelivery{
+			WebhookID: webhook.ID,
+			Event:     body.Event,
+			Payload:   string(payload),
+			Status:    model.DeliveryStatusPending,
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		_, err = u.enqueueJob(ctx, JobKindWebhook, webhookJob{DeliveryID: id})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// deliverWebhook posts one delivery and logs the attempt. A delivery that
+// fails stays pending until its last attempt.
+func (u *usecase) deliverWebhook(ctx context.Context, deliveryID int64, lastAttempt bool) error {
+	delivery, err := u.db.GetWebhookDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery.Status != model.DeliveryStatusPending {
+		return nil
+	}
+
+	webhook, err := u.db.GetWebhookByID(ctx, delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	statusCode, err := postWebhook(ctx, webhook, delivery)
+	status, errMessage := model.DeliveryStatusSent, ""
+	if err != nil {
+		status, errMessage = model.DeliveryStatusPending, err.Error()
+		if lastAttempt {
+			status = model.DeliveryStatusFailed
+		}
+	}
+
+	errRecord := u.db.RecordWebhookDeliveryAttempt(ctx, deliveryID, status, statusCode, errMessage)
+	if errRecord != nil {
+		log.Println(errRecord)
+	}
+
+	return err
+}
+
+// postWebhook posts a signed delivery and returns the response status. Any
+// status other than 2xx is an error.
+func postWebhook(ctx context.Context, webhook model.Webhook, delivery model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pricemonitor-webhook")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, []byte(delivery.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// A short excerpt of the answer usually says what went wrong.
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 200))
+		return resp.StatusCode, fmt.Errorf("webhook answered %s: %s", resp.Status, bytes.TrimSpace(excerpt))
+	}
+
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the signature header value of a webhook body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}